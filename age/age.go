@@ -35,6 +35,9 @@
 //   - SOPS_AGE_KEY_CMD: a command whose stdout produces identities
 //   - SOPS_AGE_SSH_PRIVATE_KEY_FILE: a path to an SSH private key
 //
+// Alternatively, pass identities directly through
+// cipher.DecoderOptions.AgeIdentities to bypass the environment.
+//
 // The provider itself does not read identity material. It only
 // supplies public recipients used to wrap the per-file data key.
 //
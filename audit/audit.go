@@ -0,0 +1,273 @@
+// Package audit records cipher operations to pluggable sinks.
+//
+// It is an opt-in subpackage. The core cipher package exposes bare
+// callbacks (OnEncrypt, OnDecryptAudit, WalkOptions.OnFile); audit turns
+// them into structured [Event] values delivered to an [AuditSink].
+//
+// # What you get
+//
+//   - [WrapEncoder] and [WrapDecoder] record an event for every Encode
+//     and Decode call, failed ones included. When the sink fails the
+//     call fails too, so no operation goes unrecorded.
+//   - [Rotate], [AddRecipient], and [RemoveRecipient] call the cipher
+//     function of the same name and record the result.
+//   - [WalkOptions] records every file a walk processes, skips, or
+//     fails.
+//   - [FileSink] appends events to a hash-chained JSONL file. Each line
+//     carries the hash of the line before it, so [Verify] detects an
+//     edited, reordered, removed, or partially written entry.
+//
+// # Quick start
+//
+//	sink, err := audit.NewFileSink(afero.NewOsFs(), "/var/log/cipher/audit.jsonl")
+//	if err != nil {
+//	    return err
+//	}
+//	defer sink.Close()
+//	enc := audit.WrapEncoder(cipher.NewEncoder(kp), sink)
+//	dec := audit.WrapDecoder(cipher.NewDecoder(), sink)
+//
+// Check a log with `cipher audit verify LOG`.
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dcadolph/cipher"
+)
+
+// Operation names recorded in Event.Op.
+const (
+	OpEncode          = "encode"
+	OpDecode          = "decode"
+	OpRotate          = "rotate"
+	OpAddRecipient    = "add-recipient"
+	OpRemoveRecipient = "remove-recipient"
+)
+
+// ErrRecord wraps a sink failure returned by an audited operation.
+var ErrRecord = errors.New("audit: record failed")
+
+// Event is one audited cipher operation.
+type Event struct {
+	// Time is when the operation finished, in UTC.
+	Time time.Time `json:"time"`
+	// Op is the operation, one of the Op constants.
+	Op string `json:"op"`
+	// Path is the file path passed to the operation.
+	Path string `json:"path"`
+	// Walk is the walk root when the operation ran inside a walk.
+	Walk string `json:"walk,omitempty"`
+	// Recipients lists the identifiers of the file's recipients after
+	// the operation, or before it for decode. Empty when the file's
+	// metadata could not be read.
+	Recipients []string `json:"recipients,omitempty"`
+	// Bytes is the size of the operation's output.
+	Bytes int `json:"bytes,omitempty"`
+	// Skipped is why a walk skipped the file.
+	Skipped string `json:"skipped,omitempty"`
+	// Error is the operation's error message, if it failed.
+	Error string `json:"error,omitempty"`
+}
+
+// AuditSink receives audit events. Implementations must be safe for
+// concurrent use.
+type AuditSink interface {
+	// Record stores e. An error means e was not stored.
+	Record(ctx context.Context, e Event) error
+}
+
+// AuditSinkFunc adapts a function to the AuditSink interface.
+type AuditSinkFunc func(ctx context.Context, e Event) error
+
+// Record calls f(ctx, e).
+func (f AuditSinkFunc) Record(ctx context.Context, e Event) error {
+	return f(ctx, e)
+}
+
+// newEvent returns an Event for op on path stamped with the current
+// time. Recipients are read from ciphertext when it is non-nil.
+func newEvent(op, path string, ciphertext []byte, n int, err error) Event {
+	e := Event{Time: time.Now().UTC(), Op: op, Path: path, Bytes: n}
+	if ciphertext != nil {
+		e.Recipients = recipients(path, ciphertext)
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return e
+}
+
+// recipients returns the recipient identifiers in data's metadata, or
+// nil when it cannot be read.
+func recipients(path string, data []byte) []string {
+	info, err := cipher.InspectPath(path, data)
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, group := range info.Groups {
+		for _, r := range group {
+			out = append(out, r.Identifier)
+		}
+	}
+	return out
+}
+
+// record stores e in sink and joins a sink failure onto err.
+func record(ctx context.Context, sink AuditSink, e Event, err error) error {
+	if rerr := sink.Record(ctx, e); rerr != nil {
+		return errors.Join(err, fmt.Errorf("%w: %s %q: %w", ErrRecord, e.Op, e.Path, rerr))
+	}
+	return err
+}
+
+// WrapEncoder returns a cipher.Encoder that records an OpEncode event
+// for every Encode call, with the recipients of the new ciphertext.
+// When the sink fails, the ciphertext is withheld and the error wraps
+// ErrRecord.
+func WrapEncoder(enc cipher.Encoder, sink AuditSink) cipher.Encoder {
+	if enc == nil {
+		panic("audit: WrapEncoder: encoder required")
+	}
+	if sink == nil {
+		panic("audit: WrapEncoder: sink required")
+	}
+	return cipher.EncoderFunc(func(ctx context.Context, path string, data []byte) ([]byte, error) {
+		out, err := enc.Encode(ctx, path, data)
+		if err := record(ctx, sink, newEvent(OpEncode, path, out, len(out), err), err); err != nil {
+			return nil, err
+		}
+		return out, nil
+	})
+}
+
+// WrapDecoder returns a cipher.Decoder that records an OpDecode event
+// for every Decode call, with the recipients listed on the ciphertext.
+// When the sink fails, the plaintext is withheld and the error wraps
+// ErrRecord.
+func WrapDecoder(dec cipher.Decoder, sink AuditSink) cipher.Decoder {
+	if dec == nil {
+		panic("audit: WrapDecoder: decoder required")
+	}
+	if sink == nil {
+		panic("audit: WrapDecoder: sink required")
+	}
+	return cipher.DecoderFunc(func(ctx context.Context, path string, data []byte) ([]byte, error) {
+		out, err := dec.Decode(ctx, path, data)
+		if err := record(ctx, sink, newEvent(OpDecode, path, data, len(out), err), err); err != nil {
+			clear(out)
+			return nil, err
+		}
+		return out, nil
+	})
+}
+
+// Rotate calls cipher.Rotate and records an OpRotate event.
+func Rotate(
+	ctx context.Context, sink AuditSink, path string, data []byte,
+	enc cipher.Encoder, dec cipher.Decoder,
+) ([]byte, error) {
+	if sink == nil {
+		panic("audit: Rotate: sink required")
+	}
+	out, err := cipher.Rotate(ctx, path, data, enc, dec)
+	if err := record(ctx, sink, newEvent(OpRotate, path, out, len(out), err), err); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AddRecipient calls cipher.AddRecipientWith and records an
+// OpAddRecipient event.
+func AddRecipient(
+	ctx context.Context, sink AuditSink, path string, data []byte,
+	add cipher.KeyProvider, opts cipher.AddRecipientOptions,
+) ([]byte, error) {
+	if sink == nil {
+		panic("audit: AddRecipient: sink required")
+	}
+	out, err := cipher.AddRecipientWith(ctx, path, data, add, opts)
+	if err := record(ctx, sink, newEvent(OpAddRecipient, path, out, len(out), err), err); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RemoveRecipient calls cipher.RemoveRecipientWith and records an
+// OpRemoveRecipient event.
+func RemoveRecipient(
+	ctx context.Context, sink AuditSink, path string, data []byte,
+	identifiers []string, opts cipher.RemoveRecipientOptions,
+) ([]byte, error) {
+	if sink == nil {
+		panic("audit: RemoveRecipient: sink required")
+	}
+	out, err := cipher.RemoveRecipientWith(path, data, identifiers, opts)
+	if err := record(ctx, sink, newEvent(OpRemoveRecipient, path, out, len(out), err), err); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// WalkOptions returns opts with OnFile and OnSkip also recording an
+// event for each file, with Op set to op and Walk to root, and
+// WrapFile recording an event with Error set for each file that fails.
+// The original callbacks and WrapFile still run. Pass the
+// options to the walk function matching op: OpEncode for
+// EncodeWalkWith, OpDecode for DecodeWalkWith, OpRotate for
+// RotateWalkWith.
+//
+// Walk callbacks cannot fail a walk, so sink failures are collected
+// instead; call the returned function after the walk to get them.
+func WalkOptions(
+	ctx context.Context, sink AuditSink, op, root string, opts cipher.WalkOptions,
+) (cipher.WalkOptions, func() error) {
+	if sink == nil {
+		panic("audit: WalkOptions: sink required")
+	}
+	var mu sync.Mutex
+	var errs []error
+	add := func(e Event) {
+		e.Walk = root
+		if err := record(ctx, sink, e, nil); err != nil {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}
+	}
+	wrap, onFile, onSkip := opts.WrapFile, opts.OnFile, opts.OnSkip
+	opts.WrapFile = func(ctx context.Context, path string, do func(context.Context) error) error {
+		var err error
+		if wrap != nil {
+			err = wrap(ctx, path, do)
+		} else {
+			err = do(ctx)
+		}
+		if err != nil {
+			add(Event{Time: time.Now().UTC(), Op: op, Path: path, Error: err.Error()})
+		}
+		return err
+	}
+	opts.OnFile = func(path string, n int) {
+		add(Event{Time: time.Now().UTC(), Op: op, Path: path, Bytes: n})
+		if onFile != nil {
+			onFile(path, n)
+		}
+	}
+	opts.OnSkip = func(path string, reason error) {
+		add(Event{Time: time.Now().UTC(), Op: op, Path: path, Skipped: reason.Error()})
+		if onSkip != nil {
+			onSkip(path, reason)
+		}
+	}
+	return opts, func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		return errors.Join(errs...)
+	}
+}
@@ -0,0 +1,146 @@
+package audit_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/spf13/afero"
+
+	"github.com/dcadolph/cipher"
+	"github.com/dcadolph/cipher/audit"
+	"github.com/dcadolph/cipher/ciphertest"
+)
+
+// memorySink collects events in memory.
+type memorySink struct {
+	mu     sync.Mutex
+	events []audit.Event
+}
+
+// Record appends e.
+func (s *memorySink) Record(_ context.Context, e audit.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, e)
+	return nil
+}
+
+// ignoreTime drops Event.Time from comparisons.
+var ignoreTime = cmpopts.IgnoreFields(audit.Event{}, "Time")
+
+// TestWrapEncoderDecoder records encode, decode, rotate, and recipient
+// events with the file's recipients.
+func TestWrapEncoderDecoder(t *testing.T) {
+	ctx := context.Background()
+	kp, recipient := ciphertest.NewProvider(t)
+	_, other := ciphertest.NewAgeKeyPair(t)
+	sink := &memorySink{}
+	enc := audit.WrapEncoder(cipher.NewEncoder(kp), sink)
+	dec := audit.WrapDecoder(cipher.NewDecoder(), sink)
+
+	data, err := enc.Encode(ctx, "s.yaml", []byte("a: 1\n"))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	plain, err := dec.Decode(ctx, "s.yaml", data)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if _, err := dec.Decode(ctx, "p.yaml", []byte("a: 1\n")); !errors.Is(err, cipher.ErrNotEncrypted) {
+		t.Fatalf("Decode plain err = %v, want ErrNotEncrypted", err)
+	}
+	rotated, err := audit.Rotate(ctx, sink, "s.yaml", data, cipher.NewEncoder(kp), cipher.NewDecoder())
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	added, err := audit.AddRecipient(ctx, sink, "s.yaml", rotated,
+		ciphertest.MustAgeProvider(t, other), cipher.AddRecipientOptions{})
+	if err != nil {
+		t.Fatalf("AddRecipient: %v", err)
+	}
+	removed, err := audit.RemoveRecipient(ctx, sink, "s.yaml", added,
+		[]string{other}, cipher.RemoveRecipientOptions{})
+	if err != nil {
+		t.Fatalf("RemoveRecipient: %v", err)
+	}
+
+	want := []audit.Event{
+		{Op: audit.OpEncode, Path: "s.yaml", Recipients: []string{recipient}, Bytes: len(data)},
+		{Op: audit.OpDecode, Path: "s.yaml", Recipients: []string{recipient}, Bytes: len(plain)},
+		{Op: audit.OpDecode, Path: "p.yaml", Error: cipher.ErrNotEncrypted.Error()},
+		{Op: audit.OpRotate, Path: "s.yaml", Recipients: []string{recipient}, Bytes: len(rotated)},
+		{Op: audit.OpAddRecipient, Path: "s.yaml", Recipients: []string{recipient, other}, Bytes: len(added)},
+		{Op: audit.OpRemoveRecipient, Path: "s.yaml", Recipients: []string{recipient}, Bytes: len(removed)},
+	}
+	if diff := cmp.Diff(want, sink.events, ignoreTime); diff != "" {
+		t.Errorf("events mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// TestWrapDecoderSinkFailure withholds plaintext when the event cannot
+// be recorded.
+func TestWrapDecoderSinkFailure(t *testing.T) {
+	ctx := context.Background()
+	kp, _ := ciphertest.NewProvider(t)
+	data, err := cipher.NewEncoder(kp).Encode(ctx, "s.yaml", []byte("a: 1\n"))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	failing := audit.AuditSinkFunc(func(context.Context, audit.Event) error {
+		return errors.New("disk full")
+	})
+	out, err := audit.WrapDecoder(cipher.NewDecoder(), failing).Decode(ctx, "s.yaml", data)
+	if !errors.Is(err, audit.ErrRecord) || out != nil {
+		t.Errorf("Decode = %q, %v; want nil, ErrRecord", out, err)
+	}
+}
+
+// TestWalkOptions records processed, skipped, and failed files of a
+// walk.
+func TestWalkOptions(t *testing.T) {
+	ctx := context.Background()
+	kp, _ := ciphertest.NewProvider(t)
+	files := afero.NewMemMapFs()
+	enc, err := cipher.NewEncoder(kp).Encode(ctx, "b.yaml", []byte("b: 2\n"))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	for path, data := range map[string][]byte{
+		"root/a.yaml": []byte("a: 1\n"), "root/b.yaml": enc, "root/c.yaml": []byte("c: [\n"),
+	} {
+		if err := afero.WriteFile(files, path, data, 0o600); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	sink := &memorySink{}
+	var visited int
+	opts, recordErr := audit.WalkOptions(ctx, sink, audit.OpEncode, "root",
+		cipher.WalkOptions{OnFile: func(string, int) { visited++ }, ContinueOnError: true})
+	res, err := cipher.EncodeWalkWith(ctx, files, "root", cipher.NewEncoder(kp), nil, opts)
+	if err == nil || res == nil || res.Failed() != 1 {
+		t.Fatalf("EncodeWalkWith: err = %v, want one failed file", err)
+	}
+	if err := recordErr(); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if visited != 1 {
+		t.Errorf("OnFile calls = %d, want 1", visited)
+	}
+	want := []audit.Event{
+		{Op: audit.OpEncode, Path: "root/a.yaml", Walk: "root"},
+		{Op: audit.OpEncode, Path: "root/b.yaml", Walk: "root", Skipped: cipher.ErrAlreadyEncrypted.Error()},
+		{Op: audit.OpEncode, Path: "root/c.yaml", Walk: "root"},
+	}
+	if diff := cmp.Diff(want, sink.events, ignoreTime, cmpopts.IgnoreFields(audit.Event{}, "Bytes", "Error")); diff != "" {
+		t.Errorf("events mismatch (-want +got):\n%s", diff)
+	}
+	for _, e := range sink.events {
+		if (e.Error != "") != (e.Path == "root/c.yaml") {
+			t.Errorf("event for %s has Error %q, want it set only for the failed file", e.Path, e.Error)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/spf13/afero"
+)
+
+// ErrTampered signals that an audit log entry was edited, reordered, or
+// removed, or that the log is not an audit log.
+var ErrTampered = errors.New("audit: log tampered")
+
+// ErrTruncated signals that an audit log ends in a partially written
+// entry or lacks an expected anchor entry.
+var ErrTruncated = errors.New("audit: log truncated")
+
+// Entry is one line of a FileSink log: an Event with its position in
+// the hash chain.
+type Entry struct {
+	// Seq is the entry's 1-based position in the log.
+	Seq int `json:"seq"`
+	Event
+	// Prev is the Hash of the previous entry, empty for the first.
+	Prev string `json:"prev"`
+	// Hash is the hex SHA-256 of the entry encoded with an empty Hash.
+	Hash string `json:"hash"`
+}
+
+// hash returns the chain hash of e, ignoring e.Hash.
+func (e Entry) hash() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// FileSink is an AuditSink that appends events to a JSONL file, one
+// Entry per line, each chained to the one before it by hash. Only one
+// FileSink, in one process, may write a given file at a time; a second
+// writer forks the chain and the log no longer verifies.
+type FileSink struct {
+	mu   sync.Mutex
+	file afero.File
+	seq  int
+	head string
+}
+
+// NewFileSink opens the log at path on files for appending, creating it
+// with mode 0600 if it does not exist. An existing log is verified
+// first, and a log that fails verification is refused so a tampered or
+// truncated chain is never extended.
+func NewFileSink(files afero.Fs, path string) (*FileSink, error) {
+	if files == nil {
+		panic("audit: NewFileSink: filesystem required")
+	}
+	f, err := files.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("audit: open %q: %w", path, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("audit: seek %q: %w", path, err)
+	}
+	res, err := Verify(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("audit: %q: %w", path, err)
+	}
+	return &FileSink{file: f, seq: res.Entries, head: res.Head}, nil
+}
+
+// Record appends e to the log and syncs the file.
+func (s *FileSink) Record(_ context.Context, e Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return errors.New("audit: sink closed")
+	}
+	entry := Entry{Seq: s.seq + 1, Event: e, Prev: s.head}
+	hash, err := entry.hash()
+	if err != nil {
+		return fmt.Errorf("audit: encode entry: %w", err)
+	}
+	entry.Hash = hash
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("audit: encode entry: %w", err)
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("audit: write: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("audit: sync: %w", err)
+	}
+	s.seq, s.head = entry.Seq, hash
+	return nil
+}
+
+// Head returns the hash of the last entry written, empty for an empty
+// log. Store it elsewhere and pass it to VerifyWith as an anchor to
+// detect entries removed from the end of the log.
+func (s *FileSink) Head() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.head
+}
+
+// Close closes the log file. Record fails after Close.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// VerifyOptions tunes VerifyWith.
+type VerifyOptions struct {
+	// Anchor, when non-empty, is an entry hash recorded earlier, such as
+	// a FileSink.Head value. Verification fails with ErrTruncated unless
+	// an entry with this hash is in the log.
+	Anchor string
+}
+
+// VerifyResult summarizes a verified log.
+type VerifyResult struct {
+	// Entries is the number of entries in the log.
+	Entries int
+	// Head is the hash of the last entry, empty for an empty log.
+	Head string
+}
+
+// Verify reads a FileSink log from r and checks that every entry is
+// intact and chained to the one before it.
+func Verify(r io.Reader) (VerifyResult, error) {
+	return VerifyWith(r, VerifyOptions{})
+}
+
+// VerifyWith is Verify with explicit options. An edited, reordered, or
+// removed entry fails with ErrTampered and a final line without a
+// newline fails with ErrTruncated, each naming the first bad line.
+//
+// Entries removed from the end of the log leave a valid chain. Pass a
+// previously recorded head as opts.Anchor to detect that.
+func VerifyWith(r io.Reader, opts VerifyOptions) (VerifyResult, error) {
+	var res VerifyResult
+	anchored := opts.Anchor == ""
+	br := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, err := br.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				return res, fmt.Errorf("%w: line %d: partial entry", ErrTruncated, n)
+			}
+			break
+		}
+		if err != nil {
+			return res, fmt.Errorf("audit: read: %w", err)
+		}
+		line = line[:len(line)-1]
+
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return res, fmt.Errorf("%w: line %d: %w", ErrTampered, n, err)
+		}
+		if canonical, err := json.Marshal(entry); err != nil || !bytes.Equal(canonical, line) {
+			return res, fmt.Errorf("%w: line %d: not in canonical form", ErrTampered, n)
+		}
+		switch hash, err := entry.hash(); {
+		case err != nil:
+			return res, fmt.Errorf("%w: line %d: %w", ErrTampered, n, err)
+		case entry.Seq != n:
+			return res, fmt.Errorf("%w: line %d: sequence %d", ErrTampered, n, entry.Seq)
+		case entry.Prev != res.Head:
+			return res, fmt.Errorf("%w: line %d: chain broken", ErrTampered, n)
+		case entry.Hash != hash:
+			return res, fmt.Errorf("%w: line %d: hash mismatch", ErrTampered, n)
+		}
+		res.Entries, res.Head = n, entry.Hash
+		anchored = anchored || entry.Hash == opts.Anchor
+	}
+	if !anchored {
+		return res, fmt.Errorf("%w: anchor %s not found", ErrTruncated, opts.Anchor)
+	}
+	return res, nil
+}
@@ -0,0 +1,118 @@
+package audit_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/dcadolph/cipher/audit"
+)
+
+// writeLog records n events to a fresh log and returns its contents
+// and head hash.
+func writeLog(t *testing.T, n int) (string, string) {
+	t.Helper()
+	files := afero.NewMemMapFs()
+	sink, err := audit.NewFileSink(files, "audit.jsonl")
+	if err != nil {
+		t.Fatalf("NewFileSink: %v", err)
+	}
+	for i := range n {
+		e := audit.Event{Time: time.Unix(int64(i), 0).UTC(), Op: audit.OpDecode, Path: "s.yaml", Bytes: i}
+		if err := sink.Record(context.Background(), e); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+	head := sink.Head()
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	data, err := afero.ReadFile(files, "audit.jsonl")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return string(data), head
+}
+
+// TestVerify checks intact logs and each kind of tampering.
+func TestVerify(t *testing.T) {
+	log, head := writeLog(t, 3)
+	lines := strings.SplitAfter(log, "\n")
+
+	tests := []struct {
+		Name    string
+		Log     string
+		Anchor  string
+		Want    int
+		WantErr error
+	}{{ // Test 0: An intact log verifies.
+		Name: "intact", Log: log, Want: 3,
+	}, { // Test 1: An empty log verifies.
+		Name: "empty", Log: "",
+	}, { // Test 2: An edited value breaks the hash.
+		Name: "edited", Log: strings.Replace(log, `"bytes":1`, `"bytes":9`, 1), WantErr: audit.ErrTampered,
+	}, { // Test 3: A removed middle entry breaks the chain.
+		Name: "removed", Log: lines[0] + lines[2], WantErr: audit.ErrTampered,
+	}, { // Test 4: Reordered entries break the sequence.
+		Name: "reordered", Log: lines[1] + lines[0] + lines[2], WantErr: audit.ErrTampered,
+	}, { // Test 5: A partial last line is truncation.
+		Name: "partial", Log: log[:len(log)-10], WantErr: audit.ErrTruncated,
+	}, { // Test 6: Whitespace changes are not canonical.
+		Name: "reformatted", Log: strings.Replace(log, `"op":`, `"op": `, 1), WantErr: audit.ErrTampered,
+	}, { // Test 7: The anchor is found in an intact log.
+		Name: "anchor", Log: log, Anchor: head, Want: 3,
+	}, { // Test 8: Dropped trailing entries lose the anchor.
+		Name: "tail dropped", Log: lines[0] + lines[1], Anchor: head, Want: 2, WantErr: audit.ErrTruncated,
+	}}
+
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			res, err := audit.VerifyWith(strings.NewReader(test.Log), audit.VerifyOptions{Anchor: test.Anchor})
+			if !errors.Is(err, test.WantErr) || (test.WantErr == nil && err != nil) {
+				t.Fatalf("VerifyWith err = %v, want %v", err, test.WantErr)
+			}
+			if test.WantErr == nil && res.Entries != test.Want {
+				t.Errorf("Entries = %d, want %d", res.Entries, test.Want)
+			}
+		})
+	}
+}
+
+// TestFileSinkReopen appends to an existing log and refuses a tampered
+// one.
+func TestFileSinkReopen(t *testing.T) {
+	files := afero.NewMemMapFs()
+	for range 2 {
+		sink, err := audit.NewFileSink(files, "audit.jsonl")
+		if err != nil {
+			t.Fatalf("NewFileSink: %v", err)
+		}
+		if err := sink.Record(context.Background(), audit.Event{Op: audit.OpEncode, Path: "a.yaml"}); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	}
+	data, err := afero.ReadFile(files, "audit.jsonl")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	res, err := audit.Verify(strings.NewReader(string(data)))
+	if err != nil || res.Entries != 2 {
+		t.Fatalf("Verify = %+v, %v; want 2 entries", res, err)
+	}
+
+	tampered := strings.Replace(string(data), "a.yaml", "b.yaml", 1)
+	if err := afero.WriteFile(files, "audit.jsonl", []byte(tampered), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := audit.NewFileSink(files, "audit.jsonl"); !errors.Is(err, audit.ErrTampered) {
+		t.Errorf("NewFileSink on tampered log err = %v, want ErrTampered", err)
+	}
+}
@@ -212,7 +212,7 @@ func BenchmarkEncodeWalkParallelism(b *testing.B) {
 				b.StopTimer()
 				fs := prepFS()
 				b.StartTimer()
-				err := cipher.EncodeWalkWith(ctx, fs, "/root", enc, nil,
+				_, err := cipher.EncodeWalkWith(ctx, fs, "/root", enc, nil,
 					cipher.WalkOptions{Parallelism: c.Parallelism})
 				if err != nil {
 					b.Fatalf("EncodeWalkWith: %v", err)
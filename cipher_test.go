@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
@@ -212,7 +213,7 @@ func TestEncodeWalkBackupSuffix(t *testing.T) {
 		t.Fatalf("write: %v", err)
 	}
 
-	err := cipher.EncodeWalkWith(
+	_, err := cipher.EncodeWalkWith(
 		context.Background(), files, "root", enc,
 		[]cipher.FileMatcher{cipher.MatchExt("yaml")},
 		cipher.WalkOptions{BackupSuffix: ".bak"},
@@ -250,7 +251,7 @@ func TestEncodeWalkParallel(t *testing.T) {
 	}
 
 	opts := cipher.WalkOptions{Parallelism: 4}
-	if err := cipher.EncodeWalkWith(
+	if _, err := cipher.EncodeWalkWith(
 		context.Background(), files, "root", enc,
 		[]cipher.FileMatcher{cipher.MatchExt("yaml")}, opts,
 	); err != nil {
@@ -287,12 +288,132 @@ func TestEncodeWalkCtxCancelled(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
 
-	err := cipher.EncodeWalk(ctx, files, "root", enc, []cipher.FileMatcher{cipher.MatchExt("yaml")})
+	_, err := cipher.EncodeWalk(ctx, files, "root", enc, []cipher.FileMatcher{cipher.MatchExt("yaml")})
 	if !errors.Is(err, context.Canceled) {
 		t.Fatalf("err = %v, want errors.Is context.Canceled", err)
 	}
 }
 
+// TestEncodeWalkWrapFile verifies that WrapFile wraps the work on
+// each matched file, passes its context through, and sees the file's
+// error.
+func TestEncodeWalkWrapFile(t *testing.T) {
+	type ctxKey struct{}
+	boom := errors.New("boom")
+	files := afero.NewMemMapFs()
+	for _, p := range []string{"root/a.yaml", "root/b.yaml", "root/c.txt"} {
+		if err := afero.WriteFile(files, p, []byte("foo: bar\n"), 0o600); err != nil {
+			t.Fatalf("write %q: %v", p, err)
+		}
+	}
+	enc := cipher.EncoderFunc(func(ctx context.Context, path string, data []byte) ([]byte, error) {
+		if ctx.Value(ctxKey{}) != path {
+			t.Errorf("Encode %q: context not from WrapFile", path)
+		}
+		if path == "root/b.yaml" {
+			return nil, boom
+		}
+		return data, nil
+	})
+
+	var wrapped []string
+	var errs []error
+	opts := cipher.WalkOptions{
+		WrapFile: func(ctx context.Context, path string, do func(context.Context) error) error {
+			wrapped = append(wrapped, path)
+			err := do(context.WithValue(ctx, ctxKey{}, path))
+			errs = append(errs, err)
+			return err
+		},
+	}
+	_, err := cipher.EncodeWalkWith(context.Background(), files, "root", enc,
+		[]cipher.FileMatcher{cipher.MatchExt("yaml")}, opts)
+	if !errors.Is(err, boom) {
+		t.Fatalf("err = %v, want boom", err)
+	}
+	if diff := cmp.Diff([]string{"root/a.yaml", "root/b.yaml"}, wrapped); diff != "" {
+		t.Errorf("wrapped paths (-want +got):\n%s", diff)
+	}
+	if len(errs) != 2 || errs[0] != nil || !errors.Is(errs[1], boom) {
+		t.Errorf("WrapFile saw errors %v, want [nil boom]", errs)
+	}
+}
+
+// TestEncodeWalkContinueOnError verifies that the WalkResult lists
+// every visited file's outcome, and that ContinueOnError carries the
+// walk past a failed file and joins the failures.
+func TestEncodeWalkContinueOnError(t *testing.T) {
+	t.Parallel()
+	boom := errors.New("boom")
+	enc := cipher.EncoderFunc(func(_ context.Context, path string, data []byte) ([]byte, error) {
+		switch path {
+		case "root/b.yaml":
+			return nil, boom
+		case "root/d.json":
+			return nil, cipher.ErrAlreadyEncrypted
+		}
+		return append([]byte("enc:"), data...), nil
+	})
+	matchers := []cipher.FileMatcher{cipher.MatchExt("yaml", "json")}
+	head := []cipher.FileResult{
+		{Path: "root/a.yaml", Outcome: cipher.FileProcessed, Bytes: 9},
+		{Path: "root/b.yaml", Outcome: cipher.FileFailed, Err: boom},
+	}
+	tail := []cipher.FileResult{
+		{Path: "root/c.yaml", Outcome: cipher.FileProcessed, Bytes: 9},
+		{Path: "root/d.json", Outcome: cipher.FileSkipped, Err: cipher.ErrAlreadyEncrypted},
+		{Path: "root/e.txt", Outcome: cipher.FileSkipped, Err: cmpopts.AnyError},
+	}
+
+	tests := []struct {
+		Name string
+		Opts cipher.WalkOptions
+		Want []cipher.FileResult
+	}{
+		// Test 0: The walk stops at the failed file and reports the
+		// files visited before it, plus matcher skips.
+		{
+			Name: "stop",
+			Opts: cipher.WalkOptions{},
+			Want: append(slices.Clone(head), tail[2]),
+		},
+		// Test 1: ContinueOnError attempts every file.
+		{
+			Name: "continue",
+			Opts: cipher.WalkOptions{ContinueOnError: true},
+			Want: append(slices.Clone(head), tail...),
+		},
+		// Test 2: ContinueOnError attempts every file in parallel.
+		{
+			Name: "continue parallel",
+			Opts: cipher.WalkOptions{ContinueOnError: true, Parallelism: 4},
+			Want: append(slices.Clone(head), tail...),
+		},
+	}
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			t.Parallel()
+			files := afero.NewMemMapFs()
+			for _, p := range []string{"root/a.yaml", "root/b.yaml", "root/c.yaml", "root/d.json", "root/e.txt"} {
+				if err := afero.WriteFile(files, p, []byte("k: v\n"), 0o600); err != nil {
+					t.Fatalf("write %q: %v", p, err)
+				}
+			}
+			res, err := cipher.EncodeWalkWith(context.Background(), files, "root", enc, matchers, test.Opts)
+			if !errors.Is(err, boom) {
+				t.Fatalf("err = %v, want boom", err)
+			}
+			if diff := cmp.Diff(test.Want, res.Files, cmpopts.EquateErrors(),
+				cmpopts.IgnoreFields(cipher.FileResult{}, "Duration")); diff != "" {
+				t.Errorf("files (-want +got):\n%s", diff)
+			}
+			if got := res.Failed(); got != 1 {
+				t.Errorf("Failed() = %d, want 1", got)
+			}
+		})
+	}
+}
+
 // TestChainEncoders verifies that ChainEncoders feeds output through each
 // encoder in sequence.
 func TestChainEncoders(t *testing.T) {
@@ -358,7 +479,7 @@ func TestEncodeWalkAndDecodeWalk(t *testing.T) {
 	encOpts := cipher.WalkOptions{
 		OnFile: func(p string, _ int) { encoded = append(encoded, filepath.ToSlash(p)) },
 	}
-	if err := cipher.EncodeWalkWith(ctx, files, "root", enc, matchers, encOpts); err != nil {
+	if _, err := cipher.EncodeWalkWith(ctx, files, "root", enc, matchers, encOpts); err != nil {
 		t.Fatalf("encode walk: %v", err)
 	}
 
@@ -391,7 +512,7 @@ func TestEncodeWalkAndDecodeWalk(t *testing.T) {
 			}
 		},
 	}
-	if err := cipher.EncodeWalkWith(ctx, files, "root", enc, matchers, skipOpts); err != nil {
+	if _, err := cipher.EncodeWalkWith(ctx, files, "root", enc, matchers, skipOpts); err != nil {
 		t.Fatalf("second encode walk: %v", err)
 	}
 	if diff := cmp.Diff(wantEncoded, skipped, cmpopts.SortSlices(stringLess)); diff != "" {
@@ -399,7 +520,7 @@ func TestEncodeWalkAndDecodeWalk(t *testing.T) {
 	}
 
 	// Decode walk should round-trip back to original contents.
-	if err := cipher.DecodeWalk(ctx, files, "root", dec, matchers); err != nil {
+	if _, err := cipher.DecodeWalk(ctx, files, "root", dec, matchers); err != nil {
 		t.Fatalf("decode walk: %v", err)
 	}
 	yaml, _ := afero.ReadFile(files, "root/a.yaml")
@@ -422,7 +543,7 @@ func TestEncodeWalkPanicsOnNilDeps(t *testing.T) {
 				t.Fatal("expected panic on nil filesystem")
 			}
 		}()
-		_ = cipher.EncodeWalk(context.Background(), nil, ".", cipher.NewEncoder(
+		_, _ = cipher.EncodeWalk(context.Background(), nil, ".", cipher.NewEncoder(
 			cipher.StaticKeyProvider(),
 		), nil)
 	})
@@ -433,7 +554,7 @@ func TestEncodeWalkPanicsOnNilDeps(t *testing.T) {
 				t.Fatal("expected panic on nil encoder")
 			}
 		}()
-		_ = cipher.EncodeWalk(context.Background(), afero.NewMemMapFs(), ".", nil, nil)
+		_, _ = cipher.EncodeWalk(context.Background(), afero.NewMemMapFs(), ".", nil, nil)
 	})
 }
 
@@ -447,7 +568,7 @@ func TestDecodeWalkPanicsOnNilDeps(t *testing.T) {
 				t.Fatal("expected panic on nil filesystem")
 			}
 		}()
-		_ = cipher.DecodeWalk(context.Background(), nil, ".", cipher.NewDecoder(), nil)
+		_, _ = cipher.DecodeWalk(context.Background(), nil, ".", cipher.NewDecoder(), nil)
 	})
 	t.Run("nil decoder", func(t *testing.T) {
 		t.Parallel()
@@ -456,7 +577,7 @@ func TestDecodeWalkPanicsOnNilDeps(t *testing.T) {
 				t.Fatal("expected panic on nil decoder")
 			}
 		}()
-		_ = cipher.DecodeWalk(context.Background(), afero.NewMemMapFs(), ".", nil, nil)
+		_, _ = cipher.DecodeWalk(context.Background(), afero.NewMemMapFs(), ".", nil, nil)
 	})
 }
 
@@ -3,7 +3,7 @@
 // It hides the env-variable plumbing required for age round-trips and
 // exposes a few short-form assertion helpers.
 //
-// # No parallel tests
+// # Parallel tests
 //
 // Tests using helpers that mutate the SOPS_AGE_KEY environment
 // variable must not call t.Parallel. The helpers set the env via
@@ -11,13 +11,19 @@
 // would race on the same env slot. The doc comment on each helper
 // repeats this constraint.
 //
-// # Two helpers
+// [NewAgeKeyPair] leaves the environment alone. Hand its identity to
+// [cipher.DecoderOptions.AgeIdentities] and the test may run in
+// parallel with others holding different identities.
+//
+// # Helpers
 //
 //   - [NewAgeIdentity] generates a fresh age identity, registers
 //     SOPS_AGE_KEY via t.Setenv, and returns the public recipient.
 //   - [NewProvider] wraps NewAgeIdentity and returns a working
 //     [cipher.KeyProvider]. The provider yields a single age recipient
 //     whose private identity is already in the env.
+//   - [NewAgeKeyPair] returns a fresh identity and recipient without
+//     touching the env.
 //
 // # AssertRoundTrip
 //
@@ -37,6 +43,19 @@
 //	    ciphertest.AssertRoundTrip(t, context.Background(), enc, dec,
 //	        "secrets.yaml", []byte("foo: bar\n"), "foo: bar")
 //	}
+//
+// The same test without process state, safe for t.Parallel:
+//
+//	func TestMyHandlerParallel(t *testing.T) {
+//	    t.Parallel()
+//	    identity, recipient := ciphertest.NewAgeKeyPair(t)
+//	    enc := cipher.NewEncoder(ciphertest.MustAgeProvider(t, recipient))
+//	    dec := cipher.NewDecoderWith(cipher.DecoderOptions{
+//	        AgeIdentities: []string{identity},
+//	    })
+//	    ciphertest.AssertRoundTrip(t, context.Background(), enc, dec,
+//	        "secrets.yaml", []byte("foo: bar\n"), "foo: bar")
+//	}
 package ciphertest
 
 import (
@@ -74,6 +93,19 @@ func NewAgeIdentity(t testing.TB) (recipient string) {
 	return id.Recipient().String()
 }
 
+// NewAgeKeyPair generates a fresh age identity and returns its secret
+// key string and public recipient. Unlike NewAgeIdentity it does not
+// touch the environment, so callers may use t.Parallel. Pass identity
+// to cipher.DecoderOptions.AgeIdentities to decrypt.
+func NewAgeKeyPair(t testing.TB) (identity, recipient string) {
+	t.Helper()
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("ciphertest: generate identity: %v", err)
+	}
+	return id.String(), id.Recipient().String()
+}
+
 // NewProvider is a convenience that combines NewAgeIdentity with
 // cipherage.NewProvider. The returned KeyProvider yields a single age
 // recipient for which the decryption identity is already in the env.
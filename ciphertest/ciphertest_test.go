@@ -63,3 +63,16 @@ func TestMustAgeProviderConstructs(t *testing.T) {
 		t.Fatalf("group size = %d, want 1", len(groups[0]))
 	}
 }
+
+// TestNewAgeKeyPairParallel round-trips with an in-memory identity
+// and no env, so it may run in parallel.
+func TestNewAgeKeyPairParallel(t *testing.T) {
+	t.Parallel()
+	identity, recipient := ciphertest.NewAgeKeyPair(t)
+	enc := cipher.NewEncoder(ciphertest.MustAgeProvider(t, recipient))
+	dec := cipher.NewDecoderWith(cipher.DecoderOptions{AgeIdentities: []string{identity}})
+	ciphertest.AssertRoundTrip(
+		t, context.Background(), enc, dec,
+		"x.yaml", []byte("foo: bar\n"), "foo: bar",
+	)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/dcadolph/cipher/audit"
+)
+
+// newAuditCmd returns the `cipher audit` command group.
+func newAuditCmd() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "audit",
+		Short: "Inspect audit logs written by the audit package",
+	}
+	root.AddCommand(newAuditVerifyCmd())
+	return root
+}
+
+// newAuditVerifyCmd returns `cipher audit verify LOG`. It checks the
+// hash chain of a JSONL log written by audit.FileSink and exits
+// non-zero when an entry was edited, reordered, removed, or partially
+// written.
+func newAuditVerifyCmd() *cobra.Command {
+	var anchor string
+	cmd := &cobra.Command{
+		Use:   "verify LOG",
+		Short: "Verify the hash chain of an audit log",
+		Long: "verify reads LOG and checks that every entry is intact and chained\n" +
+			"to the one before it. On success it prints the entry count and the\n" +
+			"head hash. Record the head elsewhere and pass it as --anchor later\n" +
+			"to also detect entries removed from the end of the log.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := args[0]
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			res, err := audit.VerifyWith(f, audit.VerifyOptions{Anchor: anchor})
+			if err != nil {
+				return fmt.Errorf("verify %q: %w", path, err)
+			}
+			head := res.Head
+			if head == "" {
+				head = "(empty)"
+			}
+			_, err = fmt.Fprintf(cmd.OutOrStdout(), "%s: %d entries, head %s\n", path, res.Entries, head)
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&anchor, "anchor", "",
+		"hash of an earlier head that must still be in the log")
+	return cmd
+}
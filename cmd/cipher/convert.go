@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/dcadolph/cipher"
+)
+
+// newConvertCmd returns the `cipher convert` subcommand. It re-emits
+// an encrypted file in another format under the same data key and
+// recipients.
+func newConvertCmd() *cobra.Command {
+	var from, to, output string
+	ks := &keyServiceFlags{}
+	cmd := &cobra.Command{
+		Use:   "convert PATH",
+		Short: "Convert an encrypted file to another format without re-wrapping keys",
+		Long: "Convert re-emits an encrypted file in another format, keeping\n" +
+			"the data key and recipient metadata. --from defaults to PATH's\n" +
+			"extension and --to to the --output extension.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := args[0]
+			src, err := formatFlag("--from", from, path)
+			if err != nil {
+				return err
+			}
+			dst, err := formatFlag("--to", to, output)
+			if err != nil {
+				return err
+			}
+			services, closeKS, err := ks.dial()
+			if err != nil {
+				return err
+			}
+			defer closeKS()
+			data, err := readPathOrStdin(path)
+			if err != nil {
+				return err
+			}
+			out, err := cipher.ConvertWith(cmd.Context(), data, src, dst,
+				cipher.DecoderOptions{KeyServices: services})
+			if err != nil {
+				return fmt.Errorf("convert %q: %w", path, err)
+			}
+			if output == "" {
+				output = "-"
+			}
+			return writePathOrStdout(output, out)
+		},
+	}
+	cmd.Flags().StringVar(&from, "from", "", "input format: yaml, json, toml, ini, dotenv, or binary")
+	cmd.Flags().StringVar(&to, "to", "", "output format: yaml, json, toml, ini, dotenv, or binary")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "write the converted file to this path")
+	ks.bind(cmd.Flags())
+	return cmd
+}
+
+// formatFlag resolves a format flag: the named format when set,
+// otherwise the format of path's extension. flag names the flag in
+// errors.
+func formatFlag(flag, name, path string) (cipher.Format, error) {
+	switch name {
+	case "yaml", "json", "toml", "ini", "dotenv", "binary":
+		return cipher.FormatFromString(name), nil
+	case "":
+		if path == "" || path == "-" {
+			return 0, fmt.Errorf("%s is required when there is no file name to infer it from", flag)
+		}
+		return cipher.FormatForPath(path), nil
+	default:
+		return 0, errors.New(flag + ": format must be yaml, json, toml, ini, dotenv, or binary")
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/spf13/cobra"
 
@@ -10,49 +11,79 @@ import (
 
 // newDecryptCmd returns the `cipher decrypt` subcommand. Decryption
 // does not need recipient flags: sops resolves identities from the
-// standard env-based locations.
+// standard env-based locations, or through --keyservice.
 func newDecryptCmd() *cobra.Command {
-	var inPlace bool
+	var inPlace, stream, partial bool
 	var output, extract string
+	ks := &keyServiceFlags{}
 	cmd := &cobra.Command{
 		Use:   "decrypt PATH",
 		Short: "Decrypt a single sops-encrypted file",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			path := args[0]
+			dst, err := outputPath(path, output, inPlace)
+			if err != nil {
+				return err
+			}
+			services, closeKS, err := ks.dial()
+			if err != nil {
+				return err
+			}
+			defer closeKS()
+			if stream {
+				if extract != "" || partial {
+					return fmt.Errorf("--extract and --partial are incompatible with --stream")
+				}
+				dec := cipher.NewStreamDecoderWith(cipher.StreamDecoderOptions{KeyServices: services})
+				err := streamPathOrStdio(path, dst, func(w io.Writer, r io.Reader) error {
+					return dec.DecodeStream(cmd.Context(), path, w, r)
+				})
+				if err != nil {
+					return fmt.Errorf("decrypt %q: %w", path, err)
+				}
+				return nil
+			}
 			data, err := readPathOrStdin(path)
 			if err != nil {
 				return err
 			}
-			dec := cipher.NewDecoder()
+			if extract != "" && inPlace {
+				return fmt.Errorf("--extract is incompatible with --in-place")
+			}
+			if partial {
+				if extract == "" {
+					return fmt.Errorf("--partial requires --extract")
+				}
+				value, err := cipher.DecodeValueWith(cmd.Context(), path, data, extract,
+					cipher.DecoderOptions{KeyServices: services})
+				if err != nil {
+					return fmt.Errorf("decrypt %q: %w", path, err)
+				}
+				return writePathOrStdout(dst, value)
+			}
+			dec := cipher.NewDecoderWith(cipher.DecoderOptions{KeyServices: services})
 			plain, err := dec.Decode(cmd.Context(), path, data)
 			if err != nil {
 				return fmt.Errorf("decrypt %q: %w", path, err)
 			}
 			if extract != "" {
-				if inPlace {
-					return fmt.Errorf("--extract is incompatible with --in-place")
-				}
 				plain, err = extractValue(path, plain, extract)
 				if err != nil {
 					return fmt.Errorf("decrypt %q: %w", path, err)
 				}
 			}
-			switch {
-			case inPlace && path == "-":
-				return fmt.Errorf("--in-place is incompatible with stdin (path \"-\")")
-			case inPlace:
-				return writePathOrStdout(path, plain)
-			case output != "":
-				return writePathOrStdout(output, plain)
-			default:
-				return writePathOrStdout("-", plain)
-			}
+			return writePathOrStdout(dst, plain)
 		},
 	}
 	cmd.Flags().BoolVarP(&inPlace, "in-place", "i", false, "write plaintext back to PATH")
 	cmd.Flags().StringVarP(&output, "output", "o", "", "write plaintext to this path")
+	cmd.Flags().BoolVar(&stream, "stream", false,
+		"decrypt a stream written by `cipher encrypt --stream`")
 	cmd.Flags().StringVar(&extract, "extract", "",
 		`extract a sub-value by path, e.g. '["db"]["password"]' or '["hosts"][0]'`)
+	cmd.Flags().BoolVar(&partial, "partial", false,
+		"with --extract, decrypt only the selected value; the file MAC is NOT checked")
+	ks.bind(cmd.Flags())
 	return cmd
 }
@@ -2,23 +2,52 @@ package main
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/spf13/cobra"
 )
 
 // newEncryptCmd returns the `cipher encrypt` subcommand.
 func newEncryptCmd() *cobra.Command {
-	var inPlace bool
+	var inPlace, stream bool
 	var output string
+	var chunkSize int
 	flags := &providerFlags{}
+	ks := &keyServiceFlags{}
 	cmd := &cobra.Command{
 		Use:   "encrypt PATH",
 		Short: "Encrypt a single file",
 		Long: "Encrypt PATH with sops and write the result to PATH (with --in-place),\n" +
-			"to --output, or to stdout (default). Use PATH == \"-\" to read stdin.",
+			"to --output, or to stdout (default). Use PATH == \"-\" to read stdin.\n\n" +
+			"With --stream, PATH is treated as opaque bytes and encrypted in\n" +
+			"fixed-size chunks so memory use stays bounded for very large inputs.\n" +
+			"Decrypt the result with `cipher decrypt --stream`.",
 		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			path := args[0]
+			dst, err := outputPath(path, output, inPlace)
+			if err != nil {
+				return err
+			}
+			services, closeKS, err := ks.dial()
+			if err != nil {
+				return err
+			}
+			defer closeKS()
+			flags.keyServices = services
+			if stream {
+				enc, err := flags.resolveStreamEncoder(chunkSize)
+				if err != nil {
+					return err
+				}
+				err = streamPathOrStdio(path, dst, func(w io.Writer, r io.Reader) error {
+					return enc.EncodeStream(cmd.Context(), path, w, r)
+				})
+				if err != nil {
+					return fmt.Errorf("encrypt %q: %w", path, err)
+				}
+				return nil
+			}
 			enc, err := flags.resolveEncoder(cmd)
 			if err != nil {
 				return err
@@ -31,20 +60,16 @@ func newEncryptCmd() *cobra.Command {
 			if err != nil {
 				return fmt.Errorf("encrypt %q: %w", path, err)
 			}
-			switch {
-			case inPlace && path == "-":
-				return fmt.Errorf("--in-place is incompatible with stdin (path \"-\")")
-			case inPlace:
-				return writePathOrStdout(path, out)
-			case output != "":
-				return writePathOrStdout(output, out)
-			default:
-				return writePathOrStdout("-", out)
-			}
+			return writePathOrStdout(dst, out)
 		},
 	}
 	flags.bind(cmd.Flags())
+	ks.bind(cmd.Flags())
 	cmd.Flags().BoolVarP(&inPlace, "in-place", "i", false, "write encrypted bytes back to PATH")
 	cmd.Flags().StringVarP(&output, "output", "o", "", "write encrypted bytes to this path")
+	cmd.Flags().BoolVar(&stream, "stream", false,
+		"encrypt PATH as an opaque chunked stream with bounded memory")
+	cmd.Flags().IntVar(&chunkSize, "chunk-size", 0,
+		"plaintext bytes per chunk with --stream (0 = 64 KiB)")
 	return cmd
 }
@@ -11,7 +11,9 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/spf13/cobra"
 	yaml "go.yaml.in/yaml/v3"
 
@@ -143,20 +145,18 @@ func tempFileName(override, path string) string {
 }
 
 // envPairsFromPlaintext converts decrypted file contents into KEY=VALUE
-// environment pairs. Dotenv, YAML, and JSON are supported; the format
-// is inferred from path. YAML and JSON must hold a flat map of scalar
-// values.
+// environment pairs. Dotenv, YAML, JSON, and TOML are supported; the
+// format is inferred from path. YAML, JSON, and TOML must hold a flat
+// map of scalar values.
 func envPairsFromPlaintext(path string, plain []byte) ([]string, error) {
 	switch cipher.FormatForPath(path) {
 	case cipher.FormatDotenv:
 		return parseDotenv(plain), nil
-	case cipher.FormatJSON:
-		return flattenScalarMap(plain, true)
-	case cipher.FormatYAML:
-		return flattenScalarMap(plain, false)
+	case cipher.FormatJSON, cipher.FormatYAML, cipher.FormatTOML:
+		return flattenScalarMap(plain, cipher.FormatForPath(path))
 	default:
 		return nil, fmt.Errorf(
-			"exec-env: unsupported format for %q: need a .env, .yaml, or .json path", path)
+			"exec-env: unsupported format for %q: need a .env, .yaml, .json, or .toml path", path)
 	}
 }
 
@@ -180,14 +180,19 @@ func parseDotenv(plain []byte) []string {
 
 // flattenScalarMap parses a flat map of scalars into sorted KEY=VALUE
 // pairs. Non-scalar values return an error naming the offending key.
-func flattenScalarMap(plain []byte, isJSON bool) ([]string, error) {
+func flattenScalarMap(plain []byte, format cipher.Format) ([]string, error) {
 	values := map[string]any{}
-	if isJSON {
-		if err := json.Unmarshal(plain, &values); err != nil {
-			return nil, fmt.Errorf("parse json: %w", err)
-		}
-	} else if err := yaml.Unmarshal(plain, &values); err != nil {
-		return nil, fmt.Errorf("parse yaml: %w", err)
+	var err error
+	switch format {
+	case cipher.FormatJSON:
+		err = json.Unmarshal(plain, &values)
+	case cipher.FormatTOML:
+		err = toml.Unmarshal(plain, &values)
+	default:
+		err = yaml.Unmarshal(plain, &values)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", cipher.FormatName(format), err)
 	}
 
 	keys := make([]string, 0, len(values))
@@ -223,6 +228,8 @@ func scalarString(value any) (string, bool) {
 		return strconv.FormatInt(typed, 10), true
 	case float64:
 		return strconv.FormatFloat(typed, 'g', -1, 64), true
+	case time.Time:
+		return typed.Format(time.RFC3339Nano), true
 	default:
 		return "", false
 	}
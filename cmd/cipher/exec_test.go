@@ -10,7 +10,7 @@ import (
 	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
-// TestEnvPairsFromPlaintext covers dotenv, YAML, and JSON flattening,
+// TestEnvPairsFromPlaintext covers dotenv, YAML, JSON, and TOML flattening,
 // plus the non-scalar and unsupported-format error paths.
 func TestEnvPairsFromPlaintext(t *testing.T) {
 	t.Parallel()
@@ -45,6 +45,16 @@ func TestEnvPairsFromPlaintext(t *testing.T) {
 		Path:    "secrets.ini",
 		In:      "k=v",
 		WantErr: true,
+	}, { // Test 5: TOML flat scalars sort by key.
+		Name:    "toml",
+		Path:    "secrets.toml",
+		In:      "port = 5432\ndb_password = \"super-secret\"\nratio = 0.5\n",
+		WantEnv: []string{"db_password=super-secret", "port=5432", "ratio=0.5"},
+	}, { // Test 6: TOML table is not a scalar.
+		Name:    "toml table",
+		Path:    "secrets.toml",
+		In:      "[db]\npassword = \"x\"\n",
+		WantErr: true,
 	}}
 
 	for testNum, test := range tests {
@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/BurntSushi/toml"
 	yaml "go.yaml.in/yaml/v3"
 
 	"github.com/dcadolph/cipher"
@@ -43,9 +44,15 @@ func extractValue(path string, plain []byte, expr string) ([]byte, error) {
 		if err := yaml.Unmarshal(plain, &root); err != nil {
 			return nil, fmt.Errorf("extract: parse yaml: %w", err)
 		}
+	case cipher.FormatTOML:
+		table := map[string]any{}
+		if err := toml.Unmarshal(plain, &table); err != nil {
+			return nil, fmt.Errorf("extract: parse toml: %w", err)
+		}
+		root = table
 	default:
 		return nil, fmt.Errorf(
-			"extract: unsupported format for %q: need a .yaml or .json path", path)
+			"extract: unsupported format for %q: need a .yaml, .json, or .toml path", path)
 	}
 
 	cur := root
@@ -124,6 +131,12 @@ func parseExtractPath(expr string) ([]extractStep, error) {
 func stepInto(cur any, step extractStep) (any, error) {
 	if step.isIndex {
 		arr, ok := cur.([]any)
+		if tables, isTables := cur.([]map[string]any); isTables {
+			arr, ok = make([]any, len(tables)), true
+			for i, table := range tables {
+				arr[i] = table
+			}
+		}
 		if !ok {
 			return nil, fmt.Errorf("index [%d] into non-array", step.index)
 		}
@@ -161,6 +174,8 @@ func renderExtracted(cur any, format cipher.Format) ([]byte, error) {
 		return json.Marshal(cur)
 	case cipher.FormatYAML:
 		return yaml.Marshal(cur)
+	case cipher.FormatTOML:
+		return toml.Marshal(cur)
 	default:
 		return nil, fmt.Errorf("extract: cannot render %T", cur)
 	}
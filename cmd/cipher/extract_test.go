@@ -57,7 +57,7 @@ func TestParseExtractPath(t *testing.T) {
 }
 
 // TestExtractValue covers scalar, nested, array, subtree, and error
-// cases across YAML and JSON.
+// cases across YAML, JSON, and TOML.
 func TestExtractValue(t *testing.T) {
 	t.Parallel()
 	tests := []struct {
@@ -89,6 +89,14 @@ func TestExtractValue(t *testing.T) {
 	}, { // Test 6: Unsupported format errors.
 		Name: "unsupported", Path: "s.ini",
 		In: "k=v", Expr: `["k"]`, WantErr: true,
+	}, { // Test 7: Nested TOML scalar renders raw.
+		Name: "toml nested", Path: "s.toml",
+		In: "[db]\npassword = \"super-secret\"\n", Expr: `["db"]["password"]`,
+		WantOut: "super-secret",
+	}, { // Test 8: Index into a TOML array of tables.
+		Name: "toml table array", Path: "s.toml",
+		In: "[[hosts]]\nname = \"a\"\n[[hosts]]\nname = \"b\"\n", Expr: `["hosts"][1]["name"]`,
+		WantOut: "b",
 	}}
 
 	for testNum, test := range tests {
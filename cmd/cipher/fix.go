@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -18,6 +19,9 @@ import (
 func newFixCmd() *cobra.Command {
 	var configPath, backupSuffix string
 	var parallel int
+	var continueOnError, transactional bool
+	dry := &dryRunFlags{}
+	sf := &stateFlags{}
 	cmd := &cobra.Command{
 		Use:   "fix ROOT",
 		Short: "Encrypt every plaintext file under ROOT that should be encrypted per .sops.yaml",
@@ -40,9 +44,16 @@ func newFixCmd() *cobra.Command {
 				return err == nil && ok
 			})
 
+			state, err := sf.load()
+			if err != nil {
+				return err
+			}
 			opts := cipher.WalkOptions{
-				Parallelism:  parallel,
-				BackupSuffix: backupSuffix,
+				Parallelism:     parallel,
+				BackupSuffix:    backupSuffix,
+				ContinueOnError: continueOnError,
+				Transactional:   transactional,
+				State:           state,
 				OnFile: func(p string, n int) {
 					fmt.Fprintf(cmd.OutOrStdout(), "fixed %s (%d bytes)\n", p, n)
 				},
@@ -50,10 +61,13 @@ func newFixCmd() *cobra.Command {
 					fmt.Fprintf(cmd.ErrOrStderr(), "skipped %s: %v\n", p, reason)
 				},
 			}
-			return cipher.EncodeWalkWith(
+			dry.apply(cmd, &opts)
+			res, err := cipher.EncodeWalkWith(
 				cmd.Context(), afero.NewOsFs(), root, enc,
 				[]cipher.FileMatcher{matcher}, opts,
 			)
+			err = walkFailures(cmd, opts.ContinueOnError, res, err)
+			return dry.result(errors.Join(err, sf.save()))
 		},
 	}
 	cmd.Flags().StringVar(&configPath, "config", "",
@@ -61,6 +75,12 @@ func newFixCmd() *cobra.Command {
 	cmd.Flags().StringVar(&backupSuffix, "backup-suffix", "",
 		"copy each original file to <path><suffix> before overwriting")
 	cmd.Flags().IntVar(&parallel, "parallel", 1, "max files processed concurrently")
+	cmd.Flags().BoolVar(&continueOnError, "continue-on-error", false,
+		"keep going after a file fails and list every failure at the end")
+	cmd.Flags().BoolVar(&transactional, "transactional", false,
+		"replace files only if every file succeeds; otherwise roll back (see walk recover)")
+	dry.bind(cmd)
+	sf.bind(cmd)
 	return cmd
 }
 
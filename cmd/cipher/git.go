@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/dcadolph/cipher"
+)
+
+// newGitCmd returns the `cipher git` command group: helpers that git
+// invokes through .gitattributes drivers.
+func newGitCmd() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "git",
+		Short: "Git drivers for sops-encrypted files",
+	}
+	root.AddCommand(
+		newGitMergeDriverCmd(),
+		newGitTextconvCmd(),
+		newGitFilterCmd(true),
+		newGitFilterCmd(false),
+		newGitFilterProcessCmd(),
+	)
+	return root
+}
+
+// newGitMergeDriverCmd returns `cipher git merge-driver BASE OURS
+// THEIRS [PATH]`, the argument order of git's %O %A %B %P. It merges
+// the decrypted trees and writes the result to OURS, encrypted when
+// the merge is clean and as plaintext with conflict markers when it is
+// not. A conflict exits non-zero so git reports it.
+func newGitMergeDriverCmd() *cobra.Command {
+	ks := &keyServiceFlags{}
+	cmd := &cobra.Command{
+		Use:   "merge-driver BASE OURS THEIRS [PATH]",
+		Short: "Three-way merge encrypted files as a git merge driver",
+		Long: "merge-driver decrypts the ancestor, current, and other versions\n" +
+			"of a file, merges their trees key by key, and writes the result\n" +
+			"to OURS re-encrypted with OURS's recipients. On conflict OURS\n" +
+			"receives plaintext with conflict markers and the command fails.\n\n" +
+			"Configure it with:\n\n" +
+			"  git config merge.cipher.driver 'cipher git merge-driver %O %A %B %P'\n" +
+			"  echo 'secrets/** merge=cipher' >> .gitattributes",
+		Args: cobra.RangeArgs(3, 4),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			basePath, oursPath, theirsPath := args[0], args[1], args[2]
+			name := oursPath
+			opts := cipher.DecoderOptions{}
+			if len(args) == 4 {
+				name = args[3]
+				if f := cipher.FormatForPath(name); f != cipher.FormatBinary {
+					opts.Format = f
+				}
+			}
+			services, closeKS, err := ks.dial()
+			if err != nil {
+				return err
+			}
+			defer closeKS()
+			opts.KeyServices = services
+
+			base, err := readPathOrStdin(basePath)
+			if err != nil {
+				return err
+			}
+			ours, err := readPathOrStdin(oursPath)
+			if err != nil {
+				return err
+			}
+			theirs, err := readPathOrStdin(theirsPath)
+			if err != nil {
+				return err
+			}
+			out, err := cipher.MergeEncryptedWith(cmd.Context(), base, ours, theirs, opts)
+			if err != nil && !errors.Is(err, cipher.ErrMergeConflict) {
+				return fmt.Errorf("merge %q: %w", name, err)
+			}
+			if werr := writePathOrStdout(oursPath, out); werr != nil {
+				return werr
+			}
+			if err != nil {
+				return fmt.Errorf("merge %q: %w; plaintext with conflict markers written", name, err)
+			}
+			return nil
+		},
+	}
+	ks.bind(cmd.Flags())
+	return cmd
+}
+
+// newGitTextconvCmd returns `cipher git textconv FILE`, which prints a
+// stable rendering of FILE for git's diff.<driver>.textconv. Files that
+// are not encrypted print unchanged so the driver can match broadly.
+func newGitTextconvCmd() *cobra.Command {
+	ks := &keyServiceFlags{}
+	var mask, keysOnly bool
+	cmd := &cobra.Command{
+		Use:   "textconv FILE",
+		Short: "Render an encrypted file for readable git diffs",
+		Long: "textconv prints FILE decrypted, without sops metadata, so git diff\n" +
+			"shows changed values instead of ciphertext. --mask replaces each\n" +
+			"value with a digest keyed by the file's data key, which changes\n" +
+			"only when the value does. --keys-only lists key paths with a\n" +
+			"digest of the stored ciphertext and needs no identity.\n\n" +
+			"Configure it with:\n\n" +
+			"  git config diff.cipher.textconv 'cipher git textconv'\n" +
+			"  echo 'secrets/** diff=cipher' >> .gitattributes",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := args[0]
+			mode := cipher.TextconvPlain
+			switch {
+			case mask:
+				mode = cipher.TextconvMasked
+			case keysOnly:
+				mode = cipher.TextconvKeysOnly
+			}
+			data, err := readPathOrStdin(path)
+			if err != nil {
+				return err
+			}
+			opts := cipher.DecoderOptions{}
+			if mode != cipher.TextconvKeysOnly {
+				services, closeKS, err := ks.dial()
+				if err != nil {
+					return err
+				}
+				defer closeKS()
+				opts.KeyServices = services
+			}
+			out, err := cipher.TextconvWith(cmd.Context(), path, data, mode, opts)
+			switch {
+			case errors.Is(err, cipher.ErrNotEncrypted):
+				out = data
+			case err != nil:
+				return fmt.Errorf("textconv %q: %w", path, err)
+			}
+			_, err = cmd.OutOrStdout().Write(out)
+			return err
+		},
+	}
+	cmd.Flags().BoolVar(&mask, "mask", false, "replace values with keyed digests")
+	cmd.Flags().BoolVar(&keysOnly, "keys-only", false, "list key paths without decrypting")
+	cmd.MarkFlagsMutuallyExclusive("mask", "keys-only")
+	ks.bind(cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/dcadolph/cipher"
+)
+
+// pktMaxPayload is the largest payload one pkt-line may carry.
+const pktMaxPayload = 65516
+
+// gitFilter holds what the clean and smudge commands share. The
+// encoder is built from .sops.yaml on first use, so smudging works in
+// a repository without one.
+type gitFilter struct {
+	configPath string
+	opts       cipher.DecoderOptions
+	enc        cipher.Encoder
+}
+
+// clean encrypts plain for path, reusing the ciphertext in git's index.
+func (f *gitFilter) clean(ctx context.Context, path string, plain []byte) ([]byte, error) {
+	if f.enc == nil {
+		cfg, err := loadSopsConfig(f.configPath)
+		if err != nil {
+			return nil, err
+		}
+		f.enc = cipher.NewRoutedEncoder(cfg.Router(nil), cipher.EncoderOptions{KeyServices: f.opts.KeyServices})
+	}
+	return cipher.FilterClean(ctx, path, plain, indexBlob(ctx, path), f.enc, f.opts)
+}
+
+// smudge decrypts data for path.
+func (f *gitFilter) smudge(ctx context.Context, path string, data []byte) ([]byte, error) {
+	return cipher.FilterSmudge(ctx, path, data, f.opts)
+}
+
+// indexBlob returns the content git's index holds for path, or nil
+// when path is not staged or git cannot be run.
+func indexBlob(ctx context.Context, path string) []byte {
+	out, err := exec.CommandContext(ctx, "git", "cat-file", "blob", ":"+path).Output()
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+// newGitFilterCmd returns `cipher git filter-clean PATH` or
+// `cipher git filter-smudge PATH`, which filter stdin to stdout for
+// git's filter.<driver>.clean and .smudge.
+func newGitFilterCmd(clean bool) *cobra.Command {
+	ks := &keyServiceFlags{}
+	f := &gitFilter{}
+	use, short := "filter-smudge PATH", "Decrypt stdin for the working tree as a git smudge filter"
+	if clean {
+		use, short = "filter-clean PATH", "Encrypt stdin for the index as a git clean filter"
+	}
+	cmd := &cobra.Command{
+		Use:   use,
+		Short: short,
+		Long: "filter-clean encrypts the working-tree plaintext on stdin with the\n" +
+			"recipients .sops.yaml routes PATH to. When the index already holds\n" +
+			"PATH, its data key is reused, and unchanged content returns the\n" +
+			"staged ciphertext as is, so git sees no modification.\n" +
+			"filter-smudge decrypts stdin. Input that is not encrypted passes\n" +
+			"through either way.\n\n" +
+			"Configure them with:\n\n" +
+			"  git config filter.cipher.clean 'cipher git filter-clean %f'\n" +
+			"  git config filter.cipher.smudge 'cipher git filter-smudge %f'\n" +
+			"  echo 'secrets/** filter=cipher' >> .gitattributes\n\n" +
+			"or use the long-running filter-process instead.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := args[0]
+			services, closeKS, err := ks.dial()
+			if err != nil {
+				return err
+			}
+			defer closeKS()
+			f.opts.KeyServices = services
+
+			data, err := io.ReadAll(cmd.InOrStdin())
+			if err != nil {
+				return fmt.Errorf("read stdin: %w", err)
+			}
+			var out []byte
+			if clean {
+				out, err = f.clean(cmd.Context(), path, data)
+			} else {
+				out, err = f.smudge(cmd.Context(), path, data)
+			}
+			if err != nil {
+				return fmt.Errorf("filter %q: %w", path, err)
+			}
+			_, err = cmd.OutOrStdout().Write(out)
+			return err
+		},
+	}
+	if clean {
+		cmd.Flags().StringVar(&f.configPath, "config", "",
+			"path to .sops.yaml or directory containing it (default: search upward from the working directory)")
+	}
+	ks.bind(cmd.Flags())
+	return cmd
+}
+
+// newGitFilterProcessCmd returns `cipher git filter-process`, a
+// long-running filter that speaks git's filter.<driver>.process
+// protocol on stdin and stdout, so one process serves a whole
+// checkout or add.
+func newGitFilterProcessCmd() *cobra.Command {
+	ks := &keyServiceFlags{}
+	f := &gitFilter{}
+	cmd := &cobra.Command{
+		Use:   "filter-process",
+		Short: "Serve clean and smudge as a long-running git filter process",
+		Long: "filter-process runs filter-clean and filter-smudge for every file\n" +
+			"git passes it over the filter.<driver>.process protocol. A file that\n" +
+			"fails reports an error to git and the process keeps serving.\n\n" +
+			"Configure it with:\n\n" +
+			"  git config filter.cipher.process 'cipher git filter-process'\n" +
+			"  git config filter.cipher.required true\n" +
+			"  echo 'secrets/** filter=cipher' >> .gitattributes",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			services, closeKS, err := ks.dial()
+			if err != nil {
+				return err
+			}
+			defer closeKS()
+			f.opts.KeyServices = services
+			return serveFilterProcess(cmd.Context(), cmd.InOrStdin(), cmd.OutOrStdout(), cmd.ErrOrStderr(), f)
+		},
+	}
+	cmd.Flags().StringVar(&f.configPath, "config", "",
+		"path to .sops.yaml or directory containing it (default: search upward from the working directory)")
+	ks.bind(cmd.Flags())
+	return cmd
+}
+
+// serveFilterProcess runs version 2 of git's long-running filter
+// protocol until git closes in. Per-file failures are reported to git
+// as status=error and logged to errOut; protocol failures end the
+// session.
+func serveFilterProcess(ctx context.Context, in io.Reader, out, errOut io.Writer, f *gitFilter) error {
+	r := bufio.NewReader(in)
+	w := bufio.NewWriter(out)
+
+	hello, err := readPktList(r)
+	if err != nil {
+		return fmt.Errorf("filter-process handshake: %w", err)
+	}
+	if !slices.Contains(hello, "git-filter-client") || !slices.Contains(hello, "version=2") {
+		return fmt.Errorf("filter-process handshake: unsupported client %q", hello)
+	}
+	if err := writePktList(w, "git-filter-server", "version=2"); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	offered, err := readPktList(r)
+	if err != nil {
+		return fmt.Errorf("filter-process capabilities: %w", err)
+	}
+	var caps []string
+	for _, c := range []string{"capability=clean", "capability=smudge"} {
+		if slices.Contains(offered, c) {
+			caps = append(caps, c)
+		}
+	}
+	if err := writePktList(w, caps...); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	for {
+		header, err := readPktList(r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("filter-process request: %w", err)
+		}
+		var command, path string
+		for _, kv := range header {
+			key, value, _ := strings.Cut(kv, "=")
+			switch key {
+			case "command":
+				command = value
+			case "pathname":
+				path = value
+			}
+		}
+		content, err := readPktContent(r)
+		if err != nil {
+			return fmt.Errorf("filter-process %s %q: %w", command, path, err)
+		}
+
+		var result []byte
+		switch command {
+		case "clean":
+			result, err = f.clean(ctx, path, content)
+		case "smudge":
+			result, err = f.smudge(ctx, path, content)
+		default:
+			err = fmt.Errorf("unsupported command %q", command)
+		}
+		if err != nil {
+			fmt.Fprintf(errOut, "cipher git filter-process: %s %q: %v\n", command, path, err)
+			if err := writePktList(w, "status=error"); err != nil {
+				return err
+			}
+		} else if err := writeFilterResult(w, result); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// writeFilterResult sends a successful response: the status, the
+// content, and an empty list that keeps the status.
+func writeFilterResult(w io.Writer, content []byte) error {
+	if err := writePktList(w, "status=success"); err != nil {
+		return err
+	}
+	for len(content) > 0 {
+		n := min(len(content), pktMaxPayload)
+		if err := writePkt(w, content[:n]); err != nil {
+			return err
+		}
+		content = content[n:]
+	}
+	if err := writePktFlush(w); err != nil {
+		return err
+	}
+	return writePktFlush(w)
+}
+
+// readPkt reads one pkt-line. A flush packet returns a nil payload
+// and flush true.
+func readPkt(r io.Reader) (payload []byte, flush bool, err error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, false, err
+	}
+	n, err := strconv.ParseUint(string(header[:]), 16, 16)
+	if err != nil {
+		return nil, false, fmt.Errorf("bad pkt-line length %q", header[:])
+	}
+	switch {
+	case n == 0:
+		return nil, true, nil
+	case n <= 4:
+		return nil, false, fmt.Errorf("bad pkt-line length %d", n)
+	}
+	payload = make([]byte, n-4)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, false, io.ErrUnexpectedEOF
+	}
+	return payload, false, nil
+}
+
+// readPktList reads text pkt-lines up to a flush, without their
+// trailing newlines. It returns io.EOF only when r ends before the
+// first packet.
+func readPktList(r io.Reader) ([]string, error) {
+	var lines []string
+	for {
+		payload, flush, err := readPkt(r)
+		if errors.Is(err, io.EOF) && len(lines) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if flush {
+			return lines, nil
+		}
+		lines = append(lines, strings.TrimSuffix(string(payload), "\n"))
+	}
+}
+
+// readPktContent reads binary pkt-lines up to a flush.
+func readPktContent(r io.Reader) ([]byte, error) {
+	var content []byte
+	for {
+		payload, flush, err := readPkt(r)
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if flush {
+			return content, nil
+		}
+		content = append(content, payload...)
+	}
+}
+
+// writePkt writes payload as one pkt-line.
+func writePkt(w io.Writer, payload []byte) error {
+	if _, err := fmt.Fprintf(w, "%04x", len(payload)+4); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// writePktFlush writes a flush packet.
+func writePktFlush(w io.Writer) error {
+	_, err := io.WriteString(w, "0000")
+	return err
+}
+
+// writePktList writes each line as a text pkt-line, then a flush.
+func writePktList(w io.Writer, lines ...string) error {
+	for _, line := range lines {
+		if err := writePkt(w, []byte(line+"\n")); err != nil {
+			return err
+		}
+	}
+	return writePktFlush(w)
+}
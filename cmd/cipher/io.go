@@ -1,11 +1,14 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
 
 	"github.com/spf13/afero"
+
+	"github.com/dcadolph/cipher/internal/atomic"
 )
 
 // readPathOrStdin reads from path, or from stdin when path == "-".
@@ -34,6 +37,56 @@ func writePathOrStdout(path string, data []byte) error {
 	return os.WriteFile(path, data, perm)
 }
 
+// outputPath resolves where a single-file command writes its result:
+// PATH itself with --in-place, the --output path when set, or "-"
+// for stdout.
+func outputPath(path, output string, inPlace bool) (string, error) {
+	switch {
+	case inPlace && path == "-":
+		return "", errors.New("--in-place is incompatible with stdin (path \"-\")")
+	case inPlace:
+		return path, nil
+	case output != "":
+		return output, nil
+	default:
+		return "-", nil
+	}
+}
+
+// streamPathOrStdio pipes in (or stdin when in == "-") through fn into
+// out (or stdout when out == "-"). File output is staged in a temp
+// file and renamed into place only when fn succeeds, so in == out is
+// safe and a failed stream never leaves a partial file. Files keep
+// their original mode if they exist, otherwise 0o600.
+func streamPathOrStdio(in, out string, fn func(dst io.Writer, src io.Reader) error) error {
+	open := func() (io.ReadCloser, error) {
+		if in == "-" {
+			return io.NopCloser(os.Stdin), nil
+		}
+		f, err := os.Open(in)
+		if err != nil {
+			return nil, fmt.Errorf("read %q: %w", in, err)
+		}
+		return f, nil
+	}
+	run := func(dst io.Writer) error {
+		src, err := open()
+		if err != nil {
+			return err
+		}
+		defer func() { _ = src.Close() }()
+		return fn(dst, src)
+	}
+	if out == "-" {
+		return run(os.Stdout)
+	}
+	perm := os.FileMode(0o600)
+	if info, err := os.Stat(out); err == nil {
+		perm = info.Mode().Perm()
+	}
+	return atomic.WriteStream(osFs(), out, perm, run)
+}
+
 // osFs returns the afero filesystem used by walk commands. Centralized
 // so future tests can substitute MemMapFs.
 func osFs() afero.Fs { return afero.NewOsFs() }
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/getsops/sops/v3/keyservice"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// keyServiceFlags holds the client-side key service flags shared by
+// the encrypt, decrypt, rotate, and walk verbs.
+type keyServiceFlags struct {
+	addrs []string
+	local bool
+}
+
+// bind attaches --keyservice and --enable-local-keyservice to f. The
+// names and address syntax match the sops binary.
+func (k *keyServiceFlags) bind(f *pflag.FlagSet) {
+	f.StringSliceVar(&k.addrs, "keyservice", nil,
+		"remote sops key service, unix:///path or tcp://host:port (repeatable)")
+	f.BoolVar(&k.local, "enable-local-keyservice", true,
+		"also use the in-process key service alongside --keyservice")
+}
+
+// dial connects to every --keyservice address and returns the clients
+// to pass as KeyServices, plus a func that closes the connections.
+// With no addresses it returns nil so the library default applies.
+func (k *keyServiceFlags) dial() ([]keyservice.KeyServiceClient, func(), error) {
+	if len(k.addrs) == 0 {
+		if !k.local {
+			return nil, nil, errors.New("--enable-local-keyservice=false requires --keyservice")
+		}
+		return nil, func() {}, nil
+	}
+	var clients []keyservice.KeyServiceClient
+	if k.local {
+		clients = append(clients, keyservice.NewLocalClient())
+	}
+	var conns []*grpc.ClientConn
+	closeAll := func() {
+		for _, c := range conns {
+			_ = c.Close()
+		}
+	}
+	for _, addr := range k.addrs {
+		target, err := keyServiceTarget(addr)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("--keyservice %q: %w", addr, err)
+		}
+		conns = append(conns, conn)
+		clients = append(clients, keyservice.NewKeyServiceClient(conn))
+	}
+	return clients, closeAll, nil
+}
+
+// keyServiceTarget converts a sops key service address into a gRPC
+// dial target.
+func keyServiceTarget(addr string) (string, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", fmt.Errorf("--keyservice %q: %w", addr, err)
+	}
+	switch u.Scheme {
+	case "unix":
+		if u.Path == "" {
+			return "", fmt.Errorf("--keyservice %q: missing socket path", addr)
+		}
+		return "unix://" + u.Path, nil
+	case "tcp":
+		if u.Host == "" {
+			return "", fmt.Errorf("--keyservice %q: missing host:port", addr)
+		}
+		return "passthrough:///" + u.Host, nil
+	default:
+		return "", fmt.Errorf("--keyservice %q: scheme must be unix or tcp", addr)
+	}
+}
+
+// newKeyServiceCmd returns the `cipher keyservice` command group.
+func newKeyServiceCmd() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "keyservice",
+		Short: "Run a sops-compatible key service",
+	}
+	root.AddCommand(newKeyServiceServeCmd())
+	return root
+}
+
+// newKeyServiceServeCmd returns `cipher keyservice serve`. It exposes
+// the local key service over gRPC so clients on another host or in
+// another container can wrap and unwrap data keys with identities held
+// here. The wire protocol is the sops key service, so the sops binary
+// can use it too.
+func newKeyServiceServeCmd() *cobra.Command {
+	var socket, addr string
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve data-key operations over gRPC on a unix socket or TCP address",
+		Long: "Serve the sops key service protocol using the identities and\n" +
+			"credentials available to this process (SOPS_AGE_KEY, cloud\n" +
+			"credentials, GPG keyring). Clients connect with\n" +
+			"--keyservice unix:///path or --keyservice tcp://host:port.\n\n" +
+			"The service is unauthenticated and unencrypted. Prefer a unix\n" +
+			"socket (created with mode 0600), and put TCP listeners behind\n" +
+			"a private network or an authenticating proxy.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if (socket == "") == (addr == "") {
+				return errors.New("exactly one of --socket or --addr is required")
+			}
+			var lis net.Listener
+			var err error
+			if socket != "" {
+				lis, err = listenUnix(socket)
+			} else {
+				lis, err = net.Listen("tcp", addr)
+			}
+			if err != nil {
+				return fmt.Errorf("listen: %w", err)
+			}
+
+			srv := grpc.NewServer()
+			keyservice.RegisterKeyServiceServer(srv, keyservice.Server{})
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			go func() {
+				<-ctx.Done()
+				srv.GracefulStop()
+			}()
+
+			fmt.Fprintf(cmd.ErrOrStderr(), "serving sops key service on %s\n", lis.Addr())
+			if err := srv.Serve(lis); err != nil {
+				return fmt.Errorf("serve: %w", err)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&socket, "socket", "", "unix socket path to listen on")
+	cmd.Flags().StringVar(&addr, "addr", "", "TCP host:port to listen on")
+	return cmd
+}
+
+// listenUnix listens on a unix socket at path with mode 0600. If the
+// mode cannot be set the listener is closed and the socket removed.
+func listenUnix(path string) (net.Listener, error) {
+	lis, err := listenUnixPrivate(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		_ = lis.Close()
+		_ = os.Remove(path)
+		return nil, err
+	}
+	return lis, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+)
+
+// TestKeyServiceServeRoundTrip runs `keyservice serve` on a unix
+// socket and drives encrypt and decrypt through it with the local key
+// service disabled.
+func TestKeyServiceServeRoundTrip(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("identity: %v", err)
+	}
+	t.Setenv("SOPS_AGE_KEY", id.String())
+
+	// Unix socket paths are length-limited, so keep the directory short.
+	dir, err := os.MkdirTemp("", "cks")
+	if err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "ks.sock")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serve := newKeyServiceCmd()
+	serve.SetArgs([]string{"serve", "--socket", socket})
+	serve.SetContext(ctx)
+	serve.SetErr(&strings.Builder{})
+	done := make(chan error, 1)
+	go func() { done <- serve.Execute() }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("serve: %v", err)
+		}
+	})
+	for i := 0; ; i++ {
+		info, err := os.Stat(socket)
+		if err == nil && info.Mode().Perm()&0o077 != 0 {
+			t.Fatalf("socket mode = %v, want no group or other access", info.Mode().Perm())
+		}
+		if err == nil && info.Mode().Perm() == 0o600 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("socket never appeared with mode 0600")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	target := filepath.Join(dir, "s.yaml")
+	if err := os.WriteFile(target, []byte("foo: bar\n"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	remote := []string{"--keyservice", "unix://" + socket, "--enable-local-keyservice=false"}
+
+	enc := newEncryptCmd()
+	enc.SetArgs(append([]string{"--age", id.Recipient().String(), "-i", target}, remote...))
+	enc.SetContext(context.Background())
+	if err := enc.Execute(); err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	out := filepath.Join(dir, "plain.yaml")
+	dec := newDecryptCmd()
+	dec.SetArgs(append([]string{"-o", out, target}, remote...))
+	dec.SetContext(context.Background())
+	if err := dec.Execute(); err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	got, _ := os.ReadFile(out)
+	if string(got) != "foo: bar\n" {
+		t.Errorf("decrypted = %q", got)
+	}
+
+	// A dead socket with the local service disabled cannot decrypt.
+	dec = newDecryptCmd()
+	dec.SetArgs([]string{"-o", out, target,
+		"--keyservice", "unix://" + filepath.Join(dir, "missing.sock"),
+		"--enable-local-keyservice=false"})
+	dec.SetContext(context.Background())
+	dec.SetOut(&strings.Builder{})
+	dec.SetErr(&strings.Builder{})
+	if err := dec.Execute(); err == nil {
+		t.Error("decrypt through a missing key service succeeded")
+	}
+}
+
+// TestKeyServiceTarget covers address parsing.
+func TestKeyServiceTarget(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		Name    string
+		In      string
+		Want    string
+		WantErr bool
+	}{
+		// Test 0: unix socket.
+		{Name: "unix", In: "unix:///tmp/ks.sock", Want: "unix:///tmp/ks.sock"},
+		// Test 1: tcp host:port.
+		{Name: "tcp", In: "tcp://localhost:5000", Want: "passthrough:///localhost:5000"},
+		// Test 2: unknown scheme.
+		{Name: "http", In: "http://x", WantErr: true},
+		// Test 3: tcp without host.
+		{Name: "tcp empty", In: "tcp://", WantErr: true},
+	}
+	for testNum, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+			got, err := keyServiceTarget(test.In)
+			if (err != nil) != test.WantErr {
+				t.Fatalf("Test %d (%s): err = %v, wantErr = %v", testNum, test.Name, err, test.WantErr)
+			}
+			if got != test.Want {
+				t.Errorf("Test %d (%s): got = %q, want = %q", testNum, test.Name, got, test.Want)
+			}
+		})
+	}
+}
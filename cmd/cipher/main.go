@@ -9,6 +9,8 @@ import (
 	"os"
 
 	"github.com/spf13/cobra"
+
+	"github.com/dcadolph/cipher"
 )
 
 // version is the CLI version string. Set via -ldflags during build.
@@ -31,14 +33,20 @@ func init() {
 		newExecEnvCmd(),
 		newExecFileCmd(),
 		newRotateCmd(),
+		newConvertCmd(),
 		newWalkCmd(),
 		newAddRecipientCmd(),
 		newRemoveRecipientCmd(),
 		newRecipientsCmd(),
+		newMigrateCmd(),
 		newConfigCmd(),
 		newPrecommitCmd(),
+		newGitCmd(),
+		newAuditCmd(),
+		newPolicyCmd(),
 		newInfoCmd(),
 		newFixCmd(),
+		newKeyServiceCmd(),
 		newDemoCmd(),
 		newVersionCmd(),
 	)
@@ -54,7 +62,31 @@ func main() {
 			os.Exit(ee.code)
 		}
 		fmt.Fprintln(os.Stderr, "error:", err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
+	}
+}
+
+// Exit codes for library failures, by cipher.Kind. Anything else
+// exits 1.
+const (
+	exitKeyAccess = 3 // no identity matched, KMS denied, bad identity
+	exitIntegrity = 4 // MAC mismatch
+	exitInput     = 5 // plain, already encrypted, malformed, or too large
+)
+
+// exitCode maps err to a process exit code so scripts can tell key
+// access, integrity, and input failures apart.
+func exitCode(err error) int {
+	switch cipher.KindOf(err) {
+	case cipher.KindNoIdentity, cipher.KindAccessDenied, cipher.KindInvalidIdentity:
+		return exitKeyAccess
+	case cipher.KindMACMismatch:
+		return exitIntegrity
+	case cipher.KindNotEncrypted, cipher.KindAlreadyEncrypted, cipher.KindEmpty,
+		cipher.KindParse, cipher.KindUnsupportedFormat, cipher.KindTooLarge:
+		return exitInput
+	default:
+		return 1
 	}
 }
 
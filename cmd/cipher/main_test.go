@@ -3,14 +3,24 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
 
 	"filippo.io/age"
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/afero"
 
 	"github.com/dcadolph/cipher"
+	cipherage "github.com/dcadolph/cipher/age"
+	"github.com/dcadolph/cipher/audit"
+	"github.com/dcadolph/cipher/policy"
 )
 
 // TestEncryptThenDecrypt drives the CLI end-to-end: encrypt a temp
@@ -66,6 +76,431 @@ func TestEncryptThenDecrypt(t *testing.T) {
 	}
 }
 
+// TestEncryptDecryptStream drives --stream through encrypt --in-place
+// and decrypt --output and checks the payload survives byte-for-byte.
+func TestEncryptDecryptStream(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("identity: %v", err)
+	}
+	t.Setenv("SOPS_AGE_KEY", id.String())
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "dump.sql")
+	plain := bytes.Repeat([]byte("INSERT INTO t VALUES (1);\n"), 500)
+	if err := os.WriteFile(target, plain, 0o640); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	enc := newEncryptCmd()
+	enc.SetArgs([]string{"--age", id.Recipient().String(), "--stream", "--chunk-size", "1000", "-i", target})
+	enc.SetContext(context.Background())
+	if err := enc.Execute(); err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	encrypted, _ := os.ReadFile(target)
+	if !cipher.IsEncryptedStream(encrypted) {
+		t.Fatalf("file not a cipher stream after encrypt --stream")
+	}
+	if info, _ := os.Stat(target); info.Mode().Perm() != 0o640 {
+		t.Errorf("mode = %v, want 0640", info.Mode().Perm())
+	}
+
+	out := filepath.Join(dir, "restored.sql")
+	dec := newDecryptCmd()
+	dec.SetArgs([]string{"--stream", "-o", out, target})
+	dec.SetContext(context.Background())
+	if err := dec.Execute(); err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	got, _ := os.ReadFile(out)
+	if !bytes.Equal(got, plain) {
+		t.Errorf("restored payload differs: got %d bytes, want %d", len(got), len(plain))
+	}
+
+	bad := newDecryptCmd()
+	bad.SetArgs([]string{"--stream", "--extract", `["a"]`, target})
+	bad.SetContext(context.Background())
+	bad.SetErr(&bytes.Buffer{})
+	if err := bad.Execute(); err == nil {
+		t.Errorf("--stream with --extract: expected error")
+	}
+}
+
+// TestDecryptExtract verifies --extract prints a single decrypted value
+// after checking the file MAC, and that only --partial skips the check.
+func TestDecryptExtract(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("identity: %v", err)
+	}
+	t.Setenv("SOPS_AGE_KEY", id.String())
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "secrets.yaml")
+	if err := os.WriteFile(target, []byte("db:\n  password: s3cret\n  user: app\n"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	enc := newEncryptCmd()
+	enc.SetArgs([]string{"--age", id.Recipient().String(), "-i", target})
+	enc.SetContext(context.Background())
+	if err := enc.Execute(); err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	// Dropping a leaf leaves every other value decryptable but breaks
+	// the MAC, as replaying or pruning a file would.
+	data, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var kept []string
+	for line := range strings.SplitSeq(string(data), "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "user:") {
+			kept = append(kept, line)
+		}
+	}
+	tampered := filepath.Join(dir, "tampered.yaml")
+	if err := os.WriteFile(tampered, []byte(strings.Join(kept, "\n")), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	tests := []struct {
+		Name    string
+		Args    []string
+		Want    string
+		WantErr string
+	}{
+		// Test 0: Scalar extracted from a verified file.
+		{Name: "extract", Args: []string{"--extract", `["db"]["password"]`, target}, Want: "s3cret"},
+		// Test 1: Partial decrypt of an intact file.
+		{Name: "partial", Args: []string{"--extract", `["db"]["password"]`, "--partial", target}, Want: "s3cret"},
+		// Test 2: A MAC mismatch fails --extract.
+		{
+			Name: "mac mismatch", Args: []string{"--extract", `["db"]["password"]`, tampered},
+			WantErr: "MAC mismatch",
+		},
+		// Test 3: --partial skips the MAC, as its help says.
+		{
+			Name: "partial mac mismatch", Args: []string{"--extract", `["db"]["password"]`, "--partial", tampered},
+			Want: "s3cret",
+		},
+		// Test 4: --partial alone is rejected.
+		{Name: "partial without extract", Args: []string{"--partial", target}, WantErr: "--partial requires --extract"},
+	}
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "value")
+			dec := newDecryptCmd()
+			dec.SetArgs(append([]string{"-o", out}, test.Args...))
+			dec.SetContext(context.Background())
+			dec.SilenceUsage = true
+			err := dec.Execute()
+			if test.WantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.WantErr) {
+					t.Fatalf("decrypt err = %v, want %q", err, test.WantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decrypt: %v", err)
+			}
+			got, _ := os.ReadFile(out)
+			if string(got) != test.Want {
+				t.Errorf("extracted = %q, want %q", got, test.Want)
+			}
+		})
+	}
+}
+
+// TestConvertCmd converts an encrypted YAML file to JSON and decrypts
+// the result with the same identity.
+func TestConvertCmd(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("identity: %v", err)
+	}
+	t.Setenv("SOPS_AGE_KEY", id.String())
+
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "s.yaml"), filepath.Join(dir, "s.json")
+	if err := os.WriteFile(src, []byte("token: abc\n"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	enc := newEncryptCmd()
+	enc.SetArgs([]string{"--age", id.Recipient().String(), "--in-place", src})
+	enc.SetContext(context.Background())
+	if err := enc.Execute(); err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	conv := newConvertCmd()
+	conv.SetArgs([]string{src, "-o", dst})
+	conv.SetContext(context.Background())
+	if err := conv.Execute(); err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	plain, err := cipher.NewDecoder().Decode(context.Background(), dst, data)
+	if err != nil {
+		t.Fatalf("decode converted: %v", err)
+	}
+	if !strings.Contains(string(plain), `"token": "abc"`) {
+		t.Errorf("converted plaintext = %q", plain)
+	}
+
+	bad := newConvertCmd()
+	bad.SetArgs([]string{src})
+	bad.SetOut(&strings.Builder{})
+	bad.SetErr(&strings.Builder{})
+	bad.SetContext(context.Background())
+	if err := bad.Execute(); err == nil || !strings.Contains(err.Error(), "--to") {
+		t.Errorf("convert without --to or --output: err = %v", err)
+	}
+}
+
+// TestGitMergeDriver merges an encrypted file the way git invokes the
+// driver and checks the clean and conflict results written to OURS.
+func TestGitMergeDriver(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("identity: %v", err)
+	}
+	t.Setenv("SOPS_AGE_KEY", id.String())
+	enc := cipher.NewEncoder(cipherage.MustNewProvider(id.Recipient().String()))
+	dir := t.TempDir()
+	write := func(name, plain string) string {
+		t.Helper()
+		data, err := enc.Encode(context.Background(), "s.yaml", []byte(plain))
+		if err != nil {
+			t.Fatalf("encode: %v", err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		return path
+	}
+	run := func(theirs string) (string, error) {
+		t.Helper()
+		base := write("base", "a: one\nb: two\n")
+		ours := write("ours", "a: uno\nb: two\n")
+		other := write("theirs", theirs)
+		cmd := newGitMergeDriverCmd()
+		cmd.SetArgs([]string{base, ours, other, "secrets/s.yaml"})
+		cmd.SetOut(&strings.Builder{})
+		cmd.SetErr(&strings.Builder{})
+		cmd.SetContext(context.Background())
+		err := cmd.Execute()
+		data, rerr := os.ReadFile(ours)
+		if rerr != nil {
+			t.Fatalf("read: %v", rerr)
+		}
+		return string(data), err
+	}
+
+	out, err := run("a: one\nb: dos\n")
+	if err != nil {
+		t.Fatalf("clean merge: %v", err)
+	}
+	plain, err := cipher.NewDecoder().Decode(context.Background(), "s.yaml", []byte(out))
+	if err != nil {
+		t.Fatalf("decode merged: %v", err)
+	}
+	if string(plain) != "a: uno\nb: dos\n" {
+		t.Errorf("merged plaintext = %q", plain)
+	}
+
+	out, err = run("a: eins\nb: two\n")
+	if !errors.Is(err, cipher.ErrMergeConflict) {
+		t.Fatalf("conflict merge err = %v, want ErrMergeConflict", err)
+	}
+	if !strings.Contains(out, "<<<<<<< ours\na: uno\n=======\na: eins\n>>>>>>> theirs\n") {
+		t.Errorf("conflict output = %q", out)
+	}
+}
+
+// TestGitTextconv renders an encrypted file in each mode and passes
+// plaintext files through.
+func TestGitTextconv(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("identity: %v", err)
+	}
+	t.Setenv("SOPS_AGE_KEY", id.String())
+	enc := cipher.NewEncoder(cipherage.MustNewProvider(id.Recipient().String()))
+	data, err := enc.Encode(context.Background(), "s.yaml", []byte("pass: hunter2\n"))
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "s.yaml")
+	plain := filepath.Join(dir, "p.yaml")
+	if err := os.WriteFile(secret, data, 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := os.WriteFile(plain, []byte("a: 1\n"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	tests := []struct {
+		Name string
+		Args []string
+		Want *regexp.Regexp
+	}{{ // Test 0: Default output is the decrypted file.
+		Name: "plain", Args: []string{secret}, Want: regexp.MustCompile(`^pass: hunter2\n$`),
+	}, { // Test 1: --mask hides the value.
+		Name: "mask", Args: []string{"--mask", secret},
+		Want: regexp.MustCompile(`^\["pass"\] = <masked [0-9a-f]{12}>\n$`),
+	}, { // Test 2: --keys-only lists the key.
+		Name: "keys only", Args: []string{"--keys-only", secret},
+		Want: regexp.MustCompile(`^\["pass"\] = <stored [0-9a-f]{12}>\n$`),
+	}, { // Test 3: Unencrypted files pass through.
+		Name: "not encrypted", Args: []string{"--mask", plain}, Want: regexp.MustCompile(`^a: 1\n$`),
+	}}
+
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			var out strings.Builder
+			cmd := newGitTextconvCmd()
+			cmd.SetArgs(test.Args)
+			cmd.SetOut(&out)
+			cmd.SetContext(context.Background())
+			if err := cmd.Execute(); err != nil {
+				t.Fatalf("textconv: %v", err)
+			}
+			if !test.Want.MatchString(out.String()) {
+				t.Errorf("output = %q, want match %s", out.String(), test.Want)
+			}
+		})
+	}
+}
+
+// TestGitFilter cleans a file with filter-clean and serves smudge and
+// a failing request over the filter-process protocol.
+func TestGitFilter(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("identity: %v", err)
+	}
+	t.Setenv("SOPS_AGE_KEY", id.String())
+	dir := t.TempDir()
+	cfg := filepath.Join(dir, ".sops.yaml")
+	body := "creation_rules:\n  - path_regex: secrets/.*\\.yaml$\n    age: " + id.Recipient().String() + "\n"
+	if err := os.WriteFile(cfg, []byte(body), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	var stored strings.Builder
+	cmd := newGitFilterCmd(true)
+	cmd.SetArgs([]string{"--config", cfg, "secrets/s.yaml"})
+	cmd.SetIn(strings.NewReader("a: 1\n"))
+	cmd.SetOut(&stored)
+	cmd.SetContext(context.Background())
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("filter-clean: %v", err)
+	}
+	if !cipher.IsEncrypted([]byte(stored.String()), cipher.FormatYAML) {
+		t.Fatalf("filter-clean output not encrypted:\n%s", stored.String())
+	}
+
+	var in bytes.Buffer
+	request := func(lines ...string) {
+		if err := writePktList(&in, lines...); err != nil {
+			t.Fatalf("write request: %v", err)
+		}
+	}
+	content := func(data string) {
+		if err := writePkt(&in, []byte(data)); err != nil {
+			t.Fatalf("write content: %v", err)
+		}
+		if err := writePktFlush(&in); err != nil {
+			t.Fatalf("write flush: %v", err)
+		}
+	}
+	request("git-filter-client", "version=2")
+	request("capability=clean", "capability=smudge", "capability=delay")
+	request("command=smudge", "pathname=secrets/s.yaml")
+	content(stored.String())
+	request("command=smudge", "pathname=plain.yaml")
+	content("b: 2\n")
+	request("command=clean", "pathname=other/s.yaml")
+	content("c: 3\n")
+
+	var out bytes.Buffer
+	cmd = newGitFilterProcessCmd()
+	cmd.SetArgs([]string{"--config", cfg})
+	cmd.SetIn(&in)
+	cmd.SetOut(&out)
+	cmd.SetErr(&strings.Builder{})
+	cmd.SetContext(context.Background())
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("filter-process: %v", err)
+	}
+
+	list := func(want ...string) {
+		t.Helper()
+		got, err := readPktList(&out)
+		if err != nil {
+			t.Fatalf("read list: %v", err)
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("list = %q, want %q", got, want)
+		}
+	}
+	result := func(want string) {
+		t.Helper()
+		list("status=success")
+		got, err := readPktContent(&out)
+		if err != nil {
+			t.Fatalf("read content: %v", err)
+		}
+		if string(got) != want {
+			t.Errorf("content = %q, want %q", got, want)
+		}
+		list()
+	}
+	list("git-filter-server", "version=2")
+	list("capability=clean", "capability=smudge")
+	result("a: 1\n")
+	result("b: 2\n")
+	list("status=error")
+	if out.Len() != 0 {
+		t.Errorf("trailing output %q", out.String())
+	}
+}
+
+// TestGitFilterProcessHandshake checks that the server answers each
+// handshake step before git sends the next one.
+func TestGitFilterProcessHandshake(t *testing.T) {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- serveFilterProcess(context.Background(), inR, outW, io.Discard, &gitFilter{})
+		_ = outW.Close()
+	}()
+
+	if err := writePktList(inW, "git-filter-client", "version=2"); err != nil {
+		t.Fatalf("write hello: %v", err)
+	}
+	if got, err := readPktList(outR); err != nil || strings.Join(got, ",") != "git-filter-server,version=2" {
+		t.Fatalf("hello reply = %q, %v", got, err)
+	}
+	if err := writePktList(inW, "capability=smudge"); err != nil {
+		t.Fatalf("write capabilities: %v", err)
+	}
+	if got, err := readPktList(outR); err != nil || strings.Join(got, ",") != "capability=smudge" {
+		t.Fatalf("capabilities reply = %q, %v", got, err)
+	}
+	_ = inW.Close()
+	if err := <-done; err != nil {
+		t.Errorf("serveFilterProcess: %v", err)
+	}
+}
+
 // TestWalkEncryptDecrypt verifies walk subcommands across a small
 // directory tree.
 func TestWalkEncryptDecrypt(t *testing.T) {
@@ -116,6 +551,362 @@ func TestWalkEncryptDecrypt(t *testing.T) {
 	}
 }
 
+// TestWalkVerify verifies that `walk verify` passes an intact tree,
+// names every tampered file when integrity fails, rejects the flags of
+// the walks that write, and does not count a canceled walk as failures.
+func TestWalkVerify(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("identity: %v", err)
+	}
+	t.Setenv("SOPS_AGE_KEY", id.String())
+
+	dir := t.TempDir()
+	good, bad := filepath.Join(dir, "good.yaml"), filepath.Join(dir, "bad.yaml")
+	for _, p := range []string{good, bad} {
+		if err := os.WriteFile(p, []byte("foo: bar\n"), 0o600); err != nil {
+			t.Fatalf("write %q: %v", p, err)
+		}
+	}
+	runCtx := func(ctx context.Context, args ...string) (string, string, error) {
+		var stdout, stderr bytes.Buffer
+		cmd := newWalkCmd()
+		cmd.SetArgs(args)
+		cmd.SetOut(&stdout)
+		cmd.SetErr(&stderr)
+		cmd.SetContext(ctx)
+		err := cmd.Execute()
+		return stdout.String(), stderr.String(), err
+	}
+	run := func(args ...string) (string, string, error) {
+		return runCtx(context.Background(), args...)
+	}
+	if _, _, err := run("encrypt", "--age", id.Recipient().String(), dir); err != nil {
+		t.Fatalf("walk encrypt: %v", err)
+	}
+	if out, _, err := run("verify", dir); err != nil || strings.Count(out, "verified ") != 2 {
+		t.Fatalf("walk verify intact: err=%v out=%q", err, out)
+	}
+
+	data, err := os.ReadFile(bad)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	re := regexp.MustCompile(`lastmodified: "[^"]+"`)
+	tampered := re.ReplaceAll(data, []byte(`lastmodified: "2001-01-01T00:00:00Z"`))
+	if err := os.WriteFile(bad, tampered, 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	out, errOut, err := run("verify", dir)
+	if err == nil || !strings.Contains(err.Error(), "1 file(s) failed") {
+		t.Fatalf("walk verify tampered: err=%v, want 1 failure", err)
+	}
+	if !strings.Contains(errOut, "FAIL") || !strings.Contains(errOut, "bad.yaml") {
+		t.Errorf("stderr does not name bad.yaml: %q", errOut)
+	}
+	if !strings.Contains(out, "verified "+good) {
+		t.Errorf("stdout does not list good.yaml: %q", out)
+	}
+
+	for _, flag := range []string{"--transactional", "--continue-on-error", "--backup-suffix=.bak"} {
+		if _, _, err := run("verify", flag, dir); err == nil || !strings.Contains(err.Error(), "unknown flag") {
+			t.Errorf("walk verify %s: err=%v, want unknown flag", flag, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := runCtx(ctx, "verify", dir); !errors.Is(err, context.Canceled) ||
+		strings.Contains(err.Error(), "failed") {
+		t.Errorf("walk verify canceled: err=%v, want context.Canceled only", err)
+	}
+}
+
+// TestWalkDryRun verifies that `walk encrypt --dry-run` prints a plan,
+// counts files that would fail, and leaves the tree untouched.
+func TestWalkDryRun(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("identity: %v", err)
+	}
+	dir := t.TempDir()
+	seed := map[string]string{
+		filepath.Join(dir, "a.yaml"):   "foo: bar\n",
+		filepath.Join(dir, "bad.json"): "{",
+	}
+	for p, data := range seed {
+		if err := os.WriteFile(p, []byte(data), 0o600); err != nil {
+			t.Fatalf("write %q: %v", p, err)
+		}
+	}
+	var stdout bytes.Buffer
+	cmd := newWalkCmd()
+	cmd.SetArgs([]string{"encrypt", "--dry-run", "--age", id.Recipient().String(), dir})
+	cmd.SetOut(&stdout)
+	cmd.SetErr(io.Discard)
+	cmd.SetContext(context.Background())
+	err = cmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), "1 file(s) would fail") {
+		t.Fatalf("walk encrypt --dry-run: err=%v, want 1 failure", err)
+	}
+	out := stdout.String()
+	if !strings.Contains(out, "would encrypt "+filepath.Join(dir, "a.yaml")) {
+		t.Errorf("stdout does not plan a.yaml: %q", out)
+	}
+	if !strings.Contains(out, "error "+filepath.Join(dir, "bad.json")) {
+		t.Errorf("stdout does not report bad.json: %q", out)
+	}
+	for p, data := range seed {
+		if after, _ := os.ReadFile(p); string(after) != data {
+			t.Errorf("dry run rewrote %q: %q", p, after)
+		}
+	}
+}
+
+// TestWalkContinueOnError verifies that `walk encrypt
+// --continue-on-error` encrypts every good file past a bad one, lists
+// the failure, and exits non-zero.
+func TestWalkContinueOnError(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("identity: %v", err)
+	}
+	dir := t.TempDir()
+	good := []string{filepath.Join(dir, "a.yaml"), filepath.Join(dir, "z.yaml")}
+	bad := filepath.Join(dir, "bad.json")
+	for _, p := range good {
+		if err := os.WriteFile(p, []byte("foo: bar\n"), 0o600); err != nil {
+			t.Fatalf("write %q: %v", p, err)
+		}
+	}
+	if err := os.WriteFile(bad, []byte("{"), 0o600); err != nil {
+		t.Fatalf("write %q: %v", bad, err)
+	}
+	var stderr bytes.Buffer
+	cmd := newWalkCmd()
+	cmd.SetArgs([]string{"encrypt", "--continue-on-error", "--age", id.Recipient().String(), dir})
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(&stderr)
+	cmd.SetContext(context.Background())
+	err = cmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), "1 file(s) failed") {
+		t.Fatalf("walk encrypt --continue-on-error: err=%v, want 1 failure", err)
+	}
+	if !strings.Contains(stderr.String(), "FAIL") || !strings.Contains(stderr.String(), "bad.json") {
+		t.Errorf("stderr does not name bad.json: %q", stderr.String())
+	}
+	for _, p := range good {
+		data, _ := os.ReadFile(p)
+		if !cipher.IsEncryptedPath(p, data) {
+			t.Errorf("%q not encrypted after walk", p)
+		}
+	}
+}
+
+// TestWalkTransactional verifies that `cipher walk encrypt
+// --transactional` leaves every file untouched when one fails, and that
+// `cipher walk recover` then has nothing to do.
+func TestWalkTransactional(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("identity: %v", err)
+	}
+	dir := t.TempDir()
+	good := filepath.Join(dir, "a.yaml")
+	bad := filepath.Join(dir, "bad.json")
+	if err := os.WriteFile(good, []byte("foo: bar\n"), 0o600); err != nil {
+		t.Fatalf("write %q: %v", good, err)
+	}
+	if err := os.WriteFile(bad, []byte("{"), 0o600); err != nil {
+		t.Fatalf("write %q: %v", bad, err)
+	}
+	cmd := newWalkCmd()
+	cmd.SetArgs([]string{"encrypt", "--transactional", "--continue-on-error",
+		"--age", id.Recipient().String(), dir})
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	cmd.SetContext(context.Background())
+	if err := cmd.Execute(); err == nil {
+		t.Fatal("walk encrypt --transactional: want an error")
+	}
+	if data, _ := os.ReadFile(good); string(data) != "foo: bar\n" {
+		t.Errorf("%q changed by a rolled-back walk: %q", good, data)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("rolled-back walk left %d entries, want 2", len(entries))
+	}
+
+	var stdout bytes.Buffer
+	cmd = newWalkCmd()
+	cmd.SetArgs([]string{"recover", dir})
+	cmd.SetOut(&stdout)
+	cmd.SetContext(context.Background())
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("walk recover: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "no journals found") {
+		t.Errorf("walk recover stdout = %q", stdout.String())
+	}
+}
+
+// TestWalkState verifies that `cipher walk encrypt --state` skips, on
+// a second run, the files the first run encrypted.
+func TestWalkState(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("identity: %v", err)
+	}
+	dir := t.TempDir()
+	target := filepath.Join(dir, "secrets", "a.yaml")
+	if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(target, []byte("foo: bar\n"), 0o600); err != nil {
+		t.Fatalf("write %q: %v", target, err)
+	}
+	statePath := filepath.Join(dir, "state.json")
+	run := func() string {
+		t.Helper()
+		var stderr bytes.Buffer
+		cmd := newWalkCmd()
+		cmd.SetArgs([]string{"encrypt", "--state", statePath,
+			"--age", id.Recipient().String(), filepath.Dir(target)})
+		cmd.SetOut(io.Discard)
+		cmd.SetErr(&stderr)
+		cmd.SetContext(context.Background())
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("walk encrypt --state: %v", err)
+		}
+		return stderr.String()
+	}
+	if stderr := run(); stderr != "" {
+		t.Errorf("first run stderr = %q, want nothing skipped", stderr)
+	}
+	if _, err := os.Stat(statePath); err != nil {
+		t.Fatalf("state file not written: %v", err)
+	}
+	if stderr := run(); !strings.Contains(stderr, "unchanged since last walk") {
+		t.Errorf("second run stderr = %q, want a.yaml skipped as unchanged", stderr)
+	}
+}
+
+// TestWalkOut verifies that `cipher walk encrypt --out` and `cipher
+// walk decrypt --out` mirror a tree without touching the source.
+func TestWalkOut(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("identity: %v", err)
+	}
+	t.Setenv("SOPS_AGE_KEY", id.String())
+	dir := t.TempDir()
+	src := filepath.Join(dir, "staging")
+	enc := filepath.Join(dir, "encrypted")
+	dec := filepath.Join(dir, "decrypted")
+	rel := filepath.Join("app", "a.yaml")
+	if err := os.MkdirAll(filepath.Join(src, "app"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, rel), []byte("foo: bar\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	run := func(args ...string) {
+		t.Helper()
+		cmd := newWalkCmd()
+		cmd.SetArgs(args)
+		cmd.SetOut(io.Discard)
+		cmd.SetErr(io.Discard)
+		cmd.SetContext(context.Background())
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("walk %v: %v", args, err)
+		}
+	}
+	run("encrypt", "--out", enc, "--age", id.Recipient().String(), src)
+	run("decrypt", "--out", dec, enc)
+
+	if data, _ := os.ReadFile(filepath.Join(src, rel)); string(data) != "foo: bar\n" {
+		t.Errorf("source changed: %q", data)
+	}
+	data, err := os.ReadFile(filepath.Join(enc, rel))
+	if err != nil || !cipher.IsEncryptedPath(rel, data) {
+		t.Errorf("encrypted copy = %q, %v", data, err)
+	}
+	info, err := os.Stat(filepath.Join(dec, rel))
+	if err != nil || info.Mode().Perm() != 0o640 {
+		t.Fatalf("decrypted copy mode = %v, %v, want 0640", info, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dec, rel)); string(data) != "foo: bar\n" {
+		t.Errorf("decrypted copy = %q", data)
+	}
+}
+
+// TestMigrateCmd verifies that `cipher migrate` plans a move on a dry
+// run and then moves a file to the new recipient.
+func TestMigrateCmd(t *testing.T) {
+	oldID, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("identity: %v", err)
+	}
+	newID, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("identity: %v", err)
+	}
+	t.Setenv("SOPS_AGE_KEY", oldID.String())
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "a.yaml")
+	if err := os.WriteFile(target, []byte("foo: bar\n"), 0o600); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	enc := newEncryptCmd()
+	enc.SetArgs([]string{"--age", oldID.Recipient().String(), "--in-place", target})
+	enc.SetContext(context.Background())
+	if err := enc.Execute(); err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	before, _ := os.ReadFile(target)
+
+	from := "age:" + oldID.Recipient().String()
+	to := "age:" + newID.Recipient().String()
+	run := func(extra ...string) string {
+		t.Helper()
+		cmd := newMigrateCmd()
+		cmd.SetArgs(append([]string{"--from", from, "--to", to, dir}, extra...))
+		cmd.SetContext(context.Background())
+		var stdout bytes.Buffer
+		cmd.SetOut(&stdout)
+		cmd.SetErr(io.Discard)
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("migrate %v: %v", extra, err)
+		}
+		return stdout.String()
+	}
+
+	var report cipher.MigrateReport
+	if err := json.Unmarshal([]byte(run("--dry-run", "--json")), &report); err != nil {
+		t.Fatalf("decode report: %v", err)
+	}
+	if !report.DryRun || report.Migrated() != 1 {
+		t.Errorf("dry run report = %+v", report)
+	}
+	if after, _ := os.ReadFile(target); !bytes.Equal(before, after) {
+		t.Error("dry run rewrote the file")
+	}
+
+	if out := run(); !strings.HasPrefix(out, "migrated "+target+" (+"+to+", -"+from+")") {
+		t.Errorf("stdout = %q", out)
+	}
+	data, _ := os.ReadFile(target)
+	info, err := cipher.InspectPath(target, data)
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	want := [][]cipher.RecipientInfo{{{Type: "age", Identifier: newID.Recipient().String()}}}
+	if diff := cmp.Diff(want, info.Groups); diff != "" {
+		t.Errorf("recipients (-want +got):\n%s", diff)
+	}
+}
+
 // TestInfoCmd verifies that `cipher info` returns JSON metadata for a
 // sops-encrypted file.
 func TestInfoCmd(t *testing.T) {
@@ -151,6 +942,51 @@ func TestInfoCmd(t *testing.T) {
 	}
 }
 
+// TestAuditVerifyCmd verifies that `cipher audit verify` accepts an
+// intact log and rejects one with an edited entry.
+func TestAuditVerifyCmd(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := audit.NewFileSink(afero.NewOsFs(), log)
+	if err != nil {
+		t.Fatalf("sink: %v", err)
+	}
+	for _, path := range []string{"a.yaml", "b.yaml"} {
+		if err := sink.Record(context.Background(), audit.Event{Op: audit.OpEncode, Path: path}); err != nil {
+			t.Fatalf("record: %v", err)
+		}
+	}
+	head := sink.Head()
+	if err := sink.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	cmd := newAuditVerifyCmd()
+	cmd.SetArgs([]string{"--anchor", head, log})
+	var stdout bytes.Buffer
+	cmd.SetOut(&stdout)
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if want := log + ": 2 entries, head " + head + "\n"; stdout.String() != want {
+		t.Errorf("stdout = %q, want %q", stdout.String(), want)
+	}
+
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatalf("read log: %v", err)
+	}
+	edited := bytes.Replace(data, []byte(`"a.yaml"`), []byte(`"c.yaml"`), 1)
+	if err := os.WriteFile(log, edited, 0o600); err != nil {
+		t.Fatalf("write log: %v", err)
+	}
+	cmd = newAuditVerifyCmd()
+	cmd.SetArgs([]string{log})
+	cmd.SetOut(io.Discard)
+	if err := cmd.Execute(); !errors.Is(err, audit.ErrTampered) {
+		t.Errorf("verify edited log: err = %v, want ErrTampered", err)
+	}
+}
+
 // TestRecipientsListCmd verifies that `cipher recipients list` returns
 // the encrypted file's recipients.
 func TestRecipientsListCmd(t *testing.T) {
@@ -221,6 +1057,47 @@ func TestConfigCheckCmd(t *testing.T) {
 	}
 }
 
+// TestPolicyCheckCmd verifies that `cipher policy check` reports a
+// plaintext file under a policy rule as JSON and exits non-zero.
+func TestPolicyCheckCmd(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	policyPath := filepath.Join(dir, policy.FileName)
+	body := "rules:\n  - name: prod\n    path_regex: ^prod/\n    require_types: [kms]\n"
+	if err := os.WriteFile(policyPath, []byte(body), 0o600); err != nil {
+		t.Fatalf("write policy: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "prod"), 0o700); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	target := filepath.Join(dir, "prod", "db.yaml")
+	if err := os.WriteFile(target, []byte("password: hunter2\n"), 0o600); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	cmd := newPolicyCheckCmd()
+	cmd.SetArgs([]string{"--policy", policyPath, "--json", dir})
+	cmd.SetContext(context.Background())
+	var stdout bytes.Buffer
+	cmd.SetOut(&stdout)
+	cmd.SetErr(io.Discard)
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = true
+	if err := cmd.Execute(); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	var got []policy.Violation
+	if err := json.Unmarshal(stdout.Bytes(), &got); err != nil {
+		t.Fatalf("decode %q: %v", stdout.String(), err)
+	}
+	want := []policy.Violation{
+		{Path: target, Rule: "prod", Code: policy.CodeNotEncrypted, Message: "not sops-encrypted"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("violations (-want +got):\n%s", diff)
+	}
+}
+
 // TestEncryptRequiresRecipient verifies the CLI errors when no
 // recipient flag is supplied.
 func TestEncryptRequiresRecipient(t *testing.T) {
@@ -239,3 +1116,29 @@ func TestEncryptRequiresRecipient(t *testing.T) {
 		t.Fatal("expected error for missing recipient flags")
 	}
 }
+
+// TestExitCode verifies the exit code chosen for each failure kind.
+func TestExitCode(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		Name string
+		Err  error
+		Want int
+	}{{ // Test 0: Key access failure.
+		Name: "access", Err: &cipher.Error{Kind: cipher.KindAccessDenied, Err: cipher.ErrDecode}, Want: exitKeyAccess,
+	}, { // Test 1: Integrity failure.
+		Name: "mac", Err: cipher.ErrMACMismatch, Want: exitIntegrity,
+	}, { // Test 2: Input failure.
+		Name: "plain", Err: cipher.ErrNotEncrypted, Want: exitInput,
+	}, { // Test 3: Anything else.
+		Name: "other", Err: os.ErrNotExist, Want: 1,
+	}}
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			t.Parallel()
+			if got := exitCode(test.Err); got != test.Want {
+				t.Errorf("exitCode = %d, want %d", got, test.Want)
+			}
+		})
+	}
+}
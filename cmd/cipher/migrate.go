@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/dcadolph/cipher"
+)
+
+// newMigrateCmd returns `cipher migrate ROOT --from TYPE:ID --to TYPE:ID`.
+// It moves every matching file under ROOT from the --from recipients to
+// the --to recipients by re-wrapping data keys, and reports each file.
+func newMigrateCmd() *cobra.Command {
+	wf := &walkFlags{}
+	ks := &keyServiceFlags{}
+	var from, to []string
+	var dryRun, asJSON, pretty bool
+	cmd := &cobra.Command{
+		Use:   "migrate ROOT --from TYPE:ID --to TYPE:ID",
+		Short: "Move encrypted files under ROOT from one set of recipients to another",
+		Long: "migrate plans each matching file from its sops metadata: files with\n" +
+			"any --from recipient gain the --to recipients they lack and lose the\n" +
+			"--from ones. Payloads are not re-encrypted; the data key is re-wrapped,\n" +
+			"so run `cipher walk rotate` afterwards to retire it. TYPE is one of\n" +
+			"age, kms, gcp_kms, hc_vault, azure_kv, or pgp. With --dry-run nothing\n" +
+			"is written and no identity is needed.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sources, err := parseRecipientSpecs("--from", from)
+			if err != nil {
+				return err
+			}
+			targets, err := parseRecipientSpecs("--to", to)
+			if err != nil {
+				return err
+			}
+			kp, err := recipientsProvider(targets)
+			if err != nil {
+				return err
+			}
+			matchers, err := wf.matchers()
+			if err != nil {
+				return err
+			}
+			services, closeKS, err := ks.dial()
+			if err != nil {
+				return err
+			}
+			defer closeKS()
+			opts := cipher.MigrateOptions{
+				Walk: cipher.WalkOptions{
+					Parallelism:     wf.parallel,
+					BackupSuffix:    wf.backupSuffix,
+					ContinueOnError: wf.continueOnError,
+					Transactional:   wf.transactional,
+				},
+				DryRun:      dryRun,
+				KeyServices: services,
+			}
+			report, err := cipher.MigrateWalkWith(cmd.Context(), osFs(), args[0], sources, kp, matchers, opts)
+			if report == nil {
+				return err
+			}
+			if asJSON {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				if pretty {
+					enc.SetIndent("", "  ")
+				}
+				if encErr := enc.Encode(report); encErr != nil {
+					return encErr
+				}
+			} else {
+				printMigrateReport(cmd.OutOrStdout(), cmd.ErrOrStderr(), report)
+			}
+			return err
+		},
+	}
+	wf.bind(cmd)
+	ks.bind(cmd.Flags())
+	cmd.Flags().StringSliceVar(&from, "from", nil,
+		"recipient to migrate away from, as TYPE:ID (repeatable)")
+	cmd.Flags().StringSliceVar(&to, "to", nil,
+		"recipient to migrate to, as TYPE:ID (repeatable)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the plan without writing")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the report as JSON")
+	cmd.Flags().BoolVar(&pretty, "pretty", false, "indent the JSON output")
+	_ = cmd.MarkFlagRequired("from")
+	_ = cmd.MarkFlagRequired("to")
+	return cmd
+}
+
+// parseRecipientSpecs parses TYPE:ID specs, splitting at the first
+// colon so identifiers such as KMS ARNs keep theirs.
+func parseRecipientSpecs(flag string, specs []string) ([]cipher.RecipientInfo, error) {
+	out := make([]cipher.RecipientInfo, 0, len(specs))
+	for _, spec := range specs {
+		typ, id, ok := strings.Cut(spec, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("%s %q: want TYPE:ID", flag, spec)
+		}
+		if _, known := recipientTypeFlags[typ]; !known {
+			return nil, fmt.Errorf("%s %q: unknown recipient type %q", flag, spec, typ)
+		}
+		out = append(out, cipher.RecipientInfo{Type: typ, Identifier: id})
+	}
+	return out, nil
+}
+
+// recipientTypeFlags maps a sops recipient type to the providerFlags
+// field holding recipients of that type.
+var recipientTypeFlags = map[string]func(*providerFlags) *string{
+	"age":      func(p *providerFlags) *string { return &p.age },
+	"kms":      func(p *providerFlags) *string { return &p.kms },
+	"gcp_kms":  func(p *providerFlags) *string { return &p.gcpkms },
+	"hc_vault": func(p *providerFlags) *string { return &p.vault },
+	"azure_kv": func(p *providerFlags) *string { return &p.azkv },
+	"pgp":      func(p *providerFlags) *string { return &p.pgp },
+}
+
+// recipientsProvider builds a KeyProvider for recipients by routing
+// each one to the matching recipient flag.
+func recipientsProvider(recipients []cipher.RecipientInfo) (cipher.KeyProvider, error) {
+	pf := &providerFlags{}
+	for _, r := range recipients {
+		field := recipientTypeFlags[r.Type](pf)
+		if *field != "" {
+			*field += ","
+		}
+		*field += r.Identifier
+	}
+	return pf.keyProvider()
+}
+
+// printMigrateReport writes one line per migrated file to stdout and
+// one per skipped file to stderr.
+func printMigrateReport(stdout, stderr io.Writer, report *cipher.MigrateReport) {
+	verb := "migrated"
+	if report.DryRun {
+		verb = "would migrate"
+	}
+	for _, f := range report.Files {
+		if f.Skipped != "" {
+			fmt.Fprintf(stderr, "skipped %s: %s\n", f.Path, f.Skipped)
+			continue
+		}
+		var changes []string
+		for _, r := range f.Add {
+			changes = append(changes, "+"+r.Type+":"+r.Identifier)
+		}
+		for _, r := range f.Remove {
+			changes = append(changes, "-"+r.Type+":"+r.Identifier)
+		}
+		fmt.Fprintf(stdout, "%s %s (%s)\n", verb, f.Path, strings.Join(changes, ", "))
+	}
+}
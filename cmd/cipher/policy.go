@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/dcadolph/cipher/policy"
+)
+
+// newPolicyCmd returns the `cipher policy` command group.
+func newPolicyCmd() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "policy",
+		Short: "Enforce recipient policy on encrypted files",
+		Long: "Enforce recipient policy on encrypted files. Rules name key types\n" +
+			"in require_types and forbid_types as age, azure_kv, gcp_kms,\n" +
+			"hc_vault, hckms, kms, or pgp.",
+	}
+	root.AddCommand(newPolicyCheckCmd())
+	return root
+}
+
+// newPolicyCheckCmd returns `cipher policy check [ROOT]`. It walks ROOT
+// and checks every file a policy rule matches against that rule's
+// recipient constraints, reading only sops metadata.
+func newPolicyCheckCmd() *cobra.Command {
+	var policyPath string
+	var asJSON, pretty bool
+	cmd := &cobra.Command{
+		Use:   "check [ROOT]",
+		Short: "Check encrypted files under ROOT against a recipient policy",
+		Long: "check walks ROOT (default \".\") and checks every file matched by a\n" +
+			"rule in the policy file: that it is encrypted, has the required key\n" +
+			"types and recipients, none of the forbidden ones, and a high enough\n" +
+			"shamir threshold. Rules match paths relative to ROOT. Key types\n" +
+			"are age, azure_kv, gcp_kms, hc_vault, hckms, kms, or pgp. Nothing\n" +
+			"is decrypted. Exits 1 if any file violates the policy.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			root := "."
+			if len(args) == 1 {
+				root = args[0]
+			}
+			p, err := loadPolicy(policyPath)
+			if err != nil {
+				return err
+			}
+			violations, err := p.CheckWalk(cmd.Context(), afero.NewOsFs(), root, nil)
+			if err != nil {
+				return fmt.Errorf("policy check %q: %w", root, err)
+			}
+			if asJSON {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				if pretty {
+					enc.SetIndent("", "  ")
+				}
+				if violations == nil {
+					violations = []policy.Violation{}
+				}
+				if err := enc.Encode(violations); err != nil {
+					return err
+				}
+			} else if len(violations) == 0 {
+				fmt.Fprintf(cmd.OutOrStdout(), "ok: %s\n", root)
+			} else {
+				fmt.Fprintf(cmd.ErrOrStderr(), "found %d violation(s) under %s:\n", len(violations), root)
+				for _, v := range violations {
+					fmt.Fprintf(cmd.ErrOrStderr(), "  - %s (%s)\n", v.Error(), v.Code)
+				}
+			}
+			if len(violations) > 0 {
+				return fmt.Errorf("%d violation(s) found", len(violations))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&policyPath, "policy", "",
+		"path to the policy file or a directory containing "+policy.FileName+"; default searches upward from cwd")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print violations as a JSON array")
+	cmd.Flags().BoolVar(&pretty, "pretty", false, "indent the JSON output")
+	return cmd
+}
+
+// loadPolicy loads the policy at path when set, or the nearest policy
+// file at or above the current working directory.
+func loadPolicy(path string) (*policy.Policy, error) {
+	if path != "" {
+		return policy.Load(path)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	return policy.LoadFromDir(cwd)
+}
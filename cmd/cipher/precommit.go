@@ -6,6 +6,7 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"github.com/dcadolph/cipher/policy"
 	"github.com/dcadolph/cipher/precommit"
 )
 
@@ -14,16 +15,25 @@ import (
 // offending paths on the first violation; exits 0 when staged content
 // either does not match the .sops.yaml rules or is already encrypted.
 func newPrecommitCmd() *cobra.Command {
-	var configPath string
+	var configPath, policyPath string
 	var maxStagedBytes int64
 	cmd := &cobra.Command{
 		Use:   "precommit [PATH...]",
 		Short: "Reject any staged file that should be sops-encrypted but is not",
 		Long: "With no PATH arguments, scans git-staged files. Otherwise scans the\n" +
 			"supplied paths on disk. Exits 1 (with details on stderr) if any file\n" +
-			"matches a .sops.yaml creation rule but is not sops-encrypted.",
+			"matches a .sops.yaml creation rule but is not sops-encrypted, or\n" +
+			"breaks the recipient policy given with --policy.",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			checker, err := buildChecker(configPath, maxStagedBytes)
+			var opts []precommit.Option
+			if policyPath != "" {
+				p, err := policy.Load(policyPath)
+				if err != nil {
+					return err
+				}
+				opts = append(opts, precommit.WithPolicy(p))
+			}
+			checker, err := buildChecker(configPath, maxStagedBytes, opts...)
 			if err != nil {
 				return err
 			}
@@ -42,21 +52,24 @@ func newPrecommitCmd() *cobra.Command {
 			for _, v := range violations {
 				fmt.Fprintln(os.Stderr, "BLOCKED:", v.Error())
 			}
-			return fmt.Errorf("%d file(s) violate .sops.yaml", len(violations))
+			return fmt.Errorf("%d file(s) violate .sops.yaml or policy", len(violations))
 		},
 	}
 	cmd.Flags().StringVar(&configPath, "config", "",
 		"path to .sops.yaml or directory containing it; default searches upward from cwd")
 	cmd.Flags().Int64Var(&maxStagedBytes, "max-staged-bytes", precommit.DefaultMaxStagedBytes,
 		"reject staged blobs larger than this many bytes; 0 disables the cap")
+	cmd.Flags().StringVar(&policyPath, "policy", "",
+		"path to a recipient policy file, or a directory containing "+policy.FileName+", to enforce too")
 	return cmd
 }
 
 // buildChecker returns a precommit.Checker using configPath when set,
 // or by walking up from the current working directory. maxStagedBytes
-// caps the per-file staged blob read; pass 0 to disable.
-func buildChecker(configPath string, maxStagedBytes int64) (*precommit.Checker, error) {
-	opts := []precommit.Option{precommit.WithMaxStagedBytes(maxStagedBytes)}
+// caps the per-file staged blob read; pass 0 to disable. extra options
+// are applied after it.
+func buildChecker(configPath string, maxStagedBytes int64, extra ...precommit.Option) (*precommit.Checker, error) {
+	opts := append([]precommit.Option{precommit.WithMaxStagedBytes(maxStagedBytes)}, extra...)
 	if configPath != "" {
 		return precommit.NewChecker(configPath, opts...)
 	}
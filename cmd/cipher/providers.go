@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/getsops/sops/v3/keyservice"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 
@@ -38,6 +39,11 @@ type providerFlags struct {
 	unencryptedSuffix string
 	macOnlyEncrypted  bool
 	shamirThreshold   int
+	kubernetes        bool
+
+	// keyServices is set by commands that bind keyServiceFlags, before
+	// an encoder is resolved. Nil means the library default.
+	keyServices []keyservice.KeyServiceClient
 }
 
 // bind attaches the recipient and encoder-tuning flags to f. Repeated
@@ -65,6 +71,8 @@ func (p *providerFlags) bind(f *pflag.FlagSet) {
 		"never encrypt keys whose name ends with this suffix")
 	f.BoolVar(&p.macOnlyEncrypted, "mac-only-encrypted", false,
 		"compute the message authentication code over encrypted leaves only")
+	f.BoolVar(&p.kubernetes, "kubernetes", false,
+		"encrypt only data and stringData in Kubernetes Secret and ConfigMap manifests")
 	f.IntVar(&p.shamirThreshold, "shamir-threshold", 0,
 		"number of key groups required to recover the data key (0 = sops default)")
 }
@@ -78,6 +86,8 @@ func (p *providerFlags) encoderOptions() cipher.EncoderOptions {
 		UnencryptedSuffix: p.unencryptedSuffix,
 		MAC:               macModeFromFlag(p.macOnlyEncrypted),
 		ShamirThreshold:   p.shamirThreshold,
+		Kubernetes:        p.kubernetes,
+		KeyServices:       p.keyServices,
 	}
 }
 
@@ -180,6 +190,29 @@ func (p *providerFlags) resolveEncoder(_ *cobra.Command) (cipher.Encoder, error)
 	return cipher.NewEncoderWith(kp, encOpts), nil
 }
 
+// resolveStreamEncoder builds a StreamEncoder from the flags. Routing
+// and the recipient flags behave as in resolveEncoder; the key-filter
+// and MAC flags do not apply to opaque streams and are ignored.
+func (p *providerFlags) resolveStreamEncoder(chunkSize int) (cipher.StreamEncoder, error) {
+	opts := cipher.StreamEncoderOptions{
+		ChunkSize:       chunkSize,
+		ShamirThreshold: p.shamirThreshold,
+		KeyServices:     p.keyServices,
+	}
+	router, err := p.router()
+	if err != nil {
+		return nil, err
+	}
+	if router != nil {
+		return cipher.NewRoutedStreamEncoder(router, opts), nil
+	}
+	kp, err := p.keyProvider()
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewStreamEncoderWith(kp, opts), nil
+}
+
 // parseAWSContext converts repeated `key=value` flag entries into the
 // macModeFromFlag maps the --mac-only-encrypted bool flag to a
 // MACMode. The CLI runs a single encoder per invocation, so the
@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -75,6 +77,7 @@ type recipientReport struct {
 func newRecipientsDriftCmd() *cobra.Command {
 	var configPath string
 	var pretty bool
+	sf := &stateFlags{}
 	cmd := &cobra.Command{
 		Use:   "drift ROOT",
 		Short: "Report files whose recipients diverge from their .sops.yaml rule",
@@ -84,16 +87,21 @@ func newRecipientsDriftCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
-			reports, err := walkRecipientReports(cmd.Context(), args[0], cfg, false)
+			state, err := sf.load()
 			if err != nil {
 				return err
 			}
+			reports, err := walkRecipientReports(cmd.Context(), args[0], cfg, false, state)
+			if err := errors.Join(err, sf.save()); err != nil {
+				return err
+			}
 			return emitReports(cmd.OutOrStdout(), reports, pretty)
 		},
 	}
 	cmd.Flags().StringVar(&configPath, "config", "",
 		"path to .sops.yaml or directory containing it")
 	cmd.Flags().BoolVar(&pretty, "pretty", false, "indent the JSON output")
+	sf.bind(cmd)
 	return cmd
 }
 
@@ -103,6 +111,7 @@ func newRecipientsDriftCmd() *cobra.Command {
 func newRecipientsOrphansCmd() *cobra.Command {
 	var configPath string
 	var pretty bool
+	sf := &stateFlags{}
 	cmd := &cobra.Command{
 		Use:   "orphans ROOT",
 		Short: "Report files with recipients the .sops.yaml rule no longer expects",
@@ -112,30 +121,54 @@ func newRecipientsOrphansCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
-			reports, err := walkRecipientReports(cmd.Context(), args[0], cfg, true)
+			state, err := sf.load()
 			if err != nil {
 				return err
 			}
+			reports, err := walkRecipientReports(cmd.Context(), args[0], cfg, true, state)
+			if err := errors.Join(err, sf.save()); err != nil {
+				return err
+			}
 			return emitReports(cmd.OutOrStdout(), reports, pretty)
 		},
 	}
 	cmd.Flags().StringVar(&configPath, "config", "",
 		"path to .sops.yaml or directory containing it")
 	cmd.Flags().BoolVar(&pretty, "pretty", false, "indent the JSON output")
+	sf.bind(cmd)
 	return cmd
 }
 
 // walkRecipientReports walks root and, for each encrypted file matched
 // by a creation rule in cfg, returns a recipientReport summarizing
 // drift between actual recipients and the rule's expected set. When
-// onlyOrphans is true, reports with no Added entries are omitted.
+// onlyOrphans is true, reports with no Added entries are omitted. When
+// state is non-nil, files it proves unchanged since they were last
+// found plaintext or free of drift under the same config are not
+// read, and such files are remembered in it.
 func walkRecipientReports(
 	ctx context.Context, root string,
-	cfg *sopsconfig.Config, onlyOrphans bool,
+	cfg *sopsconfig.Config, onlyOrphans bool, state *cipher.WalkState,
 ) ([]recipientReport, error) {
 	router := cfg.Router(nil)
 	files := afero.NewOsFs()
 	var reports []recipientReport
+	var scope string
+	if state != nil {
+		rules, err := os.ReadFile(cfg.Path)
+		if err != nil {
+			return nil, fmt.Errorf("read %q: %w", cfg.Path, err)
+		}
+		// The rules decide what drift is, so results under one
+		// version of the config say nothing about another.
+		scope = fmt.Sprintf("recipients %x", sha256.Sum256(rules))
+	}
+	remember := func(path string, data []byte) error {
+		if state == nil {
+			return nil
+		}
+		return state.Remember(files, scope, path, data)
+	}
 
 	err := afero.Walk(files, root, func(path string, info fs.FileInfo, walkErr error) error {
 		if walkErr != nil {
@@ -154,12 +187,21 @@ func walkRecipientReports(
 		if !matched {
 			return nil
 		}
+		if state != nil {
+			unchanged, err := state.Unchanged(files, scope, path)
+			if err != nil {
+				return err
+			}
+			if unchanged {
+				return nil
+			}
+		}
 		data, err := os.ReadFile(path)
 		if err != nil {
 			return fmt.Errorf("read %q: %w", path, err)
 		}
 		if !cipher.IsEncryptedPath(path, data) {
-			return nil
+			return remember(path, data)
 		}
 		actual, err := cipher.InspectPath(path, data)
 		if err != nil {
@@ -175,10 +217,10 @@ func walkRecipientReports(
 			Added:   sortedDiff(actualSet, expected),
 			Removed: sortedDiff(expected, actualSet),
 		}
-		if onlyOrphans && len(report.Added) == 0 {
-			return nil
-		}
 		if len(report.Added) == 0 && len(report.Removed) == 0 {
+			return remember(path, data)
+		}
+		if onlyOrphans && len(report.Added) == 0 {
 			return nil
 		}
 		reports = append(reports, report)
@@ -13,16 +13,23 @@ import (
 // recipients (or a different set, if recipient flags are supplied).
 func newRotateCmd() *cobra.Command {
 	flags := &providerFlags{}
+	ks := &keyServiceFlags{}
 	cmd := &cobra.Command{
 		Use:   "rotate PATH [PATH...]",
 		Short: "Rotate the data key (and optionally recipients) for one or more files",
 		Args:  cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			services, closeKS, err := ks.dial()
+			if err != nil {
+				return err
+			}
+			defer closeKS()
+			flags.keyServices = services
 			enc, err := flags.resolveEncoder(cmd)
 			if err != nil {
 				return err
 			}
-			dec := cipher.NewDecoder()
+			dec := cipher.NewDecoderWith(cipher.DecoderOptions{KeyServices: services})
 			for _, path := range args {
 				data, err := readPathOrStdin(path)
 				if err != nil {
@@ -40,5 +47,6 @@ func newRotateCmd() *cobra.Command {
 		},
 	}
 	flags.bind(cmd.Flags())
+	ks.bind(cmd.Flags())
 	return cmd
 }
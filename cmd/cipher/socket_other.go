@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "net"
+
+// listenUnixPrivate listens on a unix socket at path. Platforms without
+// a umask rely on listenUnix setting the socket's mode afterwards.
+func listenUnixPrivate(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
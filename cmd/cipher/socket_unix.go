@@ -0,0 +1,18 @@
+//go:build unix
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenUnixPrivate listens on a unix socket at path that only the
+// owner can connect to. The socket is created under a 0077 umask, so
+// it is never reachable by others, even before listenUnix tightens its
+// mode.
+func listenUnixPrivate(path string) (net.Listener, error) {
+	old := syscall.Umask(0o077)
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
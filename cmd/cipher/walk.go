@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"regexp"
@@ -12,33 +13,53 @@ import (
 )
 
 // newWalkCmd returns the `cipher walk` command group with encrypt,
-// decrypt, and rotate subcommands.
+// decrypt, rotate, verify, and recover subcommands.
 func newWalkCmd() *cobra.Command {
 	root := &cobra.Command{
 		Use:   "walk",
 		Short: "Walk a directory and apply an operation to every match",
 	}
-	root.AddCommand(newWalkEncryptCmd(), newWalkDecryptCmd(), newWalkRotateCmd())
+	root.AddCommand(newWalkEncryptCmd(), newWalkDecryptCmd(), newWalkRotateCmd(), newWalkVerifyCmd(),
+		newWalkRecoverCmd())
 	return root
 }
 
 // walkFlags holds shared walk-time flags.
 type walkFlags struct {
-	exts         []string
-	regex        string
-	parallel     int
-	backupSuffix string
+	exts            []string
+	regex           string
+	parallel        int
+	backupSuffix    string
+	continueOnError bool
+	transactional   bool
+	out             string
 }
 
 func (w *walkFlags) bind(cmd *cobra.Command) {
+	w.bindMatch(cmd)
+	cmd.Flags().StringVar(&w.backupSuffix, "backup-suffix", "",
+		"copy each original file to <path><suffix> before overwriting (empty disables backups)")
+	cmd.Flags().BoolVar(&w.continueOnError, "continue-on-error", false,
+		"keep going after a file fails and list every failure at the end")
+	cmd.Flags().BoolVar(&w.transactional, "transactional", false,
+		"replace files only if every file succeeds; otherwise roll back (see walk recover)")
+}
+
+// bindMatch binds --ext, --regex, and --parallel, the flags that apply
+// to every walk including verify, which writes nothing.
+func (w *walkFlags) bindMatch(cmd *cobra.Command) {
 	cmd.Flags().StringSliceVar(&w.exts, "ext", []string{"yaml", "yml", "json"},
 		"file extensions to match (comma-separated, repeatable)")
 	cmd.Flags().StringVar(&w.regex, "regex", "",
 		"regex matcher applied to each path (overrides --ext when set)")
 	cmd.Flags().IntVar(&w.parallel, "parallel", 1,
 		"max files processed concurrently")
-	cmd.Flags().StringVar(&w.backupSuffix, "backup-suffix", "",
-		"copy each original file to <path><suffix> before overwriting (empty disables backups)")
+}
+
+// bindOut binds --out, for the verbs that write files.
+func (w *walkFlags) bindOut(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&w.out, "out", "",
+		"write results to this directory, mirroring ROOT, and leave ROOT untouched")
 }
 
 func (w *walkFlags) matchers() ([]cipher.FileMatcher, error) {
@@ -52,26 +73,143 @@ func (w *walkFlags) matchers() ([]cipher.FileMatcher, error) {
 	return []cipher.FileMatcher{cipher.MatchExt(w.exts...)}, nil
 }
 
+// walkFailures returns err, the error of a walk that produced res. When
+// the walk ran with ContinueOnError and files failed, it instead prints
+// a FAIL line per failed file to stderr and returns an error counting
+// them.
+func walkFailures(cmd *cobra.Command, continued bool, res *cipher.WalkResult, err error) error {
+	if !continued || err == nil || res == nil || res.Failed() == 0 {
+		return err
+	}
+	for _, f := range res.Files {
+		if f.Outcome == cipher.FileFailed {
+			fmt.Fprintf(cmd.ErrOrStderr(), "FAIL %v\n", f.Err)
+		}
+	}
+	return fmt.Errorf("%d file(s) failed", res.Failed())
+}
+
+// dryRunFlags holds --dry-run for the verbs that write files, and
+// renders the plan a dry-run walk reports.
+type dryRunFlags struct {
+	dryRun bool
+	failed int
+}
+
+func (d *dryRunFlags) bind(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&d.dryRun, "dry-run", false,
+		"print what would happen to each file without writing anything")
+}
+
+// apply sets opts up for a dry run when --dry-run is set: one line per
+// file on stdout instead of the OnFile and OnSkip output.
+func (d *dryRunFlags) apply(cmd *cobra.Command, opts *cipher.WalkOptions) {
+	if !d.dryRun {
+		return
+	}
+	opts.DryRun = true
+	opts.OnPlan = func(p cipher.FilePlan) {
+		out := cmd.OutOrStdout()
+		switch p.Action {
+		case cipher.PlanSkip:
+			fmt.Fprintf(out, "skip %s: %v\n", p.Path, p.Reason)
+		case cipher.PlanError:
+			d.failed++
+			fmt.Fprintf(out, "error %s: %v\n", p.Path, p.Reason)
+		default:
+			if p.Rule != "" {
+				fmt.Fprintf(out, "would %s %s (rule %s)\n", p.Action, p.Path, p.Rule)
+			} else {
+				fmt.Fprintf(out, "would %s %s\n", p.Action, p.Path)
+			}
+		}
+	}
+}
+
+// result returns err, the walk's error, or on a dry run an error
+// counting the files that would fail.
+func (d *dryRunFlags) result(err error) error {
+	if err != nil || d.failed == 0 {
+		return err
+	}
+	return fmt.Errorf("%d file(s) would fail", d.failed)
+}
+
+// stateFlags holds --state for the verbs that can skip files left
+// unchanged since an earlier run.
+type stateFlags struct {
+	path  string
+	state *cipher.WalkState
+}
+
+func (s *stateFlags) bind(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&s.path, "state", "",
+		"cache file recording the files each run handled; later runs skip them while unchanged")
+}
+
+// load reads the state file named by --state, or returns nil when the
+// flag is unset.
+func (s *stateFlags) load() (*cipher.WalkState, error) {
+	if s.path == "" {
+		return nil, nil
+	}
+	state, err := cipher.LoadWalkState(osFs(), s.path)
+	if err != nil {
+		return nil, err
+	}
+	s.state = state
+	return state, nil
+}
+
+// save writes the state back to the --state file, if one was loaded.
+func (s *stateFlags) save() error {
+	if s.state == nil {
+		return nil
+	}
+	return s.state.Save(osFs(), s.path)
+}
+
 // newWalkEncryptCmd: `cipher walk encrypt ROOT`.
 func newWalkEncryptCmd() *cobra.Command {
 	wf := &walkFlags{}
 	pf := &providerFlags{}
+	ks := &keyServiceFlags{}
+	dry := &dryRunFlags{}
+	sf := &stateFlags{}
+	var stream bool
+	var chunkSize int
 	cmd := &cobra.Command{
 		Use:   "encrypt ROOT",
 		Short: "Encrypt every matching file under ROOT",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			enc, err := pf.resolveEncoder(cmd)
+			matchers, err := wf.matchers()
 			if err != nil {
 				return err
 			}
-			matchers, err := wf.matchers()
+			services, closeKS, err := ks.dial()
+			if err != nil {
+				return err
+			}
+			defer closeKS()
+			pf.keyServices = services
+			if stream && sf.path != "" {
+				return errors.New("--state cannot be combined with --stream")
+			}
+			if wf.out != "" && sf.path != "" {
+				return errors.New("--state cannot be combined with --out")
+			}
+			state, err := sf.load()
 			if err != nil {
 				return err
 			}
 			opts := cipher.WalkOptions{
-				Parallelism:  wf.parallel,
-				BackupSuffix: wf.backupSuffix,
+				Parallelism:     wf.parallel,
+				BackupSuffix:    wf.backupSuffix,
+				ContinueOnError: wf.continueOnError,
+				Transactional:   wf.transactional,
+				OutputRoot:      wf.out,
+				State:           state,
 				OnFile: func(p string, n int) {
 					fmt.Fprintf(cmd.OutOrStdout(), "encrypted %s (%d bytes)\n", p, n)
 				},
@@ -79,17 +217,44 @@ func newWalkEncryptCmd() *cobra.Command {
 					fmt.Fprintf(cmd.ErrOrStderr(), "skipped %s: %v\n", p, reason)
 				},
 			}
-			return cipher.EncodeWalkWith(cmd.Context(), osFs(), args[0], enc, matchers, opts)
+			dry.apply(cmd, &opts)
+			if stream {
+				enc, err := pf.resolveStreamEncoder(chunkSize)
+				if err != nil {
+					return err
+				}
+				res, err := cipher.EncodeStreamWalkWith(cmd.Context(), osFs(), args[0], enc, matchers, opts)
+				return dry.result(walkFailures(cmd, opts.ContinueOnError, res, err))
+			}
+			enc, err := pf.resolveEncoder(cmd)
+			if err != nil {
+				return err
+			}
+			res, err := cipher.EncodeWalkWith(cmd.Context(), osFs(), args[0], enc, matchers, opts)
+			err = walkFailures(cmd, opts.ContinueOnError, res, err)
+			return dry.result(errors.Join(err, sf.save()))
 		},
 	}
 	wf.bind(cmd)
+	wf.bindOut(cmd)
+	dry.bind(cmd)
+	sf.bind(cmd)
 	pf.bind(cmd.Flags())
+	ks.bind(cmd.Flags())
+	cmd.Flags().BoolVar(&stream, "stream", false,
+		"encrypt each file as an opaque chunked stream with bounded memory")
+	cmd.Flags().IntVar(&chunkSize, "chunk-size", 0,
+		"plaintext bytes per chunk with --stream (0 = 64 KiB)")
 	return cmd
 }
 
 // newWalkDecryptCmd: `cipher walk decrypt ROOT`.
 func newWalkDecryptCmd() *cobra.Command {
 	wf := &walkFlags{}
+	ks := &keyServiceFlags{}
+	dry := &dryRunFlags{}
+	sf := &stateFlags{}
+	var stream bool
 	cmd := &cobra.Command{
 		Use:   "decrypt ROOT",
 		Short: "Decrypt every matching file under ROOT",
@@ -99,9 +264,28 @@ func newWalkDecryptCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
+			services, closeKS, err := ks.dial()
+			if err != nil {
+				return err
+			}
+			defer closeKS()
+			if stream && sf.path != "" {
+				return errors.New("--state cannot be combined with --stream")
+			}
+			if wf.out != "" && sf.path != "" {
+				return errors.New("--state cannot be combined with --out")
+			}
+			state, err := sf.load()
+			if err != nil {
+				return err
+			}
 			opts := cipher.WalkOptions{
-				Parallelism:  wf.parallel,
-				BackupSuffix: wf.backupSuffix,
+				Parallelism:     wf.parallel,
+				BackupSuffix:    wf.backupSuffix,
+				ContinueOnError: wf.continueOnError,
+				Transactional:   wf.transactional,
+				OutputRoot:      wf.out,
+				State:           state,
 				OnFile: func(p string, n int) {
 					fmt.Fprintf(cmd.OutOrStdout(), "decrypted %s (%d bytes)\n", p, n)
 				},
@@ -109,13 +293,31 @@ func newWalkDecryptCmd() *cobra.Command {
 					fmt.Fprintf(cmd.ErrOrStderr(), "skipped %s: %v\n", p, reason)
 				},
 			}
-			return cipher.DecodeWalkWith(
+			dry.apply(cmd, &opts)
+			if stream {
+				res, err := cipher.DecodeStreamWalkWith(
+					cmd.Context(), osFs(), args[0],
+					cipher.NewStreamDecoderWith(cipher.StreamDecoderOptions{KeyServices: services}),
+					matchers, opts,
+				)
+				return dry.result(walkFailures(cmd, opts.ContinueOnError, res, err))
+			}
+			res, err := cipher.DecodeWalkWith(
 				cmd.Context(), osFs(), args[0],
-				cipher.NewDecoder(), matchers, opts,
+				cipher.NewDecoderWith(cipher.DecoderOptions{KeyServices: services}),
+				matchers, opts,
 			)
+			err = walkFailures(cmd, opts.ContinueOnError, res, err)
+			return dry.result(errors.Join(err, sf.save()))
 		},
 	}
 	wf.bind(cmd)
+	wf.bindOut(cmd)
+	dry.bind(cmd)
+	sf.bind(cmd)
+	ks.bind(cmd.Flags())
+	cmd.Flags().BoolVar(&stream, "stream", false,
+		"decrypt files written by `cipher walk encrypt --stream`")
 	return cmd
 }
 
@@ -123,12 +325,20 @@ func newWalkDecryptCmd() *cobra.Command {
 func newWalkRotateCmd() *cobra.Command {
 	wf := &walkFlags{}
 	pf := &providerFlags{}
+	ks := &keyServiceFlags{}
+	dry := &dryRunFlags{}
 	var olderThan string
 	cmd := &cobra.Command{
 		Use:   "rotate ROOT",
 		Short: "Rotate every matching file under ROOT",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			services, closeKS, err := ks.dial()
+			if err != nil {
+				return err
+			}
+			defer closeKS()
+			pf.keyServices = services
 			enc, err := pf.resolveEncoder(cmd)
 			if err != nil {
 				return err
@@ -148,8 +358,11 @@ func newWalkRotateCmd() *cobra.Command {
 				matchers = []cipher.FileMatcher{cipher.MatchAllOf(combined...)}
 			}
 			opts := cipher.WalkOptions{
-				Parallelism:  wf.parallel,
-				BackupSuffix: wf.backupSuffix,
+				Parallelism:     wf.parallel,
+				BackupSuffix:    wf.backupSuffix,
+				ContinueOnError: wf.continueOnError,
+				Transactional:   wf.transactional,
+				OutputRoot:      wf.out,
 				OnFile: func(p string, n int) {
 					fmt.Fprintf(cmd.OutOrStdout(), "rotated %s (%d bytes)\n", p, n)
 				},
@@ -157,20 +370,98 @@ func newWalkRotateCmd() *cobra.Command {
 					fmt.Fprintf(cmd.ErrOrStderr(), "skipped %s: %v\n", p, reason)
 				},
 			}
-			return cipher.RotateWalkWith(
+			dry.apply(cmd, &opts)
+			res, err := cipher.RotateWalkWith(
 				cmd.Context(), osFs(), args[0],
-				enc, cipher.NewDecoder(), matchers, opts,
+				enc, cipher.NewDecoderWith(cipher.DecoderOptions{KeyServices: services}),
+				matchers, opts,
 			)
+			return dry.result(walkFailures(cmd, opts.ContinueOnError, res, err))
 		},
 	}
 	wf.bind(cmd)
+	wf.bindOut(cmd)
+	dry.bind(cmd)
 	pf.bind(cmd.Flags())
+	ks.bind(cmd.Flags())
 	cmd.Flags().StringVar(&olderThan, "older-than", "",
 		"only rotate files whose sops metadata.LastModified is older than this"+
 			" duration (e.g. 90d, 720h)")
 	return cmd
 }
 
+// newWalkVerifyCmd: `cipher walk verify ROOT`. It checks every match
+// and exits non-zero if any file fails, listing each failure.
+func newWalkVerifyCmd() *cobra.Command {
+	wf := &walkFlags{}
+	ks := &keyServiceFlags{}
+	sf := &stateFlags{}
+	cmd := &cobra.Command{
+		Use:   "verify ROOT",
+		Short: "Check the MAC of every matching file under ROOT without decrypting to disk",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			matchers, err := wf.matchers()
+			if err != nil {
+				return err
+			}
+			services, closeKS, err := ks.dial()
+			if err != nil {
+				return err
+			}
+			defer closeKS()
+			state, err := sf.load()
+			if err != nil {
+				return err
+			}
+			opts := cipher.WalkOptions{
+				Parallelism: wf.parallel,
+				State:       state,
+				OnFile: func(p string, _ int) {
+					fmt.Fprintf(cmd.OutOrStdout(), "verified %s\n", p)
+				},
+				OnSkip: func(p string, reason error) {
+					fmt.Fprintf(cmd.ErrOrStderr(), "skipped %s: %v\n", p, reason)
+				},
+			}
+			res, err := cipher.VerifyWalkWith(
+				cmd.Context(), osFs(), args[0],
+				cipher.DecoderOptions{KeyServices: services}, matchers, opts,
+			)
+			return errors.Join(walkFailures(cmd, true, res, err), sf.save())
+		},
+	}
+	wf.bindMatch(cmd)
+	sf.bind(cmd)
+	ks.bind(cmd.Flags())
+	return cmd
+}
+
+// newWalkRecoverCmd: `cipher walk recover ROOT`. It replays the
+// journals that transactional walks under ROOT left behind, completing
+// walks that had committed and rolling back the rest.
+func newWalkRecoverCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "recover ROOT",
+		Short: "Finish or roll back transactional walks under ROOT that were interrupted",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			recovered, err := cipher.RecoverWalks(osFs(), args[0])
+			for _, r := range recovered {
+				verb := "rolled back"
+				if r.Committed {
+					verb = "completed"
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%s %d file(s) from %s\n", verb, len(r.Files), r.Journal)
+			}
+			if err == nil && len(recovered) == 0 {
+				fmt.Fprintf(cmd.OutOrStdout(), "no journals found under %s\n", args[0])
+			}
+			return err
+		},
+	}
+}
+
 // olderThanMatcher returns a FileMatcher that admits only files whose
 // sops metadata.LastModified is older than the given duration. The
 // duration accepts the time.ParseDuration syntax extended with a "d"
@@ -0,0 +1,71 @@
+package cipher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dcadolph/cipher/internal/sopsx"
+)
+
+// Convert re-emits an encrypted file in another format, for example
+// secrets.yaml as secrets.json, without re-wrapping the data key. The
+// recipients, key groups, and other sops metadata carry over, so the
+// same identities decrypt the result. Values are decrypted in memory,
+// reshaped by the target format, and encrypted again under the
+// original data key; the MAC is recomputed.
+//
+// Returns ErrNotEncrypted for plain input and an error wrapping
+// ErrUnsupportedFormat when the content cannot be represented in to,
+// such as nested maps in dotenv. The caller needs an identity for one
+// of the file's recipients.
+func Convert(ctx context.Context, data []byte, from, to Format) ([]byte, error) {
+	return ConvertWith(ctx, data, from, to, DecoderOptions{})
+}
+
+// ConvertWith is Convert with explicit options. Key services,
+// identities, decryption order, IgnoreMAC, cipher, and
+// MaxCiphertextBytes apply; Format and the callbacks do not.
+func ConvertWith(
+	ctx context.Context, data []byte, from, to Format, opts DecoderOptions,
+) (_ []byte, err error) {
+	defer wrapError(&err, "convert", "", from)
+	log := opts.Logger
+	if log == nil {
+		log = NopLogger
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	services, err := identityKeyServices(opts.KeyServices, opts.AgeIdentities, opts.PGPPrivateKeys)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+
+	log.Debugf("cipher.Convert start: from=%s to=%s bytes=%d", FormatName(from), FormatName(to), len(data))
+	out, err := sopsx.Convert(ctx, sopsx.ConvertInput{
+		Data:               data,
+		From:               from,
+		To:                 to,
+		KeyServices:        services,
+		DecryptionOrder:    opts.DecryptionOrder,
+		IgnoreMAC:          opts.IgnoreMAC,
+		Cipher:             opts.Cipher,
+		MaxCiphertextBytes: opts.MaxCiphertextBytes,
+	})
+	if ue := asUnwrapError("", err); ue != nil {
+		return nil, ue
+	}
+	switch {
+	case errors.Is(err, sopsx.ErrNotEncrypted):
+		return nil, ErrNotEncrypted
+	case errors.Is(err, sopsx.ErrTooLarge):
+		return nil, ErrTooLarge
+	case errors.Is(err, sopsx.ErrConvert):
+		return nil, fmt.Errorf("%w: %s to %s: %w", ErrUnsupportedFormat, FormatName(from), FormatName(to), err)
+	case err != nil:
+		return nil, fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+	log.Debugf("cipher.Convert done: from=%s to=%s bytes=%d", FormatName(from), FormatName(to), len(out))
+	return out, nil
+}
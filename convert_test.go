@@ -0,0 +1,90 @@
+package cipher_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/dcadolph/cipher"
+	cipherage "github.com/dcadolph/cipher/age"
+)
+
+// TestConvert converts an encrypted file between formats and checks
+// that the recipients carry over and the result decrypts.
+func TestConvert(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	id := mustAgeIdentity(t)
+	enc := cipher.NewEncoder(cipherage.MustNewProvider(id.Recipient().String()))
+	dec := cipher.NewDecoderWith(cipher.DecoderOptions{AgeIdentities: []string{id.String()}})
+	opts := cipher.DecoderOptions{AgeIdentities: []string{id.String()}}
+
+	tests := []struct {
+		Name     string
+		Path     string
+		In       string
+		From, To cipher.Format
+		OutPath  string
+		Want     string
+		WantErr  error
+	}{{ // Test 0: YAML to JSON.
+		Name: "yaml to json", Path: "s.yaml", In: "token: abc\n",
+		From: cipher.FormatYAML, To: cipher.FormatJSON, OutPath: "s.json",
+		Want: "{\n\t\"token\": \"abc\"\n}\n",
+	}, { // Test 1: Dotenv to YAML.
+		Name: "dotenv to yaml", Path: "s.env", In: "TOKEN=abc\n",
+		From: cipher.FormatDotenv, To: cipher.FormatYAML, OutPath: "s.yaml",
+		Want: "TOKEN: abc\n",
+	}, { // Test 2: YAML to TOML.
+		Name: "yaml to toml", Path: "s.yaml", In: "token: abc\ndb:\n  port: 5432\n",
+		From: cipher.FormatYAML, To: cipher.FormatTOML, OutPath: "s.toml",
+		Want: "token = \"abc\"\n\n[db]\nport = 5432\n",
+	}, { // Test 3: Nested YAML cannot become dotenv.
+		Name: "nested to dotenv", Path: "s.yaml", In: "db:\n  user: app\n",
+		From: cipher.FormatYAML, To: cipher.FormatDotenv, WantErr: cipher.ErrUnsupportedFormat,
+	}, { // Test 4: Plain input.
+		Name: "plain", In: "a: b\n",
+		From: cipher.FormatYAML, To: cipher.FormatJSON, WantErr: cipher.ErrNotEncrypted,
+	}}
+
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			t.Parallel()
+			data := []byte(test.In)
+			if test.Path != "" {
+				var err error
+				if data, err = enc.Encode(ctx, test.Path, data); err != nil {
+					t.Fatalf("Encode: %v", err)
+				}
+			}
+			out, err := cipher.ConvertWith(ctx, data, test.From, test.To, opts)
+			if !errors.Is(err, test.WantErr) {
+				t.Fatalf("ConvertWith err = %v, want %v", err, test.WantErr)
+			}
+			if test.WantErr != nil {
+				if e := (*cipher.Error)(nil); !errors.As(err, &e) || e.Op != "convert" {
+					t.Errorf("err %v is not a convert *cipher.Error", err)
+				}
+				return
+			}
+			before, _ := cipher.Inspect(data, test.From)
+			after, err := cipher.Inspect(out, test.To)
+			if err != nil {
+				t.Fatalf("Inspect converted: %v", err)
+			}
+			if diff := cmp.Diff(before.Groups, after.Groups); diff != "" {
+				t.Errorf("recipients changed (-before +after):\n%s", diff)
+			}
+			plain, err := dec.Decode(ctx, test.OutPath, out)
+			if err != nil {
+				t.Fatalf("Decode converted: %v", err)
+			}
+			if diff := cmp.Diff(test.Want, string(plain)); diff != "" {
+				t.Errorf("plaintext mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
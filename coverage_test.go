@@ -485,7 +485,7 @@ func TestRotateWalkSkipsPlain(t *testing.T) {
 	}
 
 	var skipped, rotated int
-	err = cipher.RotateWalkWith(ctx, files, "/root", enc, dec, nil, cipher.WalkOptions{
+	_, err = cipher.RotateWalkWith(ctx, files, "/root", enc, dec, nil, cipher.WalkOptions{
 		OnFile: func(string, int) { rotated++ },
 		OnSkip: func(string, error) { skipped++ },
 	})
@@ -508,9 +508,9 @@ func TestRotateWalkPanicsOnNilArgs(t *testing.T) {
 		Name string
 		Run  func()
 	}{
-		{"nil files", func() { _ = cipher.RotateWalkWith(ctx, nil, "/", noopEnc, noopDec, nil, cipher.WalkOptions{}) }},
-		{"nil enc", func() { _ = cipher.RotateWalkWith(ctx, files, "/", nil, noopDec, nil, cipher.WalkOptions{}) }},
-		{"nil dec", func() { _ = cipher.RotateWalkWith(ctx, files, "/", noopEnc, nil, nil, cipher.WalkOptions{}) }},
+		{"nil files", func() { _, _ = cipher.RotateWalkWith(ctx, nil, "/", noopEnc, noopDec, nil, cipher.WalkOptions{}) }},
+		{"nil enc", func() { _, _ = cipher.RotateWalkWith(ctx, files, "/", nil, noopDec, nil, cipher.WalkOptions{}) }},
+		{"nil dec", func() { _, _ = cipher.RotateWalkWith(ctx, files, "/", noopEnc, nil, nil, cipher.WalkOptions{}) }},
 	}
 	for _, c := range cases {
 		t.Run(c.Name, func(t *testing.T) {
@@ -534,8 +534,8 @@ func TestEncodeWalkPanicsOnNilArgs(t *testing.T) {
 		Name string
 		Run  func()
 	}{
-		{"nil files", func() { _ = cipher.EncodeWalkWith(ctx, nil, "/", noopEnc, nil, cipher.WalkOptions{}) }},
-		{"nil enc", func() { _ = cipher.EncodeWalkWith(ctx, files, "/", nil, nil, cipher.WalkOptions{}) }},
+		{"nil files", func() { _, _ = cipher.EncodeWalkWith(ctx, nil, "/", noopEnc, nil, cipher.WalkOptions{}) }},
+		{"nil enc", func() { _, _ = cipher.EncodeWalkWith(ctx, files, "/", nil, nil, cipher.WalkOptions{}) }},
 	}
 	for _, c := range cases {
 		t.Run(c.Name, func(t *testing.T) {
@@ -559,8 +559,8 @@ func TestDecodeWalkPanicsOnNilArgs(t *testing.T) {
 		Name string
 		Run  func()
 	}{
-		{"nil files", func() { _ = cipher.DecodeWalkWith(ctx, nil, "/", noopDec, nil, cipher.WalkOptions{}) }},
-		{"nil dec", func() { _ = cipher.DecodeWalkWith(ctx, files, "/", nil, nil, cipher.WalkOptions{}) }},
+		{"nil files", func() { _, _ = cipher.DecodeWalkWith(ctx, nil, "/", noopDec, nil, cipher.WalkOptions{}) }},
+		{"nil dec", func() { _, _ = cipher.DecodeWalkWith(ctx, files, "/", nil, nil, cipher.WalkOptions{}) }},
 	}
 	for _, c := range cases {
 		t.Run(c.Name, func(t *testing.T) {
@@ -590,7 +590,7 @@ func TestEncodeWalkParallelMany(t *testing.T) {
 	}
 
 	var processed atomic.Int32
-	err := cipher.EncodeWalkWith(ctx, files, "/root", enc, nil, cipher.WalkOptions{
+	_, err := cipher.EncodeWalkWith(ctx, files, "/root", enc, nil, cipher.WalkOptions{
 		Parallelism: 4,
 		OnFile:      func(string, int) { processed.Add(1) },
 	})
@@ -618,7 +618,7 @@ func TestEncodeWalkParallelCancelOnError(t *testing.T) {
 	enc := cipher.EncoderFunc(func(context.Context, string, []byte) ([]byte, error) {
 		return nil, sentinel
 	})
-	err := cipher.EncodeWalkWith(context.Background(), files, "/root", enc, nil,
+	_, err := cipher.EncodeWalkWith(context.Background(), files, "/root", enc, nil,
 		cipher.WalkOptions{Parallelism: 4})
 	if !errors.Is(err, sentinel) {
 		t.Errorf("err = %v, want sentinel", err)
@@ -643,7 +643,7 @@ func TestEncodeWalkContextCancel(t *testing.T) {
 
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
-	err := cipher.EncodeWalkWith(ctx, files, "/root", enc, nil, cipher.WalkOptions{})
+	_, err := cipher.EncodeWalkWith(ctx, files, "/root", enc, nil, cipher.WalkOptions{})
 	if !errors.Is(err, context.Canceled) {
 		t.Errorf("err = %v, want context.Canceled", err)
 	}
@@ -660,7 +660,7 @@ func TestEncodeWalkBackupOnEncode(t *testing.T) {
 	if err := afero.WriteFile(files, "/root/a.yaml", original, 0o600); err != nil {
 		t.Fatalf("seed: %v", err)
 	}
-	err := cipher.EncodeWalkWith(ctx, files, "/root", enc, nil,
+	_, err := cipher.EncodeWalkWith(ctx, files, "/root", enc, nil,
 		cipher.WalkOptions{BackupSuffix: ".bak"})
 	if err != nil {
 		t.Fatalf("EncodeWalkWith: %v", err)
@@ -683,7 +683,7 @@ func TestDecodeWalkSkipsPlain(t *testing.T) {
 		t.Fatalf("seed: %v", err)
 	}
 	var skips int
-	err := cipher.DecodeWalkWith(context.Background(), files, "/r", dec, nil,
+	_, err := cipher.DecodeWalkWith(context.Background(), files, "/r", dec, nil,
 		cipher.WalkOptions{OnSkip: func(string, error) { skips++ }})
 	if err != nil {
 		t.Errorf("DecodeWalkWith: %v", err)
@@ -705,7 +705,7 @@ func TestWalkMatcherRejection(t *testing.T) {
 		t.Fatalf("seed txt: %v", err)
 	}
 	var skips int
-	err := cipher.EncodeWalkWith(context.Background(), files, "/root", enc,
+	_, err := cipher.EncodeWalkWith(context.Background(), files, "/root", enc,
 		[]cipher.FileMatcher{cipher.MatchExt(".yaml")},
 		cipher.WalkOptions{OnSkip: func(string, error) { skips++ }})
 	if err != nil {
@@ -986,7 +986,7 @@ func TestDiffRecipientsBeforeErr(t *testing.T) {
 // TestFormatNameRoundTrip covers FormatName for each format.
 func TestFormatNameRoundTrip(t *testing.T) {
 	t.Parallel()
-	names := []string{"yaml", "json", "dotenv", "ini", "binary"}
+	names := []string{"yaml", "json", "toml", "dotenv", "ini", "binary"}
 	for _, name := range names {
 		f := cipher.FormatFromString(name)
 		got := cipher.FormatName(f)
@@ -1089,7 +1089,7 @@ func TestFollowSymlinksIgnoredOnMemFS(t *testing.T) {
 		t.Fatalf("seed: %v", err)
 	}
 	dec := cipher.NewDecoder()
-	err := cipher.DecodeWalkWith(context.Background(), files, "/r", dec, nil,
+	_, err := cipher.DecodeWalkWith(context.Background(), files, "/r", dec, nil,
 		cipher.WalkOptions{FollowSymlinks: true})
 	if err != nil {
 		t.Errorf("DecodeWalkWith FollowSymlinks=true: %v", err)
@@ -1118,7 +1118,7 @@ func TestFollowSymlinksDetectsCycle(t *testing.T) {
 		cycles int
 		hits   int
 	)
-	err := cipher.EncodeWalkWith(
+	_, err := cipher.EncodeWalkWith(
 		context.Background(), files, dir, enc,
 		[]cipher.FileMatcher{cipher.MatchExt("yaml")},
 		cipher.WalkOptions{
@@ -1152,7 +1152,7 @@ func TestWalkSerialFastPath(t *testing.T) {
 		t.Fatalf("seed: %v", err)
 	}
 	var hits int
-	err := cipher.EncodeWalkWith(context.Background(), files, "/r", enc, nil,
+	_, err := cipher.EncodeWalkWith(context.Background(), files, "/r", enc, nil,
 		cipher.WalkOptions{Parallelism: 1, OnFile: func(string, int) { hits++ }})
 	if err != nil {
 		t.Fatalf("EncodeWalkWith: %v", err)
@@ -1172,7 +1172,7 @@ func TestWalkPropagatesReadError(t *testing.T) {
 		t.Fatalf("seed: %v", err)
 	}
 	files := &readFailFs{Fs: base}
-	err := cipher.DecodeWalkWith(context.Background(), files, "/r", dec, nil,
+	_, err := cipher.DecodeWalkWith(context.Background(), files, "/r", dec, nil,
 		cipher.WalkOptions{})
 	if err == nil {
 		t.Fatal("err = nil, want read failure")
@@ -37,6 +37,17 @@ type DecoderOptions struct {
 	// KeyServices overrides the default local key service. Empty means
 	// a single local key service is used.
 	KeyServices []keyservice.KeyServiceClient
+	// AgeIdentities are age identities (AGE-SECRET-KEY-1..., SSH
+	// private keys, or identity file contents) held in memory. When
+	// AgeIdentities or PGPPrivateKeys is set, an in-process key service
+	// unwraps age and PGP data keys with them alone and never consults
+	// SOPS_AGE_KEY, key files, or the GnuPG keyring. It is tried before
+	// KeyServices and, when KeyServices is empty, replaces the default
+	// local key service; other key types still reach the local service.
+	AgeIdentities []string
+	// PGPPrivateKeys are armored OpenPGP private keys held in memory.
+	// Passphrase-protected keys are rejected. See AgeIdentities.
+	PGPPrivateKeys [][]byte
 	// DecryptionOrder controls which key types are tried first.
 	// Empty means sops.DefaultDecryptionOrder.
 	DecryptionOrder []string
@@ -53,10 +64,8 @@ type DecoderOptions struct {
 	// file path, the full set of recipients recorded in the file's
 	// metadata, and the error from the recipient-introspection step.
 	// Use this for compliance audit trails when you need to log "this
-	// file was decrypted and these identities are listed on it." The
-	// specific MasterKey that performed the unwrap is not surfaced
-	// because sops's public API does not expose it. The recipient set
-	// is sufficient for most compliance regimes.
+	// file was decrypted and these identities are listed on it." Use
+	// OnUnwrapAudit to learn which master key performed the unwrap.
 	//
 	// When introspection fails (corrupt metadata, format mismatch),
 	// recipients is empty and inspectErr is non-nil. The callback is
@@ -64,6 +73,13 @@ type DecoderOptions struct {
 	// the recipient list could not be read" instead of silently
 	// missing the event.
 	OnDecryptAudit func(path string, recipients []RecipientInfo, inspectErr error)
+	// OnUnwrapAudit is called after every data-key unwrap, successful
+	// or not, with each master key tried in order. The attempt with a
+	// nil Err is the key that unwrapped; the others carry the reason
+	// they failed, such as a KMS AccessDenied or no matching age
+	// identity. A failed unwrap also returns an *UnwrapError with the
+	// same attempts. Nil is a no-op.
+	OnUnwrapAudit func(path string, attempts []UnwrapAttempt)
 	// MaxCiphertextBytes is the maximum allowed ciphertext size in
 	// bytes. Zero means no limit. Callers handling untrusted input
 	// (HTTP request bodies, webhook payloads, etc.) should set this
@@ -85,18 +101,28 @@ func NewDecoderWith(opts DecoderOptions) Decoder {
 	if log == nil {
 		log = NopLogger
 	}
-	return DecoderFunc(func(ctx context.Context, path string, data []byte) ([]byte, error) {
+	services, ksErr := identityKeyServices(opts.KeyServices, opts.AgeIdentities, opts.PGPPrivateKeys)
+	return DecoderFunc(func(ctx context.Context, path string, data []byte) (_ []byte, err error) {
+		defer wrapError(&err, "decode", path, opts.Format)
+		if ksErr != nil {
+			return nil, fmt.Errorf("%w: %w", ErrDecode, ksErr)
+		}
 		log.Debugf("cipher.Decode start: path=%s bytes=%d", path, len(data))
-		out, err := sopsx.Decrypt(sopsx.DecryptInput{
+		out, err := sopsx.Decrypt(ctx, sopsx.DecryptInput{
 			Path:               path,
 			Data:               data,
 			Format:             opts.Format,
-			KeyServices:        opts.KeyServices,
+			KeyServices:        services,
 			DecryptionOrder:    opts.DecryptionOrder,
 			IgnoreMAC:          opts.IgnoreMAC,
 			Cipher:             opts.Cipher,
 			MaxCiphertextBytes: opts.MaxCiphertextBytes,
+			OnUnwrap:           unwrapAudit(opts.OnUnwrapAudit, path),
 		})
+		if ue := asUnwrapError(path, err); ue != nil {
+			log.Warnf("cipher.Decode unwrap failed: path=%s attempts=%d", path, len(ue.Attempts))
+			return nil, ue
+		}
 		switch {
 		case errors.Is(err, sopsx.ErrNotEncrypted):
 			log.Warnf("cipher.Decode skip not-encrypted: path=%s", path)
@@ -114,22 +140,56 @@ func NewDecoderWith(opts DecoderOptions) Decoder {
 			opts.OnDecrypt(path, len(data), len(out))
 		}
 		if opts.OnDecryptAudit != nil {
-			info, infoErr := InspectPath(path, data)
-			if infoErr != nil {
-				log.Warnf("cipher.Decode audit introspection failed: path=%s err=%v", path, infoErr)
-				opts.OnDecryptAudit(path, nil, infoErr)
-			} else {
-				var all []RecipientInfo
-				for _, g := range info.Groups {
-					all = append(all, g...)
-				}
-				opts.OnDecryptAudit(path, all, nil)
-			}
+			auditDecrypt(log, opts.OnDecryptAudit, path, data)
 		}
 		return out, nil
 	})
 }
 
+// identityKeyServices returns services with an in-memory identity key
+// service in front when ageIdentities or pgpKeys is non-empty. Parse
+// failures wrap ErrIdentity.
+func identityKeyServices(
+	services []keyservice.KeyServiceClient, ageIdentities []string, pgpKeys [][]byte,
+) ([]keyservice.KeyServiceClient, error) {
+	if len(ageIdentities) == 0 && len(pgpKeys) == 0 {
+		return services, nil
+	}
+	client, err := sopsx.NewIdentityClient(ageIdentities, pgpKeys)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrIdentity, err)
+	}
+	return append([]keyservice.KeyServiceClient{client}, services...), nil
+}
+
+// unwrapAudit binds path to cb for sopsx's OnUnwrap hook. It returns
+// nil when cb is nil.
+func unwrapAudit(cb func(string, []UnwrapAttempt), path string) func([]UnwrapAttempt) {
+	if cb == nil {
+		return nil
+	}
+	return func(attempts []UnwrapAttempt) { cb(path, attempts) }
+}
+
+// auditDecrypt reads the recipient list of data and reports it to cb.
+// Introspection failures are logged and passed to cb so the decrypt
+// event is still recorded.
+func auditDecrypt(
+	log Logger, cb func(string, []RecipientInfo, error), path string, data []byte,
+) {
+	info, infoErr := InspectPath(path, data)
+	if infoErr != nil {
+		log.Warnf("cipher.Decode audit introspection failed: path=%s err=%v", path, infoErr)
+		cb(path, nil, infoErr)
+		return
+	}
+	var all []RecipientInfo
+	for _, g := range info.Groups {
+		all = append(all, g...)
+	}
+	cb(path, all, nil)
+}
+
 // ChainDecoders returns a Decoder that feeds the output of each Decoder
 // into the next.
 func ChainDecoders(first Decoder, rest ...Decoder) Decoder {
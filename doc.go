@@ -50,6 +50,16 @@
 // environment, Vault token, GPG keyrings). The same environment that
 // drives the sops binary works here.
 //
+// To hold identities per request instead, set
+// [DecoderOptions.AgeIdentities] or [DecoderOptions.PGPPrivateKeys].
+// An in-process key service then unwraps age and PGP data keys with
+// those alone, so services can serve several tenants from one process
+// and tests can run in parallel without touching SOPS_AGE_KEY.
+//
+// Set [EncoderOptions.Kubernetes], on the encoder or on a [Rule], to
+// encrypt only the data and stringData values of Kubernetes Secret and
+// ConfigMap manifests, so apiVersion, kind, and metadata stay readable.
+//
 // # Backends
 //
 // Each backend lives in its own subpackage and implements [KeyProvider]:
@@ -65,6 +75,11 @@
 // recipient decrypts) or [ChainKeyProviders] (each provider stays its
 // own group, useful with [EncoderOptions.ShamirThreshold]).
 //
+// To route data-key wrapping through your own code, such as a custom
+// KMS or an HSM shim, implement [Wrapper] (or fill in [WrapperFuncs])
+// and pass [NewKeyService] in [EncoderOptions.KeyServices] and
+// [DecoderOptions.KeyServices]. No gRPC server is involved.
+//
 // # Walking a directory
 //
 // [EncodeWalk], [DecodeWalk], and [RotateWalk] apply an Encoder, Decoder,
@@ -78,14 +93,77 @@
 // files on decode are not failures; they fire OnSkip with the relevant
 // sentinel error ([ErrAlreadyEncrypted] or [ErrNotEncrypted]).
 //
+// Every walk returns a [WalkResult] listing each visited file's
+// outcome, duration, byte count, and error. A walk stops at the first
+// failure unless [WalkOptions.ContinueOnError] is set, in which case
+// every file is attempted and the returned error joins the failures,
+// so one bad file in a large rotation does not force a restart.
+//
+// Set [WalkOptions.Transactional] to make a walk all-or-nothing: each
+// output is staged beside its file and recorded in a journal in the
+// root, and nothing is replaced until every file has succeeded. A
+// failure or canceled context rolls the whole walk back. If the process
+// dies mid-walk, [RecoverWalks] replays the journal, finishing a walk
+// that had committed and rolling back any other.
+//
+// Set [WalkOptions.OutputRoot], and optionally [WalkOptions.OutputFs],
+// to write results to a separate tree instead of in place. Each file
+// lands at the same path relative to the root with the same mode, and
+// matched files the walk skips are copied unchanged, so a plaintext
+// staging directory can be mirrored into an encrypted one, or an
+// encrypted tree decrypted into a tmpfs, without touching the source.
+//
+// Set [WalkOptions.State] to make repeat walks incremental. A
+// [WalkState] remembers the size, modification time, and SHA-256 of
+// each file an encode, decode, or verify walk handled, and the next
+// walk of the same kind skips files proven unchanged, reporting
+// [ErrUnchanged] to OnSkip without parsing them. Load it with
+// [LoadWalkState] and persist it with [WalkState.Save].
+//
+// Set [WalkOptions.DryRun] to plan a walk without writing: each file is
+// reported to [WalkOptions.OnPlan] as a [FilePlan] saying whether it
+// would be encrypted, decrypted, rotated, skipped, or fail. Encoders and
+// routers that implement [RuleDescriber], such as those from
+// [NewRoutedEncoder] and sopsconfig, also name the rule that would pick
+// each file's recipients.
+//
+// # Streaming large payloads
+//
+// [Encoder] and [Decoder] hold the whole file in memory. For opaque
+// payloads too large for that, such as database dumps, use
+// [NewStreamEncoder] and [NewStreamDecoder]. They read from an
+// [io.Reader] and write to an [io.Writer] in fixed-size chunks, all
+// encrypted under one sops data key wrapped for the usual recipients.
+// The output is a cipher stream rather than a sops document; detect it
+// with [IsEncryptedStream]. [EncodeStreamWalk] and [DecodeStreamWalk]
+// are the walker counterparts.
+//
 // # Operations beyond a single Encode
 //
 //   - [Edit] decrypts, calls a mutator, re-encrypts, and writes atomically.
+//   - [EditTree] applies [SetValue], [DeleteValue], and [RenameKey] edits
+//     under the file's existing data key; unchanged values keep their
+//     exact ENC[...] strings, so diffs show only what changed.
 //   - [Rotate] decrypts and re-encrypts with a fresh data key.
+//   - [MergeEncrypted] three-way merges encrypted files by key and
+//     re-encrypts the result; `cipher git merge-driver` runs it for git.
+//   - [Textconv] renders an encrypted file as stable decrypted, masked,
+//     or keys-only text for `git diff` via `cipher git textconv`.
+//   - [FilterClean] and [FilterSmudge] back git's clean and smudge
+//     filters, keeping the data key of the staged version so unchanged
+//     files clean to the same ciphertext.
+//   - [Convert] re-emits a file in another format under the same data
+//     key and recipients.
 //   - [AddRecipient] inserts new keys into the file's key groups without
 //     decrypting the payload.
 //   - [RemoveRecipient] drops master keys by identifier without
 //     decrypting the payload.
+//   - [MigrateWalk] moves every file under a root from one set of
+//     recipients to another, with a dry-run plan and a per-file report.
+//   - [DecodeValue] decrypts one key path of a YAML, JSON, or TOML file and
+//     leaves every other value encrypted in memory.
+//   - [Verify] checks a file's MAC without returning plaintext;
+//     [VerifyWalk] checks a whole tree and reports every failure.
 //   - [Inspect] and [InspectPath] read recipient metadata without
 //     decryption.
 //   - [DiffRecipients] and [DiffRecipientsPath] compute added and
@@ -107,6 +185,29 @@
 //     path.
 //   - [ErrInputTooLarge] is returned when [EncoderOptions.MaxPlaintextBytes]
 //     is exceeded.
+//   - [ErrKeyPath] and [ErrKeyNotFound] are returned when a key path
+//     is malformed or does not resolve.
+//   - [ErrKeyExists] is returned when [RenameKey] would overwrite a
+//     sibling key.
+//   - [ErrUnsupportedKey] is returned by a [NewKeyService] service
+//     whose [Wrapper] does not handle the requested key.
+//   - [ErrMACMismatch] is returned by [Verify] when a file's values or
+//     MAC have been tampered with.
+//   - [ErrIdentity] is returned when in-memory identities in options
+//     cannot be parsed.
+//   - [ErrStreamTruncated] is returned when an encrypted stream ends
+//     before its authenticated trailer.
+//
+// Operations return an [*Error] that records the operation, path,
+// format, and a [Kind] such as [KindNoIdentity], [KindAccessDenied],
+// [KindMACMismatch], or [KindParse]. It wraps the sentinel chain, so
+// [errors.Is] keeps working; [KindOf] reads the Kind from any error.
+//
+// When no master key can unwrap a file's data key, the [*Error] wraps
+// an [*UnwrapError] listing every key tried, and its Causes hold the
+// failed ones with their reasons. [DecoderOptions.OnUnwrapAudit]
+// reports the same attempts, including the key that succeeded, after
+// every unwrap.
 //
 // # Formats
 //
@@ -115,6 +216,13 @@
 // stable aliases so callers do not need to import sops sub-packages
 // for common operations.
 //
+// [FormatTOML] adds TOML, which sops itself does not read. Values are
+// encrypted per key as in YAML, metadata sits in a [sops] table, and
+// EncryptedRegex, [Inspect], and [DecodeValue] work as they do for
+// YAML. Comments are not kept, plain keys are written before tables,
+// and local dates and times decrypt as offset date-times. The upstream
+// sops binary cannot open these files.
+//
 // # Related subpackages
 //
 //   - cipher/sopsconfig parses .sops.yaml and returns a [Router].
@@ -122,7 +230,15 @@
 //     should be encrypted.
 //   - cipher/httpmw provides net/http middleware that decrypts inbound
 //     bodies or encrypts outbound bodies.
-//   - cipher/otelcipher wraps Encoder, Decoder, and KeyProvider with
-//     OpenTelemetry spans.
+//   - cipher/otelcipher wraps Encoder, Decoder, KeyProvider, the walks,
+//     and Edit and the recipient operations with OpenTelemetry spans
+//     and metrics.
+//   - cipher/promcipher records Prometheus counters and histograms for
+//     the same calls and for walks.
+//   - cipher/audit records encode, decode, rotate, recipient, and walk
+//     events to pluggable sinks, including a hash-chained JSONL file.
+//   - cipher/policy checks the recipients of encrypted files against
+//     declarative rules, such as required key types or a minimum
+//     shamir threshold per path.
 //   - cipher/ciphertest exposes test helpers for code that uses cipher.
 package cipher
@@ -0,0 +1,124 @@
+package cipher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dcadolph/cipher/internal/sopsx"
+)
+
+// TreeEdit is one structured change applied by EditTree. Build one
+// with SetValue, DeleteValue, or RenameKey.
+type TreeEdit struct {
+	op     sopsx.TreeEditOp
+	path   KeyPath
+	value  any
+	newKey string
+}
+
+// SetValue returns a TreeEdit that stores value at path, replacing any
+// existing node. Missing intermediate map keys are created, and an
+// index equal to the array length appends. value may be a string,
+// bool, integer, float, time.Time, nil, map[string]any, or []any.
+func SetValue(path KeyPath, value any) TreeEdit {
+	return TreeEdit{op: sopsx.TreeEditSet, path: path, value: value}
+}
+
+// DeleteValue returns a TreeEdit that removes the node at path.
+func DeleteValue(path KeyPath) TreeEdit {
+	return TreeEdit{op: sopsx.TreeEditDelete, path: path}
+}
+
+// RenameKey returns a TreeEdit that renames the map key at path to
+// newKey, keeping its position. Every value under the key moves to a
+// new key path, so those values are re-encrypted.
+func RenameKey(path KeyPath, newKey string) TreeEdit {
+	return TreeEdit{op: sopsx.TreeEditRename, path: path, newKey: newKey}
+}
+
+// EditTree applies edits to an encrypted YAML, JSON, or TOML document and
+// returns the re-encrypted bytes. Unlike Edit, it keeps the file's
+// data key and recipients and reuses the IV of every unchanged value,
+// so the ENC[...] strings of untouched values stay byte-identical and
+// a diff shows only what changed, plus the MAC and lastmodified
+// metadata. No key service call wraps a new data key.
+//
+// Edits run in order against the first document. The file MAC is
+// verified before editing and recomputed after. Returns ErrKeyNotFound
+// when an edit's path does not resolve and ErrKeyExists when a rename
+// collides.
+func EditTree(ctx context.Context, path string, data []byte, edits ...TreeEdit) ([]byte, error) {
+	return EditTreeWith(ctx, path, data, DecoderOptions{}, edits...)
+}
+
+// EditTreeWith is EditTree with explicit options. opts supplies the
+// format, key services, decryption order, cipher, and size limit used
+// to open the file; IgnoreMAC skips verification before editing.
+func EditTreeWith(
+	ctx context.Context, path string, data []byte, opts DecoderOptions, edits ...TreeEdit,
+) (_ []byte, err error) {
+	defer wrapError(&err, "edit", path, opts.Format)
+	log := opts.Logger
+	if log == nil {
+		log = NopLogger
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	format := opts.Format
+	if format == 0 {
+		format = FormatForPath(path)
+	}
+	if format != FormatYAML && format != FormatJSON && format != FormatTOML {
+		return nil, fmt.Errorf("%w: %q: key paths need YAML, JSON, or TOML", ErrUnsupportedFormat, path)
+	}
+	in := make([]sopsx.TreeEdit, 0, len(edits))
+	for i, e := range edits {
+		if len(e.path) == 0 {
+			return nil, fmt.Errorf("%w: edit %d: empty path", ErrKeyPath, i)
+		}
+		if e.op == sopsx.TreeEditRename && e.path[len(e.path)-1].IsIndex {
+			return nil, fmt.Errorf("%w: edit %d: rename %s: last step must be a map key",
+				ErrKeyPath, i, e.path)
+		}
+		in = append(in, sopsx.TreeEdit{
+			Op:     e.op,
+			Steps:  e.path,
+			Value:  e.value,
+			NewKey: e.newKey,
+		})
+	}
+
+	services, err := identityKeyServices(opts.KeyServices, opts.AgeIdentities, opts.PGPPrivateKeys)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+
+	log.Debugf("cipher.EditTree start: path=%s bytes=%d edits=%d", path, len(data), len(edits))
+	out, err := sopsx.EditTree(ctx, sopsx.EditTreeInput{
+		Path:               path,
+		Data:               data,
+		Format:             format,
+		Edits:              in,
+		KeyServices:        services,
+		DecryptionOrder:    opts.DecryptionOrder,
+		IgnoreMAC:          opts.IgnoreMAC,
+		Cipher:             opts.Cipher,
+		MaxCiphertextBytes: opts.MaxCiphertextBytes,
+	})
+	switch {
+	case errors.Is(err, sopsx.ErrNotEncrypted):
+		return nil, ErrNotEncrypted
+	case errors.Is(err, sopsx.ErrTooLarge):
+		return nil, ErrTooLarge
+	case errors.Is(err, sopsx.ErrPathNotFound):
+		return nil, fmt.Errorf("%w: %w", ErrKeyNotFound, err)
+	case errors.Is(err, sopsx.ErrKeyExists):
+		return nil, fmt.Errorf("%w: %w", ErrKeyExists, err)
+	case err != nil:
+		return nil, fmt.Errorf("%w: %w", ErrEncode, err)
+	}
+	log.Debugf("cipher.EditTree done: path=%s bytes=%d", path, len(out))
+	return out, nil
+}
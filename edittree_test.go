@@ -0,0 +1,146 @@
+package cipher_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/dcadolph/cipher"
+	cipherage "github.com/dcadolph/cipher/age"
+)
+
+// TestEditTree checks decoded output after each kind of edit and that
+// only edited lines and sops metadata differ in the ciphertext.
+func TestEditTree(t *testing.T) {
+	recipient := newAgeIdentity(t)
+	enc := cipher.NewEncoder(cipherage.MustNewProvider(recipient))
+	ctx := context.Background()
+
+	mustPath := func(expr string) cipher.KeyPath {
+		p, err := cipher.ParseKeyPath(expr)
+		if err != nil {
+			t.Fatalf("ParseKeyPath(%q): %v", expr, err)
+		}
+		return p
+	}
+
+	tests := []struct {
+		Name        string
+		Path        string
+		In          string
+		Edits       []cipher.TreeEdit
+		WantOut     string
+		WantChanged []string
+		WantErr     error
+	}{{ // Test 0: Set one YAML leaf; only that line changes.
+		Name: "yaml set", Path: "s.yaml",
+		In:          "db:\n  user: app\n  password: old\napi: key\n",
+		Edits:       []cipher.TreeEdit{cipher.SetValue(mustPath(`["db"]["password"]`), "new")},
+		WantOut:     "db:\n    user: app\n    password: new\napi: key\n",
+		WantChanged: []string{"password:"},
+	}, { // Test 1: Delete a JSON key; remaining values are untouched.
+		Name: "json delete", Path: "s.json",
+		In:      `{"a":"1","b":"2","c":"3"}`,
+		Edits:   []cipher.TreeEdit{cipher.DeleteValue(mustPath(`["b"]`))},
+		WantOut: "{\n\t\"a\": \"1\",\n\t\"c\": \"3\"\n}\n",
+	}, { // Test 2: Rename re-encrypts only the moved value.
+		Name: "yaml rename", Path: "s.yaml",
+		In:          "old: v\nkeep: k\n",
+		Edits:       []cipher.TreeEdit{cipher.RenameKey(mustPath(`["old"]`), "new")},
+		WantOut:     "new: v\nkeep: k\n",
+		WantChanged: []string{"new:"},
+	}, { // Test 3: Edits apply in order.
+		Name: "sequence", Path: "s.yaml",
+		In: "a: 1\n",
+		Edits: []cipher.TreeEdit{
+			cipher.SetValue(mustPath(`["b"]`), map[string]any{"y": true, "x": []any{1, "two"}}),
+			cipher.DeleteValue(mustPath(`["a"]`)),
+		},
+		WantOut:     "b:\n    x:\n        - 1\n        - two\n    \"y\": true\n",
+		WantChanged: []string{"a:", "b:", "x:", "- 1", "- two", "y"},
+	}, { // Test 4: Missing key errors.
+		Name: "missing", Path: "s.yaml",
+		In:      "a: 1\n",
+		Edits:   []cipher.TreeEdit{cipher.DeleteValue(mustPath(`["nope"]`))},
+		WantErr: cipher.ErrKeyNotFound,
+	}, { // Test 5: Rename collision errors.
+		Name: "collision", Path: "s.yaml",
+		In:      "a: 1\nb: 2\n",
+		Edits:   []cipher.TreeEdit{cipher.RenameKey(mustPath(`["a"]`), "b")},
+		WantErr: cipher.ErrKeyExists,
+	}, { // Test 6: Renaming an array index is rejected up front.
+		Name: "rename index", Path: "s.yaml",
+		In:      "l:\n  - a\n",
+		Edits:   []cipher.TreeEdit{cipher.RenameKey(mustPath(`["l"][0]`), "x")},
+		WantErr: cipher.ErrKeyPath,
+	}, { // Test 7: Unsupported format errors.
+		Name: "unsupported", Path: "s.env",
+		In:      "K=v\n",
+		Edits:   []cipher.TreeEdit{cipher.DeleteValue(mustPath(`["K"]`))},
+		WantErr: cipher.ErrUnsupportedFormat,
+	}}
+
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			data, err := enc.Encode(ctx, test.Path, []byte(test.In))
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			out, err := cipher.EditTree(ctx, test.Path, data, test.Edits...)
+			if test.WantErr != nil {
+				if !errors.Is(err, test.WantErr) {
+					t.Fatalf("err = %v, want %v", err, test.WantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := cipher.NewDecoder().Decode(ctx, test.Path, out)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if diff := cmp.Diff(test.WantOut, string(got)); diff != "" {
+				t.Errorf("output mismatch (-want +got):\n%s", diff)
+			}
+			before := strings.Split(string(data), "\n")
+			for _, line := range strings.Split(string(out), "\n") {
+				if !strings.Contains(line, "ENC[") || strings.Contains(line, "mac") {
+					continue
+				}
+				if containsLine(before, line) || containsAny(line, test.WantChanged) {
+					continue
+				}
+				t.Errorf("unexpected changed line %q", line)
+			}
+		})
+	}
+
+	plain := []byte("a: 1\n")
+	_, err := cipher.EditTree(ctx, "s.yaml", plain, cipher.DeleteValue(mustPath(`["a"]`)))
+	if !errors.Is(err, cipher.ErrNotEncrypted) {
+		t.Errorf("plain input err = %v, want ErrNotEncrypted", err)
+	}
+}
+
+func containsLine(lines []string, line string) bool {
+	for _, l := range lines {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(s string, subs []string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
@@ -61,6 +61,13 @@ type EncoderOptions struct {
 	EncryptedSuffix string
 	// UnencryptedSuffix excludes keys with this suffix from encryption.
 	UnencryptedSuffix string
+	// Kubernetes, when true, detects Kubernetes Secret and ConfigMap
+	// manifests, including multi-document YAML, and encrypts only their
+	// data and stringData values (KubernetesEncryptedRegex). It applies
+	// only when none of the four key filters above is set and every
+	// document is a Secret or ConfigMap; other files, including ones
+	// that mix in other kinds, encode as usual.
+	Kubernetes bool
 	// MAC controls which leaves the file MAC covers. Zero value
 	// (MACInherit) defers to base in a router or to the sops default.
 	// Set MACOnAll or MACOnEncrypted to lock the mode for this Encoder
@@ -82,7 +89,8 @@ type EncoderOptions struct {
 	// MaxPlaintextBytes caps the input size. Encode returns an
 	// ErrInputTooLarge wrapping ErrEncode when len(data) > MaxPlaintextBytes.
 	// Zero means no cap. Sops loads the whole file into memory before
-	// emitting, so very large inputs are best detected here.
+	// emitting, so very large inputs are best detected here. Encrypt
+	// large opaque payloads with a StreamEncoder instead.
 	MaxPlaintextBytes int
 }
 
@@ -107,7 +115,8 @@ func NewEncoderWith(kp KeyProvider, opts EncoderOptions) Encoder {
 	if log == nil {
 		log = NopLogger
 	}
-	return EncoderFunc(func(ctx context.Context, path string, data []byte) ([]byte, error) {
+	return EncoderFunc(func(ctx context.Context, path string, data []byte) (_ []byte, err error) {
+		defer wrapError(&err, "encode", path, opts.Format)
 		log.Debugf("cipher.Encode start: path=%s bytes=%d", path, len(data))
 		if opts.MaxPlaintextBytes > 0 && len(data) > opts.MaxPlaintextBytes {
 			return nil, fmt.Errorf("%w: %w (limit %d, got %d)",
@@ -128,7 +137,7 @@ func NewEncoderWith(kp KeyProvider, opts EncoderOptions) Encoder {
 			KeyGroups:         groups,
 			KeyServices:       opts.KeyServices,
 			Cipher:            opts.Cipher,
-			EncryptedRegex:    opts.EncryptedRegex,
+			EncryptedRegex:    kubernetesRegex(opts, path, data),
 			UnencryptedRegex:  opts.UnencryptedRegex,
 			EncryptedSuffix:   opts.EncryptedSuffix,
 			UnencryptedSuffix: opts.UnencryptedSuffix,
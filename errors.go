@@ -1,6 +1,16 @@
 package cipher
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/aws/smithy-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/dcadolph/cipher/internal/sopsx"
+)
 
 // ErrEncode is the sentinel returned when encryption fails. Concrete
 // errors wrap ErrEncode so callers can match with errors.Is.
@@ -29,6 +39,11 @@ var ErrAlreadyEncrypted = errors.New("already encrypted")
 // plain files.
 var ErrNotEncrypted = errors.New("not encrypted")
 
+// ErrUnchanged is reported to WalkOptions.OnSkip for a file that
+// WalkOptions.State proves unchanged since a previous walk of the same
+// kind handled it.
+var ErrUnchanged = errors.New("unchanged since last walk")
+
 // ErrParse is returned when input bytes cannot be parsed as the requested
 // format. Use this with errors.Is to tell "this file is corrupt" apart
 // from "this file is plaintext" (ErrNotEncrypted).
@@ -45,3 +60,294 @@ var ErrOrphanRecipient = errors.New("would orphan secret (no recipients remain)"
 // plaintext during parsing, so callers handling untrusted input should
 // set DecoderOptions.MaxCiphertextBytes to a sensible upper bound.
 var ErrTooLarge = errors.New("ciphertext exceeds size limit")
+
+// ErrKeyPath is returned when a key path expression cannot be parsed.
+var ErrKeyPath = errors.New("invalid key path")
+
+// ErrKeyNotFound is returned when a key path does not resolve to a
+// node in the decoded document.
+var ErrKeyNotFound = errors.New("key path not found")
+
+// ErrKeyExists is returned by EditTree when a RenameKey edit would
+// overwrite an existing sibling key.
+var ErrKeyExists = errors.New("key already exists")
+
+// ErrIdentity is returned when in-memory age identities or PGP private
+// keys supplied through options cannot be parsed.
+var ErrIdentity = errors.New("invalid identity")
+
+// ErrUnsupportedKey is returned by a key service built with
+// NewKeyService when its Wrapper does not handle the requested key.
+// sops moves on to the next key service or master key.
+var ErrUnsupportedKey = errors.New("key not supported by key service")
+
+// ErrMACMismatch is returned by Verify when a file fails its integrity
+// check: the MAC does not match the values, or a value does not
+// authenticate under the file's data key.
+var ErrMACMismatch = errors.New("MAC mismatch")
+
+// ErrMergeConflict is returned by MergeEncrypted when both sides
+// changed the same value in different ways. The accompanying output is
+// plaintext with conflict markers, not an encrypted file.
+var ErrMergeConflict = errors.New("merge conflict")
+
+// Kind categorizes a failure so callers can branch on it without
+// matching error strings. Use KindOf to read it from any error.
+type Kind int
+
+const (
+	// KindUnknown is a failure that fits no other category.
+	KindUnknown Kind = iota
+	// KindNotEncrypted means the input carries no sops metadata.
+	KindNotEncrypted
+	// KindAlreadyEncrypted means the input already carries sops metadata.
+	KindAlreadyEncrypted
+	// KindEmpty means the input has nothing to encrypt.
+	KindEmpty
+	// KindTooLarge means the input exceeds a configured size limit.
+	KindTooLarge
+	// KindUnsupportedFormat means the format is not handled.
+	KindUnsupportedFormat
+	// KindParse means the input is malformed for its format.
+	KindParse
+	// KindMACMismatch means the file failed its integrity check.
+	KindMACMismatch
+	// KindNoIdentity means no available identity matched any recipient.
+	KindNoIdentity
+	// KindAccessDenied means a key backend, such as a KMS, refused the
+	// caller's credentials.
+	KindAccessDenied
+	// KindInvalidIdentity means an identity supplied in options could
+	// not be parsed.
+	KindInvalidIdentity
+	// KindNoRecipients means there are no recipients to encrypt for,
+	// or an edit would leave none.
+	KindNoRecipients
+	// KindKeyPath means a key path is malformed, missing, or collides.
+	KindKeyPath
+	// KindCanceled means the context was canceled or timed out.
+	KindCanceled
+	// KindConflict means a merge could not reconcile both sides.
+	KindConflict
+)
+
+// String returns the lower-case name of k, such as "access-denied".
+func (k Kind) String() string {
+	switch k {
+	case KindNotEncrypted:
+		return "not-encrypted"
+	case KindAlreadyEncrypted:
+		return "already-encrypted"
+	case KindEmpty:
+		return "empty"
+	case KindTooLarge:
+		return "too-large"
+	case KindUnsupportedFormat:
+		return "unsupported-format"
+	case KindParse:
+		return "parse"
+	case KindMACMismatch:
+		return "mac-mismatch"
+	case KindNoIdentity:
+		return "no-identity"
+	case KindAccessDenied:
+		return "access-denied"
+	case KindInvalidIdentity:
+		return "invalid-identity"
+	case KindNoRecipients:
+		return "no-recipients"
+	case KindKeyPath:
+		return "key-path"
+	case KindCanceled:
+		return "canceled"
+	case KindConflict:
+		return "conflict"
+	default:
+		return "unknown"
+	}
+}
+
+// Error is the error returned by the Encoder, Decoder, and other
+// operations of this package. It records where the failure happened
+// and its Kind. Err keeps the original chain, so errors.Is still
+// matches ErrDecode, ErrNotEncrypted, and the other sentinels, and the
+// message is Err's message unchanged.
+type Error struct {
+	// Op is the operation: "encode", "decode", "verify", "edit",
+	// "convert", "merge", "textconv", "clean", "add-recipient", or
+	// "remove-recipient".
+	Op string
+	// Path is the file path passed to the operation.
+	Path string
+	// Format is the format the operation used for Path.
+	Format Format
+	// Kind categorizes the failure.
+	Kind Kind
+	// Causes lists each master key that failed to unwrap the data key
+	// and why. Kind is KindAccessDenied when any cause's key backend
+	// refused the caller's credentials. Empty when the failure is not
+	// a key failure.
+	Causes []UnwrapAttempt
+	// Err is the underlying error.
+	Err error
+}
+
+// Error implements error.
+func (e *Error) Error() string { return e.Err.Error() }
+
+// Unwrap returns e.Err.
+func (e *Error) Unwrap() error { return e.Err }
+
+// KindOf reports the Kind of err: the Kind field of an *Error in its
+// chain, or else a category derived from the sentinels and key service
+// errors it wraps. It returns KindUnknown for nil.
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return classify(err)
+}
+
+// wrapError replaces a non-nil *errp with an *Error for op on path.
+// An *Error already in the chain is left as is. Use it deferred with a
+// named error result.
+func wrapError(errp *error, op, path string, format Format) {
+	err := *errp
+	if err == nil {
+		return
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return
+	}
+	if format == 0 {
+		format = FormatForPath(path)
+	}
+	*errp = &Error{
+		Op: op, Path: path, Format: format,
+		Kind: classify(err), Causes: unwrapCauses(err), Err: err,
+	}
+}
+
+// classify derives a Kind from the chain of err.
+func classify(err error) Kind {
+	switch {
+	case err == nil:
+		return KindUnknown
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return KindCanceled
+	case errors.Is(err, ErrNotEncrypted):
+		return KindNotEncrypted
+	case errors.Is(err, ErrAlreadyEncrypted):
+		return KindAlreadyEncrypted
+	case errors.Is(err, ErrEmpty):
+		return KindEmpty
+	case errors.Is(err, ErrTooLarge), errors.Is(err, ErrInputTooLarge):
+		return KindTooLarge
+	case errors.Is(err, ErrUnsupportedFormat):
+		return KindUnsupportedFormat
+	case errors.Is(err, ErrMACMismatch):
+		return KindMACMismatch
+	case errors.Is(err, ErrParse), errors.Is(err, ErrStreamTruncated):
+		return KindParse
+	case errors.Is(err, ErrIdentity):
+		return KindInvalidIdentity
+	case errors.Is(err, ErrNoKeyGroups), errors.Is(err, ErrNoMatchingRule),
+		errors.Is(err, ErrOrphanRecipient):
+		return KindNoRecipients
+	case errors.Is(err, ErrKeyPath), errors.Is(err, ErrKeyNotFound), errors.Is(err, ErrKeyExists):
+		return KindKeyPath
+	case errors.Is(err, ErrMergeConflict):
+		return KindConflict
+	}
+	if causes := unwrapCauses(err); len(causes) > 0 {
+		for _, c := range causes {
+			if attemptDenied(c.Err) {
+				return KindAccessDenied
+			}
+		}
+		return KindNoIdentity
+	}
+	switch {
+	case accessDenied(err):
+		return KindAccessDenied
+	case errors.Is(err, sopsx.ErrNoIdentity), errors.Is(err, ErrUnsupportedKey):
+		return KindNoIdentity
+	}
+	return KindUnknown
+}
+
+// unwrapCauses returns the failed attempts of an unwrap error in the
+// chain of err, or nil.
+func unwrapCauses(err error) []UnwrapAttempt {
+	var attempts []UnwrapAttempt
+	var ue *UnwrapError
+	var se *sopsx.UnwrapError
+	switch {
+	case errors.As(err, &ue):
+		attempts = ue.Attempts
+	case errors.As(err, &se):
+		attempts = se.Attempts
+	}
+	var failed []UnwrapAttempt
+	for _, a := range attempts {
+		if a.Err != nil {
+			failed = append(failed, a)
+		}
+	}
+	return failed
+}
+
+// deniedCodes are the AWS API error codes that mean the caller's
+// credentials were refused.
+var deniedCodes = map[string]bool{
+	"AccessDenied": true, "AccessDeniedException": true,
+	"UnauthorizedOperation": true, "UnrecognizedClientException": true,
+	"InvalidSignatureException": true, "ExpiredTokenException": true,
+}
+
+// accessDenied reports whether the chain of err holds a typed key
+// backend error refusing the caller's credentials: a gRPC
+// PermissionDenied or Unauthenticated status, or an AWS API error
+// with a denied code.
+func accessDenied(err error) bool {
+	if err == nil {
+		return false
+	}
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.PermissionDenied, codes.Unauthenticated:
+			return true
+		}
+	}
+	var ae smithy.APIError
+	return errors.As(err, &ae) && deniedCodes[ae.ErrorCode()]
+}
+
+// deniedMarkers are lower-cased fragments that cloud KMS and Vault
+// clients put in authorization failures that carry no typed error.
+var deniedMarkers = []string{
+	"accessdenied", "access denied", "permissiondenied", "permission denied",
+	"forbidden", "unauthorized", "unauthenticated", "not authorized",
+}
+
+// attemptDenied reports whether err, the error of one unwrap attempt,
+// is a key backend refusing the caller's credentials. The text of an
+// attempt error is the provider's own, so it falls back to matching
+// deniedMarkers when no typed error is present.
+func attemptDenied(err error) bool {
+	if err == nil {
+		return false
+	}
+	if accessDenied(err) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, m := range deniedMarkers {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
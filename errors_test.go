@@ -0,0 +1,125 @@
+package cipher_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/smithy-go"
+	"github.com/getsops/sops/v3/keyservice"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/dcadolph/cipher"
+	cipherage "github.com/dcadolph/cipher/age"
+)
+
+// TestKindOf checks the categories derived from sentinels.
+func TestKindOf(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		Name string
+		Err  error
+		Want cipher.Kind
+	}{{ // Test 0: nil is unknown.
+		Name: "nil", Want: cipher.KindUnknown,
+	}, { // Test 1: Wrapped sentinel.
+		Name: "not encrypted", Err: fmt.Errorf("x: %w", cipher.ErrNotEncrypted), Want: cipher.KindNotEncrypted,
+	}, { // Test 2: Both size sentinels are too large.
+		Name: "input too large", Err: cipher.ErrInputTooLarge, Want: cipher.KindTooLarge,
+	}, { // Test 3: MAC mismatch.
+		Name: "mac", Err: cipher.ErrMACMismatch, Want: cipher.KindMACMismatch,
+	}, { // Test 4: Canceled context.
+		Name: "canceled", Err: fmt.Errorf("x: %w", context.Canceled), Want: cipher.KindCanceled,
+	}, { // Test 5: gRPC PermissionDenied from a key service.
+		Name: "grpc denied", Err: status.Error(codes.PermissionDenied, "no"), Want: cipher.KindAccessDenied,
+	}, { // Test 6: AWS API error with a denied code.
+		Name: "aws denied", Err: fmt.Errorf("kms: %w", &smithy.GenericAPIError{Code: "AccessDeniedException"}),
+		Want: cipher.KindAccessDenied,
+	}, { // Test 7: AWS API error with another code.
+		Name: "aws other", Err: &smithy.GenericAPIError{Code: "ThrottlingException"}, Want: cipher.KindUnknown,
+	}, { // Test 8: Denied text in an unwrap attempt's provider error.
+		Name: "attempt denied", Err: &cipher.UnwrapError{Attempts: []cipher.UnwrapAttempt{{
+			Type: "hc_vault", Err: errors.New("Code: 403. Errors: permission denied"),
+		}}}, Want: cipher.KindAccessDenied,
+	}, { // Test 9: Denied text outside an unwrap attempt is not classified.
+		Name: "denied text", Err: errors.New("open /srv/forbidden/a.yaml: no such file"), Want: cipher.KindUnknown,
+	}, { // Test 10: An *Error's Kind wins.
+		Name: "typed", Err: &cipher.Error{Kind: cipher.KindParse, Err: errors.New("x")}, Want: cipher.KindParse,
+	}, { // Test 11: Anything else is unknown.
+		Name: "other", Err: errors.New("boom"), Want: cipher.KindUnknown,
+	}}
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			t.Parallel()
+			if got := cipher.KindOf(test.Err); got != test.Want {
+				t.Errorf("KindOf = %v, want %v", got, test.Want)
+			}
+		})
+	}
+}
+
+// TestDecodeError checks that Decode returns an *Error with Op, Path,
+// Format, Kind, and per-key causes while the sentinels still match.
+func TestDecodeError(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	holder, stranger := mustAgeIdentity(t), mustAgeIdentity(t)
+	data, err := cipher.NewEncoder(cipherage.MustNewProvider(holder.Recipient().String())).
+		Encode(ctx, "s.yaml", []byte("a: b\n"))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	denied := cipher.NewKeyService(cipher.WrapperFuncs{
+		UnwrapFunc: func(context.Context, *keyservice.Key, []byte) ([]byte, error) {
+			return nil, status.Error(codes.PermissionDenied, "kms: AccessDenied")
+		},
+	})
+
+	tests := []struct {
+		Name       string
+		Data       []byte
+		Opts       cipher.DecoderOptions
+		WantKind   cipher.Kind
+		WantIs     error
+		WantCauses int
+	}{{ // Test 0: Plain input.
+		Name: "plain", Data: []byte("a: b\n"),
+		WantKind: cipher.KindNotEncrypted, WantIs: cipher.ErrNotEncrypted,
+	}, { // Test 1: No identity matches the recipient.
+		Name: "no identity", Data: data,
+		Opts:     cipher.DecoderOptions{AgeIdentities: []string{stranger.String()}},
+		WantKind: cipher.KindNoIdentity, WantIs: cipher.ErrDecode, WantCauses: 1,
+	}, { // Test 2: The only key service denies access.
+		Name: "access denied", Data: data,
+		Opts:     cipher.DecoderOptions{KeyServices: []keyservice.KeyServiceClient{denied}},
+		WantKind: cipher.KindAccessDenied, WantIs: cipher.ErrDecode, WantCauses: 1,
+	}, { // Test 3: Unparsable in-memory identity.
+		Name: "bad identity", Data: data,
+		Opts:     cipher.DecoderOptions{AgeIdentities: []string{"nope"}},
+		WantKind: cipher.KindInvalidIdentity, WantIs: cipher.ErrIdentity,
+	}}
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			t.Parallel()
+			_, err := cipher.NewDecoderWith(test.Opts).Decode(ctx, "s.yaml", test.Data)
+			var e *cipher.Error
+			if !errors.As(err, &e) {
+				t.Fatalf("Decode err %T (%v) is not *cipher.Error", err, err)
+			}
+			if e.Op != "decode" || e.Path != "s.yaml" || e.Format != cipher.FormatYAML {
+				t.Errorf("Error = {Op:%q Path:%q Format:%v}", e.Op, e.Path, e.Format)
+			}
+			if e.Kind != test.WantKind {
+				t.Errorf("Kind = %v, want %v", e.Kind, test.WantKind)
+			}
+			if !errors.Is(err, test.WantIs) {
+				t.Errorf("errors.Is(%v, %v) = false", err, test.WantIs)
+			}
+			if len(e.Causes) != test.WantCauses {
+				t.Errorf("Causes = %v, want %d", e.Causes, test.WantCauses)
+			}
+		})
+	}
+}
@@ -63,7 +63,7 @@ func ExampleEncodeWalk() {
 	const recipient = "age1..."
 	enc := cipher.NewEncoder(cipherage.MustNewProvider(recipient))
 	files := afero.NewOsFs()
-	err := cipher.EncodeWalk(
+	_, err := cipher.EncodeWalk(
 		context.Background(), files, "./secrets", enc,
 		[]cipher.FileMatcher{cipher.MatchExt("yaml", "yml", "json")},
 	)
@@ -82,7 +82,7 @@ func ExampleEncodeWalkWith() {
 		OnFile:      func(p string, n int) { log.Printf("encrypted %s (%d)", p, n) },
 		OnSkip:      func(p string, reason error) { log.Printf("skipped %s: %v", p, reason) },
 	}
-	err := cipher.EncodeWalkWith(
+	_, err := cipher.EncodeWalkWith(
 		context.Background(), afero.NewOsFs(), "./secrets", enc,
 		[]cipher.FileMatcher{cipher.MatchExt("yaml", "json")}, opts,
 	)
@@ -46,7 +46,7 @@ func main() {
 	}
 
 	matchers := []cipher.FileMatcher{cipher.MatchExt("yaml", "yml", "json")}
-	if err := cipher.EncodeWalkWith(ctx, fs, "/secrets", enc, matchers, opts); err != nil {
+	if _, err := cipher.EncodeWalkWith(ctx, fs, "/secrets", enc, matchers, opts); err != nil {
 		log.Fatalf("walk: %v", err)
 	}
 
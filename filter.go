@@ -0,0 +1,94 @@
+package cipher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dcadolph/cipher/internal/sopsx"
+)
+
+// FilterClean is a git clean filter: it turns the plaintext in the
+// working tree into the ciphertext git stores. previous is the
+// ciphertext git currently holds for path, usually the index entry, or
+// nil for a new file.
+//
+// When plaintext has the same content as previous decrypts to,
+// previous is returned byte for byte, so a checkout that smudged the
+// file does not show as modified. When the content changed, plaintext
+// is encrypted under the data key, recipients, and key filters of
+// previous, and values that did not change keep their ciphertext.
+// Recipient changes in enc's routing therefore apply only to new
+// files; use Rotate or AddRecipient for existing ones.
+//
+// enc encrypts new files, and files whose previous version no held
+// identity can unwrap. Any other failure to reuse previous, such as a
+// MAC mismatch or a key backend refusing access, is returned rather
+// than silently replacing the file's recipients. Plaintext that is
+// empty or already encrypted is returned unchanged.
+func FilterClean(
+	ctx context.Context, path string, plaintext, previous []byte, enc Encoder, opts DecoderOptions,
+) (_ []byte, err error) {
+	if enc == nil {
+		panic("cipher: FilterClean: encoder required")
+	}
+	defer wrapError(&err, "clean", path, opts.Format)
+	log := opts.Logger
+	if log == nil {
+		log = NopLogger
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	format := opts.Format
+	if format == 0 {
+		format = FormatForPath(path)
+	}
+	if len(plaintext) == 0 || IsEncrypted(plaintext, format) {
+		return plaintext, nil
+	}
+
+	if IsEncrypted(previous, format) {
+		services, err := identityKeyServices(opts.KeyServices, opts.AgeIdentities, opts.PGPPrivateKeys)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrDecode, err)
+		}
+		log.Debugf("cipher.FilterClean reencrypt: path=%s bytes=%d", path, len(plaintext))
+		out, err := sopsx.Reencrypt(ctx, sopsx.ReencryptInput{
+			Path:               path,
+			Data:               previous,
+			Plain:              plaintext,
+			Format:             format,
+			KeyServices:        services,
+			DecryptionOrder:    opts.DecryptionOrder,
+			IgnoreMAC:          opts.IgnoreMAC,
+			Cipher:             opts.Cipher,
+			MaxCiphertextBytes: opts.MaxCiphertextBytes,
+			OnUnwrap:           unwrapAudit(opts.OnUnwrapAudit, path),
+		})
+		switch ue := asUnwrapError(path, err); {
+		case err == nil:
+			return out, nil
+		case errors.Is(err, sopsx.ErrNotEncrypted), ue != nil && KindOf(ue) == KindNoIdentity:
+			log.Warnf("cipher.FilterClean previous version unreadable, encrypting fresh: path=%s err=%v", path, err)
+		case ue != nil:
+			return nil, ue
+		case errors.Is(err, sopsx.ErrTooLarge):
+			return nil, ErrTooLarge
+		default:
+			return nil, fmt.Errorf("%w: %w", ErrDecode, err)
+		}
+	}
+	return enc.Encode(ctx, path, plaintext)
+}
+
+// FilterSmudge is a git smudge filter: it decrypts the ciphertext git
+// stores for path into the working tree. Data that is not encrypted is
+// returned unchanged.
+func FilterSmudge(ctx context.Context, path string, data []byte, opts DecoderOptions) ([]byte, error) {
+	out, err := NewDecoderWith(opts).Decode(ctx, path, data)
+	if errors.Is(err, ErrNotEncrypted) {
+		return data, nil
+	}
+	return out, err
+}
@@ -0,0 +1,125 @@
+package cipher_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/dcadolph/cipher"
+	cipherage "github.com/dcadolph/cipher/age"
+)
+
+// TestFilterCleanSmudge round-trips a file through the git filters and
+// checks that cleaning an unchanged checkout reproduces the stored bytes.
+func TestFilterCleanSmudge(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	id := mustAgeIdentity(t)
+	enc := cipher.NewEncoder(cipherage.MustNewProvider(id.Recipient().String()))
+	opts := cipher.DecoderOptions{AgeIdentities: []string{id.String()}}
+
+	stored, err := cipher.FilterClean(ctx, "s.yaml", []byte("a: 1\n"), nil, enc, opts)
+	if err != nil {
+		t.Fatalf("FilterClean new: %v", err)
+	}
+	if !cipher.IsEncrypted(stored, cipher.FormatYAML) {
+		t.Fatalf("clean output not encrypted:\n%s", stored)
+	}
+	checkout, err := cipher.FilterSmudge(ctx, "s.yaml", stored, opts)
+	if err != nil {
+		t.Fatalf("FilterSmudge: %v", err)
+	}
+	if string(checkout) != "a: 1\n" {
+		t.Errorf("smudged = %q", checkout)
+	}
+
+	tests := []struct {
+		Name      string
+		In        string
+		Previous  []byte
+		WantSame  bool
+		WantPlain string
+	}{{ // Test 0: Unchanged checkout cleans to the stored bytes.
+		Name: "unchanged", In: string(checkout), Previous: stored, WantSame: true, WantPlain: "a: 1\n",
+	}, { // Test 1: Edited plaintext produces new ciphertext.
+		Name: "edited", In: "a: 2\n", Previous: stored, WantPlain: "a: 2\n",
+	}, { // Test 2: Ciphertext in the working tree passes through.
+		Name: "already encrypted", In: string(stored), WantSame: true, WantPlain: "a: 1\n",
+	}}
+
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			t.Parallel()
+			out, err := cipher.FilterClean(ctx, "s.yaml", []byte(test.In), test.Previous, enc, opts)
+			if err != nil {
+				t.Fatalf("FilterClean: %v", err)
+			}
+			if same := string(out) == string(stored); same != test.WantSame {
+				t.Errorf("output equals stored = %v, want %v:\n%s", same, test.WantSame, out)
+			}
+			plain, err := cipher.FilterSmudge(ctx, "s.yaml", out, opts)
+			if err != nil {
+				t.Fatalf("FilterSmudge: %v", err)
+			}
+			if string(plain) != test.WantPlain {
+				t.Errorf("smudged = %q, want %q", plain, test.WantPlain)
+			}
+		})
+	}
+
+	if out, err := cipher.FilterSmudge(ctx, "p.yaml", []byte("a: 1\n"), opts); err != nil || string(out) != "a: 1\n" {
+		t.Errorf("FilterSmudge plain = %q, %v", out, err)
+	}
+}
+
+// TestFilterCleanPreviousUnusable checks that FilterClean encrypts
+// fresh only when no identity can unwrap the previous version, and
+// returns any other failure to reuse it.
+func TestFilterCleanPreviousUnusable(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	id, other := mustAgeIdentity(t), mustAgeIdentity(t)
+	enc := cipher.NewEncoder(cipherage.MustNewProvider(id.Recipient().String()))
+	opts := cipher.DecoderOptions{AgeIdentities: []string{id.String()}}
+
+	foreign, err := cipher.NewEncoder(cipherage.MustNewProvider(other.Recipient().String())).
+		Encode(ctx, "s.yaml", []byte("a: 1\n"))
+	if err != nil {
+		t.Fatalf("encode foreign: %v", err)
+	}
+	stored, err := enc.Encode(ctx, "s.yaml", []byte("a: 1\n"))
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	tampered := regexp.MustCompile(`mac: ENC\[AES256_GCM,data:[^,]+`).
+		ReplaceAll(stored, []byte("mac: ENC[AES256_GCM,data:AAAA"))
+
+	tests := []struct {
+		Name     string
+		Previous []byte
+		WantErr  error
+	}{{ // Test 0: A previous version no identity can unwrap is replaced.
+		Name: "foreign", Previous: foreign,
+	}, { // Test 1: A previous version that fails to decrypt is an error.
+		Name: "tampered", Previous: tampered, WantErr: cipher.ErrDecode,
+	}}
+
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			t.Parallel()
+			out, err := cipher.FilterClean(ctx, "s.yaml", []byte("a: 2\n"), test.Previous, enc, opts)
+			if !errors.Is(err, test.WantErr) {
+				t.Fatalf("FilterClean err = %v, want %v", err, test.WantErr)
+			}
+			if test.WantErr != nil {
+				return
+			}
+			plain, err := cipher.FilterSmudge(ctx, "s.yaml", out, opts)
+			if err != nil || string(plain) != "a: 2\n" {
+				t.Errorf("smudged = %q, %v, want %q", plain, err, "a: 2\n")
+			}
+		})
+	}
+}
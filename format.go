@@ -1,32 +1,39 @@
 package cipher
 
-import "github.com/getsops/sops/v3/cmd/sops/formats"
+import (
+	"github.com/getsops/sops/v3/cmd/sops/formats"
+
+	"github.com/dcadolph/cipher/internal/sopsx"
+)
 
 // Format identifies a sops file format. It is a type alias for the
 // underlying sops format type so callers can pass Format values
 // directly to lower-level sops APIs without conversion.
 type Format = formats.Format
 
-// Format constants mirror the underlying sops formats.
+// Format constants mirror the underlying sops formats. FormatTOML has
+// no sops counterpart; cipher supplies its own store for it, so pass it
+// only to cipher APIs.
 const (
 	FormatBinary = formats.Binary
 	FormatDotenv = formats.Dotenv
 	FormatIni    = formats.Ini
 	FormatJSON   = formats.Json
 	FormatYAML   = formats.Yaml
+	FormatTOML   = sopsx.FormatTOML
 )
 
 // FormatForPath returns the format associated with the file path,
 // inferred from its extension. Unknown extensions resolve to FormatBinary.
 func FormatForPath(path string) Format {
-	return formats.FormatForPath(path)
+	return sopsx.FormatForPath(path)
 }
 
 // FormatFromString returns the Format for the given lowercase name
-// ("yaml", "json", "ini", "dotenv", "binary"). Unknown names resolve
-// to FormatBinary.
+// ("yaml", "json", "toml", "ini", "dotenv", "binary"). Unknown names
+// resolve to FormatBinary.
 func FormatFromString(name string) Format {
-	return formats.FormatFromString(name)
+	return sopsx.FormatFromString(name)
 }
 
 // FormatName returns the canonical string name for f.
@@ -36,6 +43,8 @@ func FormatName(f Format) string {
 		return "yaml"
 	case FormatJSON:
 		return "json"
+	case FormatTOML:
+		return "toml"
 	case FormatIni:
 		return "ini"
 	case FormatDotenv:
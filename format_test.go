@@ -29,6 +29,8 @@ func TestFormatForPath(t *testing.T) {
 		{In: "data.bin", Want: FormatBinary},
 		// Test 6: No extension falls back to binary.
 		{In: "data", Want: FormatBinary},
+		// Test 7: TOML by extension.
+		{In: "conf/app.toml", Want: FormatTOML},
 	}
 	for testNum, test := range tests {
 		t.Run(fmt.Sprintf("test %d", testNum), func(t *testing.T) {
@@ -115,8 +115,13 @@ func applyOptions(opts []Option) *config {
 // before invoking the next handler. The Content-Length header is
 // rewritten to match the plaintext.
 //
-// Decryption failures respond with 400. Bodies exceeding the cap
-// respond with 413. The next handler is not invoked in either case.
+// Failures are mapped by cipher.KindOf. Bodies exceeding the cap, or
+// the decoder's MaxCiphertextBytes, respond with 413 and unsupported
+// formats with 415. Key access failures on the server side (a KMS
+// denying its credentials, or an unparsable configured identity)
+// respond with 500 without detail. Every other decryption failure,
+// including a payload no server identity can unwrap, responds with
+// 400. The next handler is not invoked in any of these cases.
 func DecryptRequestBody(
 	dec cipher.Decoder, pathFn PathFunc, opts ...Option,
 ) func(http.Handler) http.Handler {
@@ -139,7 +144,12 @@ func DecryptRequestBody(
 			plain, err := dec.Decode(r.Context(), path, body)
 			if err != nil {
 				cfg.log.Warnf("httpmw.DecryptRequestBody decode: path=%s err=%v", path, err)
-				http.Error(w, "decrypt: "+err.Error(), http.StatusBadRequest)
+				status := decodeStatus(err)
+				if status == http.StatusInternalServerError {
+					http.Error(w, "decrypt failed", status)
+					return
+				}
+				http.Error(w, "decrypt: "+err.Error(), status)
 				return
 			}
 			r.Body = io.NopCloser(bytes.NewReader(plain))
@@ -247,6 +257,21 @@ func errStatus(err error) int {
 	return http.StatusBadRequest
 }
 
+// decodeStatus maps a decode error to an HTTP status code by its
+// cipher.Kind.
+func decodeStatus(err error) int {
+	switch cipher.KindOf(err) {
+	case cipher.KindTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case cipher.KindUnsupportedFormat:
+		return http.StatusUnsupportedMediaType
+	case cipher.KindAccessDenied, cipher.KindInvalidIdentity:
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
+
 // bufferedWriter is a http.ResponseWriter that buffers writes so the
 // middleware can mutate the body before flushing to the client. It
 // intentionally implements only the three http.ResponseWriter methods,
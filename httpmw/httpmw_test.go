@@ -3,6 +3,8 @@ package httpmw_test
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -88,6 +90,51 @@ func TestDecryptRequestBodyTooLarge(t *testing.T) {
 	}
 }
 
+// TestDecryptRequestBodyStatusByKind verifies that decode failures map
+// to HTTP statuses by cipher.Kind and that server-side key failures do
+// not leak detail.
+func TestDecryptRequestBodyStatusByKind(t *testing.T) {
+	tests := []struct {
+		Name       string
+		Err        error
+		WantStatus int
+		WantDetail bool
+	}{{ // Test 0: Decoder size limit is 413.
+		Name: "too large", Err: cipher.ErrTooLarge,
+		WantStatus: http.StatusRequestEntityTooLarge, WantDetail: true,
+	}, { // Test 1: Unsupported format is 415.
+		Name: "unsupported", Err: fmt.Errorf("%w: x", cipher.ErrUnsupportedFormat),
+		WantStatus: http.StatusUnsupportedMediaType, WantDetail: true,
+	}, { // Test 2: KMS access denied is a server failure without detail.
+		Name:       "access denied",
+		Err:        &cipher.Error{Op: "decode", Kind: cipher.KindAccessDenied, Err: errors.New("kms: AccessDenied")},
+		WantStatus: http.StatusInternalServerError,
+	}, { // Test 3: MAC mismatch is the client's problem.
+		Name: "mac", Err: fmt.Errorf("%w: x", cipher.ErrMACMismatch),
+		WantStatus: http.StatusBadRequest, WantDetail: true,
+	}}
+
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			dec := cipher.DecoderFunc(func(context.Context, string, []byte) ([]byte, error) {
+				return nil, test.Err
+			})
+			inner := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+				t.Fatal("inner handler should not be invoked")
+			})
+			wrapped := httpmw.DecryptRequestBody(dec, httpmw.DefaultPathFunc)(inner)
+			rec := httptest.NewRecorder()
+			wrapped.ServeHTTP(rec, httptest.NewRequest("POST", "/x.yaml", strings.NewReader("x")))
+			if rec.Code != test.WantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, test.WantStatus)
+			}
+			if got := strings.Contains(rec.Body.String(), test.Err.Error()); got != test.WantDetail {
+				t.Errorf("body %q: detail present = %v, want %v", rec.Body.String(), got, test.WantDetail)
+			}
+		})
+	}
+}
+
 // TestEncryptResponseBodyRoundTrip verifies the middleware writes
 // encrypted bytes to the client.
 func TestEncryptResponseBodyRoundTrip(t *testing.T) {
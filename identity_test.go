@@ -0,0 +1,129 @@
+package cipher_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/dcadolph/cipher"
+	cipherage "github.com/dcadolph/cipher/age"
+)
+
+// TestDecoderAgeIdentities decrypts with identities passed through
+// DecoderOptions instead of the environment. Subtests run in parallel
+// with a different identity each.
+func TestDecoderAgeIdentities(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	tests := []struct {
+		Name    string
+		Use     func(id, other *age.X25519Identity) []string
+		WantErr error
+	}{{ // Test 0: The matching identity decrypts.
+		Name: "match",
+		Use:  func(id, _ *age.X25519Identity) []string { return []string{id.String()} },
+	}, { // Test 1: Any one of several identities is enough.
+		Name: "several",
+		Use: func(id, other *age.X25519Identity) []string {
+			return []string{other.String(), id.String()}
+		},
+	}, { // Test 2: A non-matching identity fails.
+		Name:    "wrong",
+		Use:     func(_, other *age.X25519Identity) []string { return []string{other.String()} },
+		WantErr: cipher.ErrDecode,
+	}, { // Test 3: Garbage identities report ErrIdentity.
+		Name:    "garbage",
+		Use:     func(_, _ *age.X25519Identity) []string { return []string{"AGE-SECRET-KEY-nope"} },
+		WantErr: cipher.ErrIdentity,
+	}}
+
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			t.Parallel()
+			id, other := mustAgeIdentity(t), mustAgeIdentity(t)
+			enc := cipher.NewEncoder(cipherage.MustNewProvider(id.Recipient().String()))
+			data, err := enc.Encode(ctx, "s.yaml", []byte("k: v\n"))
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			dec := cipher.NewDecoderWith(cipher.DecoderOptions{AgeIdentities: test.Use(id, other)})
+			got, err := dec.Decode(ctx, "s.yaml", data)
+			if test.WantErr != nil {
+				if !errors.Is(err, test.WantErr) {
+					t.Fatalf("err = %v, want %v", err, test.WantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if string(got) != "k: v\n" {
+				t.Errorf("got %q", got)
+			}
+		})
+	}
+}
+
+// TestIdentitiesAcrossOperations checks that value lookups, tree
+// edits, recipient additions, and streams honor in-memory identities.
+func TestIdentitiesAcrossOperations(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	id, added := mustAgeIdentity(t), mustAgeIdentity(t)
+	opts := cipher.DecoderOptions{AgeIdentities: []string{id.String()}}
+	enc := cipher.NewEncoder(cipherage.MustNewProvider(id.Recipient().String()))
+	data, err := enc.Encode(ctx, "s.yaml", []byte("k: v\n"))
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	got, err := cipher.DecodeValueWith(ctx, "s.yaml", data, `["k"]`, opts)
+	if err != nil || string(got) != "v" {
+		t.Fatalf("DecodeValueWith = %q, %v", got, err)
+	}
+
+	edited, err := cipher.EditTreeWith(ctx, "s.yaml", data, opts,
+		cipher.SetValue(cipher.KeyPath{{Key: "k"}}, "w"))
+	if err != nil {
+		t.Fatalf("EditTreeWith: %v", err)
+	}
+
+	widened, err := cipher.AddRecipient(ctx, "s.yaml", edited,
+		cipherage.MustNewProvider(added.Recipient().String()), opts)
+	if err != nil {
+		t.Fatalf("AddRecipient: %v", err)
+	}
+	dec := cipher.NewDecoderWith(cipher.DecoderOptions{AgeIdentities: []string{added.String()}})
+	got, err = dec.Decode(ctx, "s.yaml", widened)
+	if err != nil || string(got) != "k: w\n" {
+		t.Fatalf("decode with added identity = %q, %v", got, err)
+	}
+
+	var ct, pt bytes.Buffer
+	senc := cipher.NewStreamEncoder(cipherage.MustNewProvider(id.Recipient().String()))
+	if err := senc.EncodeStream(ctx, "blob", &ct, bytes.NewReader([]byte("payload"))); err != nil {
+		t.Fatalf("EncodeStream: %v", err)
+	}
+	sdec := cipher.NewStreamDecoderWith(cipher.StreamDecoderOptions{AgeIdentities: []string{id.String()}})
+	if err := sdec.DecodeStream(ctx, "blob", &pt, &ct); err != nil {
+		t.Fatalf("DecodeStream: %v", err)
+	}
+	if pt.String() != "payload" {
+		t.Errorf("stream plaintext = %q", pt.String())
+	}
+}
+
+// mustAgeIdentity generates an age identity without touching the env.
+func mustAgeIdentity(t *testing.T) *age.X25519Identity {
+	t.Helper()
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generate identity: %v", err)
+	}
+	return id
+}
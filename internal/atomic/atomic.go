@@ -5,19 +5,20 @@
 //
 // # Durability
 //
-// WriteFile calls Sync on the temp file before closing and Sync on
-// the parent directory after renaming. On POSIX filesystems backed by
-// afero.OsFs this means data and the rename are durable when WriteFile
-// returns. Some afero adapters (in-memory, network) silently no-op on
-// Sync; on those backends durability is whatever the adapter provides.
-// Some Windows configurations do not allow Sync on directory handles
-// and the dir sync is skipped with no error returned.
+// WriteFile and WriteStream call Sync on the temp file before closing
+// and Sync on the parent directory after renaming. On POSIX filesystems
+// backed by afero.OsFs this means data and the rename are durable when
+// WriteFile returns. Some afero adapters (in-memory, network) silently
+// no-op on Sync; on those backends durability is whatever the adapter
+// provides. Some Windows configurations do not allow Sync on directory
+// handles and the dir sync is skipped with no error returned.
 package atomic
 
 import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -32,6 +33,18 @@ import (
 // Returns the error from the first failing step. On failure the temp
 // file is removed (best effort) and the destination is left untouched.
 func WriteFile(files afero.Fs, path string, data []byte, perm fs.FileMode) error {
+	return WriteStream(files, path, perm, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// WriteStream is WriteFile for content produced incrementally. write
+// is called once with the temp file; whatever it writes becomes the
+// new content of path. If write returns an error the temp file is
+// removed and the destination is left untouched, so a failed stream
+// never exposes a partial file.
+func WriteStream(files afero.Fs, path string, perm fs.FileMode, write func(io.Writer) error) error {
 	dir := filepath.Dir(path)
 	base := filepath.Base(path)
 	tmpName, err := randSuffix()
@@ -44,7 +57,7 @@ func WriteFile(files afero.Fs, path string, data []byte, perm fs.FileMode) error
 	if err != nil {
 		return fmt.Errorf("atomic: open temp %q: %w", tmpPath, err)
 	}
-	if _, err := f.Write(data); err != nil {
+	if err := write(f); err != nil {
 		_ = f.Close()
 		_ = files.Remove(tmpPath)
 		return fmt.Errorf("atomic: write temp %q: %w", tmpPath, err)
@@ -58,11 +71,21 @@ func WriteFile(files afero.Fs, path string, data []byte, perm fs.FileMode) error
 		_ = files.Remove(tmpPath)
 		return fmt.Errorf("atomic: close temp %q: %w", tmpPath, err)
 	}
-	if err := files.Rename(tmpPath, path); err != nil {
+	if err := Rename(files, tmpPath, path); err != nil {
 		_ = files.Remove(tmpPath)
-		return fmt.Errorf("atomic: rename %q -> %q: %w", tmpPath, path, err)
+		return err
+	}
+	return nil
+}
+
+// Rename renames oldPath to newPath, replacing newPath if it exists,
+// and syncs newPath's directory so the rename is durable where the
+// backend supports it.
+func Rename(files afero.Fs, oldPath, newPath string) error {
+	if err := files.Rename(oldPath, newPath); err != nil {
+		return fmt.Errorf("atomic: rename %q -> %q: %w", oldPath, newPath, err)
 	}
-	syncDir(files, dir)
+	syncDir(files, filepath.Dir(newPath))
 	return nil
 }
 
@@ -2,6 +2,7 @@ package atomic_test
 
 import (
 	"errors"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -268,6 +269,75 @@ func TestWriteFileTimingDoesNotPanic(t *testing.T) {
 	}
 }
 
+// TestWriteStream verifies that streamed content lands at the
+// destination and that a failing producer leaves the previous content
+// and no temp file behind.
+func TestWriteStream(t *testing.T) {
+	t.Parallel()
+	files := afero.NewMemMapFs()
+	if err := afero.WriteFile(files, "/data/a.txt", []byte("original"), 0o600); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	err := atomic.WriteStream(files, "/data/a.txt", 0o600, func(w io.Writer) error {
+		for i := range 3 {
+			if _, err := io.WriteString(w, strconv.Itoa(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	got, _ := afero.ReadFile(files, "/data/a.txt")
+	if string(got) != "012" {
+		t.Errorf("read = %q, want %q", got, "012")
+	}
+
+	stub := errors.New("producer failed")
+	err = atomic.WriteStream(files, "/data/a.txt", 0o600, func(w io.Writer) error {
+		_, _ = io.WriteString(w, "partial")
+		return stub
+	})
+	if !errors.Is(err, stub) {
+		t.Fatalf("err = %v, want %v", err, stub)
+	}
+	got, _ = afero.ReadFile(files, "/data/a.txt")
+	if string(got) != "012" {
+		t.Errorf("after failure read = %q, want %q", got, "012")
+	}
+	entries, _ := afero.ReadDir(files, "/data")
+	if len(entries) != 1 {
+		t.Errorf("entries = %d, want 1 (temp file left behind)", len(entries))
+	}
+}
+
+// TestRename verifies that Rename replaces an existing destination and
+// wraps failures.
+func TestRename(t *testing.T) {
+	t.Parallel()
+	files := afero.NewMemMapFs()
+	for path, data := range map[string]string{"/data/new": "new", "/data/a.txt": "old"} {
+		if err := afero.WriteFile(files, path, []byte(data), 0o600); err != nil {
+			t.Fatalf("seed %s: %v", path, err)
+		}
+	}
+	if err := atomic.Rename(files, "/data/new", "/data/a.txt"); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	got, _ := afero.ReadFile(files, "/data/a.txt")
+	if string(got) != "new" {
+		t.Errorf("read = %q, want %q", got, "new")
+	}
+	if ok, _ := afero.Exists(files, "/data/new"); ok {
+		t.Error("source still exists after rename")
+	}
+	err := atomic.Rename(files, "/data/missing", "/data/a.txt")
+	if err == nil || !strings.Contains(err.Error(), "atomic: rename") {
+		t.Errorf("err = %v, want atomic: rename error", err)
+	}
+}
+
 // errStubFile is an afero.File whose Write returns a stub error so the
 // temp body write path can be exercised.
 type errStubFile struct {
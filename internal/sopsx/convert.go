@@ -0,0 +1,119 @@
+package sopsx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/aes"
+	"github.com/getsops/sops/v3/cmd/sops/common"
+	"github.com/getsops/sops/v3/keyservice"
+)
+
+// ErrConvert signals that a tree cannot be represented in the target
+// format, for example nested maps in dotenv.
+var ErrConvert = errors.New("sopsx: cannot convert")
+
+// ConvertInput holds inputs for re-emitting an encrypted file in
+// another format.
+type ConvertInput struct {
+	// Data is the encrypted file content.
+	Data []byte
+	// From is the format of Data. Must be non-zero.
+	From Format
+	// To is the output format. Must be non-zero.
+	To Format
+	// KeyServices are the key services used to unwrap data keys. If empty,
+	// a single local key service is used.
+	KeyServices []keyservice.KeyServiceClient
+	// DecryptionOrder is the order in which decryption methods are tried.
+	// If empty, sops.DefaultDecryptionOrder is used.
+	DecryptionOrder []string
+	// IgnoreMAC, when true, skips verification of the source MAC.
+	IgnoreMAC bool
+	// Cipher is the sops cipher. If nil, aes.NewCipher() is used.
+	Cipher sops.Cipher
+	// MaxCiphertextBytes is the maximum allowed input size in bytes.
+	// Zero means no limit.
+	MaxCiphertextBytes int
+}
+
+// Convert re-emits in.Data in the in.To format under the same data key
+// and metadata. The tree is decrypted in memory, passed through the
+// target store's plain emitter and parser so it takes the shape that
+// format can hold, and encrypted again with the original data key. The
+// MAC is recomputed because it depends on value order and types, which
+// a format change can alter. Values whose key path and plaintext are
+// unchanged keep their ciphertext.
+//
+// Returns ErrNotEncrypted for plain input and ErrConvert when the tree
+// cannot be represented in in.To.
+func Convert(ctx context.Context, in ConvertInput) ([]byte, error) {
+	if in.MaxCiphertextBytes > 0 && len(in.Data) > in.MaxCiphertextBytes {
+		return nil, ErrTooLarge
+	}
+	if !IsEncrypted(in.Data, in.From) {
+		return nil, ErrNotEncrypted
+	}
+
+	cipher := in.Cipher
+	if cipher == nil {
+		cipher = aes.NewCipher()
+	}
+	services := in.KeyServices
+	if len(services) == 0 {
+		services = []keyservice.KeyServiceClient{keyservice.NewLocalClient()}
+	}
+	order := in.DecryptionOrder
+	if len(order) == 0 {
+		order = sops.DefaultDecryptionOrder
+	}
+
+	src := StoreFor(in.From)
+	dst := StoreFor(in.To)
+	tree, err := src.LoadEncryptedFile(in.Data)
+	if err != nil {
+		return nil, fmt.Errorf("sopsx: load encrypted: %w", err)
+	}
+	if _, _, err := UnwrapDataKey(ctx, &tree.Metadata, services, order); err != nil {
+		return nil, err
+	}
+	dataKey, err := common.DecryptTree(common.DecryptTreeOpts{
+		Tree:            &tree,
+		KeyServices:     services,
+		DecryptionOrder: order,
+		IgnoreMac:       in.IgnoreMAC,
+		Cipher:          cipher,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sopsx: decrypt tree: %w", err)
+	}
+	defer clear(dataKey)
+
+	plain, err := dst.EmitPlainFile(tree.Branches)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConvert, err)
+	}
+	branches, err := dst.LoadPlainFile(plain)
+	clear(plain)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConvert, err)
+	}
+	tree.Branches = branches
+	tree.Metadata.LastModified = time.Now().UTC()
+
+	if err := common.EncryptTree(common.EncryptTreeOpts{
+		DataKey: dataKey,
+		Tree:    &tree,
+		Cipher:  cipher,
+	}); err != nil {
+		return nil, fmt.Errorf("sopsx: encrypt tree: %w", err)
+	}
+	out, err := dst.EmitEncryptedFile(tree)
+	if err != nil {
+		return nil, fmt.Errorf("sopsx: emit encrypted: %w", err)
+	}
+	return out, nil
+}
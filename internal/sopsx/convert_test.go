@@ -0,0 +1,76 @@
+package sopsx_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/getsops/sops/v3/cmd/sops/formats"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/dcadolph/cipher/internal/sopsx"
+)
+
+// TestConvert converts encrypted files between formats and checks the
+// result decrypts to the expected plaintext under the same data key.
+func TestConvert(t *testing.T) {
+	groups := ageGroups(t, ageRecipient(t))
+	tests := []struct {
+		Name     string
+		In       string
+		From, To sopsx.Format
+		Want     string
+		WantErr  error
+	}{{ // Test 0: YAML to JSON.
+		Name: "yaml to json", In: "db:\n  user: app\n  port: 5432\n",
+		From: formats.Yaml, To: formats.Json,
+		Want: "{\n\t\"db\": {\n\t\t\"user\": \"app\",\n\t\t\"port\": 5432\n\t}\n}\n",
+	}, { // Test 1: Dotenv to YAML.
+		Name: "dotenv to yaml", In: "A=1\nB=two\n",
+		From: formats.Dotenv, To: formats.Yaml,
+		Want: "A: \"1\"\nB: two\n",
+	}, { // Test 2: Nested maps do not fit dotenv.
+		Name: "yaml to dotenv nested", In: "db:\n  user: app\n",
+		From: formats.Yaml, To: formats.Dotenv, WantErr: sopsx.ErrConvert,
+	}}
+
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			enc, err := sopsx.Encrypt(sopsx.EncryptInput{Data: []byte(test.In), Format: test.From, KeyGroups: groups})
+			if err != nil {
+				t.Fatalf("Encrypt: %v", err)
+			}
+			out, err := sopsx.Convert(context.Background(), sopsx.ConvertInput{Data: enc, From: test.From, To: test.To})
+			if !errors.Is(err, test.WantErr) {
+				t.Fatalf("Convert err = %v, want %v", err, test.WantErr)
+			}
+			if test.WantErr != nil {
+				return
+			}
+			if err := sopsx.Verify(context.Background(), sopsx.VerifyInput{Data: out, Format: test.To}); err != nil {
+				t.Errorf("Verify converted: %v", err)
+			}
+			srcInfo, _ := sopsx.Inspect(enc, test.From)
+			dstInfo, _ := sopsx.Inspect(out, test.To)
+			if diff := cmp.Diff(srcInfo.Groups, dstInfo.Groups); diff != "" {
+				t.Errorf("recipients changed (-src +dst):\n%s", diff)
+			}
+			plain, err := sopsx.Decrypt(context.Background(), sopsx.DecryptInput{Data: out, Format: test.To})
+			if err != nil {
+				t.Fatalf("Decrypt converted: %v", err)
+			}
+			if diff := cmp.Diff(test.Want, string(plain)); diff != "" {
+				t.Errorf("plaintext mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestConvertNotEncrypted rejects plain input.
+func TestConvertNotEncrypted(t *testing.T) {
+	_, err := sopsx.Convert(context.Background(), sopsx.ConvertInput{Data: []byte("a: b\n"), From: formats.Yaml, To: formats.Json})
+	if !errors.Is(err, sopsx.ErrNotEncrypted) {
+		t.Fatalf("Convert err = %v, want ErrNotEncrypted", err)
+	}
+}
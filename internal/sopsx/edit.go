@@ -0,0 +1,342 @@
+package sopsx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/aes"
+	"github.com/getsops/sops/v3/cmd/sops/common"
+	"github.com/getsops/sops/v3/keyservice"
+)
+
+// ErrKeyExists signals that a rename target is already present in the
+// parent map.
+var ErrKeyExists = errors.New("sopsx: key already exists")
+
+// TreeEditOp selects the kind of change a TreeEdit makes.
+type TreeEditOp int
+
+const (
+	// TreeEditSet stores Value at Steps, replacing any existing node.
+	TreeEditSet TreeEditOp = iota + 1
+	// TreeEditDelete removes the node at Steps.
+	TreeEditDelete
+	// TreeEditRename renames the map key at Steps to NewKey.
+	TreeEditRename
+)
+
+// TreeEdit is one structured change to a decrypted sops tree.
+type TreeEdit struct {
+	// Op is the kind of change.
+	Op TreeEditOp
+	// Steps addresses the node to change. Must be non-empty.
+	Steps []PathStep
+	// Value is the new node for TreeEditSet: a scalar, map[string]any,
+	// or []any.
+	Value any
+	// NewKey is the replacement key for TreeEditRename.
+	NewKey string
+}
+
+// EditTreeInput holds inputs for editing an encrypted file in place.
+type EditTreeInput struct {
+	// Path is the file path used to derive Format when Format is zero.
+	Path string
+	// Data is the encrypted file content.
+	Data []byte
+	// Format is the sops format. If zero, it is derived from Path.
+	Format Format
+	// Edits are applied in order to the first document of the file.
+	Edits []TreeEdit
+	// KeyServices are the key services used to unwrap the data key. If
+	// empty, a single local key service is used.
+	KeyServices []keyservice.KeyServiceClient
+	// DecryptionOrder is the order in which decryption methods are tried.
+	// If empty, sops.DefaultDecryptionOrder is used.
+	DecryptionOrder []string
+	// IgnoreMAC, when true, skips MAC verification before editing.
+	IgnoreMAC bool
+	// Cipher is the sops cipher. If nil, aes.NewCipher() is used. The
+	// same instance decrypts and re-encrypts, so a cipher that caches
+	// IVs (as the AES cipher does) keeps unchanged values identical.
+	Cipher sops.Cipher
+	// MaxCiphertextBytes is the maximum allowed input size in bytes.
+	// Zero means no limit.
+	MaxCiphertextBytes int
+}
+
+// EditTree decrypts the file, applies in.Edits, and re-encrypts it
+// under the existing data key and recipients. The AES cipher reuses
+// the IV of every value whose plaintext and key path are unchanged, so
+// those ENC[...] strings come out byte-identical and a version-control
+// diff shows only the edited values plus the MAC and lastmodified
+// metadata. Values under a renamed key move to a new path and are
+// re-encrypted.
+//
+// Returns ErrNotEncrypted for plain input, ErrPathNotFound when an
+// edit's path does not resolve, and ErrKeyExists when a rename would
+// overwrite a sibling.
+func EditTree(ctx context.Context, in EditTreeInput) ([]byte, error) {
+	if in.MaxCiphertextBytes > 0 && len(in.Data) > in.MaxCiphertextBytes {
+		return nil, ErrTooLarge
+	}
+	if in.Format == 0 && in.Path != "" {
+		in.Format = FormatForPath(in.Path)
+	}
+	if !IsEncrypted(in.Data, in.Format) {
+		return nil, ErrNotEncrypted
+	}
+
+	cipher := in.Cipher
+	if cipher == nil {
+		cipher = aes.NewCipher()
+	}
+	services := in.KeyServices
+	if len(services) == 0 {
+		services = []keyservice.KeyServiceClient{keyservice.NewLocalClient()}
+	}
+	order := in.DecryptionOrder
+	if len(order) == 0 {
+		order = sops.DefaultDecryptionOrder
+	}
+
+	store := StoreFor(in.Format)
+	tree, err := store.LoadEncryptedFile(in.Data)
+	if err != nil {
+		return nil, fmt.Errorf("sopsx: load encrypted: %w", err)
+	}
+	if len(tree.Branches) == 0 {
+		return nil, fmt.Errorf("%w: empty document", ErrPathNotFound)
+	}
+	tree.FilePath = in.Path
+	if _, _, err := UnwrapDataKey(ctx, &tree.Metadata, services, order); err != nil {
+		return nil, err
+	}
+
+	dataKey, err := common.DecryptTree(common.DecryptTreeOpts{
+		Tree:            &tree,
+		KeyServices:     services,
+		DecryptionOrder: order,
+		IgnoreMac:       in.IgnoreMAC,
+		Cipher:          cipher,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sopsx: decrypt tree: %w", err)
+	}
+	defer clear(dataKey)
+
+	var root any = tree.Branches[0]
+	for i, edit := range in.Edits {
+		if len(edit.Steps) == 0 {
+			return nil, fmt.Errorf("%w: edit %d: empty key path", ErrPathNotFound, i)
+		}
+		root, err = applyEdit(root, edit.Steps, edit)
+		if err != nil {
+			return nil, fmt.Errorf("edit %d: %w", i, err)
+		}
+	}
+	tree.Branches[0] = root.(sops.TreeBranch)
+	tree.Metadata.LastModified = time.Now().UTC()
+
+	if err := common.EncryptTree(common.EncryptTreeOpts{
+		DataKey: dataKey,
+		Tree:    &tree,
+		Cipher:  cipher,
+	}); err != nil {
+		return nil, fmt.Errorf("sopsx: encrypt tree: %w", err)
+	}
+	out, err := store.EmitEncryptedFile(tree)
+	if err != nil {
+		return nil, fmt.Errorf("sopsx: emit encrypted: %w", err)
+	}
+	return out, nil
+}
+
+// applyEdit returns node with edit applied at steps. Intermediate map
+// keys are created for TreeEditSet; every other missing step fails.
+func applyEdit(node any, steps []PathStep, edit TreeEdit) (any, error) {
+	step, last := steps[0], len(steps) == 1
+	if step.IsIndex {
+		arr, ok := node.([]any)
+		if !ok {
+			return nil, fmt.Errorf("%w: index [%d] into non-array", ErrPathNotFound, step.Index)
+		}
+		pos := arrayPosition(arr, step.Index)
+		appending := edit.Op == TreeEditSet && last && step.Index == countElements(arr)
+		if pos < 0 && !appending {
+			return nil, fmt.Errorf("%w: index [%d] out of range (len %d)",
+				ErrPathNotFound, step.Index, countElements(arr))
+		}
+		if !last {
+			child, err := applyEdit(arr[pos], steps[1:], edit)
+			if err != nil {
+				return nil, err
+			}
+			arr[pos] = child
+			return arr, nil
+		}
+		switch edit.Op {
+		case TreeEditSet:
+			value, err := treeValue(edit.Value)
+			if err != nil {
+				return nil, err
+			}
+			if appending {
+				return append(arr, value), nil
+			}
+			arr[pos] = value
+			return arr, nil
+		case TreeEditDelete:
+			return slices.Delete(arr, pos, pos+1), nil
+		default:
+			return nil, fmt.Errorf("sopsx: rename needs a map key, got index [%d]", step.Index)
+		}
+	}
+
+	branch, ok := node.(sops.TreeBranch)
+	if !ok {
+		return nil, fmt.Errorf("%w: key %q into non-map", ErrPathNotFound, step.Key)
+	}
+	pos := slices.IndexFunc(branch, func(item sops.TreeItem) bool {
+		key, ok := item.Key.(string)
+		return ok && key == step.Key
+	})
+	if !last {
+		if pos < 0 {
+			if edit.Op != TreeEditSet {
+				return nil, fmt.Errorf("%w: key %q not found", ErrPathNotFound, step.Key)
+			}
+			branch = append(branch, sops.TreeItem{Key: step.Key, Value: sops.TreeBranch{}})
+			pos = len(branch) - 1
+		}
+		child, err := applyEdit(branch[pos].Value, steps[1:], edit)
+		if err != nil {
+			return nil, err
+		}
+		branch[pos].Value = child
+		return branch, nil
+	}
+	switch edit.Op {
+	case TreeEditSet:
+		value, err := treeValue(edit.Value)
+		if err != nil {
+			return nil, err
+		}
+		if pos < 0 {
+			return append(branch, sops.TreeItem{Key: step.Key, Value: value}), nil
+		}
+		branch[pos].Value = value
+		return branch, nil
+	case TreeEditDelete:
+		if pos < 0 {
+			return nil, fmt.Errorf("%w: key %q not found", ErrPathNotFound, step.Key)
+		}
+		return slices.Delete(branch, pos, pos+1), nil
+	case TreeEditRename:
+		if pos < 0 {
+			return nil, fmt.Errorf("%w: key %q not found", ErrPathNotFound, step.Key)
+		}
+		if edit.NewKey == step.Key {
+			return branch, nil
+		}
+		if slices.ContainsFunc(branch, func(item sops.TreeItem) bool {
+			key, ok := item.Key.(string)
+			return ok && key == edit.NewKey
+		}) {
+			return nil, fmt.Errorf("%w: %q", ErrKeyExists, edit.NewKey)
+		}
+		branch[pos].Key = edit.NewKey
+		return branch, nil
+	default:
+		return nil, fmt.Errorf("sopsx: unknown edit op %d", edit.Op)
+	}
+}
+
+// arrayPosition maps a logical index, which skips comments, to a slice
+// position. Returns -1 when index is out of range.
+func arrayPosition(arr []any, index int) int {
+	if index < 0 {
+		return -1
+	}
+	for pos, v := range arr {
+		if isCommentNode(v) {
+			continue
+		}
+		if index == 0 {
+			return pos
+		}
+		index--
+	}
+	return -1
+}
+
+// countElements returns the number of non-comment elements in arr.
+func countElements(arr []any) int {
+	n := 0
+	for _, v := range arr {
+		if !isCommentNode(v) {
+			n++
+		}
+	}
+	return n
+}
+
+// treeValue converts a plain Go value into the node types the sops
+// cipher and stores accept. Map keys are sorted so the output order is
+// deterministic.
+func treeValue(v any) (any, error) {
+	switch typed := v.(type) {
+	case nil, string, bool, int, float64, time.Time:
+		return typed, nil
+	case []byte:
+		return string(typed), nil
+	case int8:
+		return int(typed), nil
+	case int16:
+		return int(typed), nil
+	case int32:
+		return int(typed), nil
+	case int64:
+		return int(typed), nil
+	case uint8:
+		return int(typed), nil
+	case uint16:
+		return int(typed), nil
+	case uint32:
+		return int(typed), nil
+	case float32:
+		return float64(typed), nil
+	case map[string]any:
+		keys := make([]string, 0, len(typed))
+		for k := range typed {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		branch := make(sops.TreeBranch, 0, len(keys))
+		for _, k := range keys {
+			child, err := treeValue(typed[k])
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", k, err)
+			}
+			branch = append(branch, sops.TreeItem{Key: k, Value: child})
+		}
+		return branch, nil
+	case []any:
+		out := make([]any, 0, len(typed))
+		for i, elem := range typed {
+			child, err := treeValue(elem)
+			if err != nil {
+				return nil, fmt.Errorf("index [%d]: %w", i, err)
+			}
+			out = append(out, child)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("sopsx: unsupported value type %T", v)
+	}
+}
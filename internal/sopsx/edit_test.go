@@ -0,0 +1,123 @@
+package sopsx_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/dcadolph/cipher/internal/sopsx"
+)
+
+// TestEditTree applies structured edits to an encrypted YAML file and
+// checks the decrypted result and that untouched values keep their
+// exact ciphertext.
+func TestEditTree(t *testing.T) {
+	groups := ageGroups(t, ageRecipient(t))
+	plain := "db:\n  user: app\n  # rotate monthly\n  password: hunter2\nhosts:\n  - a\n  - b\n"
+	enc, err := sopsx.Encrypt(sopsx.EncryptInput{Path: "s.yaml", Data: []byte(plain), KeyGroups: groups})
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	userLine := encLine(t, enc, "user:")
+
+	tests := []struct {
+		Name     string
+		Edits    []sopsx.TreeEdit
+		Want     string
+		KeepUser bool
+		WantErr  error
+	}{{ // Test 1: Set replaces a leaf and keeps siblings byte-identical.
+		Name: "set leaf",
+		Edits: []sopsx.TreeEdit{{Op: sopsx.TreeEditSet,
+			Steps: []sopsx.PathStep{{Key: "db"}, {Key: "password"}}, Value: "rotated"}},
+		Want:     "db:\n    user: app\n    # rotate monthly\n    password: rotated\nhosts:\n    - a\n    - b\n",
+		KeepUser: true,
+	}, { // Test 2: Set creates intermediate maps.
+		Name: "set nested new",
+		Edits: []sopsx.TreeEdit{{Op: sopsx.TreeEditSet,
+			Steps: []sopsx.PathStep{{Key: "cache"}, {Key: "ttl"}}, Value: 30}},
+		Want:     "db:\n    user: app\n    # rotate monthly\n    password: hunter2\nhosts:\n    - a\n    - b\ncache:\n    ttl: 30\n",
+		KeepUser: true,
+	}, { // Test 3: Set at the array length appends.
+		Name: "append",
+		Edits: []sopsx.TreeEdit{{Op: sopsx.TreeEditSet,
+			Steps: []sopsx.PathStep{{Key: "hosts"}, {Index: 2, IsIndex: true}}, Value: "c"}},
+		Want:     "db:\n    user: app\n    # rotate monthly\n    password: hunter2\nhosts:\n    - a\n    - b\n    - c\n",
+		KeepUser: true,
+	}, { // Test 4: Delete an array element.
+		Name: "delete index",
+		Edits: []sopsx.TreeEdit{{Op: sopsx.TreeEditDelete,
+			Steps: []sopsx.PathStep{{Key: "hosts"}, {Index: 0, IsIndex: true}}}},
+		Want:     "db:\n    user: app\n    # rotate monthly\n    password: hunter2\nhosts:\n    - b\n",
+		KeepUser: true,
+	}, { // Test 5: Rename moves the subtree and re-encrypts it.
+		Name: "rename",
+		Edits: []sopsx.TreeEdit{{Op: sopsx.TreeEditRename,
+			Steps: []sopsx.PathStep{{Key: "db"}}, NewKey: "database"}},
+		Want: "database:\n    user: app\n    # rotate monthly\n    password: hunter2\nhosts:\n    - a\n    - b\n",
+	}, { // Test 6: Rename onto an existing key fails.
+		Name: "rename collision",
+		Edits: []sopsx.TreeEdit{{Op: sopsx.TreeEditRename,
+			Steps: []sopsx.PathStep{{Key: "db"}}, NewKey: "hosts"}},
+		WantErr: sopsx.ErrKeyExists,
+	}, { // Test 7: Delete a missing key fails.
+		Name: "delete missing",
+		Edits: []sopsx.TreeEdit{{Op: sopsx.TreeEditDelete,
+			Steps: []sopsx.PathStep{{Key: "nope"}}}},
+		WantErr: sopsx.ErrPathNotFound,
+	}, { // Test 8: Index past the end fails for Set.
+		Name: "set out of range",
+		Edits: []sopsx.TreeEdit{{Op: sopsx.TreeEditSet,
+			Steps: []sopsx.PathStep{{Key: "hosts"}, {Index: 5, IsIndex: true}}, Value: "x"}},
+		WantErr: sopsx.ErrPathNotFound,
+	}, { // Test 9: Empty path fails.
+		Name:    "empty",
+		Edits:   []sopsx.TreeEdit{{Op: sopsx.TreeEditDelete}},
+		WantErr: sopsx.ErrPathNotFound,
+	}}
+	for testNum, tt := range tests {
+		t.Run(fmt.Sprintf("test %d", testNum+1), func(t *testing.T) {
+			out, err := sopsx.EditTree(context.Background(), sopsx.EditTreeInput{Path: "s.yaml", Data: enc, Edits: tt.Edits})
+			if tt.WantErr != nil {
+				if !errors.Is(err, tt.WantErr) {
+					t.Fatalf("%s: err = %v, want %v", tt.Name, err, tt.WantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", tt.Name, err)
+			}
+			got, err := sopsx.Decrypt(context.Background(), sopsx.DecryptInput{Path: "s.yaml", Data: out})
+			if err != nil {
+				t.Fatalf("%s: Decrypt: %v", tt.Name, err)
+			}
+			if diff := cmp.Diff(tt.Want, string(got)); diff != "" {
+				t.Errorf("%s mismatch (-want +got):\n%s", tt.Name, diff)
+			}
+			if tt.KeepUser && encLine(t, out, "user:") != userLine {
+				t.Errorf("%s: unchanged value was re-encrypted", tt.Name)
+			}
+		})
+	}
+
+	_, err = sopsx.EditTree(context.Background(), sopsx.EditTreeInput{Path: "s.yaml", Data: []byte(plain)})
+	if !errors.Is(err, sopsx.ErrNotEncrypted) {
+		t.Errorf("plain input err = %v, want ErrNotEncrypted", err)
+	}
+}
+
+// encLine returns the first line of data containing prefix.
+func encLine(t *testing.T, data []byte, prefix string) string {
+	t.Helper()
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.Contains(line, prefix) {
+			return line
+		}
+	}
+	t.Fatalf("no line with %q in:\n%s", prefix, data)
+	return ""
+}
@@ -0,0 +1,146 @@
+package sopsx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	sopsage "github.com/getsops/sops/v3/age"
+	"github.com/getsops/sops/v3/keyservice"
+)
+
+// ErrNoIdentity signals that an in-memory key service holds no
+// identity able to unwrap a data key of the requested type.
+var ErrNoIdentity = errors.New("sopsx: no in-memory identity for key")
+
+// IdentityServer is a keyservice.KeyServiceServer that unwraps age and
+// PGP data keys with identities held in memory instead of reading
+// SOPS_AGE_KEY, key files, or a GnuPG keyring. Every other key type
+// (KMS, Vault, Azure, ...) and every Encrypt request is forwarded to
+// the standard local server, since those need no private material
+// from the caller.
+//
+// An IdentityServer never falls back to process-wide identity sources
+// for age or PGP keys, so two servers with different identities can
+// run side by side in one process.
+type IdentityServer struct {
+	age   sopsage.ParsedIdentities
+	pgp   openpgp.EntityList
+	local keyservice.Server
+}
+
+// NewIdentityServer parses ageIdentities and pgpKeys into an
+// IdentityServer. Each age entry may be a single identity or the
+// contents of an identity file; blank lines and # comments are
+// ignored. Each PGP entry is an armored private key block, which must
+// not be passphrase protected.
+func NewIdentityServer(ageIdentities []string, pgpKeys [][]byte) (*IdentityServer, error) {
+	srv := &IdentityServer{}
+	if len(ageIdentities) > 0 {
+		if err := srv.age.Import(ageIdentities...); err != nil {
+			return nil, fmt.Errorf("sopsx: age identities: %w", err)
+		}
+	}
+	for i, armored := range pgpKeys {
+		ring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armored))
+		if err != nil {
+			return nil, fmt.Errorf("sopsx: pgp key %d: %w", i, err)
+		}
+		for _, entity := range ring {
+			if entity.PrivateKey == nil {
+				return nil, fmt.Errorf("sopsx: pgp key %d: %X has no private key",
+					i, entity.PrimaryKey.Fingerprint)
+			}
+			if pgpEncrypted(entity) {
+				return nil, fmt.Errorf("sopsx: pgp key %d: %X is passphrase protected",
+					i, entity.PrimaryKey.Fingerprint)
+			}
+		}
+		srv.pgp = append(srv.pgp, ring...)
+	}
+	return srv, nil
+}
+
+// NewIdentityClient returns a key service client backed by a new
+// IdentityServer. See NewIdentityServer for the accepted inputs.
+func NewIdentityClient(ageIdentities []string, pgpKeys [][]byte) (keyservice.KeyServiceClient, error) {
+	srv, err := NewIdentityServer(ageIdentities, pgpKeys)
+	if err != nil {
+		return nil, err
+	}
+	return keyservice.NewCustomLocalClient(srv), nil
+}
+
+// Encrypt wraps a data key. Wrapping uses only public key material, so
+// the request is served by the standard local server.
+func (s *IdentityServer) Encrypt(
+	ctx context.Context, req *keyservice.EncryptRequest,
+) (*keyservice.EncryptResponse, error) {
+	return s.local.Encrypt(ctx, req)
+}
+
+// Decrypt unwraps a data key. Age and PGP keys are unwrapped with the
+// in-memory identities only; other key types go to the local server.
+func (s *IdentityServer) Decrypt(
+	ctx context.Context, req *keyservice.DecryptRequest,
+) (*keyservice.DecryptResponse, error) {
+	switch k := req.GetKey().GetKeyType().(type) {
+	case *keyservice.Key_AgeKey:
+		if len(s.age) == 0 {
+			return nil, fmt.Errorf("%w: age %s", ErrNoIdentity, k.AgeKey.Recipient)
+		}
+		key := sopsage.MasterKey{
+			Recipient:    k.AgeKey.Recipient,
+			EncryptedKey: string(req.Ciphertext),
+		}
+		s.age.ApplyToMasterKey(&key)
+		plain, err := key.Decrypt()
+		if err != nil {
+			return nil, err
+		}
+		return &keyservice.DecryptResponse{Plaintext: plain}, nil
+	case *keyservice.Key_PgpKey:
+		if len(s.pgp) == 0 {
+			return nil, fmt.Errorf("%w: pgp %s", ErrNoIdentity, k.PgpKey.Fingerprint)
+		}
+		plain, err := s.decryptPGP(req.Ciphertext)
+		if err != nil {
+			return nil, fmt.Errorf("pgp %s: %w", k.PgpKey.Fingerprint, err)
+		}
+		return &keyservice.DecryptResponse{Plaintext: plain}, nil
+	default:
+		return s.local.Decrypt(ctx, req)
+	}
+}
+
+// decryptPGP reads an armored PGP message with the in-memory keyring.
+func (s *IdentityServer) decryptPGP(ciphertext []byte) ([]byte, error) {
+	block, err := armor.Decode(strings.NewReader(string(ciphertext)))
+	if err != nil {
+		return nil, fmt.Errorf("armor decode: %w", err)
+	}
+	md, err := openpgp.ReadMessage(block.Body, s.pgp, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNoIdentity, err)
+	}
+	return io.ReadAll(md.UnverifiedBody)
+}
+
+// pgpEncrypted reports whether the primary key or any subkey of entity
+// still needs a passphrase.
+func pgpEncrypted(entity *openpgp.Entity) bool {
+	if entity.PrivateKey.Encrypted {
+		return true
+	}
+	for _, sub := range entity.Subkeys {
+		if sub.PrivateKey != nil && sub.PrivateKey.Encrypted {
+			return true
+		}
+	}
+	return false
+}
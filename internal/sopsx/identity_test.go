@@ -0,0 +1,163 @@
+package sopsx_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/getsops/sops/v3/keyservice"
+
+	"github.com/dcadolph/cipher/internal/sopsx"
+)
+
+// TestIdentityClientAge decrypts a file with an in-memory age identity
+// and checks that a client holding another identity, or none, fails
+// instead of falling back to the environment.
+func TestIdentityClientAge(t *testing.T) {
+	t.Parallel()
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generate identity: %v", err)
+	}
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generate identity: %v", err)
+	}
+	enc, err := sopsx.Encrypt(sopsx.EncryptInput{
+		Path: "s.yaml", Data: []byte("a: b\n"), KeyGroups: ageGroups(t, id.Recipient().String()),
+	})
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	tests := []struct {
+		Name       string
+		Identities []string
+		PGP        [][]byte
+		WantErr    bool
+	}{
+		// Test 1: Matching identity decrypts.
+		{Name: "match", Identities: []string{id.String()}},
+		// Test 2: Identity file contents with comments are accepted.
+		{Name: "file", Identities: []string{"# key\n" + other.String() + "\n" + id.String() + "\n"}},
+		// Test 3: Wrong identity fails.
+		{Name: "wrong", Identities: []string{other.String()}, WantErr: true},
+		// Test 4: PGP only means no age identity at all.
+		{Name: "pgp only", PGP: [][]byte{armoredPGPKey(t, newPGPEntity(t))}, WantErr: true},
+	}
+	for testNum, tt := range tests {
+		t.Run(fmt.Sprintf("test %d", testNum+1), func(t *testing.T) {
+			client, err := sopsx.NewIdentityClient(tt.Identities, tt.PGP)
+			if err != nil {
+				t.Fatalf("%s: NewIdentityClient: %v", tt.Name, err)
+			}
+			got, err := sopsx.Decrypt(context.Background(), sopsx.DecryptInput{
+				Path: "s.yaml", Data: enc, KeyServices: []keyservice.KeyServiceClient{client},
+			})
+			if tt.WantErr {
+				if err == nil {
+					t.Fatalf("%s: decrypt succeeded, want error", tt.Name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%s: Decrypt: %v", tt.Name, err)
+			}
+			if string(got) != "a: b\n" {
+				t.Errorf("%s: got %q", tt.Name, got)
+			}
+		})
+	}
+}
+
+// TestIdentityServerPGP unwraps a PGP-encrypted data key with an
+// in-memory private key and rejects keys it cannot use.
+func TestIdentityServerPGP(t *testing.T) {
+	t.Parallel()
+	entity := newPGPEntity(t)
+	dataKey := []byte("0123456789abcdef0123456789abcdef")
+
+	var msg bytes.Buffer
+	w, err := armor.Encode(&msg, "PGP MESSAGE", nil)
+	if err != nil {
+		t.Fatalf("armor: %v", err)
+	}
+	pt, err := openpgp.Encrypt(w, openpgp.EntityList{entity}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	if _, err := pt.Write(dataKey); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := pt.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close armor: %v", err)
+	}
+	req := &keyservice.DecryptRequest{
+		Ciphertext: msg.Bytes(),
+		Key: &keyservice.Key{KeyType: &keyservice.Key_PgpKey{
+			PgpKey: &keyservice.PgpKey{Fingerprint: fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint)},
+		}},
+	}
+
+	srv, err := sopsx.NewIdentityServer(nil, [][]byte{armoredPGPKey(t, entity)})
+	if err != nil {
+		t.Fatalf("NewIdentityServer: %v", err)
+	}
+	rsp, err := srv.Decrypt(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if !bytes.Equal(rsp.Plaintext, dataKey) {
+		t.Errorf("plaintext = %q, want %q", rsp.Plaintext, dataKey)
+	}
+
+	srv, err = sopsx.NewIdentityServer(nil, [][]byte{armoredPGPKey(t, newPGPEntity(t))})
+	if err != nil {
+		t.Fatalf("NewIdentityServer: %v", err)
+	}
+	if _, err := srv.Decrypt(context.Background(), req); !errors.Is(err, sopsx.ErrNoIdentity) {
+		t.Errorf("wrong key err = %v, want ErrNoIdentity", err)
+	}
+
+	if _, err := sopsx.NewIdentityServer(nil, [][]byte{[]byte("not a key")}); err == nil {
+		t.Error("garbage pgp key accepted")
+	}
+	if _, err := sopsx.NewIdentityServer([]string{"AGE-SECRET-KEY-bogus"}, nil); err == nil {
+		t.Error("garbage age identity accepted")
+	}
+}
+
+// newPGPEntity generates an unprotected OpenPGP key pair.
+func newPGPEntity(t *testing.T) *openpgp.Entity {
+	t.Helper()
+	entity, err := openpgp.NewEntity("cipher test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatalf("new entity: %v", err)
+	}
+	return entity
+}
+
+// armoredPGPKey serializes the private half of entity in armored form.
+func armoredPGPKey(t *testing.T, entity *openpgp.Entity) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatalf("armor: %v", err)
+	}
+	if err := entity.SerializePrivate(w, nil); err != nil {
+		t.Fatalf("serialize: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close armor: %v", err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,398 @@
+package sopsx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/aes"
+	"github.com/getsops/sops/v3/cmd/sops/common"
+	"github.com/getsops/sops/v3/cmd/sops/formats"
+	"github.com/getsops/sops/v3/keyservice"
+)
+
+// MergeInput holds inputs for a three-way merge of encrypted files.
+type MergeInput struct {
+	// Base is the common ancestor. Empty means the file was added on
+	// both sides.
+	Base []byte
+	// Ours is the current side. Its data key and metadata are kept.
+	Ours []byte
+	// Theirs is the side being merged in.
+	Theirs []byte
+	// Format is the sops format of all three inputs. If zero, it is
+	// detected from Ours.
+	Format Format
+	// KeyServices are the key services used to unwrap data keys. If empty,
+	// a single local key service is used.
+	KeyServices []keyservice.KeyServiceClient
+	// DecryptionOrder is the order in which decryption methods are tried.
+	// If empty, sops.DefaultDecryptionOrder is used.
+	DecryptionOrder []string
+	// IgnoreMAC, when true, skips verification of the input MACs.
+	IgnoreMAC bool
+	// Cipher is the sops cipher. If nil, aes.NewCipher() is used.
+	Cipher sops.Cipher
+	// MaxCiphertextBytes is the maximum allowed size of each input in
+	// bytes. Zero means no limit.
+	MaxCiphertextBytes int
+}
+
+// Merge decrypts the three inputs, merges their trees key by key, and
+// re-encrypts the result under the data key and metadata of Ours.
+// Values unchanged from Ours keep their ciphertext.
+//
+// A key changed on only one side takes that side's value; a key changed
+// the same way on both sides merges cleanly. Maps merge recursively;
+// arrays and scalars are compared whole. Comments are taken from Ours.
+//
+// When a key cannot be merged, Merge returns plaintext instead: the
+// file as it would be with every conflict resolved each way, with
+// <<<<<<< ours / ======= / >>>>>>> theirs markers around the lines
+// that differ, and the number of conflicts. The plaintext is never
+// encrypted. Returns ErrNotEncrypted when Ours, Theirs, or a non-empty
+// Base is not encrypted.
+func Merge(ctx context.Context, in MergeInput) ([]byte, int, error) {
+	for _, data := range [][]byte{in.Base, in.Ours, in.Theirs} {
+		if in.MaxCiphertextBytes > 0 && len(data) > in.MaxCiphertextBytes {
+			return nil, 0, ErrTooLarge
+		}
+	}
+	format := in.Format
+	if format == 0 {
+		detected, ok := DetectFormat(in.Ours)
+		if !ok {
+			return nil, 0, ErrNotEncrypted
+		}
+		format = detected
+	}
+	hasBase := len(bytes.TrimSpace(in.Base)) > 0
+	if !IsEncrypted(in.Ours, format) || !IsEncrypted(in.Theirs, format) ||
+		hasBase && !IsEncrypted(in.Base, format) {
+		return nil, 0, ErrNotEncrypted
+	}
+
+	cipher := in.Cipher
+	other := in.Cipher
+	if cipher == nil {
+		// Base and theirs decrypt with their own cipher so their IVs do
+		// not seed the one that re-encrypts ours.
+		cipher, other = aes.NewCipher(), aes.NewCipher()
+	}
+	services := in.KeyServices
+	if len(services) == 0 {
+		services = []keyservice.KeyServiceClient{keyservice.NewLocalClient()}
+	}
+	order := in.DecryptionOrder
+	if len(order) == 0 {
+		order = sops.DefaultDecryptionOrder
+	}
+	store := StoreFor(format)
+	open := func(data []byte, c sops.Cipher) (sops.Tree, []byte, error) {
+		tree, err := store.LoadEncryptedFile(data)
+		if err != nil {
+			return sops.Tree{}, nil, fmt.Errorf("sopsx: load encrypted: %w", err)
+		}
+		if _, _, err := UnwrapDataKey(ctx, &tree.Metadata, services, order); err != nil {
+			return sops.Tree{}, nil, err
+		}
+		key, err := common.DecryptTree(common.DecryptTreeOpts{
+			Tree:            &tree,
+			KeyServices:     services,
+			DecryptionOrder: order,
+			IgnoreMac:       in.IgnoreMAC,
+			Cipher:          c,
+		})
+		if err != nil {
+			return sops.Tree{}, nil, fmt.Errorf("sopsx: decrypt tree: %w", err)
+		}
+		return tree, key, nil
+	}
+
+	ours, dataKey, err := open(in.Ours, cipher)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer clear(dataKey)
+	theirs, theirsKey, err := open(in.Theirs, other)
+	if err != nil {
+		return nil, 0, err
+	}
+	clear(theirsKey)
+	var base sops.TreeBranches
+	if hasBase {
+		tree, baseKey, err := open(in.Base, other)
+		if err != nil {
+			return nil, 0, err
+		}
+		clear(baseKey)
+		base = tree.Branches
+	}
+
+	merged, conflicts := mergeDocuments(base, ours.Branches, theirs.Branches, false)
+	if conflicts > 0 {
+		alt, _ := mergeDocuments(base, ours.Branches, theirs.Branches, true)
+		a, err := store.EmitPlainFile(merged)
+		if err != nil {
+			return nil, 0, fmt.Errorf("sopsx: emit plain: %w", err)
+		}
+		b, err := store.EmitPlainFile(alt)
+		if err != nil {
+			return nil, 0, fmt.Errorf("sopsx: emit plain: %w", err)
+		}
+		return markConflicts(a, b), conflicts, nil
+	}
+
+	ours.Branches = merged
+	ours.Metadata.LastModified = time.Now().UTC()
+	if err := common.EncryptTree(common.EncryptTreeOpts{
+		DataKey: dataKey,
+		Tree:    &ours,
+		Cipher:  cipher,
+	}); err != nil {
+		return nil, 0, fmt.Errorf("sopsx: encrypt tree: %w", err)
+	}
+	out, err := store.EmitEncryptedFile(ours)
+	if err != nil {
+		return nil, 0, fmt.Errorf("sopsx: emit encrypted: %w", err)
+	}
+	return out, 0, nil
+}
+
+// DetectFormat returns the structured format data parses as with sops
+// metadata, trying JSON, YAML, TOML, INI, and dotenv in that order.
+// The second result is false when none match.
+func DetectFormat(data []byte) (Format, bool) {
+	for _, f := range []Format{formats.Json, formats.Yaml, FormatTOML, formats.Ini, formats.Dotenv} {
+		if IsEncrypted(data, f) {
+			return f, true
+		}
+	}
+	return 0, false
+}
+
+// absentNode marks a key or document missing on one side of a merge.
+type absentNode struct{}
+
+// isAbsent reports whether v is absentNode.
+func isAbsent(v any) bool {
+	_, ok := v.(absentNode)
+	return ok
+}
+
+// mergeDocuments merges each document by position. takeTheirs picks
+// the side a conflict resolves to; the conflict count is the same
+// either way.
+func mergeDocuments(base, ours, theirs sops.TreeBranches, takeTheirs bool) (sops.TreeBranches, int) {
+	doc := func(branches sops.TreeBranches, i int) any {
+		if i < len(branches) {
+			return branches[i]
+		}
+		return absentNode{}
+	}
+	var out sops.TreeBranches
+	conflicts := 0
+	for i := range max(len(ours), len(theirs)) {
+		v, n := mergeNode(doc(base, i), doc(ours, i), doc(theirs, i), takeTheirs)
+		conflicts += n
+		if branch, ok := v.(sops.TreeBranch); ok {
+			out = append(out, branch)
+		}
+	}
+	return out, conflicts
+}
+
+// mergeNode merges one node. It returns absentNode when the merged
+// node is deleted.
+func mergeNode(base, ours, theirs any, takeTheirs bool) (any, int) {
+	switch {
+	case reflect.DeepEqual(ours, theirs), reflect.DeepEqual(base, theirs):
+		return ours, 0
+	case reflect.DeepEqual(base, ours):
+		return theirs, 0
+	}
+	o, oursMap := ours.(sops.TreeBranch)
+	t, theirsMap := theirs.(sops.TreeBranch)
+	if oursMap && theirsMap {
+		b, _ := base.(sops.TreeBranch)
+		return mergeBranch(b, o, t, takeTheirs)
+	}
+	if takeTheirs {
+		return theirs, 1
+	}
+	return ours, 1
+}
+
+// mergeBranch merges two maps against their base. Keys keep the order
+// of ours, followed by keys only theirs has, in their order.
+func mergeBranch(base, ours, theirs sops.TreeBranch, takeTheirs bool) (sops.TreeBranch, int) {
+	lookup := func(branch sops.TreeBranch, key any) any {
+		for _, item := range branch {
+			if item.Key == key {
+				return item.Value
+			}
+		}
+		return absentNode{}
+	}
+	out := make(sops.TreeBranch, 0, len(ours))
+	conflicts := 0
+	add := func(key, b, o, t any) {
+		v, n := mergeNode(b, o, t, takeTheirs)
+		conflicts += n
+		if !isAbsent(v) {
+			out = append(out, sops.TreeItem{Key: key, Value: v})
+		}
+	}
+	for _, item := range ours {
+		if _, isComment := item.Key.(sops.Comment); isComment {
+			out = append(out, item)
+			continue
+		}
+		add(item.Key, lookup(base, item.Key), item.Value, lookup(theirs, item.Key))
+	}
+	for _, item := range theirs {
+		if _, isComment := item.Key.(sops.Comment); isComment {
+			continue
+		}
+		if !isAbsent(lookup(ours, item.Key)) {
+			continue
+		}
+		add(item.Key, lookup(base, item.Key), absentNode{}, item.Value)
+	}
+	return out, conflicts
+}
+
+// markConflicts writes the lines common to ours and theirs once and
+// wraps each run of differing lines in conflict markers. Lines are
+// matched by a longest common subsequence found with Myers' diff in
+// linear space.
+func markConflicts(ours, theirs []byte) []byte {
+	a := splitLines(string(ours))
+	b := splitLines(string(theirs))
+
+	var out bytes.Buffer
+	var hunkA, hunkB []string
+	writeLine := func(line string) {
+		out.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			out.WriteByte('\n')
+		}
+	}
+	flush := func() {
+		if len(hunkA) == 0 && len(hunkB) == 0 {
+			return
+		}
+		out.WriteString("<<<<<<< ours\n")
+		for _, line := range hunkA {
+			writeLine(line)
+		}
+		out.WriteString("=======\n")
+		for _, line := range hunkB {
+			writeLine(line)
+		}
+		out.WriteString(">>>>>>> theirs\n")
+		hunkA, hunkB = nil, nil
+	}
+	i, j := 0, 0
+	for _, m := range commonLines(a, b, 0, len(a), 0, len(b), nil) {
+		hunkA = append(hunkA, a[i:m[0]]...)
+		hunkB = append(hunkB, b[j:m[1]]...)
+		flush()
+		out.WriteString(a[m[0]])
+		i, j = m[0]+1, m[1]+1
+	}
+	hunkA = append(hunkA, a[i:]...)
+	hunkB = append(hunkB, b[j:]...)
+	flush()
+	return out.Bytes()
+}
+
+// commonLines appends to matches the index pairs of a longest common
+// subsequence of a[alo:ahi] and b[blo:bhi], in order. It splits the
+// problem at the middle snake of the shortest edit script, so it needs
+// space linear in the input.
+func commonLines(a, b []string, alo, ahi, blo, bhi int, matches [][2]int) [][2]int {
+	for alo < ahi && blo < bhi && a[alo] == b[blo] {
+		matches = append(matches, [2]int{alo, blo})
+		alo++
+		blo++
+	}
+	aend, bend := ahi, bhi
+	for alo < aend && blo < bend && a[aend-1] == b[bend-1] {
+		aend--
+		bend--
+	}
+	// With the common ends trimmed, a script of one edit leaves one side
+	// empty, so any middle snake found here splits off shorter scripts.
+	if alo < aend && blo < bend {
+		x, y, u, v := middleSnake(a, b, alo, aend, blo, bend)
+		matches = commonLines(a, b, alo, x, blo, y, matches)
+		for ; x < u; x, y = x+1, y+1 {
+			matches = append(matches, [2]int{x, y})
+		}
+		matches = commonLines(a, b, u, aend, v, bend, matches)
+	}
+	for ; aend < ahi; aend, bend = aend+1, bend+1 {
+		matches = append(matches, [2]int{aend, bend})
+	}
+	return matches
+}
+
+// middleSnake returns the snake, from (x, y) to (u, v), in the middle
+// of a shortest edit script turning a[alo:ahi] into b[blo:bhi]. It
+// runs Myers' search from both ends until the paths overlap.
+func middleSnake(a, b []string, alo, ahi, blo, bhi int) (x, y, u, v int) {
+	n, m := ahi-alo, bhi-blo
+	delta := n - m
+	odd := delta%2 != 0
+	off := (n+m+1)/2 + 1
+	// fwd[off+k] is the furthest x reached on diagonal k = x-y from the
+	// start, and rev[off+k] the furthest reached on diagonal k from the
+	// end, counting x and y back from n and m.
+	fwd := make([]int, 2*off+1)
+	rev := make([]int, 2*off+1)
+	for d := 0; d < off; d++ {
+		for k := -d; k <= d; k += 2 {
+			xs := fwd[off+k+1]
+			if k != -d && (k == d || fwd[off+k-1] >= fwd[off+k+1]) {
+				xs = fwd[off+k-1] + 1
+			}
+			xe := xs
+			for xe < n && xe-k < m && a[alo+xe] == b[blo+xe-k] {
+				xe++
+			}
+			fwd[off+k] = xe
+			if kr := delta - k; odd && kr >= -(d-1) && kr <= d-1 && xe+rev[off+kr] >= n {
+				return alo + xs, blo + xs - k, alo + xe, blo + xe - k
+			}
+		}
+		for k := -d; k <= d; k += 2 {
+			xs := rev[off+k+1]
+			if k != -d && (k == d || rev[off+k-1] >= rev[off+k+1]) {
+				xs = rev[off+k-1] + 1
+			}
+			xe := xs
+			for xe < n && xe-k < m && a[ahi-1-xe] == b[bhi-1-(xe-k)] {
+				xe++
+			}
+			rev[off+k] = xe
+			if kf := delta - k; !odd && kf >= -d && kf <= d && xe+fwd[off+kf] >= n {
+				return ahi - xe, bhi - (xe - k), ahi - xs, bhi - (xs - k)
+			}
+		}
+	}
+	panic("sopsx: middleSnake: no overlap")
+}
+
+// splitLines splits s after each newline, dropping the empty tail.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
@@ -0,0 +1,160 @@
+package sopsx_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/getsops/sops/v3/cmd/sops/formats"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/dcadolph/cipher/internal/sopsx"
+)
+
+// TestMerge merges three encrypted YAML versions and checks the merged
+// plaintext or the conflict output.
+func TestMerge(t *testing.T) {
+	groups := ageGroups(t, ageRecipient(t))
+	tests := []struct {
+		Name              string
+		Base, Ours, Their string
+		Want              string
+		WantConflicts     int
+	}{{ // Test 0: Edits to different keys both land.
+		Name: "disjoint", Base: "a: 1\nb: 2\n", Ours: "a: 10\nb: 2\n", Their: "a: 1\nb: 20\n",
+		Want: "a: 10\nb: 20\n",
+	}, { // Test 1: The same edit on both sides is not a conflict.
+		Name: "same edit", Base: "a: 1\n", Ours: "a: 2\n", Their: "a: 2\n",
+		Want: "a: 2\n",
+	}, { // Test 2: Additions on both sides, theirs appended after ours.
+		Name: "additions", Base: "a: 1\n", Ours: "a: 1\nb: 2\n", Their: "a: 1\nc: 3\n",
+		Want: "a: 1\nb: 2\nc: 3\n",
+	}, { // Test 3: A deletion on one side wins over no change.
+		Name: "deletion", Base: "a: 1\nb: 2\n", Ours: "a: 1\n", Their: "a: 5\nb: 2\n",
+		Want: "a: 5\n",
+	}, { // Test 4: Nested maps merge recursively.
+		Name: "nested", Base: "db:\n  user: app\n  pass: x\n",
+		Ours: "db:\n  user: admin\n  pass: x\n", Their: "db:\n  user: app\n  pass: z\n",
+		Want: "db:\n    user: admin\n    pass: z\n",
+	}, { // Test 5: No base, both sides added the file.
+		Name: "no base", Ours: "a: 1\n", Their: "b: 2\n",
+		Want: "a: 1\nb: 2\n",
+	}, { // Test 6: Different edits to one value conflict.
+		Name: "conflict", Base: "a: 1\nb: 2\n", Ours: "a: 10\nb: 2\n", Their: "a: 11\nb: 3\n",
+		Want:          "<<<<<<< ours\na: 10\n=======\na: 11\n>>>>>>> theirs\nb: 3\n",
+		WantConflicts: 1,
+	}, { // Test 7: Modify against delete conflicts.
+		Name: "modify delete", Base: "a: 1\nb: 2\n", Ours: "a: 1\n", Their: "a: 1\nb: 5\n",
+		Want:          "a: 1\n<<<<<<< ours\n=======\nb: 5\n>>>>>>> theirs\n",
+		WantConflicts: 1,
+	}}
+
+	encrypt := func(t *testing.T, plain string) []byte {
+		t.Helper()
+		if plain == "" {
+			return nil
+		}
+		enc, err := sopsx.Encrypt(sopsx.EncryptInput{Data: []byte(plain), Format: formats.Yaml, KeyGroups: groups})
+		if err != nil {
+			t.Fatalf("Encrypt: %v", err)
+		}
+		return enc
+	}
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			out, conflicts, err := sopsx.Merge(context.Background(), sopsx.MergeInput{
+				Base: encrypt(t, test.Base), Ours: encrypt(t, test.Ours), Theirs: encrypt(t, test.Their),
+			})
+			if err != nil {
+				t.Fatalf("Merge: %v", err)
+			}
+			if conflicts != test.WantConflicts {
+				t.Fatalf("conflicts = %d, want %d", conflicts, test.WantConflicts)
+			}
+			got := out
+			if conflicts == 0 {
+				if got, err = sopsx.Decrypt(context.Background(), sopsx.DecryptInput{Data: out, Format: formats.Yaml}); err != nil {
+					t.Fatalf("Decrypt merged: %v", err)
+				}
+			}
+			if diff := cmp.Diff(test.Want, string(got)); diff != "" {
+				t.Errorf("merged mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestMergeLargeConflict checks that conflict markers for a large file
+// pair up only the differing lines, without a table quadratic in the
+// line count.
+func TestMergeLargeConflict(t *testing.T) {
+	groups := ageGroups(t, ageRecipient(t))
+	const n = 5000
+	var base, ours, theirs, want strings.Builder
+	for _, b := range []*strings.Builder{&base, &ours, &theirs, &want} {
+		b.WriteString("items:\n")
+	}
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&base, "    - v%d\n", i)
+		if i%2 == 1 {
+			fmt.Fprintf(&ours, "    - v%d\n", i)
+			fmt.Fprintf(&theirs, "    - v%d\n", i)
+			fmt.Fprintf(&want, "    - v%d\n", i)
+			continue
+		}
+		fmt.Fprintf(&ours, "    - o%d\n", i)
+		fmt.Fprintf(&theirs, "    - t%d\n", i)
+		fmt.Fprintf(&want, "<<<<<<< ours\n    - o%d\n=======\n    - t%d\n>>>>>>> theirs\n", i, i)
+	}
+	encrypt := func(plain string) []byte {
+		enc, err := sopsx.Encrypt(sopsx.EncryptInput{Data: []byte(plain), Format: formats.Yaml, KeyGroups: groups})
+		if err != nil {
+			t.Fatalf("Encrypt: %v", err)
+		}
+		return enc
+	}
+	out, conflicts, err := sopsx.Merge(context.Background(), sopsx.MergeInput{
+		Base: encrypt(base.String()), Ours: encrypt(ours.String()), Theirs: encrypt(theirs.String()),
+	})
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if conflicts != 1 {
+		t.Errorf("conflicts = %d, want 1", conflicts)
+	}
+	if diff := cmp.Diff(want.String(), string(out)); diff != "" {
+		t.Errorf("conflict output mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// TestMergeKeepsOursCiphertext checks that values unchanged from ours
+// keep their ENC[...] strings and that the format is detected.
+func TestMergeKeepsOursCiphertext(t *testing.T) {
+	groups := ageGroups(t, ageRecipient(t))
+	enc := func(plain string) []byte {
+		out, err := sopsx.Encrypt(sopsx.EncryptInput{Data: []byte(plain), Format: formats.Json, KeyGroups: groups})
+		if err != nil {
+			t.Fatalf("Encrypt: %v", err)
+		}
+		return out
+	}
+	ours := enc(`{"keep":"same","b":"1"}`)
+	out, _, err := sopsx.Merge(context.Background(), sopsx.MergeInput{
+		Base: enc(`{"keep":"same","b":"1"}`), Ours: ours, Theirs: enc(`{"keep":"same","b":"2"}`),
+	})
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	line := func(data []byte) string {
+		for l := range strings.SplitSeq(string(data), "\n") {
+			if strings.Contains(l, `"keep"`) {
+				return l
+			}
+		}
+		return ""
+	}
+	if line(out) == "" || line(out) != line(ours) {
+		t.Errorf("keep ciphertext changed:\nours:   %s\nmerged: %s", line(ours), line(out))
+	}
+}
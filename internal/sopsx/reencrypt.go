@@ -0,0 +1,130 @@
+package sopsx
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/aes"
+	"github.com/getsops/sops/v3/cmd/sops/common"
+	"github.com/getsops/sops/v3/keyservice"
+)
+
+// ReencryptInput holds inputs for encrypting new plaintext under an
+// existing file's data key.
+type ReencryptInput struct {
+	// Path is the file path used to derive Format when Format is zero.
+	Path string
+	// Data is the previous encrypted content.
+	Data []byte
+	// Plain is the new plaintext.
+	Plain []byte
+	// Format is the sops format of both Data and Plain. If zero, it is
+	// derived from Path.
+	Format Format
+	// KeyServices are the key services used to unwrap the data key. If
+	// empty, a single local key service is used.
+	KeyServices []keyservice.KeyServiceClient
+	// DecryptionOrder is the order in which decryption methods are tried.
+	// If empty, sops.DefaultDecryptionOrder is used.
+	DecryptionOrder []string
+	// IgnoreMAC, when true, skips verification of the MAC of Data.
+	IgnoreMAC bool
+	// Cipher is the sops cipher. If nil, aes.NewCipher() is used.
+	Cipher sops.Cipher
+	// MaxCiphertextBytes is the maximum allowed size of Data in bytes.
+	// Zero means no limit.
+	MaxCiphertextBytes int
+	// OnUnwrap, when non-nil, receives every master key tried while
+	// unwrapping the data key, whether or not the unwrap succeeded.
+	OnUnwrap func(attempts []UnwrapAttempt)
+}
+
+// Reencrypt encrypts Plain under the data key, recipients, and key
+// filters recorded in Data. When Plain parses to the same tree Data
+// decrypts to, Data is returned unchanged, so formatting-only edits
+// produce no new ciphertext. Otherwise values Data already held at the
+// same key path keep their ciphertext and only the changed values, the
+// timestamp, and the MAC differ.
+//
+// Returns ErrNotEncrypted when Data does not carry sops metadata and
+// ErrAlreadyEncrypted when Plain does.
+func Reencrypt(ctx context.Context, in ReencryptInput) ([]byte, error) {
+	if in.MaxCiphertextBytes > 0 && len(in.Data) > in.MaxCiphertextBytes {
+		return nil, ErrTooLarge
+	}
+	if in.Format == 0 && in.Path != "" {
+		in.Format = FormatForPath(in.Path)
+	}
+	if !IsEncrypted(in.Data, in.Format) {
+		return nil, ErrNotEncrypted
+	}
+	if IsEncrypted(in.Plain, in.Format) {
+		return nil, ErrAlreadyEncrypted
+	}
+
+	cipher := in.Cipher
+	if cipher == nil {
+		cipher = aes.NewCipher()
+	}
+	services := in.KeyServices
+	if len(services) == 0 {
+		services = []keyservice.KeyServiceClient{keyservice.NewLocalClient()}
+	}
+	order := in.DecryptionOrder
+	if len(order) == 0 {
+		order = sops.DefaultDecryptionOrder
+	}
+
+	store := StoreFor(in.Format)
+	branches, err := store.LoadPlainFile(in.Plain)
+	if err != nil {
+		return nil, fmt.Errorf("sopsx: load plain: %w", err)
+	}
+	if len(branches) == 0 {
+		return nil, ErrEmpty
+	}
+	tree, err := store.LoadEncryptedFile(in.Data)
+	if err != nil {
+		return nil, fmt.Errorf("sopsx: load encrypted: %w", err)
+	}
+	tree.FilePath = in.Path
+	_, attempts, err := UnwrapDataKey(ctx, &tree.Metadata, services, order)
+	if in.OnUnwrap != nil {
+		in.OnUnwrap(attempts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := common.DecryptTree(common.DecryptTreeOpts{
+		Tree:            &tree,
+		KeyServices:     services,
+		DecryptionOrder: order,
+		IgnoreMac:       in.IgnoreMAC,
+		Cipher:          cipher,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sopsx: decrypt tree: %w", err)
+	}
+	defer clear(dataKey)
+	if reflect.DeepEqual(branches, tree.Branches) {
+		return in.Data, nil
+	}
+
+	tree.Branches = branches
+	tree.Metadata.LastModified = time.Now().UTC()
+	if err := common.EncryptTree(common.EncryptTreeOpts{
+		DataKey: dataKey,
+		Tree:    &tree,
+		Cipher:  cipher,
+	}); err != nil {
+		return nil, fmt.Errorf("sopsx: encrypt tree: %w", err)
+	}
+	out, err := store.EmitEncryptedFile(tree)
+	if err != nil {
+		return nil, fmt.Errorf("sopsx: emit encrypted: %w", err)
+	}
+	return out, nil
+}
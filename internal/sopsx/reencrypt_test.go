@@ -0,0 +1,58 @@
+package sopsx_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/getsops/sops/v3/cmd/sops/formats"
+
+	"github.com/dcadolph/cipher/internal/sopsx"
+)
+
+// TestReencrypt checks that unchanged content keeps the previous bytes
+// and that changed content keeps unchanged values' ciphertext.
+func TestReencrypt(t *testing.T) {
+	groups := ageGroups(t, ageRecipient(t))
+	prev, err := sopsx.Encrypt(sopsx.EncryptInput{
+		Data: []byte("a: 1\nb: two\n"), Format: formats.Yaml, KeyGroups: groups,
+	})
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	reencrypt := func(plain string) []byte {
+		t.Helper()
+		out, err := sopsx.Reencrypt(context.Background(), sopsx.ReencryptInput{Data: prev, Plain: []byte(plain), Format: formats.Yaml})
+		if err != nil {
+			t.Fatalf("Reencrypt: %v", err)
+		}
+		return out
+	}
+
+	if out := reencrypt("a: 1\nb: 'two'\n"); string(out) != string(prev) {
+		t.Errorf("reformatted plaintext changed ciphertext:\n%s", out)
+	}
+	out := reencrypt("a: 1\nb: three\n")
+	if got := encLine(t, out, "a:"); got != encLine(t, prev, "a:") {
+		t.Errorf("unchanged value re-encrypted: %q vs %q", got, encLine(t, prev, "a:"))
+	}
+	if encLine(t, out, "b:") == encLine(t, prev, "b:") {
+		t.Error("changed value kept its ciphertext")
+	}
+	plain, err := sopsx.Decrypt(context.Background(), sopsx.DecryptInput{Data: out, Format: formats.Yaml})
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if string(plain) != "a: 1\nb: three\n" {
+		t.Errorf("plaintext = %q", plain)
+	}
+
+	_, err = sopsx.Reencrypt(context.Background(), sopsx.ReencryptInput{Data: []byte("a: 1\n"), Plain: []byte("a: 2\n"), Format: formats.Yaml})
+	if !errors.Is(err, sopsx.ErrNotEncrypted) {
+		t.Errorf("plain previous err = %v, want ErrNotEncrypted", err)
+	}
+	_, err = sopsx.Reencrypt(context.Background(), sopsx.ReencryptInput{Data: prev, Plain: prev, Format: formats.Yaml})
+	if !errors.Is(err, sopsx.ErrAlreadyEncrypted) {
+		t.Errorf("encrypted plaintext err = %v, want ErrAlreadyEncrypted", err)
+	}
+}
@@ -5,6 +5,7 @@
 package sopsx
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
@@ -13,7 +14,6 @@ import (
 	"github.com/getsops/sops/v3/aes"
 	"github.com/getsops/sops/v3/cmd/sops/common"
 	"github.com/getsops/sops/v3/cmd/sops/formats"
-	"github.com/getsops/sops/v3/config"
 	"github.com/getsops/sops/v3/keyservice"
 	"github.com/getsops/sops/v3/version"
 )
@@ -86,7 +86,7 @@ func Encrypt(in EncryptInput) ([]byte, error) {
 		return nil, ErrNoKeyGroups
 	}
 	if in.Format == 0 && in.Path != "" {
-		in.Format = formats.FormatForPath(in.Path)
+		in.Format = FormatForPath(in.Path)
 	}
 	if IsEncrypted(in.Data, in.Format) {
 		return nil, ErrAlreadyEncrypted
@@ -101,7 +101,7 @@ func Encrypt(in EncryptInput) ([]byte, error) {
 		services = []keyservice.KeyServiceClient{keyservice.NewLocalClient()}
 	}
 
-	store := common.StoreForFormat(in.Format, config.NewStoresConfig())
+	store := StoreFor(in.Format)
 	branches, err := store.LoadPlainFile(in.Data)
 	if err != nil {
 		return nil, fmt.Errorf("sopsx: load plain: %w", err)
@@ -169,16 +169,19 @@ type DecryptInput struct {
 	// set this to a sensible upper bound to defend against pathological
 	// inputs that allocate large buffers during parsing.
 	MaxCiphertextBytes int
+	// OnUnwrap, when non-nil, receives every master key tried while
+	// unwrapping the data key, whether or not the unwrap succeeded.
+	OnUnwrap func(attempts []UnwrapAttempt)
 }
 
 // Decrypt produces the plaintext bytes for the given input.
 // Returns ErrNotEncrypted when the input does not carry sops metadata.
-func Decrypt(in DecryptInput) ([]byte, error) {
+func Decrypt(ctx context.Context, in DecryptInput) ([]byte, error) {
 	if in.MaxCiphertextBytes > 0 && len(in.Data) > in.MaxCiphertextBytes {
 		return nil, ErrTooLarge
 	}
 	if in.Format == 0 && in.Path != "" {
-		in.Format = formats.FormatForPath(in.Path)
+		in.Format = FormatForPath(in.Path)
 	}
 	if !IsEncrypted(in.Data, in.Format) {
 		return nil, ErrNotEncrypted
@@ -197,11 +200,18 @@ func Decrypt(in DecryptInput) ([]byte, error) {
 		order = sops.DefaultDecryptionOrder
 	}
 
-	store := common.StoreForFormat(in.Format, config.NewStoresConfig())
+	store := StoreFor(in.Format)
 	tree, err := store.LoadEncryptedFile(in.Data)
 	if err != nil {
 		return nil, fmt.Errorf("sopsx: load encrypted: %w", err)
 	}
+	_, attempts, err := UnwrapDataKey(ctx, &tree.Metadata, services, order)
+	if in.OnUnwrap != nil {
+		in.OnUnwrap(attempts)
+	}
+	if err != nil {
+		return nil, err
+	}
 
 	if _, err := common.DecryptTree(common.DecryptTreeOpts{
 		Tree:            &tree,
@@ -235,6 +245,11 @@ const (
 	// a multi-group secret requiring multiple groups to decrypt.
 	// Intended for explicit Shamir users.
 	AddRecipientAsGroups
+	// AddRecipientPerGroup merges NewGroups[i] into the file's i-th
+	// existing key group, leaving the number of groups unchanged.
+	// NewGroups may not outnumber the file's groups, and empty entries
+	// leave their group alone.
+	AddRecipientPerGroup
 )
 
 // AddRecipientInput holds inputs for adding recipients to an already
@@ -264,7 +279,7 @@ type AddRecipientInput struct {
 
 // AddRecipient inserts new keys into the file's key groups and re-wraps
 // the data key. The payload ciphertext is unchanged.
-func AddRecipient(in AddRecipientInput) ([]byte, error) {
+func AddRecipient(ctx context.Context, in AddRecipientInput) ([]byte, error) {
 	if len(in.NewGroups) == 0 {
 		return nil, fmt.Errorf("sopsx: no new groups supplied")
 	}
@@ -272,7 +287,7 @@ func AddRecipient(in AddRecipientInput) ([]byte, error) {
 		return nil, ErrTooLarge
 	}
 	if in.Format == 0 && in.Path != "" {
-		in.Format = formats.FormatForPath(in.Path)
+		in.Format = FormatForPath(in.Path)
 	}
 	if !IsEncrypted(in.Data, in.Format) {
 		return nil, ErrNotEncrypted
@@ -286,14 +301,14 @@ func AddRecipient(in AddRecipientInput) ([]byte, error) {
 		order = sops.DefaultDecryptionOrder
 	}
 
-	store := common.StoreForFormat(in.Format, config.NewStoresConfig())
+	store := StoreFor(in.Format)
 	tree, err := store.LoadEncryptedFile(in.Data)
 	if err != nil {
 		return nil, fmt.Errorf("sopsx: load encrypted: %w", err)
 	}
-	dataKey, err := tree.Metadata.GetDataKeyWithKeyServices(services, order)
+	dataKey, _, err := UnwrapDataKey(ctx, &tree.Metadata, services, order)
 	if err != nil {
-		return nil, fmt.Errorf("sopsx: get data key: %w", err)
+		return nil, err
 	}
 
 	switch in.Mode {
@@ -309,6 +324,14 @@ func AddRecipient(in AddRecipientInput) ([]byte, error) {
 		}
 	case AddRecipientAsGroups:
 		tree.Metadata.KeyGroups = append(tree.Metadata.KeyGroups, in.NewGroups...)
+	case AddRecipientPerGroup:
+		if len(in.NewGroups) > len(tree.Metadata.KeyGroups) {
+			return nil, fmt.Errorf("sopsx: %d new groups for %d key groups",
+				len(in.NewGroups), len(tree.Metadata.KeyGroups))
+		}
+		for i, g := range in.NewGroups {
+			tree.Metadata.KeyGroups[i] = append(tree.Metadata.KeyGroups[i], g...)
+		}
 	default:
 		return nil, fmt.Errorf("sopsx: unknown AddRecipientMode %d", in.Mode)
 	}
@@ -358,13 +381,13 @@ func RemoveRecipient(in RemoveRecipientInput) ([]byte, error) {
 		return nil, ErrTooLarge
 	}
 	if in.Format == 0 && in.Path != "" {
-		in.Format = formats.FormatForPath(in.Path)
+		in.Format = FormatForPath(in.Path)
 	}
 	if !IsEncrypted(in.Data, in.Format) {
 		return nil, ErrNotEncrypted
 	}
 
-	store := common.StoreForFormat(in.Format, config.NewStoresConfig())
+	store := StoreFor(in.Format)
 	tree, err := store.LoadEncryptedFile(in.Data)
 	if err != nil {
 		return nil, fmt.Errorf("sopsx: load encrypted: %w", err)
@@ -448,7 +471,7 @@ func Inspect(data []byte, format Format) (*Info, error) {
 	if len(data) == 0 {
 		return nil, ErrNotEncrypted
 	}
-	store := common.StoreForFormat(format, config.NewStoresConfig())
+	store := StoreFor(format)
 	tree, err := store.LoadEncryptedFile(data)
 	switch {
 	case errors.Is(err, sops.MetadataNotFound):
@@ -504,7 +527,7 @@ func IsEncrypted(data []byte, format Format) bool {
 	if len(data) == 0 {
 		return false
 	}
-	store := common.StoreForFormat(format, config.NewStoresConfig())
+	store := StoreFor(format)
 	tree, err := store.LoadEncryptedFile(data)
 	if err != nil {
 		return false
@@ -1,6 +1,7 @@
 package sopsx_test
 
 import (
+	"context"
 	"errors"
 	"strings"
 	"testing"
@@ -62,7 +63,7 @@ func TestEncryptDecryptRoundTrip(t *testing.T) {
 		t.Fatalf("encrypted output not detected as encrypted")
 	}
 
-	got, err := sopsx.Decrypt(sopsx.DecryptInput{Path: "secrets.yaml", Data: enc})
+	got, err := sopsx.Decrypt(context.Background(), sopsx.DecryptInput{Path: "secrets.yaml", Data: enc})
 	if err != nil {
 		t.Fatalf("Decrypt: %v", err)
 	}
@@ -134,7 +135,7 @@ func TestDecryptErrors(t *testing.T) {
 
 	for testNum, test := range tests {
 		t.Run(test.Name, func(t *testing.T) {
-			_, err := sopsx.Decrypt(test.In)
+			_, err := sopsx.Decrypt(context.Background(), test.In)
 			if !errors.Is(err, test.Want) {
 				t.Errorf("test %d: err = %v, want %v", testNum, err, test.Want)
 			}
@@ -163,7 +164,7 @@ func TestAddRecipientFlatten(t *testing.T) {
 	}
 	newGroups := ageGroups(t, id2.Recipient().String())
 
-	out, err := sopsx.AddRecipient(sopsx.AddRecipientInput{
+	out, err := sopsx.AddRecipient(context.Background(), sopsx.AddRecipientInput{
 		Path:      "secrets.yaml",
 		Data:      enc,
 		NewGroups: newGroups,
@@ -202,7 +203,7 @@ func TestAddRecipientAsGroups(t *testing.T) {
 	}
 	newGroups := ageGroups(t, id2.Recipient().String())
 
-	out, err := sopsx.AddRecipient(sopsx.AddRecipientInput{
+	out, err := sopsx.AddRecipient(context.Background(), sopsx.AddRecipientInput{
 		Path:      "secrets.yaml",
 		Data:      enc,
 		NewGroups: newGroups,
@@ -262,7 +263,7 @@ func TestAddRecipientErrors(t *testing.T) {
 
 	for testNum, test := range tests {
 		t.Run(test.Name, func(t *testing.T) {
-			_, err := sopsx.AddRecipient(test.In)
+			_, err := sopsx.AddRecipient(context.Background(), test.In)
 			if err == nil || !strings.Contains(err.Error(), test.WantSubstr) {
 				t.Errorf("test %d: err = %v, want substring %q",
 					testNum, err, test.WantSubstr)
@@ -525,7 +526,7 @@ func TestDecryptIgnoreMAC(t *testing.T) {
 		t.Fatalf("Encrypt: %v", err)
 	}
 
-	if _, err := sopsx.Decrypt(sopsx.DecryptInput{
+	if _, err := sopsx.Decrypt(context.Background(), sopsx.DecryptInput{
 		Path: "secrets.yaml", Data: enc, IgnoreMAC: true,
 	}); err != nil {
 		t.Errorf("Decrypt IgnoreMAC=true on unmodified data: %v", err)
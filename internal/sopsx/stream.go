@@ -0,0 +1,399 @@
+package sopsx
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/aes"
+	"github.com/getsops/sops/v3/cmd/sops/common"
+	"github.com/getsops/sops/v3/cmd/sops/formats"
+	"github.com/getsops/sops/v3/keyservice"
+	"github.com/getsops/sops/v3/version"
+)
+
+// StreamMagic is the first line of every encrypted stream. It lets
+// callers tell a stream apart from a regular sops document by peeking
+// at the first few bytes.
+const StreamMagic = "cipher-stream/v1"
+
+// DefaultStreamChunkSize is the plaintext chunk size used when
+// StreamEncryptInput.ChunkSize is zero.
+const DefaultStreamChunkSize = 64 << 10
+
+// maxStreamChunkSize caps the chunk size a stream header may declare.
+// It bounds the line buffer a decoder allocates for untrusted input.
+const maxStreamChunkSize = 16 << 20
+
+// ErrStreamTruncated signals that an encrypted stream ended before its
+// authenticated trailer. The plaintext written so far is incomplete.
+var ErrStreamTruncated = errors.New("sopsx: stream truncated")
+
+// Stream line tags. Each record after the header is a tag, a space,
+// and a sops ENC[...] value. The tag is bound into the AES-GCM
+// additional data, so swapping it fails authentication.
+const (
+	streamChunkTag = "c"
+	streamEndTag   = "e"
+)
+
+// IsEncryptedStream reports whether prefix starts with the stream
+// magic line. Callers only need to supply the first len(StreamMagic)+1
+// bytes of the input.
+func IsEncryptedStream(prefix []byte) bool {
+	return bytes.HasPrefix(prefix, []byte(StreamMagic+"\n"))
+}
+
+// StreamEncryptInput holds inputs for encrypting a stream.
+type StreamEncryptInput struct {
+	// Path is recorded in the sops tree for audit events. It does not
+	// affect the format; streams always carry opaque bytes.
+	Path string
+	// KeyGroups are the sops key groups used to wrap the data key.
+	KeyGroups []sops.KeyGroup
+	// KeyServices are the key services used to wrap the data key. If
+	// empty, a single local key service is used.
+	KeyServices []keyservice.KeyServiceClient
+	// Cipher is the sops cipher. If nil, aes.NewCipher() is used.
+	Cipher sops.Cipher
+	// ShamirThreshold is the number of key groups required to recover
+	// the data key. Zero means default behavior.
+	ShamirThreshold int
+	// ChunkSize is the plaintext chunk size in bytes. Zero means
+	// DefaultStreamChunkSize.
+	ChunkSize int
+}
+
+// EncryptStream reads plaintext from src and writes an encrypted
+// stream to dst. Memory use is bounded by ChunkSize regardless of the
+// input length. Returns the plaintext and ciphertext byte counts.
+//
+// The stream layout is line oriented: the magic line, a compact sops
+// JSON document holding the wrapped data key, one record per chunk,
+// and a trailer that authenticates the chunk count and total length.
+// Every record is encrypted under the same data key with its position
+// in the additional data, so reordering, dropping, or truncating
+// records fails decryption.
+func EncryptStream(
+	ctx context.Context, in StreamEncryptInput, dst io.Writer, src io.Reader,
+) (plainN, cipherN int64, err error) {
+	if len(in.KeyGroups) == 0 {
+		return 0, 0, ErrNoKeyGroups
+	}
+	chunkSize := in.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultStreamChunkSize
+	}
+	if chunkSize > maxStreamChunkSize {
+		return 0, 0, fmt.Errorf("sopsx: chunk size %d exceeds limit %d", chunkSize, maxStreamChunkSize)
+	}
+	services := in.KeyServices
+	if len(services) == 0 {
+		services = []keyservice.KeyServiceClient{keyservice.NewLocalClient()}
+	}
+
+	header, dataKey, err := streamHeader(in, services, chunkSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	out := &countingWriter{w: dst}
+	if _, err := fmt.Fprintf(out, "%s\n%s\n", StreamMagic, header); err != nil {
+		return 0, out.n, fmt.Errorf("sopsx: write header: %w", err)
+	}
+
+	buf := make([]byte, chunkSize)
+	defer clear(buf)
+	var index int
+	for {
+		if err := ctx.Err(); err != nil {
+			return plainN, out.n, err
+		}
+		n, readErr := io.ReadFull(src, buf)
+		if n > 0 {
+			value, err := streamCipher(in.Cipher).Encrypt(
+				string(buf[:n]), dataKey, streamAAD(streamChunkTag, index))
+			if err != nil {
+				return plainN, out.n, fmt.Errorf("sopsx: encrypt chunk %d: %w", index, err)
+			}
+			if _, err := fmt.Fprintf(out, "%s %s\n", streamChunkTag, value); err != nil {
+				return plainN, out.n, fmt.Errorf("sopsx: write chunk %d: %w", index, err)
+			}
+			plainN += int64(n)
+			index++
+		}
+		if errors.Is(readErr, io.EOF) || errors.Is(readErr, io.ErrUnexpectedEOF) {
+			break
+		}
+		if readErr != nil {
+			return plainN, out.n, fmt.Errorf("sopsx: read plaintext: %w", readErr)
+		}
+	}
+
+	trailer, err := streamCipher(in.Cipher).Encrypt(
+		strconv.FormatInt(plainN, 10), dataKey, streamAAD(streamEndTag, index))
+	if err != nil {
+		return plainN, out.n, fmt.Errorf("sopsx: encrypt trailer: %w", err)
+	}
+	if _, err := fmt.Fprintf(out, "%s %s\n", streamEndTag, trailer); err != nil {
+		return plainN, out.n, fmt.Errorf("sopsx: write trailer: %w", err)
+	}
+	return plainN, out.n, nil
+}
+
+// StreamDecryptInput holds inputs for decrypting a stream.
+type StreamDecryptInput struct {
+	// Path is recorded in the sops tree for audit events.
+	Path string
+	// KeyServices are the key services used to unwrap the data key. If
+	// empty, a single local key service is used.
+	KeyServices []keyservice.KeyServiceClient
+	// DecryptionOrder is the order in which decryption methods are
+	// tried. If empty, sops.DefaultDecryptionOrder is used.
+	DecryptionOrder []string
+	// Cipher is the sops cipher. If nil, aes.NewCipher() is used.
+	Cipher sops.Cipher
+}
+
+// DecryptStream reads an encrypted stream from src and writes the
+// plaintext to dst one chunk at a time. Returns ErrNotEncrypted when
+// src does not start with StreamMagic and ErrStreamTruncated when the
+// trailer is missing. On any error, dst may already hold a prefix of
+// the plaintext; callers writing to disk should stage the output.
+func DecryptStream(
+	ctx context.Context, in StreamDecryptInput, dst io.Writer, src io.Reader,
+) (cipherN, plainN int64, err error) {
+	services := in.KeyServices
+	if len(services) == 0 {
+		services = []keyservice.KeyServiceClient{keyservice.NewLocalClient()}
+	}
+	order := in.DecryptionOrder
+	if len(order) == 0 {
+		order = sops.DefaultDecryptionOrder
+	}
+
+	lines := &lineReader{r: bufio.NewReaderSize(src, 4096)}
+	magic, err := lines.readLine(len(StreamMagic) + 1)
+	if err != nil || magic != StreamMagic {
+		return lines.n, 0, ErrNotEncrypted
+	}
+	headerLine, err := lines.readLine(maxStreamChunkSize)
+	if err != nil {
+		return lines.n, 0, fmt.Errorf("%w: read header: %w", ErrParse, err)
+	}
+	dataKey, chunkSize, err := openStreamHeader(ctx, in, headerLine, services, order)
+	if err != nil {
+		return lines.n, 0, err
+	}
+	defer clear(dataKey)
+
+	// A chunk of chunkSize bytes base64-encodes to roughly 4/3 of its
+	// size. The extra headroom covers the tag, IV, auth tag, and type.
+	limit := chunkSize/3*4 + 256
+	for index := 0; ; index++ {
+		if err := ctx.Err(); err != nil {
+			return lines.n, plainN, err
+		}
+		line, err := lines.readLine(limit)
+		if errors.Is(err, io.EOF) {
+			return lines.n, plainN, ErrStreamTruncated
+		}
+		if err != nil {
+			return lines.n, plainN, fmt.Errorf("%w: record %d: %w", ErrParse, index, err)
+		}
+		tag, value, ok := strings.Cut(line, " ")
+		if !ok {
+			return lines.n, plainN, fmt.Errorf("%w: record %d: missing tag", ErrParse, index)
+		}
+		if tag != streamChunkTag && tag != streamEndTag {
+			return lines.n, plainN, fmt.Errorf("%w: record %d: unknown tag %q", ErrParse, index, tag)
+		}
+		plain, err := streamCipher(in.Cipher).Decrypt(value, dataKey, streamAAD(tag, index))
+		if err != nil {
+			return lines.n, plainN, fmt.Errorf("sopsx: decrypt record %d: %w", index, err)
+		}
+		text, ok := plain.(string)
+		if !ok {
+			return lines.n, plainN, fmt.Errorf("sopsx: record %d: unexpected type %T", index, plain)
+		}
+		if tag == streamEndTag {
+			total, err := strconv.ParseInt(text, 10, 64)
+			if err != nil || total != plainN {
+				return lines.n, plainN, fmt.Errorf(
+					"sopsx: trailer length mismatch: trailer %q, decrypted %d", text, plainN)
+			}
+			if _, err := lines.r.Peek(1); !errors.Is(err, io.EOF) {
+				return lines.n, plainN, fmt.Errorf("%w: data after trailer", ErrParse)
+			}
+			return lines.n, plainN, nil
+		}
+		n, err := io.WriteString(dst, text)
+		plainN += int64(n)
+		if err != nil {
+			return lines.n, plainN, fmt.Errorf("sopsx: write plaintext: %w", err)
+		}
+	}
+}
+
+// streamHeader builds and encrypts the sops document that carries the
+// wrapped data key. The document's single data leaf records the stream
+// version and chunk size, so the MAC covers both.
+func streamHeader(
+	in StreamEncryptInput, services []keyservice.KeyServiceClient, chunkSize int,
+) ([]byte, []byte, error) {
+	tree := sops.Tree{
+		FilePath: in.Path,
+		Branches: sops.TreeBranches{{
+			sops.TreeItem{Key: "data", Value: streamDescriptor(chunkSize)},
+		}},
+		Metadata: sops.Metadata{
+			KeyGroups:       in.KeyGroups,
+			ShamirThreshold: in.ShamirThreshold,
+			Version:         version.Version,
+			LastModified:    time.Now().UTC(),
+		},
+	}
+	dataKey, genErrs := tree.GenerateDataKeyWithKeyServices(services)
+	if len(genErrs) > 0 {
+		return nil, nil, fmt.Errorf("sopsx: generate data key: %w", errors.Join(genErrs...))
+	}
+	if err := common.EncryptTree(common.EncryptTreeOpts{
+		DataKey: dataKey,
+		Tree:    &tree,
+		Cipher:  streamCipher(in.Cipher),
+	}); err != nil {
+		return nil, nil, fmt.Errorf("sopsx: encrypt header: %w", err)
+	}
+	store := StoreFor(formats.Binary)
+	doc, err := store.EmitEncryptedFile(tree)
+	if err != nil {
+		return nil, nil, fmt.Errorf("sopsx: emit header: %w", err)
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, doc); err != nil {
+		return nil, nil, fmt.Errorf("sopsx: compact header: %w", err)
+	}
+	return compact.Bytes(), dataKey, nil
+}
+
+// openStreamHeader unwraps the data key from the header document,
+// verifies its MAC, and returns the declared chunk size.
+func openStreamHeader(
+	ctx context.Context, in StreamDecryptInput, line string,
+	services []keyservice.KeyServiceClient, order []string,
+) ([]byte, int, error) {
+	store := StoreFor(formats.Binary)
+	tree, err := store.LoadEncryptedFile([]byte(line))
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: header: %w", ErrParse, err)
+	}
+	tree.FilePath = in.Path
+	if _, _, err := UnwrapDataKey(ctx, &tree.Metadata, services, order); err != nil {
+		return nil, 0, err
+	}
+	dataKey, err := common.DecryptTree(common.DecryptTreeOpts{
+		Tree:            &tree,
+		KeyServices:     services,
+		DecryptionOrder: order,
+		Cipher:          streamCipher(in.Cipher),
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("sopsx: decrypt header: %w", err)
+	}
+	plain, err := store.EmitPlainFile(tree.Branches)
+	if err != nil {
+		return nil, 0, fmt.Errorf("sopsx: header: %w", err)
+	}
+	chunkSize, err := parseStreamDescriptor(string(plain))
+	if err != nil {
+		return nil, 0, err
+	}
+	return dataKey, chunkSize, nil
+}
+
+// streamDescriptor renders the header payload for chunkSize.
+func streamDescriptor(chunkSize int) string {
+	return fmt.Sprintf("%s chunk=%d", StreamMagic, chunkSize)
+}
+
+// parseStreamDescriptor is the inverse of streamDescriptor.
+func parseStreamDescriptor(s string) (int, error) {
+	rest, ok := strings.CutPrefix(s, StreamMagic+" chunk=")
+	if !ok {
+		return 0, fmt.Errorf("%w: unknown stream descriptor %q", ErrParse, s)
+	}
+	n, err := strconv.Atoi(rest)
+	if err != nil || n <= 0 || n > maxStreamChunkSize {
+		return 0, fmt.Errorf("%w: bad chunk size in descriptor %q", ErrParse, s)
+	}
+	return n, nil
+}
+
+// streamAAD returns the additional data bound into record index.
+func streamAAD(tag string, index int) string {
+	return "stream:" + tag + ":" + strconv.Itoa(index) + ":"
+}
+
+// streamCipher returns c, or a fresh AES cipher when c is nil. The
+// AES cipher caches IVs of decrypted values for stable re-encryption;
+// a fresh instance per record keeps that cache from growing with the
+// stream length.
+func streamCipher(c sops.Cipher) sops.Cipher {
+	if c != nil {
+		return c
+	}
+	return aes.NewCipher()
+}
+
+// lineReader reads stream records and counts the bytes they consume.
+// The count excludes whatever the buffer has read ahead.
+type lineReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+// readLine reads one newline-terminated line of at most limit bytes,
+// excluding the newline. Returns io.EOF only when no bytes remain.
+func (l *lineReader) readLine(limit int) (string, error) {
+	var line []byte
+	for {
+		frag, err := l.r.ReadSlice('\n')
+		l.n += int64(len(frag))
+		line = append(line, frag...)
+		if len(line) > limit+1 {
+			return "", fmt.Errorf("line exceeds %d bytes", limit)
+		}
+		switch {
+		case err == nil:
+			return string(line[:len(line)-1]), nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case errors.Is(err, io.EOF) && len(line) == 0:
+			return "", io.EOF
+		case errors.Is(err, io.EOF):
+			return "", io.ErrUnexpectedEOF
+		default:
+			return "", err
+		}
+	}
+}
+
+// countingWriter counts bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write forwards to the wrapped writer and adds to the count.
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
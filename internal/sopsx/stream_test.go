@@ -0,0 +1,166 @@
+package sopsx_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/dcadolph/cipher/internal/sopsx"
+)
+
+// TestStreamRoundTrip encrypts and decrypts payloads around the chunk
+// boundary and verifies byte fidelity and the reported counts.
+func TestStreamRoundTrip(t *testing.T) {
+	groups := ageGroups(t, ageRecipient(t))
+	const chunk = 16
+
+	tests := []struct {
+		Name string
+		Size int
+	}{
+		// Test 0: Empty input still yields a header and trailer.
+		{Name: "empty", Size: 0},
+		// Test 1: Shorter than one chunk.
+		{Name: "short", Size: 5},
+		// Test 2: Exactly one chunk.
+		{Name: "one chunk", Size: chunk},
+		// Test 3: One byte past a chunk boundary.
+		{Name: "chunk plus one", Size: chunk + 1},
+		// Test 4: Many chunks.
+		{Name: "many chunks", Size: chunk*10 + 3},
+	}
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			plain := bytes.Repeat([]byte{0, 'a', '\n', 0xff}, test.Size)[:test.Size]
+			var enc bytes.Buffer
+			plainN, cipherN, err := sopsx.EncryptStream(context.Background(), sopsx.StreamEncryptInput{
+				Path:      "blob.bin",
+				KeyGroups: groups,
+				ChunkSize: chunk,
+			}, &enc, bytes.NewReader(plain))
+			if err != nil {
+				t.Fatalf("EncryptStream: %v", err)
+			}
+			if plainN != int64(test.Size) || cipherN != int64(enc.Len()) {
+				t.Errorf("counts = (%d, %d), want (%d, %d)", plainN, cipherN, test.Size, enc.Len())
+			}
+			if !sopsx.IsEncryptedStream(enc.Bytes()) {
+				t.Fatalf("output not detected as stream")
+			}
+
+			var got bytes.Buffer
+			readN, n, err := sopsx.DecryptStream(context.Background(), sopsx.StreamDecryptInput{
+				Path: "blob.bin",
+			}, &got, bytes.NewReader(enc.Bytes()))
+			if err != nil {
+				t.Fatalf("DecryptStream: %v", err)
+			}
+			if readN != int64(enc.Len()) || n != int64(test.Size) {
+				t.Errorf("counts = (%d, %d), want (%d, %d)", readN, n, enc.Len(), test.Size)
+			}
+			if diff := cmp.Diff(string(plain), got.String()); diff != "" {
+				t.Errorf("round trip mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestStreamTamper verifies that structural edits to an encrypted
+// stream fail decryption instead of yielding altered plaintext, and
+// that the ciphertext count covers only the records consumed.
+func TestStreamTamper(t *testing.T) {
+	groups := ageGroups(t, ageRecipient(t))
+	var enc bytes.Buffer
+	_, _, err := sopsx.EncryptStream(context.Background(), sopsx.StreamEncryptInput{
+		KeyGroups: groups,
+		ChunkSize: 4,
+	}, &enc, strings.NewReader("aaaabbbbcccc"))
+	if err != nil {
+		t.Fatalf("EncryptStream: %v", err)
+	}
+	// Lines: magic, header, three chunks, trailer.
+	lines := strings.SplitAfter(enc.String(), "\n")
+	lines = lines[:len(lines)-1]
+	join := func(idx ...int) string {
+		var b strings.Builder
+		for _, i := range idx {
+			b.WriteString(lines[i])
+		}
+		return b.String()
+	}
+
+	tests := []struct {
+		Name    string
+		Input   string
+		WantErr error
+		WantN   int
+	}{
+		// Test 0: Plain input is not a stream.
+		{Name: "plain", Input: "hello\n", WantErr: sopsx.ErrNotEncrypted, WantN: len("hello\n")},
+		// Test 1: Trailer dropped.
+		{Name: "truncated", Input: join(0, 1, 2, 3, 4), WantErr: sopsx.ErrStreamTruncated, WantN: len(join(0, 1, 2, 3, 4))},
+		// Test 2: Last chunk dropped.
+		{Name: "dropped chunk", Input: join(0, 1, 2, 3, 5), WantN: len(join(0, 1, 2, 3, 5))},
+		// Test 3: Chunks reordered; decryption stops at the first record.
+		{Name: "reordered", Input: join(0, 1, 3, 2, 4, 5), WantN: len(join(0, 1, 3))},
+		// Test 4: Trailing garbage after the trailer is not consumed.
+		{Name: "trailing data", Input: join(0, 1, 2, 3, 4, 5) + "x\n", WantErr: sopsx.ErrParse, WantN: len(join(0, 1, 2, 3, 4, 5))},
+		// Test 5: Header missing.
+		{Name: "no header", Input: join(0), WantErr: sopsx.ErrParse, WantN: len(join(0))},
+	}
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			var out bytes.Buffer
+			n, _, err := sopsx.DecryptStream(context.Background(), sopsx.StreamDecryptInput{},
+				&out, strings.NewReader(test.Input))
+			if err == nil {
+				t.Fatalf("expected error")
+			}
+			if test.WantErr != nil && !errors.Is(err, test.WantErr) {
+				t.Errorf("err = %v, want %v", err, test.WantErr)
+			}
+			if n != int64(test.WantN) {
+				t.Errorf("ciphertext count = %d, want %d", n, test.WantN)
+			}
+		})
+	}
+}
+
+// TestEncryptStreamErrors covers EncryptStream input validation.
+func TestEncryptStreamErrors(t *testing.T) {
+	groups := ageGroups(t, ageRecipient(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		Name    string
+		Ctx     context.Context
+		In      sopsx.StreamEncryptInput
+		WantErr error
+	}{
+		// Test 0: No key groups.
+		{Name: "no groups", Ctx: context.Background(), WantErr: sopsx.ErrNoKeyGroups},
+		// Test 1: Cancelled context stops before the first chunk.
+		{Name: "cancelled", Ctx: ctx, In: sopsx.StreamEncryptInput{KeyGroups: groups}, WantErr: context.Canceled},
+		// Test 2: Oversized chunk.
+		{Name: "chunk too large", Ctx: context.Background(), In: sopsx.StreamEncryptInput{
+			KeyGroups: groups, ChunkSize: 1 << 30,
+		}},
+	}
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			_, _, err := sopsx.EncryptStream(test.Ctx, test.In, &bytes.Buffer{}, strings.NewReader("x"))
+			if err == nil {
+				t.Fatalf("expected error")
+			}
+			if test.WantErr != nil && !errors.Is(err, test.WantErr) {
+				t.Errorf("err = %v, want %v", err, test.WantErr)
+			}
+		})
+	}
+}
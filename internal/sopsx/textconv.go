@@ -0,0 +1,205 @@
+package sopsx
+
+import (
+	"bytes"
+	"context"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/aes"
+	"github.com/getsops/sops/v3/cmd/sops/common"
+	"github.com/getsops/sops/v3/keyservice"
+)
+
+// TextconvMode selects how Textconv renders a file.
+type TextconvMode int
+
+const (
+	// TextconvPlain renders the decrypted file in its own format.
+	TextconvPlain TextconvMode = iota
+	// TextconvMasked renders one line per leaf with its value replaced
+	// by an HMAC-SHA256 digest under a key derived from the file's data
+	// key, never the data key itself. A value's digest changes only
+	// when the value does, until the data key rotates.
+	TextconvMasked
+	// TextconvKeysOnly renders one line per leaf with a fingerprint of
+	// the value as stored in the file. Nothing is decrypted, so no
+	// identity is needed; a fingerprint changes whenever the value is
+	// re-encrypted.
+	TextconvKeysOnly
+)
+
+// textconvDigestLen is the number of hex digits in a rendered digest.
+const textconvDigestLen = 12
+
+// TextconvMaskLabel is the HKDF-SHA256 info string that derives the
+// masking key from a data key, keeping masked digests apart from any
+// other use of the key.
+const TextconvMaskLabel = "cipher textconv mask"
+
+// TextconvInput holds inputs for rendering an encrypted file for diffs.
+type TextconvInput struct {
+	// Path is the file path used to derive Format when Format is zero.
+	Path string
+	// Data is the encrypted file content.
+	Data []byte
+	// Format is the sops format. If zero, it is derived from Path.
+	Format Format
+	// Mode selects the rendering.
+	Mode TextconvMode
+	// KeyServices are the key services used to unwrap data keys. If empty,
+	// a single local key service is used. Unused by TextconvKeysOnly.
+	KeyServices []keyservice.KeyServiceClient
+	// DecryptionOrder is the order in which decryption methods are tried.
+	// If empty, sops.DefaultDecryptionOrder is used.
+	DecryptionOrder []string
+	// IgnoreMAC, when true, skips message authentication code verification.
+	IgnoreMAC bool
+	// Cipher is the sops cipher. If nil, aes.NewCipher() is used.
+	Cipher sops.Cipher
+	// MaxCiphertextBytes is the maximum allowed input size in bytes.
+	// Zero means no limit.
+	MaxCiphertextBytes int
+	// OnUnwrap, when non-nil, receives every master key tried while
+	// unwrapping the data key, whether or not the unwrap succeeded.
+	OnUnwrap func(attempts []UnwrapAttempt)
+}
+
+// Textconv renders an encrypted file as stable text for diffing. The
+// same input always renders the same bytes, and the sops metadata is
+// left out so re-wrapping the data key or a new MAC does not show.
+//
+// TextconvMasked and TextconvKeysOnly print one leaf per line as its
+// key path in ["key"][0] form, in document order, with comments
+// dropped. Documents after the first are separated by "---" lines.
+// Returns ErrNotEncrypted when the input does not carry sops metadata.
+func Textconv(ctx context.Context, in TextconvInput) ([]byte, error) {
+	if in.MaxCiphertextBytes > 0 && len(in.Data) > in.MaxCiphertextBytes {
+		return nil, ErrTooLarge
+	}
+	if in.Format == 0 && in.Path != "" {
+		in.Format = FormatForPath(in.Path)
+	}
+	if !IsEncrypted(in.Data, in.Format) {
+		return nil, ErrNotEncrypted
+	}
+
+	store := StoreFor(in.Format)
+	tree, err := store.LoadEncryptedFile(in.Data)
+	if err != nil {
+		return nil, fmt.Errorf("sopsx: load encrypted: %w", err)
+	}
+	if in.Mode == TextconvKeysOnly {
+		return renderLeaves(tree.Branches, func(_ string, v any) string {
+			sum := sha256.Sum256(fmt.Append(nil, v))
+			return "= <stored " + hex.EncodeToString(sum[:])[:textconvDigestLen] + ">"
+		}), nil
+	}
+
+	cipher := in.Cipher
+	if cipher == nil {
+		cipher = aes.NewCipher()
+	}
+	services := in.KeyServices
+	if len(services) == 0 {
+		services = []keyservice.KeyServiceClient{keyservice.NewLocalClient()}
+	}
+	order := in.DecryptionOrder
+	if len(order) == 0 {
+		order = sops.DefaultDecryptionOrder
+	}
+	_, attempts, err := UnwrapDataKey(ctx, &tree.Metadata, services, order)
+	if in.OnUnwrap != nil {
+		in.OnUnwrap(attempts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := common.DecryptTree(common.DecryptTreeOpts{
+		Tree:            &tree,
+		KeyServices:     services,
+		DecryptionOrder: order,
+		IgnoreMac:       in.IgnoreMAC,
+		Cipher:          cipher,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sopsx: decrypt tree: %w", err)
+	}
+	defer clear(dataKey)
+
+	if in.Mode == TextconvMasked {
+		maskKey, err := hkdf.Key(sha256.New, dataKey, nil, TextconvMaskLabel, sha256.Size)
+		if err != nil {
+			return nil, fmt.Errorf("sopsx: derive mask key: %w", err)
+		}
+		defer clear(maskKey)
+		mac := hmac.New(sha256.New, maskKey)
+		return renderLeaves(tree.Branches, func(path string, v any) string {
+			mac.Reset()
+			fmt.Fprintf(mac, "%s\x00%T\x00%v", path, v, v)
+			return "= <masked " + hex.EncodeToString(mac.Sum(nil))[:textconvDigestLen] + ">"
+		}), nil
+	}
+	out, err := store.EmitPlainFile(tree.Branches)
+	if err != nil {
+		return nil, fmt.Errorf("sopsx: emit plain: %w", err)
+	}
+	return out, nil
+}
+
+// renderLeaves writes "<path> <render(path, value)>" for every leaf.
+// Empty maps and arrays are leaves rendered as {} and [].
+func renderLeaves(docs sops.TreeBranches, render func(path string, v any) string) []byte {
+	var out bytes.Buffer
+	var walk func(path string, node any)
+	walk = func(path string, node any) {
+		switch n := node.(type) {
+		case sops.TreeBranch:
+			empty := true
+			for _, item := range n {
+				if _, isComment := item.Key.(sops.Comment); isComment {
+					continue
+				}
+				empty = false
+				key, ok := item.Key.(string)
+				if !ok {
+					key = fmt.Sprint(item.Key)
+				}
+				quote := `"`
+				if strings.Contains(key, `"`) {
+					quote = "'"
+				}
+				walk(path+"["+quote+key+quote+"]", item.Value)
+			}
+			if empty {
+				out.WriteString(path + " {}\n")
+			}
+		case []any:
+			i := 0
+			for _, v := range n {
+				if isCommentNode(v) {
+					continue
+				}
+				walk(fmt.Sprintf("%s[%d]", path, i), v)
+				i++
+			}
+			if i == 0 {
+				out.WriteString(path + " []\n")
+			}
+		default:
+			out.WriteString(path + " " + render(path, n) + "\n")
+		}
+	}
+	for i, doc := range docs {
+		if i > 0 {
+			out.WriteString("---\n")
+		}
+		walk("", doc)
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,140 @@
+package sopsx_test
+
+import (
+	"context"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/cmd/sops/formats"
+	"github.com/getsops/sops/v3/keyservice"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/dcadolph/cipher/internal/sopsx"
+)
+
+// TestTextconv renders one file in each mode and checks that the
+// output is stable and hides values where it should.
+func TestTextconv(t *testing.T) {
+	groups := ageGroups(t, ageRecipient(t))
+	plain := "# note\ndb:\n    user: app\n    password: hunter2\nhosts:\n    - a\n    - b\nempty: {}\n"
+	enc, err := sopsx.Encrypt(sopsx.EncryptInput{Data: []byte(plain), Format: formats.Yaml, KeyGroups: groups})
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	digest := regexp.MustCompile(`<(masked|stored) [0-9a-f]{12}>`)
+
+	tests := []struct {
+		Name string
+		Mode sopsx.TextconvMode
+		Want string
+	}{{ // Test 0: Plain mode is the decrypted file.
+		Name: "plain", Mode: sopsx.TextconvPlain, Want: plain,
+	}, { // Test 1: Masked mode lists key paths with keyed digests.
+		Name: "masked", Mode: sopsx.TextconvMasked,
+		Want: `["db"]["user"] = <masked>` + "\n" + `["db"]["password"] = <masked>` + "\n" +
+			`["hosts"][0] = <masked>` + "\n" + `["hosts"][1] = <masked>` + "\n" + `["empty"] {}` + "\n",
+	}, { // Test 2: Keys-only mode lists the same paths without decrypting.
+		Name: "keys only", Mode: sopsx.TextconvKeysOnly,
+		Want: `["db"]["user"] = <stored>` + "\n" + `["db"]["password"] = <stored>` + "\n" +
+			`["hosts"][0] = <stored>` + "\n" + `["hosts"][1] = <stored>` + "\n" + `["empty"] {}` + "\n",
+	}}
+
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			in := sopsx.TextconvInput{Data: enc, Format: formats.Yaml, Mode: test.Mode}
+			if test.Mode == sopsx.TextconvKeysOnly {
+				t.Setenv("SOPS_AGE_KEY", "")
+			}
+			out, err := sopsx.Textconv(context.Background(), in)
+			if err != nil {
+				t.Fatalf("Textconv: %v", err)
+			}
+			again, err := sopsx.Textconv(context.Background(), in)
+			if err != nil {
+				t.Fatalf("Textconv again: %v", err)
+			}
+			if string(out) != string(again) {
+				t.Errorf("output not stable:\n%s\n---\n%s", out, again)
+			}
+			if test.Mode != sopsx.TextconvPlain && strings.Contains(string(out), "hunter2") {
+				t.Errorf("value revealed:\n%s", out)
+			}
+			got := digest.ReplaceAllString(string(out), "<$1>")
+			if diff := cmp.Diff(test.Want, got); diff != "" {
+				t.Errorf("output mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestTextconvMaskedTracksValues checks that masked digests change with
+// the value they stand for and nothing else, and are keyed with the
+// HKDF-derived mask key rather than the data key.
+func TestTextconvMaskedTracksValues(t *testing.T) {
+	groups := ageGroups(t, ageRecipient(t))
+	enc, err := sopsx.Encrypt(sopsx.EncryptInput{Data: []byte("a: x\nb: x\n"), Format: formats.Yaml, KeyGroups: groups})
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	edited, err := sopsx.EditTree(context.Background(), sopsx.EditTreeInput{
+		Data: enc, Format: formats.Yaml,
+		Edits: []sopsx.TreeEdit{{Op: sopsx.TreeEditSet, Steps: []sopsx.PathStep{{Key: "b"}}, Value: "y"}},
+	})
+	if err != nil {
+		t.Fatalf("EditTree: %v", err)
+	}
+	render := func(data []byte) []string {
+		out, err := sopsx.Textconv(context.Background(), sopsx.TextconvInput{Data: data, Format: formats.Yaml, Mode: sopsx.TextconvMasked})
+		if err != nil {
+			t.Fatalf("Textconv: %v", err)
+		}
+		return strings.Split(strings.TrimSpace(string(out)), "\n")
+	}
+	before, after := render(enc), render(edited)
+	if before[0] != after[0] {
+		t.Errorf("unchanged value digest changed: %q vs %q", before[0], after[0])
+	}
+	if before[1] == after[1] {
+		t.Errorf("changed value digest unchanged: %q", after[1])
+	}
+	if strings.TrimPrefix(before[0], `["a"]`) == strings.TrimPrefix(before[1], `["b"]`) {
+		t.Errorf("equal values at different keys share a digest: %q, %q", before[0], before[1])
+	}
+
+	tree, err := sopsx.StoreFor(formats.Yaml).LoadEncryptedFile(enc)
+	if err != nil {
+		t.Fatalf("LoadEncryptedFile: %v", err)
+	}
+	dataKey, _, err := sopsx.UnwrapDataKey(context.Background(), &tree.Metadata,
+		[]keyservice.KeyServiceClient{keyservice.NewLocalClient()}, sops.DefaultDecryptionOrder)
+	if err != nil {
+		t.Fatalf("UnwrapDataKey: %v", err)
+	}
+	maskKey, err := hkdf.Key(sha256.New, dataKey, nil, sopsx.TextconvMaskLabel, sha256.Size)
+	if err != nil {
+		t.Fatalf("hkdf: %v", err)
+	}
+	digest := func(key []byte) string {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(`["a"]` + "\x00string\x00x"))
+		return `["a"] = <masked ` + hex.EncodeToString(mac.Sum(nil))[:12] + ">"
+	}
+	if before[0] != digest(maskKey) {
+		t.Errorf("digest = %q, want %q from the derived key", before[0], digest(maskKey))
+	}
+	if before[0] == digest(dataKey) {
+		t.Errorf("digest %q is keyed with the data key itself", before[0])
+	}
+
+	if _, err := sopsx.Textconv(context.Background(), sopsx.TextconvInput{Data: []byte("a: 1\n"), Format: formats.Yaml}); !errors.Is(err, sopsx.ErrNotEncrypted) {
+		t.Errorf("plain input err = %v, want ErrNotEncrypted", err)
+	}
+}
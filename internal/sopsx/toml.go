@@ -0,0 +1,470 @@
+package sopsx
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/cmd/sops/common"
+	"github.com/getsops/sops/v3/cmd/sops/formats"
+	"github.com/getsops/sops/v3/config"
+	"github.com/getsops/sops/v3/stores"
+)
+
+// FormatTOML is the format value for TOML files. sops has no TOML
+// store, so the value sits well past the sops enum and is routed to
+// tomlStore by StoreFor.
+const FormatTOML Format = 100
+
+// FormatForPath returns the format for path, inferred from its
+// extension. It extends the sops mapping with ".toml".
+func FormatForPath(path string) Format {
+	if strings.HasSuffix(path, ".toml") {
+		return FormatTOML
+	}
+	return formats.FormatForPath(path)
+}
+
+// FormatFromString returns the format for a lowercase name. It extends
+// the sops mapping with "toml"; unknown names resolve to binary.
+func FormatFromString(name string) Format {
+	if name == "toml" {
+		return FormatTOML
+	}
+	return formats.FormatFromString(name)
+}
+
+// StoreFor returns the sops store for format, including the TOML store
+// sops does not ship.
+func StoreFor(format Format) sops.Store {
+	if format == FormatTOML {
+		return tomlStore{}
+	}
+	return common.StoreForFormat(format, config.NewStoresConfig())
+}
+
+// MarshalTOML renders a decrypted plain value as TOML. Maps become a
+// document with keys in sorted order; other values render inline.
+func MarshalTOML(v any) ([]byte, error) {
+	node := tomlFromPlain(v)
+	if branch, ok := node.(sops.TreeBranch); ok {
+		return tomlStore{}.EmitPlainFile(sops.TreeBranches{branch})
+	}
+	s, err := tomlValue(node)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(s + "\n"), nil
+}
+
+// tomlFromPlain converts plain maps and slices into sops tree nodes,
+// sorting map keys so output is stable.
+func tomlFromPlain(v any) any {
+	switch typed := v.(type) {
+	case map[string]any:
+		branch := make(sops.TreeBranch, 0, len(typed))
+		for _, key := range slices.Sorted(maps.Keys(typed)) {
+			branch = append(branch, sops.TreeItem{Key: key, Value: tomlFromPlain(typed[key])})
+		}
+		return branch
+	case []any:
+		out := make([]any, len(typed))
+		for i, e := range typed {
+			out[i] = tomlFromPlain(e)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// tomlStore implements sops.Store for TOML. Metadata lives under a
+// top-level [sops] table, laid out the way the JSON store nests it.
+//
+// TOML requires a table's plain keys before its sub-tables, so loaded
+// trees are put in that order (plain keys, then tables, then arrays of
+// tables, each in document order). Emitting and reloading a tree then
+// yields the same leaf order, which the MAC depends on. Comments are
+// not preserved, TOML has no null so nil map values are dropped, and
+// local dates and times decrypt as offset date-times.
+type tomlStore struct{}
+
+// Name implements sops.Store.
+func (tomlStore) Name() string { return "toml" }
+
+// LoadPlainFile implements sops.Store.
+func (tomlStore) LoadPlainFile(in []byte) (sops.TreeBranches, error) {
+	var doc map[string]any
+	md, err := toml.Decode(string(in), &doc)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal toml: %w", err)
+	}
+	order := make(map[string]int)
+	for i, key := range md.Keys() {
+		name := strings.Join(key, "\x00")
+		if _, seen := order[name]; !seen {
+			order[name] = i
+		}
+	}
+	return sops.TreeBranches{tomlBranch(doc, nil, order)}, nil
+}
+
+// LoadEncryptedFile implements sops.Store.
+func (s tomlStore) LoadEncryptedFile(in []byte) (sops.Tree, error) {
+	branches, err := s.LoadPlainFile(in)
+	if err != nil {
+		return sops.Tree{}, err
+	}
+	branches, metadata, err := stores.ExtractMetadata(branches, stores.MetadataOpts{
+		Flatten: stores.MetadataFlattenNone,
+	})
+	if err != nil {
+		return sops.Tree{}, err
+	}
+	return sops.Tree{Branches: branches, Metadata: metadata}, nil
+}
+
+// EmitPlainFile implements sops.Store. TOML holds one document, so
+// more than one branch is an error.
+func (tomlStore) EmitPlainFile(in sops.TreeBranches) ([]byte, error) {
+	if len(in) > 1 {
+		return nil, fmt.Errorf("toml holds a single document, got %d", len(in))
+	}
+	var b bytes.Buffer
+	if len(in) == 1 {
+		if err := writeTOMLTable(&b, nil, in[0]); err != nil {
+			return nil, err
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// EmitEncryptedFile implements sops.Store.
+func (s tomlStore) EmitEncryptedFile(in sops.Tree) ([]byte, error) {
+	branches, err := stores.SerializeMetadata(in, stores.MetadataOpts{
+		Flatten: stores.MetadataFlattenNone,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling metadata: %w", err)
+	}
+	return s.EmitPlainFile(branches)
+}
+
+// EmitValue implements sops.Store. Maps render as a document; other
+// values render inline.
+func (s tomlStore) EmitValue(v any) ([]byte, error) {
+	if branch, ok := v.(sops.TreeBranch); ok {
+		return s.EmitPlainFile(sops.TreeBranches{branch})
+	}
+	out, err := tomlValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(out), nil
+}
+
+// HasSopsTopLevelKey implements sops.Store.
+func (tomlStore) HasSopsTopLevelKey(branch sops.TreeBranch) bool {
+	return stores.HasSopsTopLevelKey(branch)
+}
+
+// tomlRank orders a value within its table: plain keys, then tables,
+// then arrays of tables.
+func tomlRank(v any) int {
+	switch typed := v.(type) {
+	case map[string]any:
+		return 1
+	case []map[string]any:
+		return 2
+	case []any:
+		if isTOMLTableArray(typed) {
+			return 2
+		}
+	}
+	return 0
+}
+
+// tomlBranch converts a decoded TOML table into a tree branch in
+// canonical order. order maps NUL-joined key paths to their position
+// in the document.
+func tomlBranch(m map[string]any, path []string, order map[string]int) sops.TreeBranch {
+	pos := func(key string) int {
+		if i, ok := order[strings.Join(append(slices.Clone(path), key), "\x00")]; ok {
+			return i
+		}
+		return math.MaxInt
+	}
+	keys := slices.Collect(maps.Keys(m))
+	slices.SortFunc(keys, func(a, b string) int {
+		return cmp.Or(
+			cmp.Compare(tomlRank(m[a]), tomlRank(m[b])),
+			cmp.Compare(pos(a), pos(b)),
+			cmp.Compare(a, b),
+		)
+	})
+	branch := make(sops.TreeBranch, 0, len(keys))
+	for _, key := range keys {
+		sub := append(slices.Clone(path), key)
+		branch = append(branch, sops.TreeItem{Key: key, Value: tomlNode(m[key], sub, order)})
+	}
+	return branch
+}
+
+// tomlNode converts a decoded TOML value into a sops tree node.
+// Integers become int, the only integer type the sops cipher accepts.
+func tomlNode(v any, path []string, order map[string]int) any {
+	switch typed := v.(type) {
+	case map[string]any:
+		return tomlBranch(typed, path, order)
+	case []map[string]any:
+		out := make([]any, len(typed))
+		for i, e := range typed {
+			out[i] = tomlBranch(e, path, order)
+		}
+		return out
+	case []any:
+		out := make([]any, len(typed))
+		for i, e := range typed {
+			out[i] = tomlNode(e, path, order)
+		}
+		return out
+	case int64:
+		return int(typed)
+	default:
+		return v
+	}
+}
+
+// isTOMLTableArray reports whether arr is non-empty and every element
+// other than comments is a table, so it can be written as [[key]]
+// sections. Both decoded maps and tree branches count.
+func isTOMLTableArray(arr []any) bool {
+	n := 0
+	for _, e := range arr {
+		switch e.(type) {
+		case sops.Comment:
+			continue
+		case sops.TreeBranch, map[string]any:
+			n++
+		default:
+			return false
+		}
+	}
+	return n > 0
+}
+
+// writeTOMLTable writes the body of the table at path: plain keys
+// first, then [table] sections, then [[array]] sections. At the top
+// level the sops metadata table is written last.
+func writeTOMLTable(b *bytes.Buffer, path []string, branch sops.TreeBranch) error {
+	var tables, arrays, meta []sops.TreeItem
+	for _, item := range branch {
+		key, ok := item.Key.(string)
+		if !ok {
+			if _, isComment := item.Key.(sops.Comment); isComment {
+				continue
+			}
+			return fmt.Errorf("toml keys must be strings, got %T", item.Key)
+		}
+		switch v := item.Value.(type) {
+		case nil:
+			continue
+		case sops.TreeBranch:
+			if len(path) == 0 && key == stores.SopsMetadataKey {
+				meta = append(meta, item)
+			} else {
+				tables = append(tables, item)
+			}
+			continue
+		case []any:
+			if isTOMLTableArray(v) {
+				arrays = append(arrays, item)
+				continue
+			}
+		}
+		val, err := tomlValue(item.Value)
+		if err != nil {
+			return fmt.Errorf("toml key %q: %w", key, err)
+		}
+		fmt.Fprintf(b, "%s = %s\n", tomlKey(key), val)
+	}
+	section := func(header string, item sops.TreeItem, body sops.TreeBranch) error {
+		sub := append(slices.Clone(path), item.Key.(string))
+		if b.Len() > 0 {
+			b.WriteByte('\n')
+		}
+		fmt.Fprintf(b, header, tomlKeyPath(sub))
+		return writeTOMLTable(b, sub, body)
+	}
+	for _, item := range tables {
+		if err := section("[%s]\n", item, item.Value.(sops.TreeBranch)); err != nil {
+			return err
+		}
+	}
+	for _, item := range arrays {
+		for _, e := range item.Value.([]any) {
+			if elem, ok := e.(sops.TreeBranch); ok {
+				if err := section("[[%s]]\n", item, elem); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, item := range meta {
+		if err := section("[%s]\n", item, item.Value.(sops.TreeBranch)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tomlValue renders v as an inline TOML value.
+func tomlValue(v any) (string, error) {
+	switch typed := v.(type) {
+	case string:
+		return tomlString(typed), nil
+	case int:
+		return strconv.Itoa(typed), nil
+	case int64:
+		return strconv.FormatInt(typed, 10), nil
+	case float64:
+		return tomlFloat(typed), nil
+	case bool:
+		return strconv.FormatBool(typed), nil
+	case time.Time:
+		return tomlTime(typed), nil
+	case []any:
+		parts := make([]string, 0, len(typed))
+		for _, e := range typed {
+			if _, isComment := e.(sops.Comment); isComment {
+				continue
+			}
+			if e == nil {
+				return "", fmt.Errorf("toml arrays cannot hold null")
+			}
+			s, err := tomlValue(e)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, s)
+		}
+		return "[" + strings.Join(parts, ", ") + "]", nil
+	case sops.TreeBranch:
+		parts := make([]string, 0, len(typed))
+		for _, item := range typed {
+			if _, isComment := item.Key.(sops.Comment); isComment || item.Value == nil {
+				continue
+			}
+			key, ok := item.Key.(string)
+			if !ok {
+				return "", fmt.Errorf("toml keys must be strings, got %T", item.Key)
+			}
+			s, err := tomlValue(item.Value)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, tomlKey(key)+" = "+s)
+		}
+		if len(parts) == 0 {
+			return "{}", nil
+		}
+		return "{ " + strings.Join(parts, ", ") + " }", nil
+	default:
+		return "", fmt.Errorf("unsupported toml value type %T", v)
+	}
+}
+
+// tomlKeyPath renders a dotted table header key.
+func tomlKeyPath(path []string) string {
+	parts := make([]string, len(path))
+	for i, p := range path {
+		parts[i] = tomlKey(p)
+	}
+	return strings.Join(parts, ".")
+}
+
+// tomlKey renders key bare when TOML allows it and quoted otherwise.
+func tomlKey(key string) string {
+	if key == "" {
+		return `""`
+	}
+	for _, r := range key {
+		if !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return tomlString(key)
+		}
+	}
+	return key
+}
+
+// tomlString renders s as a TOML basic string.
+func tomlString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// tomlFloat renders f so it always reads back as a float.
+func tomlFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "nan"
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// tomlTime renders t in the TOML date-time form it was decoded from.
+// Local dates and times carry marker zones from the decoder; anything
+// else is an offset date-time.
+func tomlTime(t time.Time) string {
+	switch t.Location().String() {
+	case "datetime-local":
+		return t.Format("2006-01-02T15:04:05.999999999")
+	case "date-local":
+		return t.Format("2006-01-02")
+	case "time-local":
+		return t.Format("15:04:05.999999999")
+	default:
+		return t.Format(time.RFC3339Nano)
+	}
+}
@@ -0,0 +1,108 @@
+package sopsx_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/dcadolph/cipher/internal/sopsx"
+)
+
+// TestTOMLRoundTrip encrypts TOML documents, verifies the MAC survives
+// the emit and reload, and checks the decrypted output.
+func TestTOMLRoundTrip(t *testing.T) {
+	groups := ageGroups(t, ageRecipient(t))
+	tests := []struct {
+		Name string
+		In   string
+		Want string
+	}{{ // Test 0: Scalars of every type keep their types.
+		Name: "scalars",
+		In:   "name = \"app\"\nport = 5432\nratio = 0.5\ntls = true\nwhen = 2024-05-01T10:00:00Z\n",
+		Want: "name = \"app\"\nport = 5432\nratio = 0.5\ntls = true\nwhen = 2024-05-01T10:00:00Z\n",
+	}, { // Test 1: Tables and arrays of tables keep document order.
+		Name: "tables",
+		In: "title = \"x\"\n\n[db]\nuser = \"app\"\n\n[db.tls]\nca = \"pem\"\n\n" +
+			"[[servers]]\nhost = \"a\"\n\n[[servers]]\nhost = \"b\"\n\n[cache]\nttl = 30\n",
+		Want: "title = \"x\"\n\n[db]\nuser = \"app\"\n\n[db.tls]\nca = \"pem\"\n\n[cache]\nttl = 30\n\n" +
+			"[[servers]]\nhost = \"a\"\n\n[[servers]]\nhost = \"b\"\n",
+	}, { // Test 2: Dotted keys after plain keys become a table.
+		Name: "dotted keys",
+		In:   "a.b = 1\nc = \"two\"\n",
+		Want: "c = \"two\"\n\n[a]\nb = 1\n",
+	}, { // Test 3: Inline arrays, inline tables, and quoted keys.
+		Name: "inline",
+		In:   "ports = [1, 2]\n\"my key\" = \"q\\\"uote\\n\"\nmixed = [{ k = \"v\" }, \"s\"]\n",
+		Want: "ports = [1, 2]\n\"my key\" = \"q\\\"uote\\n\"\nmixed = [{ k = \"v\" }, \"s\"]\n",
+	}}
+
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			enc, err := sopsx.Encrypt(sopsx.EncryptInput{
+				Data: []byte(test.In), Format: sopsx.FormatTOML, KeyGroups: groups,
+			})
+			if err != nil {
+				t.Fatalf("Encrypt: %v", err)
+			}
+			if !strings.Contains(string(enc), "\n[sops]\n") {
+				t.Errorf("encrypted output has no [sops] table:\n%s", enc)
+			}
+			if !sopsx.IsEncrypted(enc, sopsx.FormatTOML) {
+				t.Fatal("IsEncrypted = false after Encrypt")
+			}
+			if err := sopsx.Verify(context.Background(), sopsx.VerifyInput{Data: enc, Format: sopsx.FormatTOML}); err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			plain, err := sopsx.Decrypt(context.Background(), sopsx.DecryptInput{Data: enc, Format: sopsx.FormatTOML})
+			if err != nil {
+				t.Fatalf("Decrypt: %v", err)
+			}
+			if diff := cmp.Diff(test.Want, string(plain)); diff != "" {
+				t.Errorf("plaintext mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestTOMLEncryptedRegex leaves keys outside EncryptedRegex readable
+// and reports the regex through Inspect.
+func TestTOMLEncryptedRegex(t *testing.T) {
+	groups := ageGroups(t, ageRecipient(t))
+	in := "host = \"db.internal\"\npassword = \"hunter2\"\n"
+	enc, err := sopsx.Encrypt(sopsx.EncryptInput{
+		Data: []byte(in), Format: sopsx.FormatTOML, KeyGroups: groups,
+		EncryptedRegex: "^password$",
+	})
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if !strings.Contains(string(enc), "host = \"db.internal\"\n") {
+		t.Errorf("host was encrypted:\n%s", enc)
+	}
+	if strings.Contains(string(enc), "hunter2") {
+		t.Errorf("password left in plaintext:\n%s", enc)
+	}
+	info, err := sopsx.Inspect(enc, sopsx.FormatTOML)
+	if err != nil {
+		t.Fatalf("Inspect: %v", err)
+	}
+	if info.EncryptedRegex != "^password$" || len(info.Groups) != 1 {
+		t.Errorf("Inspect = %+v", info)
+	}
+}
+
+// TestTOMLFormat covers the extension and name mapping.
+func TestTOMLFormat(t *testing.T) {
+	if got := sopsx.FormatForPath("conf/app.toml"); got != sopsx.FormatTOML {
+		t.Errorf("FormatForPath = %v, want FormatTOML", got)
+	}
+	if got := sopsx.FormatFromString("toml"); got != sopsx.FormatTOML {
+		t.Errorf("FormatFromString = %v, want FormatTOML", got)
+	}
+	if got := sopsx.StoreFor(sopsx.FormatTOML).Name(); got != "toml" {
+		t.Errorf("StoreFor(FormatTOML).Name() = %q", got)
+	}
+}
@@ -0,0 +1,154 @@
+package sopsx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/keys"
+	"github.com/getsops/sops/v3/keyservice"
+	"github.com/getsops/sops/v3/shamir"
+)
+
+// UnwrapAttempt records one master key tried while unwrapping a data
+// key.
+type UnwrapAttempt struct {
+	// Group is the index of the key group holding the master key.
+	Group int
+	// Type is the sops key type identifier: "age", "pgp", "kms",
+	// "gcp_kms", "azure_kv", "hc_vault", or "hckms".
+	Type string
+	// Identifier is the master key as sops prints it, for example the
+	// age recipient or the KMS ARN.
+	Identifier string
+	// Err is nil when this key unwrapped its group's share of the data
+	// key. Otherwise it joins the error from every key service tried.
+	Err error
+}
+
+// String renders a as "type identifier: ok" or "type identifier: err".
+func (a UnwrapAttempt) String() string {
+	if a.Err == nil {
+		return a.Type + " " + a.Identifier + ": ok"
+	}
+	return a.Type + " " + a.Identifier + ": " + a.Err.Error()
+}
+
+// UnwrapError is returned when no master key, or too few key groups,
+// could unwrap the data key. Attempts lists every key tried, in order.
+type UnwrapError struct {
+	// Attempts lists every master key tried, in order.
+	Attempts []UnwrapAttempt
+	// Groups is the number of key groups in the file.
+	Groups int
+	// Threshold is the number of groups that must unwrap.
+	Threshold int
+}
+
+// Error implements error.
+func (e *UnwrapError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "sopsx: unwrap data key: %d of %d key group(s) required", e.Threshold, e.Groups)
+	for _, a := range e.Attempts {
+		b.WriteString("; ")
+		b.WriteString(a.String())
+	}
+	return b.String()
+}
+
+// Unwrap returns the error of every failed attempt.
+func (e *UnwrapError) Unwrap() []error {
+	var errs []error
+	for _, a := range e.Attempts {
+		if a.Err != nil {
+			errs = append(errs, a.Err)
+		}
+	}
+	return errs
+}
+
+// UnwrapDataKey recovers the data key of m the way sops does, trying
+// each key group's master keys in order and each key service per key,
+// but records every attempt. Each key service call uses ctx. On
+// success the key is also stored in m.DataKey so later sops calls
+// reuse it. On failure the error is an *UnwrapError carrying the same
+// attempts.
+func UnwrapDataKey(
+	ctx context.Context, m *sops.Metadata, services []keyservice.KeyServiceClient, order []string,
+) ([]byte, []UnwrapAttempt, error) {
+	if len(services) == 0 {
+		services = []keyservice.KeyServiceClient{keyservice.NewLocalClient()}
+	}
+	if len(order) == 0 {
+		order = sops.DefaultDecryptionOrder
+	}
+	threshold := 1
+	if len(m.KeyGroups) > 1 {
+		threshold = m.ShamirThreshold
+	}
+
+	var attempts []UnwrapAttempt
+	var parts [][]byte
+	for i, group := range m.KeyGroups {
+		for _, key := range orderGroup(group, order) {
+			part, err := unwrapPart(ctx, key, services)
+			attempts = append(attempts, UnwrapAttempt{
+				Group: i, Type: key.TypeToIdentifier(), Identifier: key.ToString(), Err: err,
+			})
+			if err == nil {
+				parts = append(parts, part)
+				break
+			}
+		}
+	}
+	fail := &UnwrapError{Attempts: attempts, Groups: len(m.KeyGroups), Threshold: threshold}
+	if len(parts) == 0 || len(parts) < threshold {
+		return nil, attempts, fail
+	}
+	dataKey := parts[0]
+	if len(m.KeyGroups) > 1 {
+		var err error
+		dataKey, err = shamir.Combine(parts)
+		if err != nil {
+			return nil, attempts, fmt.Errorf("sopsx: combine shamir parts: %w", err)
+		}
+	}
+	m.DataKey = dataKey
+	return dataKey, attempts, nil
+}
+
+// orderGroup returns the keys of group sorted by their position in
+// order. Key types missing from order keep their relative order at the
+// end, matching sops.
+func orderGroup(group sops.KeyGroup, order []string) sops.KeyGroup {
+	rank := func(key keys.MasterKey) int {
+		if i := slices.Index(order, key.TypeToIdentifier()); i >= 0 {
+			return i
+		}
+		return len(order)
+	}
+	sorted := slices.Clone(group)
+	slices.SortStableFunc(sorted, func(a, b keys.MasterKey) int { return rank(a) - rank(b) })
+	return sorted
+}
+
+// unwrapPart asks each service in turn to decrypt key's share of the
+// data key, returning the first success or every error joined.
+func unwrapPart(ctx context.Context, key keys.MasterKey, services []keyservice.KeyServiceClient) ([]byte, error) {
+	svcKey := keyservice.KeyFromMasterKey(key)
+	var errs []error
+	for _, svc := range services {
+		rsp, err := svc.Decrypt(ctx, &keyservice.DecryptRequest{
+			Ciphertext: key.EncryptedDataKey(),
+			Key:        &svcKey,
+		})
+		if err == nil {
+			return rsp.Plaintext, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
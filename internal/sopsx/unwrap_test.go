@@ -0,0 +1,131 @@
+package sopsx_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/getsops/sops/v3/keyservice"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc"
+
+	"github.com/dcadolph/cipher/internal/sopsx"
+)
+
+// TestUnwrapAttempts checks that every master key tried is reported,
+// in order, on success through OnUnwrap and on failure through
+// *UnwrapError.
+func TestUnwrapAttempts(t *testing.T) {
+	t.Parallel()
+	var ids [3]*age.X25519Identity
+	for i := range ids {
+		id, err := age.GenerateX25519Identity()
+		if err != nil {
+			t.Fatalf("generate identity: %v", err)
+		}
+		ids[i] = id
+	}
+	first, second := ids[0].Recipient().String(), ids[1].Recipient().String()
+	enc, err := sopsx.Encrypt(sopsx.EncryptInput{
+		Path: "s.yaml", Data: []byte("a: b\n"), KeyGroups: ageGroups(t, first+","+second),
+	})
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	type attempt struct {
+		Identifier string
+		OK         bool
+	}
+	tests := []struct {
+		Name     string
+		Identity *age.X25519Identity
+		Want     []attempt
+		WantErr  bool
+	}{{ // Test 0: The second recipient unwraps after the first fails.
+		Name: "second key", Identity: ids[1],
+		Want: []attempt{{first, false}, {second, true}},
+	}, { // Test 1: The first recipient unwraps and the second is not tried.
+		Name: "first key", Identity: ids[0],
+		Want: []attempt{{first, true}},
+	}, { // Test 2: No recipient matches; every key is reported.
+		Name: "no match", Identity: ids[2],
+		Want:    []attempt{{first, false}, {second, false}},
+		WantErr: true,
+	}}
+
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			t.Parallel()
+			client, err := sopsx.NewIdentityClient([]string{test.Identity.String()}, nil)
+			if err != nil {
+				t.Fatalf("NewIdentityClient: %v", err)
+			}
+			var got []attempt
+			_, err = sopsx.Decrypt(context.Background(), sopsx.DecryptInput{
+				Path: "s.yaml", Data: enc,
+				KeyServices: []keyservice.KeyServiceClient{client},
+				OnUnwrap: func(attempts []sopsx.UnwrapAttempt) {
+					for _, a := range attempts {
+						if a.Type != "age" || a.Group != 0 {
+							t.Errorf("attempt %v: want age key in group 0", a)
+						}
+						got = append(got, attempt{a.Identifier, a.Err == nil})
+					}
+				},
+			})
+			if diff := cmp.Diff(test.Want, got); diff != "" {
+				t.Errorf("attempts mismatch (-want +got):\n%s", diff)
+			}
+			var ue *sopsx.UnwrapError
+			if errors.As(err, &ue) != test.WantErr {
+				t.Fatalf("Decrypt err = %v, want UnwrapError %v", err, test.WantErr)
+			}
+			if test.WantErr && len(ue.Attempts) != len(test.Want) {
+				t.Errorf("UnwrapError has %d attempts, want %d", len(ue.Attempts), len(test.Want))
+			}
+		})
+	}
+}
+
+// ctxClient is a key service that fails with the error of the context
+// it is called with.
+type ctxClient struct {
+	keyservice.KeyServiceClient
+}
+
+// Decrypt implements keyservice.KeyServiceClient.
+func (ctxClient) Decrypt(
+	ctx context.Context, _ *keyservice.DecryptRequest, _ ...grpc.CallOption,
+) (*keyservice.DecryptResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("no identity")
+}
+
+// TestUnwrapContext checks that the caller's context reaches each key
+// service.
+func TestUnwrapContext(t *testing.T) {
+	t.Parallel()
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generate identity: %v", err)
+	}
+	enc, err := sopsx.Encrypt(sopsx.EncryptInput{
+		Path: "s.yaml", Data: []byte("a: b\n"), KeyGroups: ageGroups(t, id.Recipient().String()),
+	})
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = sopsx.Decrypt(ctx, sopsx.DecryptInput{
+		Path: "s.yaml", Data: enc, KeyServices: []keyservice.KeyServiceClient{ctxClient{}},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Decrypt err = %v, want context.Canceled", err)
+	}
+}
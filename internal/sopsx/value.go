@@ -0,0 +1,224 @@
+package sopsx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/aes"
+	"github.com/getsops/sops/v3/keyservice"
+)
+
+// ErrPathNotFound signals that a key path does not resolve to a node
+// in the document.
+var ErrPathNotFound = errors.New("sopsx: key path not found")
+
+// PathStep is one step of a key path: a map key or an array index.
+type PathStep struct {
+	// Key is the map key when IsIndex is false.
+	Key string
+	// Index is the array index when IsIndex is true.
+	Index int
+	// IsIndex reports whether this step indexes an array.
+	IsIndex bool
+}
+
+// DecryptValueInput holds inputs for decrypting one node of a file.
+type DecryptValueInput struct {
+	// Path is the file path used to derive Format when Format is zero.
+	Path string
+	// Data is the encrypted file content.
+	Data []byte
+	// Format is the sops format. If zero, it is derived from Path.
+	Format Format
+	// Steps selects the node to decrypt. Must be non-empty.
+	Steps []PathStep
+	// KeyServices are the key services used to unwrap data keys. If empty,
+	// a single local key service is used.
+	KeyServices []keyservice.KeyServiceClient
+	// DecryptionOrder is the order in which decryption methods are tried.
+	// If empty, sops.DefaultDecryptionOrder is used.
+	DecryptionOrder []string
+	// Cipher is the sops cipher. If nil, aes.NewCipher() is used.
+	Cipher sops.Cipher
+	// MaxCiphertextBytes is the maximum allowed input size in bytes.
+	// Zero means no limit.
+	MaxCiphertextBytes int
+	// OnUnwrap, when non-nil, receives every master key tried while
+	// unwrapping the data key, whether or not the unwrap succeeded.
+	OnUnwrap func(attempts []UnwrapAttempt)
+}
+
+// DecryptValue decrypts only the node selected by in.Steps and returns
+// it as plain Go values: map[string]any for maps, []any for arrays,
+// and the scalar itself otherwise. Leaves outside the selected subtree
+// are never passed to the cipher.
+//
+// The file MAC covers every leaf, so it cannot be checked without
+// decrypting the whole file and is skipped here. Each value is still
+// authenticated by AES-GCM with its tree path as additional data, so a
+// value moved from another key or encrypted under another data key is
+// rejected.
+//
+// Returns ErrNotEncrypted when the input does not carry sops metadata
+// and ErrPathNotFound when the steps do not resolve. Only the first
+// document of a multi-document file is searched.
+func DecryptValue(ctx context.Context, in DecryptValueInput) (any, error) {
+	if in.MaxCiphertextBytes > 0 && len(in.Data) > in.MaxCiphertextBytes {
+		return nil, ErrTooLarge
+	}
+	if len(in.Steps) == 0 {
+		return nil, fmt.Errorf("%w: empty key path", ErrPathNotFound)
+	}
+	if in.Format == 0 && in.Path != "" {
+		in.Format = FormatForPath(in.Path)
+	}
+	if !IsEncrypted(in.Data, in.Format) {
+		return nil, ErrNotEncrypted
+	}
+
+	cipher := in.Cipher
+	if cipher == nil {
+		cipher = aes.NewCipher()
+	}
+	services := in.KeyServices
+	if len(services) == 0 {
+		services = []keyservice.KeyServiceClient{keyservice.NewLocalClient()}
+	}
+	order := in.DecryptionOrder
+	if len(order) == 0 {
+		order = sops.DefaultDecryptionOrder
+	}
+
+	store := StoreFor(in.Format)
+	tree, err := store.LoadEncryptedFile(in.Data)
+	if err != nil {
+		return nil, fmt.Errorf("sopsx: load encrypted: %w", err)
+	}
+	if len(tree.Branches) == 0 {
+		return nil, fmt.Errorf("%w: empty document", ErrPathNotFound)
+	}
+
+	pruned, err := prunePath(tree.Branches[0], in.Steps)
+	if err != nil {
+		return nil, err
+	}
+	tree.Branches = sops.TreeBranches{pruned.(sops.TreeBranch)}
+
+	dataKey, attempts, err := UnwrapDataKey(ctx, &tree.Metadata, services, order)
+	if in.OnUnwrap != nil {
+		in.OnUnwrap(attempts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer clear(dataKey)
+	if _, err := tree.Decrypt(dataKey, cipher); err != nil {
+		return nil, fmt.Errorf("sopsx: decrypt value: %w", err)
+	}
+
+	var node any = tree.Branches[0]
+	for range in.Steps {
+		switch n := node.(type) {
+		case sops.TreeBranch:
+			node = n[0].Value
+		case []any:
+			node = n[0]
+		}
+	}
+	return plainValue(node), nil
+}
+
+// prunePath returns a copy of node that keeps only the items on steps
+// and the full subtree they select. Arrays shrink to the one selected
+// element, counting past comments; sops does not bind array positions
+// into the additional data, so every kept value still decrypts at its
+// original path.
+func prunePath(node any, steps []PathStep) (any, error) {
+	if len(steps) == 0 {
+		return node, nil
+	}
+	step := steps[0]
+	if step.IsIndex {
+		arr, ok := node.([]any)
+		if !ok {
+			return nil, fmt.Errorf("%w: index [%d] into non-array", ErrPathNotFound, step.Index)
+		}
+		elems := make([]any, 0, len(arr))
+		for _, v := range arr {
+			if !isCommentNode(v) {
+				elems = append(elems, v)
+			}
+		}
+		if step.Index < 0 || step.Index >= len(elems) {
+			return nil, fmt.Errorf("%w: index [%d] out of range (len %d)",
+				ErrPathNotFound, step.Index, len(elems))
+		}
+		child, err := prunePath(elems[step.Index], steps[1:])
+		if err != nil {
+			return nil, err
+		}
+		return []any{child}, nil
+	}
+	branch, ok := node.(sops.TreeBranch)
+	if !ok {
+		return nil, fmt.Errorf("%w: key %q into non-map", ErrPathNotFound, step.Key)
+	}
+	for _, item := range branch {
+		if key, ok := item.Key.(string); ok && key == step.Key {
+			child, err := prunePath(item.Value, steps[1:])
+			if err != nil {
+				return nil, err
+			}
+			return sops.TreeBranch{{Key: item.Key, Value: child}}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: key %q not found", ErrPathNotFound, step.Key)
+}
+
+// isCommentNode reports whether an array element is a comment, either
+// already decrypted or still in its encrypted ENC[...,type:comment]
+// form. The type tag sits outside the ciphertext, so no key is needed.
+func isCommentNode(v any) bool {
+	switch c := v.(type) {
+	case sops.Comment:
+		return true
+	case string:
+		return strings.HasPrefix(c, "ENC[") && strings.HasSuffix(c, ",type:comment]")
+	default:
+		return false
+	}
+}
+
+// plainValue converts a decrypted sops node into plain Go values.
+// Comments are dropped and non-string keys are rendered with %v.
+func plainValue(node any) any {
+	switch n := node.(type) {
+	case sops.TreeBranch:
+		out := make(map[string]any, len(n))
+		for _, item := range n {
+			if _, isComment := item.Key.(sops.Comment); isComment {
+				continue
+			}
+			key, ok := item.Key.(string)
+			if !ok {
+				key = fmt.Sprint(item.Key)
+			}
+			out[key] = plainValue(item.Value)
+		}
+		return out
+	case []any:
+		out := make([]any, 0, len(n))
+		for _, v := range n {
+			if _, isComment := v.(sops.Comment); isComment {
+				continue
+			}
+			out = append(out, plainValue(v))
+		}
+		return out
+	default:
+		return n
+	}
+}
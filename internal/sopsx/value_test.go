@@ -0,0 +1,68 @@
+package sopsx_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/dcadolph/cipher/internal/sopsx"
+)
+
+// TestDecryptValue selects nodes out of an encrypted YAML file and
+// checks the returned plain values and error paths.
+func TestDecryptValue(t *testing.T) {
+	groups := ageGroups(t, ageRecipient(t))
+	plain := "db:\n  user: app\n  password: hunter2\nhosts:\n  # primary first\n  - a\n  - b\n"
+	enc, err := sopsx.Encrypt(sopsx.EncryptInput{Path: "s.yaml", Data: []byte(plain), KeyGroups: groups})
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	tests := []struct {
+		Name    string
+		Steps   []sopsx.PathStep
+		Want    any
+		WantErr error
+	}{
+		// Test 1: Nested scalar.
+		{Name: "scalar", Steps: []sopsx.PathStep{{Key: "db"}, {Key: "password"}}, Want: "hunter2"},
+		// Test 2: Subtree becomes a plain map.
+		{Name: "subtree", Steps: []sopsx.PathStep{{Key: "db"}},
+			Want: map[string]any{"user": "app", "password": "hunter2"}},
+		// Test 3: Array index skips the encrypted comment element.
+		{Name: "index", Steps: []sopsx.PathStep{{Key: "hosts"}, {Index: 1, IsIndex: true}}, Want: "b"},
+		// Test 4: Missing key.
+		{Name: "missing", Steps: []sopsx.PathStep{{Key: "nope"}}, WantErr: sopsx.ErrPathNotFound},
+		// Test 5: Index into a map.
+		{Name: "index into map", Steps: []sopsx.PathStep{{Index: 0, IsIndex: true}}, WantErr: sopsx.ErrPathNotFound},
+		// Test 6: Empty path.
+		{Name: "empty", WantErr: sopsx.ErrPathNotFound},
+	}
+	for testNum, tt := range tests {
+		t.Run(fmt.Sprintf("test %d", testNum+1), func(t *testing.T) {
+			got, err := sopsx.DecryptValue(context.Background(), sopsx.DecryptValueInput{Path: "s.yaml", Data: enc, Steps: tt.Steps})
+			if tt.WantErr != nil {
+				if !errors.Is(err, tt.WantErr) {
+					t.Fatalf("%s: err = %v, want %v", tt.Name, err, tt.WantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", tt.Name, err)
+			}
+			if diff := cmp.Diff(tt.Want, got); diff != "" {
+				t.Errorf("%s mismatch (-want +got):\n%s", tt.Name, diff)
+			}
+		})
+	}
+
+	_, err = sopsx.DecryptValue(context.Background(), sopsx.DecryptValueInput{
+		Path: "s.yaml", Data: []byte(plain), Steps: []sopsx.PathStep{{Key: "db"}},
+	})
+	if !errors.Is(err, sopsx.ErrNotEncrypted) {
+		t.Errorf("plain input err = %v, want ErrNotEncrypted", err)
+	}
+}
@@ -0,0 +1,96 @@
+package sopsx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/aes"
+	"github.com/getsops/sops/v3/keyservice"
+)
+
+// ErrMACMismatch signals that a file failed its integrity check: the
+// stored MAC does not match the values, cannot be decrypted, or a
+// value did not authenticate under the data key.
+var ErrMACMismatch = errors.New("sopsx: MAC mismatch")
+
+// VerifyInput holds inputs for checking a file's integrity.
+type VerifyInput struct {
+	// Path is the file path used to derive Format when Format is zero.
+	Path string
+	// Data is the encrypted file content.
+	Data []byte
+	// Format is the sops format. If zero, it is derived from Path.
+	Format Format
+	// KeyServices are the key services used to unwrap data keys. If empty,
+	// a single local key service is used.
+	KeyServices []keyservice.KeyServiceClient
+	// DecryptionOrder is the order in which decryption methods are tried.
+	// If empty, sops.DefaultDecryptionOrder is used.
+	DecryptionOrder []string
+	// Cipher is the sops cipher. If nil, aes.NewCipher() is used.
+	Cipher sops.Cipher
+	// MaxCiphertextBytes is the maximum allowed input size in bytes.
+	// Zero means no limit.
+	MaxCiphertextBytes int
+}
+
+// Verify unwraps the data key of in.Data and checks every value and
+// the file MAC, without emitting plaintext. The MAC is computed over
+// the decrypted values, so they are held in the in-memory tree until
+// Verify returns; nothing is serialized.
+//
+// Returns nil for an intact file, ErrNotEncrypted for plain input,
+// ErrMACMismatch when integrity fails, and another error when the data
+// key cannot be unwrapped or the file cannot be parsed.
+func Verify(ctx context.Context, in VerifyInput) error {
+	if in.MaxCiphertextBytes > 0 && len(in.Data) > in.MaxCiphertextBytes {
+		return ErrTooLarge
+	}
+	if in.Format == 0 && in.Path != "" {
+		in.Format = FormatForPath(in.Path)
+	}
+	if !IsEncrypted(in.Data, in.Format) {
+		return ErrNotEncrypted
+	}
+
+	cipher := in.Cipher
+	if cipher == nil {
+		cipher = aes.NewCipher()
+	}
+	services := in.KeyServices
+	if len(services) == 0 {
+		services = []keyservice.KeyServiceClient{keyservice.NewLocalClient()}
+	}
+	order := in.DecryptionOrder
+	if len(order) == 0 {
+		order = sops.DefaultDecryptionOrder
+	}
+
+	store := StoreFor(in.Format)
+	tree, err := store.LoadEncryptedFile(in.Data)
+	if err != nil {
+		return fmt.Errorf("sopsx: load encrypted: %w", err)
+	}
+	dataKey, _, err := UnwrapDataKey(ctx, &tree.Metadata, services, order)
+	if err != nil {
+		return err
+	}
+	defer clear(dataKey)
+
+	computed, err := tree.Decrypt(dataKey, cipher)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMACMismatch, err)
+	}
+	stored, err := cipher.Decrypt(tree.Metadata.MessageAuthenticationCode, dataKey,
+		tree.Metadata.LastModified.Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("%w: cannot decrypt MAC: %w", ErrMACMismatch, err)
+	}
+	if stored != computed {
+		return fmt.Errorf("%w: file has %v, computed %s", ErrMACMismatch, stored, computed)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package sopsx_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dcadolph/cipher/internal/sopsx"
+)
+
+// TestVerify checks intact, plain, and tampered inputs.
+func TestVerify(t *testing.T) {
+	groups := ageGroups(t, ageRecipient(t))
+	plain := "db:\n  user: app\n  password: hunter2\n"
+	enc, err := sopsx.Encrypt(sopsx.EncryptInput{Path: "s.yaml", Data: []byte(plain), KeyGroups: groups})
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	userLine := encLine(t, enc, "user:")
+	passLine := encLine(t, enc, "password:")
+	userVal := strings.TrimPrefix(strings.TrimSpace(userLine), "user: ")
+	passVal := strings.TrimPrefix(strings.TrimSpace(passLine), "password: ")
+	swapped := strings.NewReplacer(userVal, passVal, passVal, userVal).Replace(string(enc))
+	modLine := encLine(t, enc, "lastmodified:")
+	restamped := strings.Replace(string(enc), modLine, `    lastmodified: "2001-01-01T00:00:00Z"`, 1)
+
+	tests := []struct {
+		Name    string
+		Data    string
+		Max     int
+		WantErr error
+	}{{ // Test 0: Untouched ciphertext verifies.
+		Name: "intact", Data: string(enc),
+	}, { // Test 1: Plain input is not encrypted.
+		Name: "plain", Data: plain, WantErr: sopsx.ErrNotEncrypted,
+	}, { // Test 2: Values moved between keys fail authentication.
+		Name: "swapped values", Data: swapped, WantErr: sopsx.ErrMACMismatch,
+	}, { // Test 3: A changed timestamp breaks the MAC's AAD.
+		Name: "lastmodified", Data: restamped, WantErr: sopsx.ErrMACMismatch,
+	}, { // Test 4: Size limit applies before parsing.
+		Name: "too large", Data: string(enc), Max: 10, WantErr: sopsx.ErrTooLarge,
+	}}
+
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			err := sopsx.Verify(context.Background(), sopsx.VerifyInput{
+				Path: "s.yaml", Data: []byte(test.Data), MaxCiphertextBytes: test.Max,
+			})
+			if !errors.Is(err, test.WantErr) || (test.WantErr == nil && err != nil) {
+				t.Fatalf("Verify err = %v, want %v", err, test.WantErr)
+			}
+		})
+	}
+}
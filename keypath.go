@@ -0,0 +1,97 @@
+package cipher
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dcadolph/cipher/internal/sopsx"
+)
+
+// PathStep is one step of a KeyPath: a map key or an array index.
+type PathStep = sopsx.PathStep
+
+// KeyPath addresses one node in a structured (YAML, JSON, or TOML) secret
+// file. Build one with ParseKeyPath.
+type KeyPath []PathStep
+
+// ParseKeyPath parses a sops-style path expression such as
+// `["db"]["password"]` or `["hosts"][0]` into ordered steps. Keys use
+// single or double quotes; indexes are bare integers. Returns an error
+// wrapping ErrKeyPath for malformed or empty expressions.
+func ParseKeyPath(expr string) (KeyPath, error) {
+	var steps KeyPath
+	pos, end := 0, len(expr)
+	for pos < end {
+		for pos < end && expr[pos] == ' ' {
+			pos++
+		}
+		if pos >= end {
+			break
+		}
+		if expr[pos] != '[' {
+			return nil, fmt.Errorf("%w: expected '[' at position %d in %q", ErrKeyPath, pos, expr)
+		}
+		pos++
+		if pos >= end {
+			return nil, fmt.Errorf("%w: unterminated group in %q", ErrKeyPath, expr)
+		}
+
+		if quote := expr[pos]; quote == '"' || quote == '\'' {
+			pos++
+			start := pos
+			for pos < end && expr[pos] != quote {
+				pos++
+			}
+			if pos >= end {
+				return nil, fmt.Errorf("%w: unterminated string in %q", ErrKeyPath, expr)
+			}
+			key := expr[start:pos]
+			pos++
+			if pos >= end || expr[pos] != ']' {
+				return nil, fmt.Errorf("%w: expected ']' after key in %q", ErrKeyPath, expr)
+			}
+			pos++
+			steps = append(steps, PathStep{Key: key})
+			continue
+		}
+
+		start := pos
+		for pos < end && expr[pos] != ']' {
+			pos++
+		}
+		if pos >= end {
+			return nil, fmt.Errorf("%w: unterminated index in %q", ErrKeyPath, expr)
+		}
+		raw := strings.TrimSpace(expr[start:pos])
+		idx, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad index %q in %q", ErrKeyPath, raw, expr)
+		}
+		pos++
+		steps = append(steps, PathStep{Index: idx, IsIndex: true})
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("%w: empty path %q", ErrKeyPath, expr)
+	}
+	return steps, nil
+}
+
+// String renders p in the form ParseKeyPath accepts. Keys are
+// double-quoted unless they contain a double quote. The syntax has no
+// escapes, so a key holding both quote characters does not round-trip.
+func (p KeyPath) String() string {
+	var b strings.Builder
+	for _, step := range p {
+		if step.IsIndex {
+			fmt.Fprintf(&b, "[%d]", step.Index)
+			continue
+		}
+		quote := `"`
+		if strings.Contains(step.Key, `"`) {
+			quote = "'"
+		}
+		b.WriteString("[" + quote + step.Key + quote + "]")
+	}
+	return b.String()
+}
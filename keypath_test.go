@@ -0,0 +1,66 @@
+package cipher_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/dcadolph/cipher"
+)
+
+// TestParseKeyPath covers key and index steps, quote forms, spacing,
+// and the malformed-expression error paths.
+func TestParseKeyPath(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		Name      string
+		Expr      string
+		WantSteps cipher.KeyPath
+		WantErr   bool
+	}{{ // Test 0: Two double-quoted keys.
+		Name: "two keys", Expr: `["db"]["password"]`,
+		WantSteps: cipher.KeyPath{{Key: "db"}, {Key: "password"}},
+	}, { // Test 1: Key then index.
+		Name: "key index", Expr: `["hosts"][0]`,
+		WantSteps: cipher.KeyPath{{Key: "hosts"}, {Index: 0, IsIndex: true}},
+	}, { // Test 2: Single quotes and spacing between groups.
+		Name: "single quote", Expr: `['a'] ['b']`,
+		WantSteps: cipher.KeyPath{{Key: "a"}, {Key: "b"}},
+	}, { // Test 3: Empty expression is an error.
+		Name: "empty", Expr: "", WantErr: true,
+	}, { // Test 4: Missing opening bracket.
+		Name: "no bracket", Expr: `"a"`, WantErr: true,
+	}, { // Test 5: Unterminated string.
+		Name: "unterminated", Expr: `["a`, WantErr: true,
+	}, { // Test 6: Non-numeric index.
+		Name: "bad index", Expr: `[x]`, WantErr: true,
+	}}
+
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			t.Parallel()
+			got, err := cipher.ParseKeyPath(test.Expr)
+			if test.WantErr {
+				if !errors.Is(err, cipher.ErrKeyPath) {
+					t.Fatalf("err = %v, want ErrKeyPath (steps %+v)", err, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(test.WantSteps, got); diff != "" {
+				t.Errorf("steps mismatch (-want +got):\n%s", diff)
+			}
+			again, err := cipher.ParseKeyPath(got.String())
+			if err != nil {
+				t.Fatalf("reparse %q: %v", got.String(), err)
+			}
+			if diff := cmp.Diff(got, again); diff != "" {
+				t.Errorf("String round trip mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
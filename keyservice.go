@@ -0,0 +1,123 @@
+package cipher
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/getsops/sops/v3/keyservice"
+)
+
+// Wrapper wraps and unwraps sops data keys for the master keys recorded
+// in a file's metadata. key is the sops key-service description of one
+// master key: match on key.GetKeyType() (for example
+// *keyservice.Key_KmsKey or *keyservice.Key_AgeKey) to pick a backend.
+// Return an error wrapping ErrUnsupportedKey for keys the Wrapper does
+// not serve.
+type Wrapper interface {
+	// Wrap encrypts dataKey for key and returns the ciphertext stored
+	// in the file's metadata.
+	Wrap(ctx context.Context, key *keyservice.Key, dataKey []byte) ([]byte, error)
+	// Unwrap decrypts ciphertext previously produced by Wrap for key.
+	Unwrap(ctx context.Context, key *keyservice.Key, ciphertext []byte) ([]byte, error)
+}
+
+// WrapperFuncs adapts a pair of plain functions to Wrapper. A nil
+// function reports ErrUnsupportedKey, so an unwrap-only or wrap-only
+// service needs just one field.
+type WrapperFuncs struct {
+	// WrapFunc implements Wrapper.Wrap.
+	WrapFunc func(ctx context.Context, key *keyservice.Key, dataKey []byte) ([]byte, error)
+	// UnwrapFunc implements Wrapper.Unwrap.
+	UnwrapFunc func(ctx context.Context, key *keyservice.Key, ciphertext []byte) ([]byte, error)
+}
+
+// Wrap calls f.WrapFunc.
+func (f WrapperFuncs) Wrap(ctx context.Context, key *keyservice.Key, dataKey []byte) ([]byte, error) {
+	if f.WrapFunc == nil {
+		return nil, fmt.Errorf("%w: wrap %s", ErrUnsupportedKey, keyServiceKeyString(key))
+	}
+	return f.WrapFunc(ctx, key, dataKey)
+}
+
+// Unwrap calls f.UnwrapFunc.
+func (f WrapperFuncs) Unwrap(ctx context.Context, key *keyservice.Key, ciphertext []byte) ([]byte, error) {
+	if f.UnwrapFunc == nil {
+		return nil, fmt.Errorf("%w: unwrap %s", ErrUnsupportedKey, keyServiceKeyString(key))
+	}
+	return f.UnwrapFunc(ctx, key, ciphertext)
+}
+
+// NewKeyService returns a sops key service client that answers Encrypt
+// and Decrypt calls in process through w, with no gRPC server. Pass it
+// in EncoderOptions.KeyServices or DecoderOptions.KeyServices to plug
+// in a custom KMS, an HSM shim, or a test double. Combine it with
+// keyservice.NewLocalClient() to keep the built-in backends for keys w
+// does not serve. Panics if w is nil.
+func NewKeyService(w Wrapper) keyservice.KeyServiceClient {
+	return keyservice.NewCustomLocalClient(NewKeyServiceServer(w))
+}
+
+// NewKeyServiceServer returns the keyservice.KeyServiceServer behind
+// NewKeyService, for callers that register it on their own gRPC
+// server. Panics if w is nil.
+func NewKeyServiceServer(w Wrapper) keyservice.KeyServiceServer {
+	if w == nil {
+		panic("cipher: NewKeyServiceServer: Wrapper required")
+	}
+	return wrapperServer{w: w}
+}
+
+// wrapperServer adapts a Wrapper to keyservice.KeyServiceServer.
+type wrapperServer struct {
+	w Wrapper
+}
+
+// Encrypt implements keyservice.KeyServiceServer.
+func (s wrapperServer) Encrypt(
+	ctx context.Context, req *keyservice.EncryptRequest,
+) (*keyservice.EncryptResponse, error) {
+	if req.GetKey().GetKeyType() == nil {
+		return nil, fmt.Errorf("%w: missing key", ErrUnsupportedKey)
+	}
+	out, err := s.w.Wrap(ctx, req.Key, req.Plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return &keyservice.EncryptResponse{Ciphertext: out}, nil
+}
+
+// Decrypt implements keyservice.KeyServiceServer.
+func (s wrapperServer) Decrypt(
+	ctx context.Context, req *keyservice.DecryptRequest,
+) (*keyservice.DecryptResponse, error) {
+	if req.GetKey().GetKeyType() == nil {
+		return nil, fmt.Errorf("%w: missing key", ErrUnsupportedKey)
+	}
+	out, err := s.w.Unwrap(ctx, req.Key, req.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return &keyservice.DecryptResponse{Plaintext: out}, nil
+}
+
+// keyServiceKeyString renders key as "type:identifier" for errors.
+func keyServiceKeyString(key *keyservice.Key) string {
+	switch k := key.GetKeyType().(type) {
+	case *keyservice.Key_AgeKey:
+		return "age:" + k.AgeKey.GetRecipient()
+	case *keyservice.Key_PgpKey:
+		return "pgp:" + k.PgpKey.GetFingerprint()
+	case *keyservice.Key_KmsKey:
+		return "kms:" + k.KmsKey.GetArn()
+	case *keyservice.Key_GcpKmsKey:
+		return "gcp_kms:" + k.GcpKmsKey.GetResourceId()
+	case *keyservice.Key_AzureKeyvaultKey:
+		return "azure_kv:" + k.AzureKeyvaultKey.GetVaultUrl() + "/" + k.AzureKeyvaultKey.GetName()
+	case *keyservice.Key_VaultKey:
+		return "hc_vault:" + k.VaultKey.GetVaultAddress() + "/" + k.VaultKey.GetKeyName()
+	case *keyservice.Key_HckmsKey:
+		return "hckms:" + k.HckmsKey.GetKeyId()
+	default:
+		return "unknown"
+	}
+}
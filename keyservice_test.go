@@ -0,0 +1,108 @@
+package cipher_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/getsops/sops/v3/keyservice"
+
+	"github.com/dcadolph/cipher"
+	cipherage "github.com/dcadolph/cipher/age"
+)
+
+// xorWrapper is a test double that "wraps" data keys by XOR with a
+// fixed byte and records the key types it saw.
+type xorWrapper struct {
+	seen []string
+}
+
+func (w *xorWrapper) Wrap(_ context.Context, key *keyservice.Key, dataKey []byte) ([]byte, error) {
+	w.seen = append(w.seen, fmt.Sprintf("wrap %T", key.GetKeyType()))
+	return xor(dataKey), nil
+}
+
+func (w *xorWrapper) Unwrap(_ context.Context, key *keyservice.Key, ciphertext []byte) ([]byte, error) {
+	w.seen = append(w.seen, fmt.Sprintf("unwrap %T", key.GetKeyType()))
+	return xor(ciphertext), nil
+}
+
+func xor(in []byte) []byte {
+	out := make([]byte, len(in))
+	for i, b := range in {
+		out[i] = b ^ 0x5a
+	}
+	return out
+}
+
+// TestNewKeyServiceRoundTrip encrypts and decrypts through a Wrapper
+// and checks that the Wrapper, not the local age service, handled the
+// data key.
+func TestNewKeyServiceRoundTrip(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generate identity: %v", err)
+	}
+	w := &xorWrapper{}
+	ks := []keyservice.KeyServiceClient{cipher.NewKeyService(w)}
+
+	enc := cipher.NewEncoderWith(cipherage.MustNewProvider(id.Recipient().String()),
+		cipher.EncoderOptions{KeyServices: ks})
+	data, err := enc.Encode(ctx, "s.yaml", []byte("k: v\n"))
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	got, err := cipher.NewDecoderWith(cipher.DecoderOptions{KeyServices: ks}).Decode(ctx, "s.yaml", data)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if string(got) != "k: v\n" {
+		t.Errorf("got %q", got)
+	}
+	want := []string{"wrap *keyservice.Key_AgeKey", "unwrap *keyservice.Key_AgeKey"}
+	if fmt.Sprint(w.seen) != fmt.Sprint(want) {
+		t.Errorf("seen = %v, want %v", w.seen, want)
+	}
+
+	// The real age identity cannot open a key the Wrapper produced.
+	_, err = cipher.NewDecoderWith(cipher.DecoderOptions{AgeIdentities: []string{id.String()}}).
+		Decode(ctx, "s.yaml", data)
+	if !errors.Is(err, cipher.ErrDecode) {
+		t.Errorf("age decode err = %v, want ErrDecode", err)
+	}
+}
+
+// TestWrapperFuncs covers the function adapter, including nil fields.
+func TestWrapperFuncs(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	key := &keyservice.Key{KeyType: &keyservice.Key_KmsKey{KmsKey: &keyservice.KmsKey{Arn: "arn:x"}}}
+	srv := cipher.NewKeyServiceServer(cipher.WrapperFuncs{
+		UnwrapFunc: func(_ context.Context, _ *keyservice.Key, c []byte) ([]byte, error) {
+			return bytes.ToUpper(c), nil
+		},
+	})
+
+	rsp, err := srv.Decrypt(ctx, &keyservice.DecryptRequest{Key: key, Ciphertext: []byte("abc")})
+	if err != nil || string(rsp.Plaintext) != "ABC" {
+		t.Fatalf("Decrypt = %v, %v", rsp, err)
+	}
+	if _, err := srv.Encrypt(ctx, &keyservice.EncryptRequest{Key: key}); !errors.Is(err, cipher.ErrUnsupportedKey) {
+		t.Errorf("nil WrapFunc err = %v, want ErrUnsupportedKey", err)
+	}
+	if _, err := srv.Decrypt(ctx, &keyservice.DecryptRequest{}); !errors.Is(err, cipher.ErrUnsupportedKey) {
+		t.Errorf("missing key err = %v, want ErrUnsupportedKey", err)
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewKeyService(nil) did not panic")
+		}
+	}()
+	cipher.NewKeyService(nil)
+}
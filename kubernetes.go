@@ -0,0 +1,85 @@
+package cipher
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+
+	yaml "go.yaml.in/yaml/v3"
+)
+
+// KubernetesEncryptedRegex is the EncryptedRegex that
+// EncoderOptions.Kubernetes applies to Secret and ConfigMap manifests.
+// Only the values under data and stringData are encrypted; apiVersion,
+// kind, and metadata stay readable for kubectl and review.
+const KubernetesEncryptedRegex = "^(data|stringData)$"
+
+// kubernetesHeader is the part of a manifest that identifies its kind.
+type kubernetesHeader struct {
+	APIVersion string `json:"apiVersion" yaml:"apiVersion"`
+	Kind       string `json:"kind" yaml:"kind"`
+}
+
+// isSecretOrConfigMap reports whether h is a core v1 Secret or
+// ConfigMap.
+func (h kubernetesHeader) isSecretOrConfigMap() bool {
+	return h.APIVersion == "v1" && (h.Kind == "Secret" || h.Kind == "ConfigMap")
+}
+
+// isKubernetesManifest reports whether every document in data is a
+// Secret or ConfigMap. YAML input may hold several documents; empty
+// ones are ignored, and any other kind, such as a Deployment, makes
+// the whole file not a manifest so none of its values go out in
+// plaintext. JSON input is a single object. Input that does not parse
+// is not a manifest, so sops reports the parse error as it would
+// otherwise.
+func isKubernetesManifest(data []byte, format Format) bool {
+	switch format {
+	case FormatJSON:
+		var h kubernetesHeader
+		return json.Unmarshal(data, &h) == nil && h.isSecretOrConfigMap()
+	case FormatYAML:
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		found := false
+		for {
+			var doc yaml.Node
+			err := dec.Decode(&doc)
+			if errors.Is(err, io.EOF) {
+				return found
+			}
+			if err != nil {
+				return false
+			}
+			if len(doc.Content) == 1 && doc.Content[0].Tag == "!!null" {
+				continue
+			}
+			var h kubernetesHeader
+			if doc.Decode(&h) != nil || !h.isSecretOrConfigMap() {
+				return false
+			}
+			found = true
+		}
+	default:
+		return false
+	}
+}
+
+// kubernetesRegex returns the EncryptedRegex to use for data under
+// opts. It is KubernetesEncryptedRegex when Kubernetes mode is on, no
+// key filter is set, and every document in data is a Secret or
+// ConfigMap; otherwise it is opts.EncryptedRegex unchanged.
+func kubernetesRegex(opts EncoderOptions, path string, data []byte) string {
+	if !opts.Kubernetes || opts.EncryptedRegex != "" || opts.UnencryptedRegex != "" ||
+		opts.EncryptedSuffix != "" || opts.UnencryptedSuffix != "" {
+		return opts.EncryptedRegex
+	}
+	format := opts.Format
+	if format == 0 {
+		format = FormatForPath(path)
+	}
+	if !isKubernetesManifest(data, format) {
+		return opts.EncryptedRegex
+	}
+	return KubernetesEncryptedRegex
+}
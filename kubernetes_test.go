@@ -0,0 +1,107 @@
+package cipher_test
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/dcadolph/cipher"
+	cipherage "github.com/dcadolph/cipher/age"
+)
+
+// TestEncodeKubernetes covers Kubernetes mode across single and
+// multi-document manifests, JSON, non-manifests, and explicit filters.
+func TestEncodeKubernetes(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	id := mustAgeIdentity(t)
+	kp := cipherage.MustNewProvider(id.Recipient().String())
+	secret := "apiVersion: v1\nkind: Secret\nmetadata:\n  name: db\nstringData:\n  password: hunter2\n"
+	configMap := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cfg\ndata:\n  mode: prod\n"
+	deploy := "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\nspec:\n  token: hunter2\n"
+
+	tests := []struct {
+		Name      string
+		Path      string
+		In        string
+		Opts      cipher.EncoderOptions
+		WantRegex string
+		WantPlain []string
+	}{{ // Test 0: Secret keeps its header readable.
+		Name: "secret", Path: "secret.yaml", In: secret,
+		Opts:      cipher.EncoderOptions{Kubernetes: true},
+		WantRegex: cipher.KubernetesEncryptedRegex,
+		WantPlain: []string{"kind: Secret", "name: db"},
+	}, { // Test 1: Secret and ConfigMap in a multi-document file.
+		Name: "multi-document", Path: "app.yaml", In: configMap + "---\n" + secret,
+		Opts:      cipher.EncoderOptions{Kubernetes: true},
+		WantRegex: cipher.KubernetesEncryptedRegex,
+		WantPlain: []string{"kind: ConfigMap", "kind: Secret"},
+	}, { // Test 2: A Deployment beside a Secret encrypts every value.
+		Name: "mixed kinds", Path: "app.yaml", In: deploy + "---\n" + secret,
+		Opts: cipher.EncoderOptions{Kubernetes: true},
+	}, { // Test 3: JSON ConfigMap.
+		Name: "json configmap", Path: "cm.json",
+		In:        `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cfg"},"data":{"k":"hunter2"}}`,
+		Opts:      cipher.EncoderOptions{Kubernetes: true},
+		WantRegex: cipher.KubernetesEncryptedRegex,
+		WantPlain: []string{`"kind": "ConfigMap"`},
+	}, { // Test 4: Non-manifest encrypts every value.
+		Name: "not a manifest", Path: "s.yaml", In: "kind: Secret\npassword: hunter2\n",
+		Opts: cipher.EncoderOptions{Kubernetes: true},
+	}, { // Test 5: Mode off encrypts the whole Secret.
+		Name: "mode off", Path: "secret.yaml", In: secret,
+	}, { // Test 6: An explicit regex wins over Kubernetes mode.
+		Name: "explicit regex", Path: "secret.yaml", In: secret,
+		Opts:      cipher.EncoderOptions{Kubernetes: true, EncryptedRegex: "^password$"},
+		WantRegex: "^password$",
+		WantPlain: []string{"kind: Secret"},
+	}}
+
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			t.Parallel()
+			out, err := cipher.NewEncoderWith(kp, test.Opts).Encode(ctx, test.Path, []byte(test.In))
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			if strings.Contains(string(out), "hunter2") {
+				t.Errorf("secret value left in plaintext:\n%s", out)
+			}
+			for _, want := range test.WantPlain {
+				if !strings.Contains(string(out), want) {
+					t.Errorf("output missing %q:\n%s", want, out)
+				}
+			}
+			info, err := cipher.InspectPath(test.Path, out)
+			if err != nil {
+				t.Fatalf("InspectPath: %v", err)
+			}
+			if info.EncryptedRegex != test.WantRegex {
+				t.Errorf("EncryptedRegex = %q, want %q", info.EncryptedRegex, test.WantRegex)
+			}
+		})
+	}
+}
+
+// TestRoutedEncoderKubernetesRule selects Kubernetes mode per rule.
+func TestRoutedEncoderKubernetesRule(t *testing.T) {
+	t.Parallel()
+	id := mustAgeIdentity(t)
+	router := cipher.NewRouter(cipher.Rule{
+		Match:    cipher.MatchRegex(regexp.MustCompile(`^k8s/.*\.yaml$`)),
+		Provider: cipherage.MustNewProvider(id.Recipient().String()),
+		Options:  cipher.EncoderOptions{Kubernetes: true},
+	})
+	enc := cipher.NewRoutedEncoder(router, cipher.EncoderOptions{})
+	in := "apiVersion: v1\nkind: Secret\nmetadata:\n  name: db\ndata:\n  password: aHVudGVyMg==\n"
+	out, err := enc.Encode(context.Background(), "k8s/db.yaml", []byte(in))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if !strings.Contains(string(out), "name: db") || strings.Contains(string(out), "aHVudGVyMg==") {
+		t.Errorf("rule did not apply Kubernetes mode:\n%s", out)
+	}
+}
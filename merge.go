@@ -0,0 +1,81 @@
+package cipher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dcadolph/cipher/internal/sopsx"
+)
+
+// MergeEncrypted performs a three-way merge of an encrypted file, as a
+// git merge driver does: base is the common ancestor, ours the current
+// branch, and theirs the branch being merged. All three are decrypted
+// and their trees merged key by key, so edits to different keys, or the
+// same edit on both sides, merge cleanly even though the MAC and ENC[...]
+// lines conflict textually. The result is encrypted under the data key
+// and recipients of ours, and values unchanged from ours keep their
+// ciphertext.
+//
+// Maps merge recursively; arrays and scalars are compared whole, and
+// comments come from ours. An empty base means the file was added on
+// both sides. The format is detected from ours.
+//
+// When a value changed differently on both sides, MergeEncrypted
+// returns plaintext with <<<<<<< ours / ======= / >>>>>>> theirs markers
+// around the conflicting lines, along with an error wrapping
+// ErrMergeConflict. That output is not encrypted; resolve it and
+// encrypt it again. The caller needs an identity for every input.
+func MergeEncrypted(ctx context.Context, base, ours, theirs []byte) ([]byte, error) {
+	return MergeEncryptedWith(ctx, base, ours, theirs, DecoderOptions{})
+}
+
+// MergeEncryptedWith is MergeEncrypted with explicit options. Format,
+// when set, skips detection. Key services, identities, decryption
+// order, IgnoreMAC, cipher, and MaxCiphertextBytes apply to all three
+// inputs; the callbacks do not.
+func MergeEncryptedWith(
+	ctx context.Context, base, ours, theirs []byte, opts DecoderOptions,
+) (_ []byte, err error) {
+	defer wrapError(&err, "merge", "", opts.Format)
+	log := opts.Logger
+	if log == nil {
+		log = NopLogger
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	services, err := identityKeyServices(opts.KeyServices, opts.AgeIdentities, opts.PGPPrivateKeys)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+
+	log.Debugf("cipher.MergeEncrypted start: base=%d ours=%d theirs=%d", len(base), len(ours), len(theirs))
+	out, conflicts, err := sopsx.Merge(ctx, sopsx.MergeInput{
+		Base:               base,
+		Ours:               ours,
+		Theirs:             theirs,
+		Format:             opts.Format,
+		KeyServices:        services,
+		DecryptionOrder:    opts.DecryptionOrder,
+		IgnoreMAC:          opts.IgnoreMAC,
+		Cipher:             opts.Cipher,
+		MaxCiphertextBytes: opts.MaxCiphertextBytes,
+	})
+	if ue := asUnwrapError("", err); ue != nil {
+		return nil, ue
+	}
+	switch {
+	case errors.Is(err, sopsx.ErrNotEncrypted):
+		return nil, ErrNotEncrypted
+	case errors.Is(err, sopsx.ErrTooLarge):
+		return nil, ErrTooLarge
+	case err != nil:
+		return nil, fmt.Errorf("%w: %w", ErrDecode, err)
+	case conflicts > 0:
+		log.Warnf("cipher.MergeEncrypted conflict: values=%d", conflicts)
+		return out, fmt.Errorf("%w: %d conflicting value(s)", ErrMergeConflict, conflicts)
+	}
+	log.Debugf("cipher.MergeEncrypted done: bytes=%d", len(out))
+	return out, nil
+}
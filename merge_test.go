@@ -0,0 +1,83 @@
+package cipher_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/dcadolph/cipher"
+	cipherage "github.com/dcadolph/cipher/age"
+)
+
+// TestMergeEncrypted merges encrypted YAML versions cleanly and with a
+// conflict.
+func TestMergeEncrypted(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	id := mustAgeIdentity(t)
+	enc := cipher.NewEncoder(cipherage.MustNewProvider(id.Recipient().String()))
+	opts := cipher.DecoderOptions{AgeIdentities: []string{id.String()}}
+	encode := func(plain string) []byte {
+		out, err := enc.Encode(ctx, "s.yaml", []byte(plain))
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		return out
+	}
+	base := encode("user: app\npass: x\n")
+
+	tests := []struct {
+		Name      string
+		Ours      string
+		Theirs    string
+		Want      string
+		WantError error
+	}{{ // Test 0: Edits to different keys merge and stay encrypted.
+		Name: "clean", Ours: "user: admin\npass: x\n", Theirs: "user: app\npass: z\n",
+		Want: "user: admin\npass: z\n",
+	}, { // Test 1: Both sides changed the same key.
+		Name: "conflict", Ours: "user: admin\npass: x\n", Theirs: "user: root\npass: x\n",
+		Want:      "<<<<<<< ours\nuser: admin\n=======\nuser: root\n>>>>>>> theirs\npass: x\n",
+		WantError: cipher.ErrMergeConflict,
+	}}
+
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			t.Parallel()
+			out, err := cipher.MergeEncryptedWith(ctx, base, encode(test.Ours), encode(test.Theirs), opts)
+			if !errors.Is(err, test.WantError) {
+				t.Fatalf("MergeEncryptedWith err = %v, want %v", err, test.WantError)
+			}
+			got := out
+			if test.WantError != nil {
+				if k := cipher.KindOf(err); k != cipher.KindConflict {
+					t.Errorf("KindOf = %v, want conflict", k)
+				}
+			} else {
+				if strings.Contains(string(out), "admin") {
+					t.Fatalf("merged output holds plaintext:\n%s", out)
+				}
+				got, err = cipher.NewDecoderWith(opts).Decode(ctx, "s.yaml", out)
+				if err != nil {
+					t.Fatalf("Decode merged: %v", err)
+				}
+			}
+			if diff := cmp.Diff(test.Want, string(got)); diff != "" {
+				t.Errorf("merged mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestMergeEncryptedNotEncrypted rejects plain input.
+func TestMergeEncryptedNotEncrypted(t *testing.T) {
+	t.Parallel()
+	_, err := cipher.MergeEncrypted(context.Background(), nil, []byte("a: 1\n"), []byte("a: 2\n"))
+	if !errors.Is(err, cipher.ErrNotEncrypted) {
+		t.Fatalf("err = %v, want ErrNotEncrypted", err)
+	}
+}
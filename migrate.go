@@ -0,0 +1,297 @@
+package cipher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/keys"
+	"github.com/getsops/sops/v3/keyservice"
+	"github.com/spf13/afero"
+)
+
+// ErrNothingToMigrate is reported to WalkOptions.OnSkip by MigrateWalk
+// for an encrypted file that has none of the source recipients.
+var ErrNothingToMigrate = errors.New("no source recipients to migrate")
+
+// MigrateOptions tunes MigrateWalkWith.
+type MigrateOptions struct {
+	// Walk tunes the walk. OnFile is called for every file rewritten and
+	// OnSkip for every file left alone; OnFile is not called on a dry run.
+	Walk WalkOptions
+	// DryRun, when true, plans every file from its metadata and writes
+	// nothing. No data key is unwrapped, so no identity is needed.
+	// Walk.DryRun has the same effect; the plan is the report, and
+	// Walk.OnPlan is not called.
+	DryRun bool
+	// KeyServices overrides the default local key service used to
+	// unwrap each data key and wrap it for the new recipients.
+	KeyServices []keyservice.KeyServiceClient
+	// AgeIdentities are in-memory age identities, one of which must
+	// open each migrated file's data key. Ignored when DryRun is set.
+	AgeIdentities []string
+	// PGPPrivateKeys are armored OpenPGP private keys tried alongside
+	// AgeIdentities when unwrapping each file's data key.
+	PGPPrivateKeys [][]byte
+	// DecryptionOrder controls which key types are tried first when
+	// unwrapping the data key. Empty means sops.DefaultDecryptionOrder.
+	DecryptionOrder []string
+}
+
+// MigrateFile is the plan for, or outcome of, migrating one file.
+type MigrateFile struct {
+	// Path is the file path.
+	Path string `json:"path"`
+	// Add lists the target recipients the file gains.
+	Add []RecipientInfo `json:"add,omitempty"`
+	// Remove lists the source recipients the file loses.
+	Remove []RecipientInfo `json:"remove,omitempty"`
+	// Skipped, when non-empty, is why the file was left alone.
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// MigrateReport lists what MigrateWalk did, or would do on a dry run,
+// to each file the matchers accepted.
+type MigrateReport struct {
+	// DryRun reports whether the walk only planned.
+	DryRun bool `json:"dry_run"`
+	// Files holds one entry per file, sorted by path.
+	Files []MigrateFile `json:"files"`
+}
+
+// Migrated returns the number of files rewritten, or that would be
+// rewritten on a dry run.
+func (r *MigrateReport) Migrated() int {
+	n := 0
+	for _, f := range r.Files {
+		if f.Skipped == "" {
+			n++
+		}
+	}
+	return n
+}
+
+// MigrateWalk moves every matching encrypted file under root from the
+// from recipients to the recipients to yields. It is
+// MigrateWalkWith with zero options.
+func MigrateWalk(
+	ctx context.Context, files afero.Fs, root string,
+	from []RecipientInfo, to KeyProvider, matchers []FileMatcher,
+) (*MigrateReport, error) {
+	return MigrateWalkWith(ctx, files, root, from, to, matchers, MigrateOptions{})
+}
+
+// MigrateWalkWith moves every matching encrypted file under root from
+// one set of recipients to another, such as from PGP to age, without
+// re-encrypting any payload.
+//
+// Each file is planned from its metadata: files carrying none of the
+// from recipients are skipped with ErrNothingToMigrate. A from entry
+// matches a recipient by Identifier, and by Type when Type is set.
+// Every other file gets the recipients of to in each key group that
+// holds a from recipient and lacks them, and then loses the from
+// recipients. No key group is added or dropped, so a Shamir file keeps
+// its threshold. The data key is
+// unchanged, so anyone who held a removed recipient can still read
+// older copies of a file; follow up with RotateWalkWith to rotate it.
+//
+// The report lists every file the matchers accepted, including those
+// skipped. When a file fails the walk stops, as other walks do, and
+// the report lists the files handled before the failure. With
+// Walk.ContinueOnError the walk goes on, the report omits failed
+// files, and the error joins their failures.
+func MigrateWalkWith(
+	ctx context.Context, files afero.Fs, root string,
+	from []RecipientInfo, to KeyProvider, matchers []FileMatcher, opts MigrateOptions,
+) (*MigrateReport, error) {
+	if files == nil {
+		panic("cipher: MigrateWalkWith: filesystem required")
+	}
+	if to == nil {
+		panic("cipher: MigrateWalkWith: KeyProvider required")
+	}
+	if len(from) == 0 {
+		return nil, fmt.Errorf("MigrateWalk: at least one source recipient required")
+	}
+	groups, err := to.KeyGroups(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("MigrateWalk: key groups: %w", err)
+	}
+	targets := flattenKeys(groups)
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("MigrateWalk: %w", ErrNoKeyGroups)
+	}
+	for _, k := range targets {
+		if matchRecipient(from, k.TypeToIdentifier(), k.ToString()) {
+			return nil, fmt.Errorf("MigrateWalk: %s %s is both a source and a target",
+				k.TypeToIdentifier(), k.ToString())
+		}
+	}
+
+	opts.DryRun = opts.DryRun || opts.Walk.DryRun
+	report := &MigrateReport{DryRun: opts.DryRun}
+	var mu sync.Mutex
+	record := func(f MigrateFile) {
+		mu.Lock()
+		defer mu.Unlock()
+		report.Files = append(report.Files, f)
+	}
+	walk := opts.Walk
+	serializeCallbacks(&walk)
+	rec := recordWalk(&walk, "")
+	dest := newWalkDest(files, root, walk)
+	_, err = runWalk(ctx, files, root, matchers, walk, rec,
+		func(ctx context.Context, dst afero.Fs, path string, info fs.FileInfo) error {
+			data, err := afero.ReadFile(files, path)
+			if err != nil {
+				return fmt.Errorf("read %q: %w", path, err)
+			}
+			plan, add, err := planMigration(path, data, from, targets)
+			switch {
+			case errors.Is(err, ErrNotEncrypted), errors.Is(err, ErrNothingToMigrate):
+				if !opts.DryRun {
+					if err := dest.passThrough(dst, path, data, info); err != nil {
+						return err
+					}
+				}
+				plan.Skipped = err.Error()
+				record(plan)
+				notify(walk.OnSkip, path, err)
+				return nil
+			case err != nil:
+				return fmt.Errorf("migrate %q: %w", path, err)
+			}
+			if opts.DryRun {
+				record(plan)
+				return nil
+			}
+			out, err := migrateFile(ctx, path, data, plan, add, opts)
+			if err != nil {
+				return err
+			}
+			if err := dest.write(dst, path, data, out, info); err != nil {
+				return err
+			}
+			record(plan)
+			notify(walk.OnFile, path, len(out))
+			return nil
+		})
+	slices.SortFunc(report.Files, func(a, b MigrateFile) int { return strings.Compare(a.Path, b.Path) })
+	return report, err
+}
+
+// planMigration returns the plan for one file and, per key group, the
+// target keys that group lacks. Only groups losing a source recipient
+// gain targets, so each group keeps its Shamir share. It returns
+// ErrNotEncrypted or ErrNothingToMigrate for files to skip.
+func planMigration(
+	path string, data []byte, from []RecipientInfo, targets []keys.MasterKey,
+) (MigrateFile, []sops.KeyGroup, error) {
+	plan := MigrateFile{Path: path}
+	info, err := InspectPath(path, data)
+	switch {
+	case errors.Is(err, ErrNotEncrypted):
+		return plan, nil, ErrNotEncrypted
+	case err != nil:
+		return plan, nil, err
+	}
+	removed := make(map[RecipientInfo]struct{})
+	added := make(map[RecipientInfo]struct{})
+	add := make([]sops.KeyGroup, len(info.Groups))
+	for i, group := range info.Groups {
+		present := make(map[RecipientInfo]struct{}, len(group))
+		losing := false
+		for _, r := range group {
+			present[r] = struct{}{}
+			if !matchRecipient(from, r.Type, r.Identifier) {
+				continue
+			}
+			losing = true
+			if _, dup := removed[r]; !dup {
+				removed[r] = struct{}{}
+				plan.Remove = append(plan.Remove, r)
+			}
+		}
+		if !losing {
+			continue
+		}
+		for _, k := range targets {
+			r := RecipientInfo{Type: k.TypeToIdentifier(), Identifier: k.ToString()}
+			if _, ok := present[r]; ok {
+				continue
+			}
+			add[i] = append(add[i], k)
+			if _, dup := added[r]; !dup {
+				added[r] = struct{}{}
+				plan.Add = append(plan.Add, r)
+			}
+		}
+	}
+	if len(plan.Remove) == 0 {
+		return plan, nil, ErrNothingToMigrate
+	}
+	return plan, add, nil
+}
+
+// migrateFile applies plan to data: it merges add[i] into the file's
+// i-th key group with AddRecipientPerGroup, then drops plan.Remove.
+// Empty groups are kept, so the Shamir layout never changes.
+func migrateFile(
+	ctx context.Context, path string, data []byte,
+	plan MigrateFile, add []sops.KeyGroup, opts MigrateOptions,
+) ([]byte, error) {
+	out := data
+	if len(plan.Add) > 0 {
+		var err error
+		out, err = AddRecipientWith(ctx, path, out, StaticKeyProvider(add...), AddRecipientOptions{
+			Mode:            AddRecipientPerGroup,
+			KeyServices:     opts.KeyServices,
+			AgeIdentities:   opts.AgeIdentities,
+			PGPPrivateKeys:  opts.PGPPrivateKeys,
+			DecryptionOrder: opts.DecryptionOrder,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("migrate %q: %w", path, err)
+		}
+	}
+	ids := make([]string, len(plan.Remove))
+	for i, r := range plan.Remove {
+		ids[i] = r.Identifier
+	}
+	out, err := RemoveRecipientWith(path, out, ids, RemoveRecipientOptions{KeepEmptyGroups: true})
+	if err != nil {
+		return nil, fmt.Errorf("migrate %q: %w", path, err)
+	}
+	return out, nil
+}
+
+// flattenKeys returns the master keys of groups in order, dropping
+// repeats.
+func flattenKeys(groups []sops.KeyGroup) []keys.MasterKey {
+	var out []keys.MasterKey
+	seen := make(map[RecipientInfo]struct{})
+	for _, g := range groups {
+		for _, k := range g {
+			r := RecipientInfo{Type: k.TypeToIdentifier(), Identifier: k.ToString()}
+			if _, dup := seen[r]; dup {
+				continue
+			}
+			seen[r] = struct{}{}
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+// matchRecipient reports whether any entry of set matches the
+// recipient typ and id. An entry with an empty Type matches any type.
+func matchRecipient(set []RecipientInfo, typ, id string) bool {
+	return slices.ContainsFunc(set, func(r RecipientInfo) bool {
+		return r.Identifier == id && (r.Type == "" || r.Type == typ)
+	})
+}
@@ -0,0 +1,194 @@
+package cipher_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/getsops/sops/v3"
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/afero"
+
+	"github.com/dcadolph/cipher"
+	cipherage "github.com/dcadolph/cipher/age"
+)
+
+// TestMigrateWalk verifies that MigrateWalkWith plans from metadata on
+// a dry run without writing, then moves matching files to the new
+// recipient, leaving plaintext and already-migrated files alone.
+func TestMigrateWalk(t *testing.T) {
+	oldRecipient := newAgeIdentity(t)
+	newID, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generate identity: %v", err)
+	}
+	newRecipient := newID.Recipient().String()
+	ctx := context.Background()
+
+	files := afero.NewMemMapFs()
+	seed := map[string][]byte{"/repo/plain.yaml": []byte("c: 3\n")}
+	for path, recipient := range map[string]string{
+		"/repo/a.yaml":     oldRecipient,
+		"/repo/sub/b.json": oldRecipient,
+		"/repo/moved.yaml": newRecipient,
+	} {
+		ct, err := cipher.NewEncoder(cipherage.MustNewProvider(recipient)).Encode(ctx, path, []byte(`{"k": "v"}`))
+		if err != nil {
+			t.Fatalf("encode %s: %v", path, err)
+		}
+		seed[path] = ct
+	}
+	for path, data := range seed {
+		if err := afero.WriteFile(files, path, data, 0o600); err != nil {
+			t.Fatalf("write %s: %v", path, err)
+		}
+	}
+
+	from := []cipher.RecipientInfo{{Type: "age", Identifier: oldRecipient}}
+	to := cipherage.MustNewProvider(newRecipient)
+	moved := []cipher.RecipientInfo{{Type: "age", Identifier: newRecipient}}
+	want := &cipher.MigrateReport{DryRun: true, Files: []cipher.MigrateFile{
+		{Path: "/repo/a.yaml", Add: moved, Remove: from},
+		{Path: "/repo/moved.yaml", Skipped: cipher.ErrNothingToMigrate.Error()},
+		{Path: "/repo/plain.yaml", Skipped: cipher.ErrNotEncrypted.Error()},
+		{Path: "/repo/sub/b.json", Add: moved, Remove: from},
+	}}
+
+	// Test 1: A dry run reports the plan and writes nothing.
+	got, err := cipher.MigrateWalkWith(ctx, files, "/repo", from, to, nil, cipher.MigrateOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("Test 1: dry run: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Test 1: report (-want +got):\n%s", diff)
+	}
+	for path, data := range seed {
+		if after, _ := afero.ReadFile(files, path); string(after) != string(data) {
+			t.Errorf("Test 1: dry run rewrote %s", path)
+		}
+	}
+
+	// Test 2: A real run moves the files and reports the same plan.
+	var skipped []string
+	opts := cipher.MigrateOptions{Walk: cipher.WalkOptions{
+		Parallelism: 2,
+		OnSkip:      func(path string, _ error) { skipped = append(skipped, path) },
+	}}
+	got, err = cipher.MigrateWalkWith(ctx, files, "/repo", from, to, nil, opts)
+	if err != nil {
+		t.Fatalf("Test 2: migrate: %v", err)
+	}
+	want.DryRun = false
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Test 2: report (-want +got):\n%s", diff)
+	}
+	if got.Migrated() != 2 || len(skipped) != 2 {
+		t.Errorf("Test 2: migrated %d, skipped %v", got.Migrated(), skipped)
+	}
+	dec := cipher.NewDecoderWith(cipher.DecoderOptions{AgeIdentities: []string{newID.String()}})
+	for _, path := range []string{"/repo/a.yaml", "/repo/sub/b.json"} {
+		data, _ := afero.ReadFile(files, path)
+		info, err := cipher.InspectPath(path, data)
+		if err != nil {
+			t.Fatalf("Test 2: inspect %s: %v", path, err)
+		}
+		if diff := cmp.Diff([][]cipher.RecipientInfo{moved}, info.Groups); diff != "" {
+			t.Errorf("Test 2: %s recipients (-want +got):\n%s", path, diff)
+		}
+		if _, err := dec.Decode(ctx, path, data); err != nil {
+			t.Errorf("Test 2: decode %s with new identity: %v", path, err)
+		}
+	}
+
+	// Test 3: A second run finds nothing to migrate.
+	got, err = cipher.MigrateWalk(ctx, files, "/repo", from, to, nil)
+	if err != nil {
+		t.Fatalf("Test 3: migrate: %v", err)
+	}
+	if got.Migrated() != 0 {
+		t.Errorf("Test 3: migrated %d files, want 0", got.Migrated())
+	}
+}
+
+// TestMigrateWalkShamir verifies that migrating a 2-of-2 Shamir file
+// adds the target to the group that loses the source, here the second
+// one, keeps both
+// groups, and leaves a file the target and the other group's holder
+// can decrypt together.
+func TestMigrateWalkShamir(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	ids := make([]*age.X25519Identity, 3)
+	for i := range ids {
+		id, err := age.GenerateX25519Identity()
+		if err != nil {
+			t.Fatalf("generate identity: %v", err)
+		}
+		ids[i] = id
+	}
+	oldRecipient := ids[0].Recipient().String()
+	otherRecipient := ids[1].Recipient().String()
+	newRecipient := ids[2].Recipient().String()
+
+	var groups []sops.KeyGroup
+	for _, r := range []string{otherRecipient, oldRecipient} {
+		g, err := cipherage.MustNewProvider(r).KeyGroups(ctx)
+		if err != nil {
+			t.Fatalf("key groups: %v", err)
+		}
+		groups = append(groups, g...)
+	}
+	const path = "/repo/a.yaml"
+	ct, err := cipher.NewEncoder(cipher.StaticKeyProvider(groups...)).Encode(ctx, path, []byte("k: v\n"))
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	files := afero.NewMemMapFs()
+	if err := afero.WriteFile(files, path, ct, 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	from := []cipher.RecipientInfo{{Type: "age", Identifier: oldRecipient}}
+	_, err = cipher.MigrateWalkWith(ctx, files, "/repo", from, cipherage.MustNewProvider(newRecipient), nil,
+		cipher.MigrateOptions{AgeIdentities: []string{ids[0].String(), ids[1].String()}})
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	data, _ := afero.ReadFile(files, path)
+	info, err := cipher.InspectPath(path, data)
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	want := [][]cipher.RecipientInfo{
+		{{Type: "age", Identifier: otherRecipient}},
+		{{Type: "age", Identifier: newRecipient}},
+	}
+	if diff := cmp.Diff(want, info.Groups); diff != "" {
+		t.Errorf("recipients (-want +got):\n%s", diff)
+	}
+	dec := cipher.NewDecoderWith(cipher.DecoderOptions{AgeIdentities: []string{ids[2].String(), ids[1].String()}})
+	got, err := dec.Decode(ctx, path, data)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if string(got) != "k: v\n" {
+		t.Errorf("decoded %q, want %q", got, "k: v\n")
+	}
+}
+
+// TestMigrateWalkRejectsOverlap verifies that a recipient named as both
+// source and target is an error rather than a removal of the target.
+func TestMigrateWalkRejectsOverlap(t *testing.T) {
+	t.Parallel()
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generate identity: %v", err)
+	}
+	recipient := id.Recipient().String()
+	_, err = cipher.MigrateWalk(context.Background(), afero.NewMemMapFs(), "/",
+		[]cipher.RecipientInfo{{Identifier: recipient}}, cipherage.MustNewProvider(recipient), nil)
+	if err == nil || errors.Is(err, cipher.ErrNothingToMigrate) {
+		t.Fatalf("err = %v, want overlap error", err)
+	}
+}
@@ -152,10 +152,11 @@ func Rotate(
 
 // RotateWalk applies Rotate to every matching file under root. Files
 // that are not encrypted are skipped. Plain files do not cause failure.
+// The result reports every file visited, including when the walk fails.
 func RotateWalk(
 	ctx context.Context, files afero.Fs, root string,
 	enc Encoder, dec Decoder, matchers []FileMatcher,
-) error {
+) (*WalkResult, error) {
 	return RotateWalkWith(ctx, files, root, enc, dec, matchers, WalkOptions{})
 }
 
@@ -163,7 +164,7 @@ func RotateWalk(
 func RotateWalkWith(
 	ctx context.Context, files afero.Fs, root string,
 	enc Encoder, dec Decoder, matchers []FileMatcher, opts WalkOptions,
-) error {
+) (*WalkResult, error) {
 	if files == nil {
 		panic("cipher: RotateWalkWith: filesystem required")
 	}
@@ -174,24 +175,29 @@ func RotateWalkWith(
 		panic("cipher: RotateWalkWith: decoder required")
 	}
 	serializeCallbacks(&opts)
-	return runWalk(ctx, files, root, matchers, opts,
-		func(ctx context.Context, fs afero.Fs, path string, info fs.FileInfo) error {
-			data, err := afero.ReadFile(fs, path)
+	if opts.DryRun {
+		return runPlan(ctx, files, root, matchers, opts, planRotate(enc))
+	}
+	rec := recordWalk(&opts, "")
+	dest := newWalkDest(files, root, opts)
+	return runWalk(ctx, files, root, matchers, opts, rec,
+		func(ctx context.Context, dst afero.Fs, path string, info fs.FileInfo) error {
+			data, err := afero.ReadFile(files, path)
 			if err != nil {
 				return fmt.Errorf("read %q: %w", path, err)
 			}
 			out, err := Rotate(ctx, path, data, enc, dec)
 			switch {
 			case errors.Is(err, ErrNotEncrypted):
+				if err := dest.passThrough(dst, path, data, info); err != nil {
+					return err
+				}
 				notify(opts.OnSkip, path, ErrNotEncrypted)
 				return nil
 			case err != nil:
 				return err
 			}
-			if err := writeBackup(fs, path, data, info, opts.BackupSuffix); err != nil {
-				return err
-			}
-			if err := atomic.WriteFile(fs, path, out, info.Mode().Perm()); err != nil {
+			if err := dest.write(dst, path, data, out, info); err != nil {
 				return err
 			}
 			notify(opts.OnFile, path, len(out))
@@ -212,6 +218,10 @@ const (
 	// groups. With Shamir-threshold defaults this turns the file into
 	// a multi-group secret requiring multiple groups to decrypt.
 	AddRecipientAsGroups
+	// AddRecipientPerGroup merges the i-th new key group into the
+	// file's i-th key group, keeping the file's Shamir layout. An empty
+	// new group leaves its counterpart alone.
+	AddRecipientPerGroup
 )
 
 // AddRecipientOptions tunes AddRecipient behavior.
@@ -224,6 +234,13 @@ type AddRecipientOptions struct {
 	Format Format
 	// KeyServices overrides the default local key service.
 	KeyServices []keyservice.KeyServiceClient
+	// AgeIdentities are in-memory age identities that recover the
+	// existing data key before it is wrapped for the new recipients.
+	// Wrapping still goes through the local key service.
+	AgeIdentities []string
+	// PGPPrivateKeys are armored OpenPGP private keys that may recover
+	// the existing data key in place of the GnuPG keyring.
+	PGPPrivateKeys [][]byte
 	// DecryptionOrder controls which key types are tried first when
 	// unwrapping the data key. Empty means sops.DefaultDecryptionOrder.
 	DecryptionOrder []string
@@ -244,6 +261,8 @@ func AddRecipient(
 	return AddRecipientWith(ctx, path, data, add, AddRecipientOptions{
 		Format:          opts.Format,
 		KeyServices:     opts.KeyServices,
+		AgeIdentities:   opts.AgeIdentities,
+		PGPPrivateKeys:  opts.PGPPrivateKeys,
 		DecryptionOrder: opts.DecryptionOrder,
 	})
 }
@@ -253,10 +272,11 @@ func AddRecipient(
 func AddRecipientWith(
 	ctx context.Context, path string, data []byte,
 	add KeyProvider, opts AddRecipientOptions,
-) ([]byte, error) {
+) (_ []byte, err error) {
 	if add == nil {
 		panic("cipher: AddRecipient: KeyProvider required")
 	}
+	defer wrapError(&err, "add-recipient", path, opts.Format)
 	groups, err := add.KeyGroups(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("AddRecipient: key groups: %w", err)
@@ -264,13 +284,17 @@ func AddRecipientWith(
 	if len(groups) == 0 {
 		return nil, fmt.Errorf("AddRecipient: %w", ErrNoKeyGroups)
 	}
-	out, err := sopsx.AddRecipient(sopsx.AddRecipientInput{
+	services, err := identityKeyServices(opts.KeyServices, opts.AgeIdentities, opts.PGPPrivateKeys)
+	if err != nil {
+		return nil, fmt.Errorf("AddRecipient: %w", err)
+	}
+	out, err := sopsx.AddRecipient(ctx, sopsx.AddRecipientInput{
 		Path:               path,
 		Data:               data,
 		Format:             opts.Format,
 		NewGroups:          groups,
 		Mode:               sopsx.AddRecipientMode(opts.Mode),
-		KeyServices:        opts.KeyServices,
+		KeyServices:        services,
 		DecryptionOrder:    opts.DecryptionOrder,
 		MaxCiphertextBytes: opts.MaxCiphertextBytes,
 	})
@@ -285,12 +309,17 @@ func AddRecipientWith(
 	return out, nil
 }
 
-// RemoveRecipientOptions tunes RemoveRecipient behavior.
+// RemoveRecipientOptions tunes RemoveRecipient behavior. There are no
+// identity fields: RemoveRecipient never unwraps the data key.
 type RemoveRecipientOptions struct {
 	// AllowOrphan, when true, permits removal that would leave the file
 	// with zero remaining master keys. The resulting file is
 	// undecryptable forever. Required to make data destruction explicit.
 	AllowOrphan bool
+	// KeepEmptyGroups, when true, keeps a key group that loses every
+	// key instead of dropping it. A Shamir file that drops a group can
+	// no longer reach its threshold.
+	KeepEmptyGroups bool
 	// Format, when non-zero, fixes the sops format. When zero, format
 	// is derived from path.
 	Format Format
@@ -320,7 +349,8 @@ func RemoveRecipient(
 func RemoveRecipientWith(
 	path string, data []byte,
 	identifiers []string, opts RemoveRecipientOptions,
-) ([]byte, error) {
+) (_ []byte, err error) {
+	defer wrapError(&err, "remove-recipient", path, opts.Format)
 	if len(identifiers) == 0 {
 		return nil, fmt.Errorf("RemoveRecipient: at least one identifier required")
 	}
@@ -329,7 +359,7 @@ func RemoveRecipientWith(
 		Data:               data,
 		Format:             opts.Format,
 		Identifiers:        identifiers,
-		DropEmptyGroups:    true,
+		DropEmptyGroups:    !opts.KeepEmptyGroups,
 		AllowOrphan:        opts.AllowOrphan,
 		MaxCiphertextBytes: opts.MaxCiphertextBytes,
 	})
@@ -181,7 +181,7 @@ func TestRotateWalk(t *testing.T) {
 	}
 	before, _ := afero.ReadFile(files, "root/a.yaml")
 
-	err := cipher.RotateWalk(ctx, files, "root", enc, dec, []cipher.FileMatcher{
+	_, err := cipher.RotateWalk(ctx, files, "root", enc, dec, []cipher.FileMatcher{
 		cipher.MatchExt("yaml"),
 	})
 	if err != nil {
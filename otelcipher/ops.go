@@ -0,0 +1,234 @@
+package otelcipher
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/afero"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/dcadolph/cipher"
+)
+
+// Walk file outcomes recorded in cipher.outcome.
+const (
+	outcomeProcessed = "processed"
+	outcomeSkipped   = "skipped"
+	outcomeFailed    = "failed"
+)
+
+// EncodeWalkWith is cipher.EncodeWalkWith with a cipher.EncodeWalk
+// span around the walk, a cipher.WalkFile span per file, and enc
+// wrapped by in.
+func (in *Instrumentation) EncodeWalkWith(
+	ctx context.Context, files afero.Fs, root string,
+	enc cipher.Encoder, matchers []cipher.FileMatcher, opts cipher.WalkOptions,
+) (*cipher.WalkResult, error) {
+	if enc == nil {
+		panic("otelcipher: EncodeWalkWith: encoder required")
+	}
+	enc = in.WrapEncoder(enc)
+	return in.walk(ctx, "cipher.EncodeWalk", "encode", root, opts,
+		func(ctx context.Context, opts cipher.WalkOptions) (*cipher.WalkResult, error) {
+			return cipher.EncodeWalkWith(ctx, files, root, enc, matchers, opts)
+		})
+}
+
+// DecodeWalkWith is cipher.DecodeWalkWith with a cipher.DecodeWalk
+// span around the walk, a cipher.WalkFile span per file, and dec
+// wrapped by in.
+func (in *Instrumentation) DecodeWalkWith(
+	ctx context.Context, files afero.Fs, root string,
+	dec cipher.Decoder, matchers []cipher.FileMatcher, opts cipher.WalkOptions,
+) (*cipher.WalkResult, error) {
+	if dec == nil {
+		panic("otelcipher: DecodeWalkWith: decoder required")
+	}
+	dec = in.WrapDecoder(dec)
+	return in.walk(ctx, "cipher.DecodeWalk", "decode", root, opts,
+		func(ctx context.Context, opts cipher.WalkOptions) (*cipher.WalkResult, error) {
+			return cipher.DecodeWalkWith(ctx, files, root, dec, matchers, opts)
+		})
+}
+
+// RotateWalkWith is cipher.RotateWalkWith with a cipher.RotateWalk
+// span around the walk, a cipher.WalkFile span per file, and enc and
+// dec wrapped by in.
+func (in *Instrumentation) RotateWalkWith(
+	ctx context.Context, files afero.Fs, root string,
+	enc cipher.Encoder, dec cipher.Decoder, matchers []cipher.FileMatcher, opts cipher.WalkOptions,
+) (*cipher.WalkResult, error) {
+	if enc == nil {
+		panic("otelcipher: RotateWalkWith: encoder required")
+	}
+	if dec == nil {
+		panic("otelcipher: RotateWalkWith: decoder required")
+	}
+	enc, dec = in.WrapEncoder(enc), in.WrapDecoder(dec)
+	return in.walk(ctx, "cipher.RotateWalk", "rotate", root, opts,
+		func(ctx context.Context, opts cipher.WalkOptions) (*cipher.WalkResult, error) {
+			return cipher.RotateWalkWith(ctx, files, root, enc, dec, matchers, opts)
+		})
+}
+
+// walkFile is the state of one file in a walk.
+type walkFile struct {
+	span    trace.Span
+	skipped string
+}
+
+// walk runs run under a span named name and hooks opts so every file
+// gets a child span and a cipher.walk.files count. The callbacks and
+// WrapFile already in opts still run.
+func (in *Instrumentation) walk(
+	ctx context.Context, name, op, root string, opts cipher.WalkOptions,
+	run func(ctx context.Context, opts cipher.WalkOptions) (*cipher.WalkResult, error),
+) (*cipher.WalkResult, error) {
+	ctx, span := in.tracer.Start(ctx, name, trace.WithAttributes(
+		attribute.String("cipher.walk.root", root),
+	))
+	defer span.End()
+
+	// inFlight maps a path to its walkFile while WrapFile runs. OnFile
+	// and OnSkip run inside do on the file's goroutine, so walkFile needs
+	// no lock. Matcher rejections are reported before any file work and
+	// find no entry.
+	var inFlight sync.Map
+	var processed, skipped, failed atomic.Int64
+	wrap, onFile, onSkip := opts.WrapFile, opts.OnFile, opts.OnSkip
+	opts.WrapFile = func(ctx context.Context, path string, do func(context.Context) error) error {
+		ctx, fspan := in.start(ctx, "cipher.WalkFile", path, 0)
+		defer fspan.End()
+		f := &walkFile{span: fspan}
+		inFlight.Store(path, f)
+		defer inFlight.Delete(path)
+
+		var err error
+		if wrap != nil {
+			err = wrap(ctx, path, do)
+		} else {
+			err = do(ctx)
+		}
+
+		attrs := []attribute.KeyValue{
+			attribute.String("cipher.operation", op),
+			attribute.String("cipher.format", formatName(path, 0)),
+		}
+		switch {
+		case err != nil:
+			failed.Add(1)
+			attrs = append(attrs,
+				attribute.String("cipher.outcome", outcomeFailed),
+				attribute.String("error.type", fail(fspan, err)))
+		case f.skipped != "":
+			skipped.Add(1)
+			attrs = append(attrs, attribute.String("cipher.outcome", outcomeSkipped))
+		default:
+			processed.Add(1)
+			attrs = append(attrs, attribute.String("cipher.outcome", outcomeProcessed))
+		}
+		in.walkFiles.Add(ctx, 1, metric.WithAttributes(attrs...))
+		return err
+	}
+	opts.OnFile = func(path string, n int) {
+		if f, ok := inFlight.Load(path); ok {
+			f.(*walkFile).span.SetAttributes(attribute.Int("cipher.output_bytes", n))
+		}
+		if onFile != nil {
+			onFile(path, n)
+		}
+	}
+	opts.OnSkip = func(path string, reason error) {
+		if f, ok := inFlight.Load(path); ok {
+			wf := f.(*walkFile)
+			wf.skipped = cipher.KindOf(reason).String()
+			wf.span.SetAttributes(attribute.String("cipher.skipped", wf.skipped))
+		}
+		if onSkip != nil {
+			onSkip(path, reason)
+		}
+	}
+
+	res, err := run(ctx, opts)
+	span.SetAttributes(
+		attribute.Int64("cipher.walk.processed", processed.Load()),
+		attribute.Int64("cipher.walk.skipped", skipped.Load()),
+		attribute.Int64("cipher.walk.failed", failed.Load()),
+	)
+	if err != nil {
+		fail(span, err)
+	}
+	return res, err
+}
+
+// EditWith is cipher.EditWith with a cipher.Edit span, and enc and
+// dec wrapped by in. fn's input and output are never recorded.
+func (in *Instrumentation) EditWith(
+	ctx context.Context, files afero.Fs, path string,
+	enc cipher.Encoder, dec cipher.Decoder,
+	fn func(plaintext []byte) ([]byte, error),
+	opts cipher.EditOptions,
+) (err error) {
+	if enc == nil {
+		panic("otelcipher: EditWith: encoder required")
+	}
+	if dec == nil {
+		panic("otelcipher: EditWith: decoder required")
+	}
+	ctx, span := in.start(ctx, "cipher.Edit", path, 0)
+	defer span.End()
+	start := time.Now()
+	defer func() { in.finish(ctx, span, "edit", path, 0, start, err) }()
+	return cipher.EditWith(ctx, files, path, in.WrapEncoder(enc), in.WrapDecoder(dec), fn, opts)
+}
+
+// AddRecipientWith is cipher.AddRecipientWith with a
+// cipher.AddRecipient span, and add wrapped by in. The span records the
+// recipient types of the result.
+func (in *Instrumentation) AddRecipientWith(
+	ctx context.Context, path string, data []byte,
+	add cipher.KeyProvider, opts cipher.AddRecipientOptions,
+) (_ []byte, err error) {
+	if add == nil {
+		panic("otelcipher: AddRecipientWith: KeyProvider required")
+	}
+	ctx, span := in.start(ctx, "cipher.AddRecipient", path, opts.Format,
+		attribute.Int("cipher.ciphertext_bytes", len(data)),
+	)
+	defer span.End()
+	start := time.Now()
+	defer func() { in.finish(ctx, span, "add-recipient", path, opts.Format, start, err) }()
+	out, err := cipher.AddRecipientWith(ctx, path, data, in.WrapKeyProvider(add), opts)
+	if err != nil {
+		return nil, err
+	}
+	setRecipientTypes(span, path, out)
+	return out, nil
+}
+
+// RemoveRecipientWith is cipher.RemoveRecipientWith with a
+// cipher.RemoveRecipient span, a child of the span in ctx. The span
+// records how many identifiers were requested, not the identifiers,
+// and the recipient types of the result.
+func (in *Instrumentation) RemoveRecipientWith(
+	ctx context.Context, path string, data []byte,
+	identifiers []string, opts cipher.RemoveRecipientOptions,
+) (_ []byte, err error) {
+	ctx, span := in.start(ctx, "cipher.RemoveRecipient", path, opts.Format,
+		attribute.Int("cipher.ciphertext_bytes", len(data)),
+		attribute.Int("cipher.identifiers", len(identifiers)),
+	)
+	defer span.End()
+	start := time.Now()
+	defer func() { in.finish(ctx, span, "remove-recipient", path, opts.Format, start, err) }()
+	out, err := cipher.RemoveRecipientWith(path, data, identifiers, opts)
+	if err != nil {
+		return nil, err
+	}
+	setRecipientTypes(span, path, out)
+	return out, nil
+}
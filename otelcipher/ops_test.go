@@ -0,0 +1,296 @@
+package otelcipher_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/dcadolph/cipher"
+	"github.com/dcadolph/cipher/ciphertest"
+	"github.com/dcadolph/cipher/otelcipher"
+)
+
+// newInstrumentation returns an Instrumentation backed by an in-memory
+// span recorder and a manual metric reader.
+func newInstrumentation(t *testing.T) (*otelcipher.Instrumentation, func() []sdktrace.ReadOnlySpan, *sdkmetric.ManualReader) {
+	t.Helper()
+	rec, tp := newTracer(t)
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	in := otelcipher.NewInstrumentation(otelcipher.Options{
+		Tracer: tp.Tracer("test"),
+		Meter:  mp.Meter("test"),
+	})
+	return in, rec.Ended, reader
+}
+
+// collect returns every metric data point in reader as "name
+// attr=value ..." mapped to its count or sum.
+func collect(t *testing.T, reader *sdkmetric.ManualReader) map[string]int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	key := func(name string, set attribute.Set) string {
+		parts := []string{name}
+		for _, kv := range set.ToSlice() {
+			parts = append(parts, string(kv.Key)+"="+kv.Value.Emit())
+		}
+		return strings.Join(parts, " ")
+	}
+	out := make(map[string]int64)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					out[key(m.Name, dp.Attributes)] = dp.Value
+				}
+			case metricdata.Histogram[float64]:
+				for _, dp := range data.DataPoints {
+					out[key(m.Name, dp.Attributes)] = int64(dp.Count)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// spanAttr returns the value of attribute key on span, or nil.
+func spanAttr(span sdktrace.ReadOnlySpan, key string) any {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kvValue(kv)
+		}
+	}
+	return nil
+}
+
+// TestEncodeWalkWithSpans verifies the walk span, one child span per
+// file with the Encode span beneath it, and the walk file metric.
+func TestEncodeWalkWithSpans(t *testing.T) {
+	kp, _ := ciphertest.NewProvider(t)
+	in, ended, reader := newInstrumentation(t)
+	ctx := context.Background()
+	enc := cipher.NewEncoder(kp)
+	done, err := enc.Encode(ctx, "/r/done.yaml", []byte("a: 1\n"))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	files := afero.NewMemMapFs()
+	for path, data := range map[string][]byte{
+		"/r/done.yaml": done,
+		"/r/new.yaml":  []byte("b: 2\n"),
+		"/r/new.json":  []byte(`{"c":3}`),
+	} {
+		if err := afero.WriteFile(files, path, data, 0o600); err != nil {
+			t.Fatalf("seed %s: %v", path, err)
+		}
+	}
+
+	var onFile int
+	_, err = in.EncodeWalkWith(ctx, files, "/r", enc, nil, cipher.WalkOptions{
+		OnFile: func(string, int) { onFile++ },
+	})
+	if err != nil {
+		t.Fatalf("EncodeWalkWith: %v", err)
+	}
+	if onFile != 2 {
+		t.Errorf("OnFile calls = %d, want 2", onFile)
+	}
+
+	var walk sdktrace.ReadOnlySpan
+	fileSpans := map[string]sdktrace.ReadOnlySpan{}
+	encodes := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range ended() {
+		switch s.Name() {
+		case "cipher.EncodeWalk":
+			walk = s
+		case "cipher.WalkFile":
+			fileSpans[spanAttr(s, "cipher.path").(string)] = s
+		case "cipher.Encode":
+			encodes[spanAttr(s, "cipher.path").(string)] = s
+		}
+	}
+	if walk == nil {
+		t.Fatal("no cipher.EncodeWalk span")
+	}
+	if got := spanAttr(walk, "cipher.walk.processed"); got != int64(2) {
+		t.Errorf("walk processed = %v, want 2", got)
+	}
+	if got := spanAttr(walk, "cipher.walk.skipped"); got != int64(1) {
+		t.Errorf("walk skipped = %v, want 1", got)
+	}
+	if len(fileSpans) != 3 {
+		t.Fatalf("file spans = %d, want 3", len(fileSpans))
+	}
+	for path, fs := range fileSpans {
+		if fs.Parent().SpanID() != walk.SpanContext().SpanID() {
+			t.Errorf("%s: file span not a child of the walk span", path)
+		}
+		if e := encodes[path]; e == nil || e.Parent().SpanID() != fs.SpanContext().SpanID() {
+			t.Errorf("%s: Encode span not a child of the file span", path)
+		}
+	}
+	if got := spanAttr(fileSpans["/r/done.yaml"], "cipher.skipped"); got != "already-encrypted" {
+		t.Errorf("done.yaml cipher.skipped = %v", got)
+	}
+	if got := spanAttr(encodes["/r/new.json"], "cipher.recipient_types"); got != `["age"]` {
+		t.Errorf("new.json recipient types = %v, want [age]", got)
+	}
+
+	got := collect(t, reader)
+	want := map[string]int64{
+		"cipher.walk.files cipher.format=yaml cipher.operation=encode cipher.outcome=processed": 1,
+		"cipher.walk.files cipher.format=json cipher.operation=encode cipher.outcome=processed": 1,
+		"cipher.walk.files cipher.format=yaml cipher.operation=encode cipher.outcome=skipped":   1,
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %d, want %d", k, got[k], v)
+		}
+	}
+	if got["cipher.operation.duration cipher.format=yaml cipher.operation=encode"] != 1 {
+		t.Errorf("encode duration not recorded: %v", got)
+	}
+}
+
+// TestWalkFileFailure verifies that a failing file marks its span and
+// the walk span with error.type and counts the file as failed.
+func TestWalkFileFailure(t *testing.T) {
+	in, ended, reader := newInstrumentation(t)
+	files := afero.NewMemMapFs()
+	if err := afero.WriteFile(files, "/r/a.yaml", []byte("a: 1\n"), 0o600); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	dec := cipher.DecoderFunc(func(context.Context, string, []byte) ([]byte, error) {
+		return nil, cipher.ErrMACMismatch
+	})
+	_, err := in.DecodeWalkWith(context.Background(), files, "/r", dec, nil, cipher.WalkOptions{})
+	if !errors.Is(err, cipher.ErrMACMismatch) {
+		t.Fatalf("err = %v, want ErrMACMismatch", err)
+	}
+	for _, s := range ended() {
+		if s.Name() != "cipher.WalkFile" && s.Name() != "cipher.DecodeWalk" {
+			continue
+		}
+		if s.Status().Code != codes.Error || spanAttr(s, "error.type") != "mac-mismatch" {
+			t.Errorf("%s: status %v error.type %v", s.Name(), s.Status(), spanAttr(s, "error.type"))
+		}
+	}
+	k := "cipher.walk.files cipher.format=yaml cipher.operation=decode cipher.outcome=failed error.type=mac-mismatch"
+	if got := collect(t, reader)[k]; got != 1 {
+		t.Errorf("%s = %d, want 1", k, got)
+	}
+}
+
+// TestRecipientOps verifies the AddRecipient and RemoveRecipient spans
+// and that AddRecipient's key provider gets its own child span.
+func TestRecipientOps(t *testing.T) {
+	kp, _ := ciphertest.NewProvider(t)
+	_, extra := ciphertest.NewAgeKeyPair(t)
+	in, ended, _ := newInstrumentation(t)
+	ctx := context.Background()
+	ct, err := cipher.NewEncoder(kp).Encode(ctx, "s.yaml", []byte("a: 1\n"))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	added, err := in.AddRecipientWith(ctx, "s.yaml", ct, ciphertest.MustAgeProvider(t, extra), cipher.AddRecipientOptions{})
+	if err != nil {
+		t.Fatalf("AddRecipientWith: %v", err)
+	}
+	if _, err := in.RemoveRecipientWith(ctx, "s.yaml", added, []string{extra}, cipher.RemoveRecipientOptions{}); err != nil {
+		t.Fatalf("RemoveRecipientWith: %v", err)
+	}
+
+	names := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range ended() {
+		names[s.Name()] = s
+	}
+	add, remove, groups := names["cipher.AddRecipient"], names["cipher.RemoveRecipient"], names["cipher.KeyGroups"]
+	if add == nil || remove == nil || groups == nil {
+		t.Fatalf("spans = %v", names)
+	}
+	if groups.Parent().SpanID() != add.SpanContext().SpanID() {
+		t.Error("KeyGroups span not a child of AddRecipient")
+	}
+	if got := spanAttr(add, "cipher.recipient_types"); got != `["age"]` {
+		t.Errorf("AddRecipient recipient types = %v", got)
+	}
+	if got := spanAttr(remove, "cipher.identifiers"); got != int64(1) {
+		t.Errorf("RemoveRecipient identifiers = %v, want 1", got)
+	}
+}
+
+// TestNoPlaintextInTelemetry verifies that no span attribute, event,
+// status, or metric attribute carries plaintext, including when a parse
+// error quotes the input.
+func TestNoPlaintextInTelemetry(t *testing.T) {
+	const secret = "hunter2-s3cr3t"
+	kp, _ := ciphertest.NewProvider(t)
+	in, ended, reader := newInstrumentation(t)
+	ctx := context.Background()
+	files := afero.NewMemMapFs()
+	if err := afero.WriteFile(files, "/r/s.yaml", []byte("password: "+secret+"\n"), 0o600); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	enc, dec := cipher.NewEncoder(kp), cipher.NewDecoder()
+
+	if _, err := in.EncodeWalkWith(ctx, files, "/r", enc, nil, cipher.WalkOptions{}); err != nil {
+		t.Fatalf("EncodeWalkWith: %v", err)
+	}
+	err := in.EditWith(ctx, files, "/r/s.yaml", enc, dec, func(p []byte) ([]byte, error) {
+		return append(p, "other: "+secret+"\n"...), nil
+	}, cipher.EditOptions{})
+	if err != nil {
+		t.Fatalf("EditWith: %v", err)
+	}
+	if _, err := in.DecodeWalkWith(ctx, files, "/r", dec, nil, cipher.WalkOptions{}); err != nil {
+		t.Fatalf("DecodeWalkWith: %v", err)
+	}
+	// The dotenv parser quotes the offending line in its error.
+	bad := []byte("A=1\n" + secret + "\n")
+	if _, err := in.WrapEncoder(enc).Encode(ctx, "bad.env", bad); err == nil || !strings.Contains(err.Error(), secret) {
+		t.Fatalf("Encode malformed: err = %v, want an error quoting the input", err)
+	}
+
+	check := func(where, s string) {
+		if strings.Contains(s, secret) {
+			t.Errorf("%s leaks plaintext: %q", where, s)
+		}
+	}
+	for _, s := range ended() {
+		for _, kv := range s.Attributes() {
+			check(s.Name()+" attribute "+string(kv.Key), kv.Value.Emit())
+		}
+		for _, ev := range s.Events() {
+			for _, kv := range ev.Attributes {
+				check(s.Name()+" event "+ev.Name, kv.Value.Emit())
+			}
+		}
+		check(s.Name()+" status", s.Status().Description)
+	}
+	for k := range collect(t, reader) {
+		check("metric", k)
+	}
+
+	var failed int64
+	for k, n := range collect(t, reader) {
+		if strings.HasPrefix(k, "cipher.operation.duration cipher.format=dotenv cipher.operation=encode error.type=") {
+			failed += n
+		}
+	}
+	if failed != 1 {
+		t.Errorf("failed dotenv encodes recorded = %d, want 1", failed)
+	}
+}
@@ -1,4 +1,5 @@
-// Package otelcipher emits OpenTelemetry spans for cipher operations.
+// Package otelcipher emits OpenTelemetry spans and metrics for cipher
+// operations.
 //
 // It is an opt-in subpackage. Code that does not import otelcipher does
 // not pull in the OpenTelemetry SDK or its dependencies.
@@ -18,7 +19,30 @@
 //     attributes. Useful when key sourcing is slow (KMS lookups,
 //     network calls) and you want timing visibility.
 //
-// Errors are recorded on the span and set its status to error.
+// An [Instrumentation] offers the same wrappers plus drop-in versions
+// of the walks and file operations:
+//
+//   - EncodeWalkWith, DecodeWalkWith, and RotateWalkWith emit a parent
+//     span for the whole walk and one cipher.WalkFile child span per
+//     file, with the Encode and Decode spans of that file beneath it.
+//   - EditWith, AddRecipientWith, and RemoveRecipientWith emit
+//     cipher.Edit, cipher.AddRecipient, and cipher.RemoveRecipient
+//     spans.
+//
+// Every wrapper also records metrics on the meter:
+//
+//	cipher.operation.duration   histogram, s   cipher.operation, cipher.format, error.type
+//	cipher.key_provider.duration histogram, s  cipher.recipient_types, error.type
+//	cipher.walk.files           counter        cipher.operation, cipher.format, cipher.outcome, error.type
+//
+// # Attributes
+//
+// Spans record the path, format, byte counts, and recipient types
+// (age, kms, pgp, ...) of the files they touch, and never their
+// content. Failures set the span status to error and record error.type,
+// the cipher.Kind of the error such as "mac-mismatch". The error message
+// itself is not recorded, because a parse error can quote the input it
+// failed on, and that input may be plaintext.
 //
 // # Quick start
 //
@@ -32,76 +56,150 @@
 //	enc := otelcipher.WrapEncoder(cipher.NewEncoder(kp), tracer)
 //	dec := otelcipher.WrapDecoder(cipher.NewDecoder(), tracer)
 //
-// Passing a nil tracer falls back to otel.Tracer(TracerName).
+// Passing a nil tracer falls back to otel.Tracer(TracerName), and
+// metrics go to otel.Meter(TracerName). Use [NewInstrumentation] to
+// choose the meter.
 package otelcipher
 
 import (
 	"context"
+	"errors"
+	"slices"
+	"time"
 
 	"github.com/getsops/sops/v3"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
 	"go.opentelemetry.io/otel/trace"
 
 	"github.com/dcadolph/cipher"
 )
 
 // TracerName is the default OTel tracer name used by Wrap helpers when
-// the caller supplies a nil tracer.
+// the caller supplies a nil tracer. It also names the default meter.
 const TracerName = "github.com/dcadolph/cipher"
 
+// Options tunes NewInstrumentation.
+type Options struct {
+	// Tracer creates spans. If nil, otel.Tracer(TracerName) is used.
+	Tracer trace.Tracer
+	// Meter creates the metric instruments. If nil,
+	// otel.Meter(TracerName) is used.
+	Meter metric.Meter
+}
+
+// Instrumentation emits spans and metrics for cipher operations. Build
+// one per tracer and meter and share it; it is safe for concurrent use.
+type Instrumentation struct {
+	tracer      trace.Tracer
+	duration    metric.Float64Histogram
+	keyDuration metric.Float64Histogram
+	walkFiles   metric.Int64Counter
+}
+
+// NewInstrumentation returns an Instrumentation that uses opts.Tracer
+// and opts.Meter. Instrument creation errors are passed to otel.Handle
+// and leave metrics disabled.
+func NewInstrumentation(opts Options) *Instrumentation {
+	tracer := opts.Tracer
+	if tracer == nil {
+		tracer = otel.Tracer(TracerName)
+	}
+	meter := opts.Meter
+	if meter == nil {
+		meter = otel.Meter(TracerName)
+	}
+	in, err := newInstruments(meter)
+	if err != nil {
+		otel.Handle(err)
+		in, _ = newInstruments(noop.Meter{})
+	}
+	in.tracer = tracer
+	return in
+}
+
+// newInstruments creates the metric instruments on meter.
+func newInstruments(meter metric.Meter) (*Instrumentation, error) {
+	duration, err1 := meter.Float64Histogram("cipher.operation.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of cipher operations."))
+	keyDuration, err2 := meter.Float64Histogram("cipher.key_provider.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of key provider KeyGroups calls."))
+	walkFiles, err3 := meter.Int64Counter("cipher.walk.files",
+		metric.WithUnit("{file}"),
+		metric.WithDescription("Files handled by cipher walks, by outcome."))
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return nil, err
+	}
+	return &Instrumentation{duration: duration, keyDuration: keyDuration, walkFiles: walkFiles}, nil
+}
+
 // WrapEncoder returns a cipher.Encoder that emits an OTel span around
 // every Encode call. The span is named "cipher.Encode" and carries
 // path, plaintext byte count, and ciphertext byte count attributes.
-// Errors are recorded on the span and set its status to error.
+// Errors set the span status to error.
 func WrapEncoder(enc cipher.Encoder, tracer trace.Tracer) cipher.Encoder {
+	return NewInstrumentation(Options{Tracer: tracer}).WrapEncoder(enc)
+}
+
+// WrapDecoder returns a cipher.Decoder that emits an OTel span around
+// every Decode call.
+func WrapDecoder(dec cipher.Decoder, tracer trace.Tracer) cipher.Decoder {
+	return NewInstrumentation(Options{Tracer: tracer}).WrapDecoder(dec)
+}
+
+// WrapKeyProvider returns a cipher.KeyProvider that emits an OTel span
+// around every KeyGroups call. Useful when key sourcing is slow
+// (e.g. KMS lookups) and you want timing visibility.
+func WrapKeyProvider(kp cipher.KeyProvider, tracer trace.Tracer) cipher.KeyProvider {
+	return NewInstrumentation(Options{Tracer: tracer}).WrapKeyProvider(kp)
+}
+
+// WrapEncoder is the package-level WrapEncoder using in's tracer and
+// meter. Spans also carry cipher.format and, when sampled,
+// cipher.recipient_types read from the new ciphertext.
+func (in *Instrumentation) WrapEncoder(enc cipher.Encoder) cipher.Encoder {
 	if enc == nil {
 		panic("otelcipher: WrapEncoder: encoder required")
 	}
-	if tracer == nil {
-		tracer = otel.Tracer(TracerName)
-	}
 	return cipher.EncoderFunc(func(ctx context.Context, path string, data []byte) ([]byte, error) {
-		ctx, span := tracer.Start(ctx, "cipher.Encode",
-			trace.WithAttributes(
-				attribute.String("cipher.path", path),
-				attribute.Int("cipher.plaintext_bytes", len(data)),
-			),
+		ctx, span := in.start(ctx, "cipher.Encode", path, 0,
+			attribute.Int("cipher.plaintext_bytes", len(data)),
 		)
 		defer span.End()
+		start := time.Now()
 		out, err := enc.Encode(ctx, path, data)
+		in.finish(ctx, span, "encode", path, 0, start, err)
 		if err != nil {
-			span.RecordError(err)
-			span.SetStatus(codes.Error, err.Error())
 			return nil, err
 		}
 		span.SetAttributes(attribute.Int("cipher.ciphertext_bytes", len(out)))
+		setRecipientTypes(span, path, out)
 		return out, nil
 	})
 }
 
-// WrapDecoder returns a cipher.Decoder that emits an OTel span around
-// every Decode call.
-func WrapDecoder(dec cipher.Decoder, tracer trace.Tracer) cipher.Decoder {
+// WrapDecoder is the package-level WrapDecoder using in's tracer and
+// meter. Spans also carry cipher.format and, when sampled,
+// cipher.recipient_types read from the ciphertext.
+func (in *Instrumentation) WrapDecoder(dec cipher.Decoder) cipher.Decoder {
 	if dec == nil {
 		panic("otelcipher: WrapDecoder: decoder required")
 	}
-	if tracer == nil {
-		tracer = otel.Tracer(TracerName)
-	}
 	return cipher.DecoderFunc(func(ctx context.Context, path string, data []byte) ([]byte, error) {
-		ctx, span := tracer.Start(ctx, "cipher.Decode",
-			trace.WithAttributes(
-				attribute.String("cipher.path", path),
-				attribute.Int("cipher.ciphertext_bytes", len(data)),
-			),
+		ctx, span := in.start(ctx, "cipher.Decode", path, 0,
+			attribute.Int("cipher.ciphertext_bytes", len(data)),
 		)
 		defer span.End()
+		setRecipientTypes(span, path, data)
+		start := time.Now()
 		out, err := dec.Decode(ctx, path, data)
+		in.finish(ctx, span, "decode", path, 0, start, err)
 		if err != nil {
-			span.RecordError(err)
-			span.SetStatus(codes.Error, err.Error())
 			return nil, err
 		}
 		span.SetAttributes(attribute.Int("cipher.plaintext_bytes", len(out)))
@@ -109,33 +207,106 @@ func WrapDecoder(dec cipher.Decoder, tracer trace.Tracer) cipher.Decoder {
 	})
 }
 
-// WrapKeyProvider returns a cipher.KeyProvider that emits an OTel span
-// around every KeyGroups call. Useful when key sourcing is slow
-// (e.g. KMS lookups) and you want timing visibility.
-func WrapKeyProvider(kp cipher.KeyProvider, tracer trace.Tracer) cipher.KeyProvider {
+// WrapKeyProvider is the package-level WrapKeyProvider using in's
+// tracer and meter. Spans and the cipher.key_provider.duration metric
+// also carry cipher.recipient_types.
+func (in *Instrumentation) WrapKeyProvider(kp cipher.KeyProvider) cipher.KeyProvider {
 	if kp == nil {
 		panic("otelcipher: WrapKeyProvider: provider required")
 	}
-	if tracer == nil {
-		tracer = otel.Tracer(TracerName)
-	}
 	return cipher.KeyProviderFunc(func(ctx context.Context) ([]sops.KeyGroup, error) {
-		ctx, span := tracer.Start(ctx, "cipher.KeyGroups")
+		ctx, span := in.tracer.Start(ctx, "cipher.KeyGroups")
 		defer span.End()
+		start := time.Now()
 		groups, err := kp.KeyGroups(ctx)
+		elapsed := time.Since(start).Seconds()
 		if err != nil {
-			span.RecordError(err)
-			span.SetStatus(codes.Error, err.Error())
+			kind := fail(span, err)
+			in.keyDuration.Record(ctx, elapsed, metric.WithAttributes(attribute.String("error.type", kind)))
 			return nil, err
 		}
 		var keys int
+		var types []string
 		for _, g := range groups {
 			keys += len(g)
+			for _, k := range g {
+				types = append(types, k.TypeToIdentifier())
+			}
 		}
+		slices.Sort(types)
+		typesAttr := attribute.StringSlice("cipher.recipient_types", slices.Compact(types))
 		span.SetAttributes(
 			attribute.Int("cipher.groups", len(groups)),
 			attribute.Int("cipher.keys", keys),
+			typesAttr,
 		)
+		in.keyDuration.Record(ctx, elapsed, metric.WithAttributes(typesAttr))
 		return groups, nil
 	})
 }
+
+// start starts span name for an operation on path, with the path and
+// format attributes followed by attrs.
+func (in *Instrumentation) start(
+	ctx context.Context, name, path string, format cipher.Format, attrs ...attribute.KeyValue,
+) (context.Context, trace.Span) {
+	attrs = append([]attribute.KeyValue{
+		attribute.String("cipher.path", path),
+		attribute.String("cipher.format", formatName(path, format)),
+	}, attrs...)
+	return in.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// finish records the duration of op on path since start, and marks
+// span failed when err is non-nil.
+func (in *Instrumentation) finish(
+	ctx context.Context, span trace.Span, op, path string, format cipher.Format, start time.Time, err error,
+) {
+	attrs := []attribute.KeyValue{
+		attribute.String("cipher.operation", op),
+		attribute.String("cipher.format", formatName(path, format)),
+	}
+	if err != nil {
+		attrs = append(attrs, attribute.String("error.type", fail(span, err)))
+	}
+	in.duration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(attrs...))
+}
+
+// fail sets span's status to error and records the Kind of err as
+// error.type, which it returns. The error message is not recorded.
+func fail(span trace.Span, err error) string {
+	kind := cipher.KindOf(err).String()
+	span.SetAttributes(attribute.String("error.type", kind))
+	span.SetStatus(codes.Error, kind)
+	return kind
+}
+
+// formatName returns the name of format, or of the format derived from
+// path when format is zero.
+func formatName(path string, format cipher.Format) string {
+	if format == 0 {
+		format = cipher.FormatForPath(path)
+	}
+	return cipher.FormatName(format)
+}
+
+// setRecipientTypes records the master key types in the metadata of
+// ciphertext on span. It parses the file, so it does nothing for spans
+// that are not recorded.
+func setRecipientTypes(span trace.Span, path string, ciphertext []byte) {
+	if !span.IsRecording() {
+		return
+	}
+	info, err := cipher.InspectPath(path, ciphertext)
+	if err != nil {
+		return
+	}
+	var types []string
+	for _, g := range info.Groups {
+		for _, r := range g {
+			types = append(types, r.Type)
+		}
+	}
+	slices.Sort(types)
+	span.SetAttributes(attribute.StringSlice("cipher.recipient_types", slices.Compact(types)))
+}
@@ -0,0 +1,124 @@
+package cipher
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+
+	"github.com/dcadolph/cipher/internal/atomic"
+)
+
+// walkDest resolves where a walk writes each file: over the file
+// itself, or at its mirror under WalkOptions.OutputRoot.
+type walkDest struct {
+	files        afero.Fs
+	root         string
+	outRoot      string
+	backupSuffix string
+}
+
+// newWalkDest returns the walkDest for a walk of root on files. An
+// output tree takes no backups, since the walked files are never
+// overwritten.
+func newWalkDest(files afero.Fs, root string, opts WalkOptions) walkDest {
+	d := walkDest{files: files, root: root, outRoot: opts.OutputRoot}
+	if d.outRoot == "" {
+		d.backupSuffix = opts.BackupSuffix
+	}
+	return d
+}
+
+// target returns the path on dst that receives the result for path.
+// For an output tree it creates the mirror's parent directories, with
+// the mode of path's directory.
+func (d walkDest) target(dst afero.Fs, path string) (string, error) {
+	if d.outRoot == "" {
+		return path, nil
+	}
+	rel, err := filepath.Rel(d.root, path)
+	if err != nil {
+		return "", fmt.Errorf("output path for %q: %w", path, err)
+	}
+	target := filepath.Join(d.outRoot, rel)
+	perm := fs.FileMode(0o755)
+	if info, err := d.files.Stat(filepath.Dir(path)); err == nil {
+		perm = info.Mode().Perm()
+	}
+	if err := dst.MkdirAll(filepath.Dir(target), perm); err != nil {
+		return "", fmt.Errorf("output dir for %q: %w", path, err)
+	}
+	return target, nil
+}
+
+// write stores out as the result for path, whose contents were data,
+// keeping path's mode. In place, path is backed up first when
+// BackupSuffix is set.
+func (d walkDest) write(dst afero.Fs, path string, data, out []byte, info fs.FileInfo) error {
+	if err := writeBackup(dst, path, data, info, d.backupSuffix); err != nil {
+		return err
+	}
+	target, err := d.target(dst, path)
+	if err != nil {
+		return err
+	}
+	return atomic.WriteFile(dst, target, out, info.Mode().Perm())
+}
+
+// passThrough copies data, the contents of a file the walk skipped,
+// to its mirror so an output tree holds every matched file. In place
+// it does nothing.
+func (d walkDest) passThrough(dst afero.Fs, path string, data []byte, info fs.FileInfo) error {
+	if d.outRoot == "" {
+		return nil
+	}
+	return d.write(dst, path, data, data, info)
+}
+
+// passThroughStream is passThrough for files too large to buffer.
+func (d walkDest) passThroughStream(dst afero.Fs, path string, info fs.FileInfo) error {
+	if d.outRoot == "" {
+		return nil
+	}
+	target, err := d.target(dst, path)
+	if err != nil {
+		return err
+	}
+	return atomic.WriteStream(dst, target, info.Mode().Perm(), func(w io.Writer) error {
+		src, err := d.files.Open(path)
+		if err != nil {
+			return fmt.Errorf("open %q: %w", path, err)
+		}
+		defer func() { _ = src.Close() }()
+		_, err = io.Copy(w, src)
+		return err
+	})
+}
+
+// outputFs returns the Fs a walk of root on files writes to, and
+// rejects an output tree inside the walked tree, which later walks
+// would pick up as input.
+func outputFs(files afero.Fs, root string, opts WalkOptions) (afero.Fs, error) {
+	if opts.OutputRoot == "" {
+		return files, nil
+	}
+	if opts.OutputFs != nil && opts.OutputFs != files {
+		return opts.OutputFs, nil
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	absOut, err := filepath.Abs(opts.OutputRoot)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(absRoot, absOut)
+	if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("output root %q is inside walk root %q", opts.OutputRoot, root)
+	}
+	return files, nil
+}
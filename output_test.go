@@ -0,0 +1,207 @@
+package cipher_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/afero"
+
+	"github.com/dcadolph/cipher"
+)
+
+// TestEncodeWalkOutputRoot verifies that a walk with OutputRoot leaves
+// the walked tree alone and mirrors every matched file into the output
+// tree, keeping relative paths and modes.
+func TestEncodeWalkOutputRoot(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	boom := errors.New("boom")
+	seed := map[string]string{
+		"root/a.yaml":        "a: 1\n",
+		"root/sub/b.yaml":    "b: 2\n",
+		"root/sub/done.yaml": "enc:done\n",
+		"root/notes.txt":     "hello\n",
+	}
+	enc := cipher.EncoderFunc(func(_ context.Context, path string, data []byte) ([]byte, error) {
+		switch {
+		case strings.HasSuffix(path, "fail.yaml"):
+			return nil, boom
+		case strings.HasPrefix(string(data), "enc:"):
+			return nil, cipher.ErrAlreadyEncrypted
+		}
+		return append([]byte("enc:"), data...), nil
+	})
+	stream := cipher.StreamEncoderFunc(func(_ context.Context, _ string, dst io.Writer, src io.Reader) error {
+		if _, err := io.WriteString(dst, "stream:"); err != nil {
+			return err
+		}
+		_, err := io.Copy(dst, src)
+		return err
+	})
+	yaml := []cipher.FileMatcher{cipher.MatchExt("yaml")}
+
+	tests := []struct {
+		Name    string
+		Root    string
+		Out     string
+		OwnFs   bool
+		Extra   map[string]string
+		Opts    cipher.WalkOptions
+		Stream  bool
+		Want    map[string]string
+		WantErr string
+	}{
+		// Test 0: A separate output filesystem receives the mirror,
+		// including the already-encrypted file copied unchanged.
+		{
+			Name:  "output fs",
+			Root:  "root",
+			Out:   "root",
+			OwnFs: true,
+			Opts:  cipher.WalkOptions{BackupSuffix: ".bak", Parallelism: 2},
+			Want: map[string]string{
+				"root/a.yaml":        "enc:a: 1\n",
+				"root/sub/b.yaml":    "enc:b: 2\n",
+				"root/sub/done.yaml": "enc:done\n",
+			},
+		},
+		// Test 1: The walked filesystem takes the output tree when
+		// OutputFs is nil.
+		{
+			Name: "same fs",
+			Root: "root/sub",
+			Out:  "out",
+			Want: map[string]string{
+				"out/b.yaml":    "enc:b: 2\n",
+				"out/done.yaml": "enc:done\n",
+			},
+		},
+		// Test 2: Walking a single file writes OutputRoot itself.
+		{
+			Name: "single file",
+			Root: "root/a.yaml",
+			Out:  "out/a.enc.yaml",
+			Want: map[string]string{"out/a.enc.yaml": "enc:a: 1\n"},
+		},
+		// Test 3: A stream walk mirrors the same way.
+		{
+			Name:   "stream",
+			Root:   "root/sub",
+			Out:    "out",
+			Stream: true,
+			Want: map[string]string{
+				"out/b.yaml":    "stream:b: 2\n",
+				"out/done.yaml": "stream:enc:done\n",
+			},
+		},
+		// Test 4: A failed transactional walk writes no files.
+		{
+			Name:    "transactional failure",
+			Root:    "root",
+			Out:     "out",
+			Extra:   map[string]string{"root/sub/fail.yaml": "x: 1\n"},
+			Opts:    cipher.WalkOptions{Transactional: true},
+			Want:    map[string]string{},
+			WantErr: "boom",
+		},
+		// Test 5: An output tree inside the walked tree is refused.
+		{
+			Name:    "nested",
+			Root:    "root",
+			Out:     "root/out",
+			Want:    map[string]string{},
+			WantErr: "inside walk root",
+		},
+	}
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			t.Parallel()
+			tree := make(map[string]string, len(seed))
+			for path, data := range seed {
+				tree[path] = data
+			}
+			for path, data := range test.Extra {
+				tree[path] = data
+			}
+			files := seedTree(t, tree)
+			if err := files.Chmod("root/sub/b.yaml", 0o640); err != nil {
+				t.Fatal(err)
+			}
+			opts := test.Opts
+			opts.OutputRoot = test.Out
+			outFs := files
+			if test.OwnFs {
+				outFs = afero.NewMemMapFs()
+				opts.OutputFs = outFs
+			}
+			var err error
+			if test.Stream {
+				_, err = cipher.EncodeStreamWalkWith(ctx, files, test.Root, stream, yaml, opts)
+			} else {
+				_, err = cipher.EncodeWalkWith(ctx, files, test.Root, enc, yaml, opts)
+			}
+			if test.WantErr == "" && err != nil || test.WantErr != "" && (err == nil || !strings.Contains(err.Error(), test.WantErr)) {
+				t.Fatalf("err = %v, want %q", err, test.WantErr)
+			}
+
+			got := readTree(t, files)
+			if test.OwnFs {
+				if diff := cmp.Diff(tree, got); diff != "" {
+					t.Errorf("walked tree changed (-want +got):\n%s", diff)
+				}
+				got = readTree(t, outFs)
+			} else {
+				for path, data := range tree {
+					if got[path] != data {
+						t.Errorf("walked file %q = %q, want %q", path, got[path], data)
+					}
+					delete(got, path)
+				}
+				for path, data := range readFiles(t, files, "out") {
+					got[path] = data
+				}
+			}
+			if diff := cmp.Diff(test.Want, got); diff != "" {
+				t.Errorf("output (-want +got):\n%s", diff)
+			}
+			for path := range test.Want {
+				if !strings.HasSuffix(path, "b.yaml") {
+					continue
+				}
+				info, err := outFs.Stat(path)
+				if err != nil || info.Mode().Perm() != 0o640 {
+					t.Errorf("%q mode = %v, %v, want 0640", path, info, err)
+				}
+			}
+		})
+	}
+}
+
+// readFiles returns every file under dir on files, keyed by slash
+// path, or nothing when dir does not exist.
+func readFiles(t *testing.T, files afero.Fs, dir string) map[string]string {
+	t.Helper()
+	got := make(map[string]string)
+	err := afero.Walk(files, dir, func(path string, info fs.FileInfo, err error) error {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return nil
+		case err != nil || info.IsDir():
+			return err
+		}
+		data, err := afero.ReadFile(files, path)
+		got[filepath.ToSlash(path)] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("walk %q: %v", dir, err)
+	}
+	return got
+}
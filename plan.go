@@ -0,0 +1,197 @@
+package cipher
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+
+	"github.com/spf13/afero"
+
+	"github.com/dcadolph/cipher/internal/sopsx"
+)
+
+// PlanAction is what a dry-run walk would do to a file.
+type PlanAction string
+
+const (
+	// PlanEncrypt means the file would be encrypted.
+	PlanEncrypt PlanAction = "encrypt"
+	// PlanDecrypt means the file would be decrypted.
+	PlanDecrypt PlanAction = "decrypt"
+	// PlanRotate means the file would be rotated.
+	PlanRotate PlanAction = "rotate"
+	// PlanSkip means the walk would leave the file alone; FilePlan.Reason
+	// says why, as it would be reported to OnSkip.
+	PlanSkip PlanAction = "skip"
+	// PlanError means the file would fail; FilePlan.Reason holds the
+	// error.
+	PlanError PlanAction = "error"
+)
+
+// FilePlan is what a dry-run walk would do to one file. See
+// WalkOptions.DryRun.
+type FilePlan struct {
+	// Path is the file path.
+	Path string
+	// Action is what the walk would do.
+	Action PlanAction
+	// Reason is the skip reason for PlanSkip and the error for
+	// PlanError. Nil otherwise.
+	Reason error
+	// Rule describes the routing rule that would pick the recipients,
+	// when the walk's encoder implements RuleDescriber. Empty otherwise.
+	Rule string
+}
+
+// RuleDescriber is implemented by Encoders, StreamEncoders, and
+// Routers that pick recipients per path, such as those returned by
+// NewRoutedEncoder and NewRouter. Dry-run walks use it to report which
+// rule would apply to each file.
+type RuleDescriber interface {
+	// DescribeRule returns a short description of the rule that applies
+	// to path, such as its name. Returns an error wrapping
+	// ErrNoMatchingRule when no rule applies.
+	DescribeRule(path string) (string, error)
+}
+
+// describeRule returns v's description of the rule for path, or ""
+// when v does not implement RuleDescriber.
+func describeRule(v any, path string) (string, error) {
+	if d, ok := v.(RuleDescriber); ok {
+		return d.DescribeRule(path)
+	}
+	return "", nil
+}
+
+// describeRoute is DescribeRule for encoders built on router. A router
+// that does not describe its rules still reports paths it cannot
+// route.
+func describeRoute(router Router, path string) (string, error) {
+	if d, ok := router.(RuleDescriber); ok {
+		return d.DescribeRule(path)
+	}
+	_, _, err := router.Resolve(path)
+	return "", err
+}
+
+// planFunc returns what a walk would do to the file at path.
+type planFunc func(files afero.Fs, path string) FilePlan
+
+// runPlan is runWalk for dry runs. It plans every file with plan and
+// reports the result, and every file enumeration skips, to
+// opts.OnPlan. Nothing is written; OnFile and OnSkip are not called,
+// and the result lists no files.
+func runPlan(
+	ctx context.Context, files afero.Fs, root string,
+	matchers []FileMatcher, opts WalkOptions, plan planFunc,
+) (*WalkResult, error) {
+	onPlan := opts.OnPlan
+	opts.OnFile = nil
+	opts.OnSkip = func(path string, reason error) {
+		notifyPlan(onPlan, FilePlan{Path: path, Action: PlanSkip, Reason: reason})
+	}
+	_, err := runWalk(ctx, files, root, matchers, opts, newWalkRecorder(),
+		func(_ context.Context, _ afero.Fs, path string, _ fs.FileInfo) error {
+			notifyPlan(onPlan, plan(files, path))
+			return nil
+		})
+	return &WalkResult{}, err
+}
+
+// notifyPlan invokes cb with p if cb is non-nil.
+func notifyPlan(cb func(FilePlan), p FilePlan) {
+	if cb != nil {
+		cb(p)
+	}
+}
+
+// planEncode plans encrypting a file with enc: already-encrypted and
+// empty files are skipped, and files that do not parse, or that enc
+// has no rule for, would fail.
+func planEncode(enc Encoder) planFunc {
+	return func(files afero.Fs, path string) FilePlan {
+		data, err := afero.ReadFile(files, path)
+		if err != nil {
+			return FilePlan{Path: path, Action: PlanError, Reason: fmt.Errorf("read %q: %w", path, err)}
+		}
+		if IsEncryptedPath(path, data) {
+			return FilePlan{Path: path, Action: PlanSkip, Reason: ErrAlreadyEncrypted}
+		}
+		branches, err := sopsx.StoreFor(FormatForPath(path)).LoadPlainFile(data)
+		switch {
+		case err != nil:
+			return FilePlan{Path: path, Action: PlanError, Reason: fmt.Errorf("encode %q: %w: %w", path, ErrParse, err)}
+		case len(branches) == 0:
+			return FilePlan{Path: path, Action: PlanSkip, Reason: ErrEmpty}
+		}
+		return planRoute(enc, path, PlanEncrypt)
+	}
+}
+
+// planDecode plans decrypting a file: files that are not encrypted are
+// skipped, and files whose metadata does not parse would fail.
+func planDecode(files afero.Fs, path string) FilePlan {
+	data, err := afero.ReadFile(files, path)
+	if err != nil {
+		return FilePlan{Path: path, Action: PlanError, Reason: fmt.Errorf("read %q: %w", path, err)}
+	}
+	if !IsEncryptedPath(path, data) {
+		return FilePlan{Path: path, Action: PlanSkip, Reason: ErrNotEncrypted}
+	}
+	if _, err := InspectPath(path, data); err != nil {
+		return FilePlan{Path: path, Action: PlanError, Reason: fmt.Errorf("decode %q: %w", path, err)}
+	}
+	return FilePlan{Path: path, Action: PlanDecrypt}
+}
+
+// planRotate plans rotating a file with enc: it is planDecode with the
+// rule enc would apply.
+func planRotate(enc Encoder) planFunc {
+	return func(files afero.Fs, path string) FilePlan {
+		p := planDecode(files, path)
+		if p.Action != PlanDecrypt {
+			return p
+		}
+		return planRoute(enc, path, PlanRotate)
+	}
+}
+
+// planEncodeStream plans stream-encrypting a file with enc from its
+// first bytes: empty files and cipher streams are skipped.
+func planEncodeStream(enc StreamEncoder) planFunc {
+	return func(files afero.Fs, path string) FilePlan {
+		prefix, err := readPrefix(files, path, len(sopsx.StreamMagic)+1)
+		switch {
+		case err != nil:
+			return FilePlan{Path: path, Action: PlanError, Reason: err}
+		case len(prefix) == 0:
+			return FilePlan{Path: path, Action: PlanSkip, Reason: ErrEmpty}
+		case sopsx.IsEncryptedStream(prefix):
+			return FilePlan{Path: path, Action: PlanSkip, Reason: ErrAlreadyEncrypted}
+		}
+		return planRoute(enc, path, PlanEncrypt)
+	}
+}
+
+// planDecodeStream plans stream-decrypting a file from its first
+// bytes: files that are not cipher streams are skipped.
+func planDecodeStream(files afero.Fs, path string) FilePlan {
+	prefix, err := readPrefix(files, path, len(sopsx.StreamMagic)+1)
+	switch {
+	case err != nil:
+		return FilePlan{Path: path, Action: PlanError, Reason: err}
+	case !sopsx.IsEncryptedStream(prefix):
+		return FilePlan{Path: path, Action: PlanSkip, Reason: ErrNotEncrypted}
+	}
+	return FilePlan{Path: path, Action: PlanDecrypt}
+}
+
+// planRoute returns action for path with the rule enc describes, or
+// PlanError when enc has no rule for path.
+func planRoute(enc any, path string, action PlanAction) FilePlan {
+	rule, err := describeRule(enc, path)
+	if err != nil {
+		return FilePlan{Path: path, Action: PlanError, Reason: fmt.Errorf("encode %q: %w", path, err)}
+	}
+	return FilePlan{Path: path, Action: action, Rule: rule}
+}
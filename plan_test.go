@@ -0,0 +1,144 @@
+package cipher_test
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/spf13/afero"
+
+	"github.com/dcadolph/cipher"
+	cipherage "github.com/dcadolph/cipher/age"
+)
+
+// TestWalkDryRun verifies that every walk plans each file on a dry run,
+// naming the router rule that would apply, and writes nothing.
+func TestWalkDryRun(t *testing.T) {
+	recipient := newAgeIdentity(t)
+	kp := cipherage.MustNewProvider(recipient)
+	ctx := context.Background()
+	encrypted, err := cipher.NewEncoder(kp).Encode(ctx, "x.yaml", []byte("k: v\n"))
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	seed := map[string][]byte{
+		"root/prod/a.yaml":     []byte("k: v\n"),
+		"root/prod/enc.yaml":   encrypted,
+		"root/prod/bad.json":   []byte("{"),
+		"root/prod/empty.yaml": []byte(""),
+		"root/dev/b.yaml":      []byte("k: v\n"),
+		"root/notes.txt":       []byte("hello"),
+	}
+	files := afero.NewMemMapFs()
+	for path, data := range seed {
+		if err := afero.WriteFile(files, path, data, 0o600); err != nil {
+			t.Fatalf("write %q: %v", path, err)
+		}
+	}
+	router := cipher.NewRouter(cipher.Rule{
+		Name:     "prod",
+		Match:    cipher.MatchRegex(regexp.MustCompile(`^root/prod/`)),
+		Provider: kp,
+	})
+	routed := cipher.NewRoutedEncoder(router, cipher.EncoderOptions{})
+	matchers := []cipher.FileMatcher{cipher.MatchExt("yaml", "json")}
+	skipMatcher := cipher.FilePlan{Path: "root/notes.txt", Action: cipher.PlanSkip, Reason: cmpopts.AnyError}
+
+	tests := []struct {
+		Name string
+		Walk func(opts cipher.WalkOptions) (*cipher.WalkResult, error)
+		Want []cipher.FilePlan
+	}{
+		// Test 0: Encode names the rule, skips encrypted and empty
+		// files, and reports parse and routing failures.
+		{
+			Name: "encode",
+			Walk: func(opts cipher.WalkOptions) (*cipher.WalkResult, error) {
+				return cipher.EncodeWalkWith(ctx, files, "root", routed, matchers, opts)
+			},
+			Want: []cipher.FilePlan{
+				{Path: "root/dev/b.yaml", Action: cipher.PlanError, Reason: cipher.ErrNoMatchingRule},
+				skipMatcher,
+				{Path: "root/prod/a.yaml", Action: cipher.PlanEncrypt, Rule: "prod"},
+				{Path: "root/prod/bad.json", Action: cipher.PlanError, Reason: cipher.ErrParse},
+				{Path: "root/prod/empty.yaml", Action: cipher.PlanSkip, Reason: cipher.ErrEmpty},
+				{Path: "root/prod/enc.yaml", Action: cipher.PlanSkip, Reason: cipher.ErrAlreadyEncrypted},
+			},
+		},
+		// Test 1: Decode plans only encrypted files.
+		{
+			Name: "decode",
+			Walk: func(opts cipher.WalkOptions) (*cipher.WalkResult, error) {
+				return cipher.DecodeWalkWith(ctx, files, "root/prod", cipher.NewDecoder(), matchers, opts)
+			},
+			Want: []cipher.FilePlan{
+				{Path: "root/prod/a.yaml", Action: cipher.PlanSkip, Reason: cipher.ErrNotEncrypted},
+				{Path: "root/prod/bad.json", Action: cipher.PlanSkip, Reason: cipher.ErrNotEncrypted},
+				{Path: "root/prod/empty.yaml", Action: cipher.PlanSkip, Reason: cipher.ErrNotEncrypted},
+				{Path: "root/prod/enc.yaml", Action: cipher.PlanDecrypt},
+			},
+		},
+		// Test 2: Rotate without a router names no rule.
+		{
+			Name: "rotate",
+			Walk: func(opts cipher.WalkOptions) (*cipher.WalkResult, error) {
+				return cipher.RotateWalkWith(ctx, files, "root/prod", cipher.NewEncoder(kp), cipher.NewDecoder(),
+					[]cipher.FileMatcher{cipher.MatchExt("yaml")}, opts)
+			},
+			Want: []cipher.FilePlan{
+				{Path: "root/prod/a.yaml", Action: cipher.PlanSkip, Reason: cipher.ErrNotEncrypted},
+				{Path: "root/prod/bad.json", Action: cipher.PlanSkip, Reason: cmpopts.AnyError},
+				{Path: "root/prod/empty.yaml", Action: cipher.PlanSkip, Reason: cipher.ErrNotEncrypted},
+				{Path: "root/prod/enc.yaml", Action: cipher.PlanRotate},
+			},
+		},
+		// Test 3: Stream encode plans from each file's first bytes.
+		{
+			Name: "encode stream",
+			Walk: func(opts cipher.WalkOptions) (*cipher.WalkResult, error) {
+				enc := cipher.NewRoutedStreamEncoder(router, cipher.StreamEncoderOptions{})
+				return cipher.EncodeStreamWalkWith(ctx, files, "root/prod",
+					enc, []cipher.FileMatcher{cipher.MatchExt("yaml")}, opts)
+			},
+			Want: []cipher.FilePlan{
+				{Path: "root/prod/a.yaml", Action: cipher.PlanEncrypt, Rule: "prod"},
+				{Path: "root/prod/bad.json", Action: cipher.PlanSkip, Reason: cmpopts.AnyError},
+				{Path: "root/prod/empty.yaml", Action: cipher.PlanSkip, Reason: cipher.ErrEmpty},
+				{Path: "root/prod/enc.yaml", Action: cipher.PlanEncrypt, Rule: "prod"},
+			},
+		},
+	}
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			var got []cipher.FilePlan
+			opts := cipher.WalkOptions{
+				DryRun:       true,
+				Parallelism:  2,
+				BackupSuffix: ".bak",
+				OnPlan:       func(p cipher.FilePlan) { got = append(got, p) },
+				OnFile:       func(path string, _ int) { t.Errorf("OnFile(%q) on a dry run", path) },
+				OnSkip:       func(path string, _ error) { t.Errorf("OnSkip(%q) on a dry run", path) },
+			}
+			if _, err := test.Walk(opts); err != nil {
+				t.Fatalf("walk: %v", err)
+			}
+			slices.SortFunc(got, func(a, b cipher.FilePlan) int { return strings.Compare(a.Path, b.Path) })
+			if diff := cmp.Diff(test.Want, got, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("plans (-want +got):\n%s", diff)
+			}
+			for path, data := range seed {
+				if after, _ := afero.ReadFile(files, path); string(after) != string(data) {
+					t.Errorf("dry run rewrote %s", path)
+				}
+				if ok, _ := afero.Exists(files, path+".bak"); ok {
+					t.Errorf("dry run backed up %s", path)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+// Package policy enforces organizational rules on the recipients of
+// sops-encrypted files.
+//
+// A .sops.yaml says which recipients new files get. A policy says what
+// every encrypted file must look like, whoever wrote it: that files
+// under prod/ include a KMS key and a break-glass age key, or that
+// files under payments/ need two key groups to decrypt. Rules are
+// checked against the metadata [cipher.Inspect] reads, so no payload is
+// decrypted and no identity is needed.
+//
+// # Policy file
+//
+//	rules:
+//	  - name: prod-keys
+//	    path_regex: ^prod/
+//	    require_types: [kms]
+//	    require_recipients: [age1breakglass...]
+//	  - name: payments-quorum
+//	    path_regex: ^(prod/)?payments/
+//	    min_shamir_threshold: 2
+//	    forbid_types: [pgp]
+//
+// Every rule whose path_regex matches a path applies to it, so rules
+// add up rather than the first one winning. A file that matches a rule
+// but is not encrypted is a violation.
+//
+// # Entry points
+//
+//   - [Policy.Check] checks one file's metadata.
+//   - [Policy.CheckBytes] inspects file content and checks it.
+//   - [Policy.CheckWalk] checks every file under a directory.
+//
+// precommit.WithPolicy applies a policy to the files a pre-commit hook
+// checks, and `cipher policy check` runs CheckWalk from the CLI.
+package policy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/spf13/afero"
+	"go.yaml.in/yaml/v3"
+
+	"github.com/dcadolph/cipher"
+)
+
+// FileName is the default policy file name.
+const FileName = ".cipher-policy.yaml"
+
+// ErrInvalid wraps every error from parsing or validating a policy.
+var ErrInvalid = errors.New("policy: invalid")
+
+// Violation codes recorded in Violation.Code.
+const (
+	// CodeNotEncrypted means the file matches a rule but is not
+	// sops-encrypted.
+	CodeNotEncrypted = "not-encrypted"
+	// CodeMissingType means no recipient has a required key type.
+	CodeMissingType = "missing-type"
+	// CodeMissingRecipient means a required recipient is absent.
+	CodeMissingRecipient = "missing-recipient"
+	// CodeForbiddenType means a recipient has a forbidden key type.
+	CodeForbiddenType = "forbidden-type"
+	// CodeForbiddenRecipient means a forbidden recipient is present.
+	CodeForbiddenRecipient = "forbidden-recipient"
+	// CodeShamirThreshold means fewer key groups are needed to decrypt
+	// than the rule requires.
+	CodeShamirThreshold = "shamir-threshold"
+)
+
+// keyTypes are the recipient types a rule may name, as reported in
+// cipher.RecipientInfo.Type.
+var keyTypes = []string{"age", "azure_kv", "gcp_kms", "hc_vault", "hckms", "kms", "pgp"}
+
+// Policy is a parsed policy file.
+type Policy struct {
+	// Rules are checked in order; every matching rule applies.
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule constrains the recipients of files whose path matches
+// PathRegex. Zero-valued constraints are not checked.
+type Rule struct {
+	// Name identifies the rule in violations. Defaults to "rule N",
+	// counting from 1.
+	Name string `yaml:"name"`
+	// PathRegex selects the files the rule applies to. Required.
+	PathRegex string `yaml:"path_regex"`
+	// RequireTypes lists key types, such as "kms" or "age", of which
+	// each file needs at least one recipient.
+	RequireTypes []string `yaml:"require_types"`
+	// RequireRecipients lists recipient identifiers every file must
+	// include: an age recipient, KMS ARN, PGP fingerprint, and so on.
+	RequireRecipients []string `yaml:"require_recipients"`
+	// ForbidTypes lists key types no recipient may have.
+	ForbidTypes []string `yaml:"forbid_types"`
+	// ForbidRecipients lists recipient identifiers no file may include.
+	ForbidRecipients []string `yaml:"forbid_recipients"`
+	// MinShamirThreshold is the minimum number of key groups needed to
+	// decrypt a file. A file without a threshold needs all its groups.
+	MinShamirThreshold int `yaml:"min_shamir_threshold"`
+
+	re *regexp.Regexp
+}
+
+// Violation is one rule a file breaks.
+type Violation struct {
+	// Path is the offending file path.
+	Path string `json:"path"`
+	// Rule is the name of the rule broken.
+	Rule string `json:"rule"`
+	// Code categorizes the violation; one of the Code constants.
+	Code string `json:"code"`
+	// Message is a short human-readable explanation.
+	Message string `json:"message"`
+}
+
+// Error returns v as an error string.
+func (v Violation) Error() string {
+	return fmt.Sprintf("%s: %s: %s", v.Path, v.Rule, v.Message)
+}
+
+// Parse parses and validates a policy file. Unknown fields, regexes
+// that do not compile, unknown key types, and rules without a
+// path_regex are errors wrapping ErrInvalid.
+func Parse(data []byte) (*Policy, error) {
+	var p Policy
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&p); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	if len(p.Rules) == 0 {
+		return nil, fmt.Errorf("%w: no rules defined", ErrInvalid)
+	}
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if r.PathRegex == "" {
+			return nil, fmt.Errorf("%w: %s: path_regex required", ErrInvalid, r.Name)
+		}
+		re, err := regexp.Compile(r.PathRegex)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: path_regex: %w", ErrInvalid, r.Name, err)
+		}
+		r.re = re
+		for _, t := range slices.Concat(r.RequireTypes, r.ForbidTypes) {
+			if !slices.Contains(keyTypes, t) {
+				return nil, fmt.Errorf("%w: %s: unknown key type %q (want one of %s)",
+					ErrInvalid, r.Name, t, strings.Join(keyTypes, ", "))
+			}
+		}
+		if r.MinShamirThreshold < 0 {
+			return nil, fmt.Errorf("%w: %s: min_shamir_threshold must not be negative", ErrInvalid, r.Name)
+		}
+	}
+	return &p, nil
+}
+
+// Load reads and parses the policy file at path. If path is a
+// directory, FileName is appended.
+func Load(path string) (*Policy, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("policy: stat %q: %w", path, err)
+	}
+	if info.IsDir() {
+		path = filepath.Join(path, FileName)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("policy: read %q: %w", path, err)
+	}
+	p, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", path, err)
+	}
+	return p, nil
+}
+
+// LoadFromDir loads the nearest FileName starting at dir and walking
+// up to the filesystem root. Returns an error wrapping os.ErrNotExist
+// if no policy is found.
+func LoadFromDir(dir string) (*Policy, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("policy: abs %q: %w", dir, err)
+	}
+	for cur := abs; ; {
+		candidate := filepath.Join(cur, FileName)
+		if _, err := os.Stat(candidate); err == nil {
+			return Load(candidate)
+		}
+		parent := filepath.Dir(cur)
+		if parent == cur {
+			return nil, fmt.Errorf("policy: no %s found at or above %q: %w", FileName, abs, os.ErrNotExist)
+		}
+		cur = parent
+	}
+}
+
+// Matches reports whether any rule applies to path.
+func (p *Policy) Matches(path string) bool {
+	path = filepath.ToSlash(path)
+	return slices.ContainsFunc(p.Rules, func(r Rule) bool { return r.re.MatchString(path) })
+}
+
+// Check returns the violations of every rule that applies to path,
+// given the file's metadata. A nil info means the file is not
+// encrypted.
+func (p *Policy) Check(path string, info *cipher.Info) []Violation {
+	match := filepath.ToSlash(path)
+	var out []Violation
+	for _, r := range p.Rules {
+		if !r.re.MatchString(match) {
+			continue
+		}
+		add := func(code, format string, args ...any) {
+			out = append(out, Violation{Path: path, Rule: r.Name, Code: code, Message: fmt.Sprintf(format, args...)})
+		}
+		if info == nil {
+			add(CodeNotEncrypted, "not sops-encrypted")
+			continue
+		}
+		var types, ids []string
+		for _, group := range info.Groups {
+			for _, rcpt := range group {
+				types = append(types, rcpt.Type)
+				ids = append(ids, rcpt.Identifier)
+			}
+		}
+		for _, t := range r.RequireTypes {
+			if !slices.Contains(types, t) {
+				add(CodeMissingType, "no %s recipient", t)
+			}
+		}
+		for _, id := range r.RequireRecipients {
+			if !slices.Contains(ids, id) {
+				add(CodeMissingRecipient, "missing recipient %s", id)
+			}
+		}
+		for _, t := range r.ForbidTypes {
+			if slices.Contains(types, t) {
+				add(CodeForbiddenType, "has %s recipient", t)
+			}
+		}
+		for _, id := range r.ForbidRecipients {
+			if slices.Contains(ids, id) {
+				add(CodeForbiddenRecipient, "has forbidden recipient %s", id)
+			}
+		}
+		if r.MinShamirThreshold > 0 {
+			threshold := info.ShamirThreshold
+			if threshold == 0 || threshold > len(info.Groups) {
+				threshold = len(info.Groups)
+			}
+			if threshold < r.MinShamirThreshold {
+				add(CodeShamirThreshold, "decrypts with %d key group(s), want at least %d",
+					threshold, r.MinShamirThreshold)
+			}
+		}
+	}
+	return out
+}
+
+// CheckBytes inspects data, the content of path, and returns the
+// violations of every rule that applies to it. Files no rule applies
+// to are not inspected.
+func (p *Policy) CheckBytes(path string, data []byte) []Violation {
+	if !p.Matches(path) {
+		return nil
+	}
+	info, err := cipher.InspectPath(path, data)
+	if err != nil {
+		info = nil
+	}
+	return p.Check(path, info)
+}
+
+// CheckWalk checks every file under root on files that matchers
+// accept, or every file when matchers is empty. Rules match each
+// path relative to root; violations report the full path.
+func (p *Policy) CheckWalk(
+	ctx context.Context, files afero.Fs, root string, matchers []cipher.FileMatcher,
+) ([]Violation, error) {
+	if files == nil {
+		panic("policy: CheckWalk: filesystem required")
+	}
+	var out []Violation
+	err := afero.Walk(files, root, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if len(matchers) > 0 && !cipher.MatchAnyOf(matchers...).Match(path) {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if !p.Matches(rel) {
+			return nil
+		}
+		data, err := afero.ReadFile(files, path)
+		if err != nil {
+			return fmt.Errorf("policy: read %q: %w", path, err)
+		}
+		for _, v := range p.CheckBytes(rel, data) {
+			v.Path = path
+			out = append(out, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
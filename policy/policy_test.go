@@ -0,0 +1,198 @@
+package policy_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/afero"
+
+	"github.com/dcadolph/cipher"
+	"github.com/dcadolph/cipher/ciphertest"
+	"github.com/dcadolph/cipher/policy"
+)
+
+// mustParse parses body or fails the test.
+func mustParse(t *testing.T, body string) *policy.Policy {
+	t.Helper()
+	p, err := policy.Parse([]byte(body))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	return p
+}
+
+// info returns metadata with one key group per entry of groups, each a
+// list of type:identifier pairs.
+func info(threshold int, groups ...[]cipher.RecipientInfo) *cipher.Info {
+	return &cipher.Info{Format: cipher.FormatYAML, ShamirThreshold: threshold, Groups: groups}
+}
+
+// TestParseErrors checks that malformed policies are rejected with
+// ErrInvalid.
+func TestParseErrors(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		Name string
+		Body string
+	}{{ // Test 0: No rules.
+		Name: "empty", Body: "",
+	}, { // Test 1: Missing path_regex.
+		Name: "no regex", Body: "rules:\n  - require_types: [kms]\n",
+	}, { // Test 2: Regex that does not compile.
+		Name: "bad regex", Body: "rules:\n  - path_regex: '('\n",
+	}, { // Test 3: Unknown key type.
+		Name: "bad type", Body: "rules:\n  - path_regex: x\n    require_types: [kmss]\n",
+	}, { // Test 4: Misspelled field.
+		Name: "unknown field", Body: "rules:\n  - path_regex: x\n    require_type: [kms]\n",
+	}, { // Test 5: Negative threshold.
+		Name: "negative", Body: "rules:\n  - path_regex: x\n    min_shamir_threshold: -1\n",
+	}}
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			t.Parallel()
+			if _, err := policy.Parse([]byte(test.Body)); !errors.Is(err, policy.ErrInvalid) {
+				t.Errorf("err = %v, want ErrInvalid", err)
+			}
+		})
+	}
+}
+
+// TestCheck checks the violations reported for one file's metadata.
+func TestCheck(t *testing.T) {
+	t.Parallel()
+	p := mustParse(t, `rules:
+  - name: prod
+    path_regex: ^prod/
+    require_types: [kms]
+    require_recipients: [age1breakglass]
+    forbid_types: [pgp]
+  - path_regex: payments/
+    min_shamir_threshold: 2
+    forbid_recipients: [age1former]
+`)
+	kms := cipher.RecipientInfo{Type: "kms", Identifier: "arn:aws:kms:us-east-1:1:key/a"}
+	glass := cipher.RecipientInfo{Type: "age", Identifier: "age1breakglass"}
+	former := cipher.RecipientInfo{Type: "age", Identifier: "age1former"}
+	gpg := cipher.RecipientInfo{Type: "pgp", Identifier: "ABCD"}
+
+	tests := []struct {
+		Name string
+		Path string
+		Info *cipher.Info
+		Want []string
+	}{{ // Test 0: Compliant prod file.
+		Name: "compliant", Path: "prod/db.yaml", Info: info(0, []cipher.RecipientInfo{kms, glass}),
+	}, { // Test 1: Unmatched path is not checked.
+		Name: "unmatched", Path: "dev/db.yaml",
+	}, { // Test 2: Plaintext under a rule.
+		Name: "plaintext", Path: "prod/db.yaml", Want: []string{"prod not-encrypted"},
+	}, { // Test 3: Missing type and recipient, forbidden type.
+		Name: "prod gaps", Path: "prod/db.yaml", Info: info(0, []cipher.RecipientInfo{gpg}),
+		Want: []string{"prod missing-type", "prod missing-recipient", "prod forbidden-type"},
+	}, { // Test 4: Rules add up, and one group falls short of the threshold.
+		Name: "both rules", Path: "prod/payments/k.yaml", Info: info(0, []cipher.RecipientInfo{kms, glass, former}),
+		Want: []string{"rule 2 forbidden-recipient", "rule 2 shamir-threshold"},
+	}, { // Test 5: Two groups with no threshold need both.
+		Name: "default threshold", Path: "payments/k.yaml",
+		Info: info(0, []cipher.RecipientInfo{kms}, []cipher.RecipientInfo{glass}),
+	}, { // Test 6: Two groups with threshold one.
+		Name: "low threshold", Path: "payments/k.yaml",
+		Info: info(1, []cipher.RecipientInfo{kms}, []cipher.RecipientInfo{glass}),
+		Want: []string{"rule 2 shamir-threshold"},
+	}}
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			t.Parallel()
+			var got []string
+			for _, v := range p.Check(test.Path, test.Info) {
+				if v.Path != test.Path {
+					t.Errorf("violation path = %q", v.Path)
+				}
+				got = append(got, v.Rule+" "+v.Code)
+			}
+			if diff := cmp.Diff(test.Want, got); diff != "" {
+				t.Errorf("violations (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestCheckHCKMS checks that rules can name the hckms key type.
+func TestCheckHCKMS(t *testing.T) {
+	t.Parallel()
+	p := mustParse(t, "rules:\n  - path_regex: .\n    require_types: [hckms]\n")
+	got := p.Check("a.yaml", info(0, []cipher.RecipientInfo{{Type: "age", Identifier: "age1x"}}))
+	if len(got) != 1 || got[0].Code != policy.CodeMissingType || got[0].Message != "no hckms recipient" {
+		t.Errorf("violations = %+v, want one missing hckms", got)
+	}
+	hckms := cipher.RecipientInfo{Type: "hckms", Identifier: "tr-west-1:key"}
+	if got := p.Check("a.yaml", info(0, []cipher.RecipientInfo{hckms})); len(got) != 0 {
+		t.Errorf("violations = %+v, want none", got)
+	}
+}
+
+// TestCheckWalk checks every matching file under a tree and reports
+// violations in path order.
+func TestCheckWalk(t *testing.T) {
+	kp, recipient := ciphertest.NewProvider(t)
+	ctx := context.Background()
+	files := afero.NewMemMapFs()
+	ct, err := cipher.NewEncoder(kp).Encode(ctx, "a.yaml", []byte("a: 1\n"))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	for path, data := range map[string][]byte{
+		"/repo/prod/a.yaml":    ct,
+		"/repo/prod/b.yaml":    []byte("b: 2\n"),
+		"/repo/prod/c.txt":     []byte("c"),
+		"/repo/dev/plain.yaml": []byte("d: 4\n"),
+	} {
+		if err := afero.WriteFile(files, path, data, 0o600); err != nil {
+			t.Fatalf("seed %s: %v", path, err)
+		}
+	}
+	p := mustParse(t, "rules:\n  - name: prod\n    path_regex: ^prod/\n    require_types: [kms]\n    forbid_recipients: ["+recipient+"]\n")
+
+	got, err := p.CheckWalk(ctx, files, "/repo", []cipher.FileMatcher{cipher.MatchExt("yaml")})
+	if err != nil {
+		t.Fatalf("CheckWalk: %v", err)
+	}
+	want := []policy.Violation{
+		{Path: "/repo/prod/a.yaml", Rule: "prod", Code: policy.CodeMissingType, Message: "no kms recipient"},
+		{Path: "/repo/prod/a.yaml", Rule: "prod", Code: policy.CodeForbiddenRecipient, Message: "has forbidden recipient " + recipient},
+		{Path: "/repo/prod/b.yaml", Rule: "prod", Code: policy.CodeNotEncrypted, Message: "not sops-encrypted"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("violations (-want +got):\n%s", diff)
+	}
+}
+
+// TestLoadFromDir finds the policy file in a parent directory and
+// reports ErrNotExist when there is none.
+func TestLoadFromDir(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	deep := filepath.Join(dir, "a", "b")
+	if err := os.MkdirAll(deep, 0o700); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if _, err := policy.LoadFromDir(deep); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("LoadFromDir without policy: err = %v, want ErrNotExist", err)
+	}
+	body := []byte("rules:\n  - path_regex: .\n")
+	if err := os.WriteFile(filepath.Join(dir, policy.FileName), body, 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	p, err := policy.LoadFromDir(deep)
+	if err != nil {
+		t.Fatalf("LoadFromDir: %v", err)
+	}
+	if len(p.Rules) != 1 || p.Rules[0].Name != "rule 1" {
+		t.Errorf("rules = %+v", p.Rules)
+	}
+}
@@ -15,6 +15,8 @@
 //     sops-encrypted file via [cipher.IsEncrypted].
 //   - Returns a [Violation] for any file that matches a rule but is
 //     not sops-encrypted.
+//   - With [WithPolicy], also returns a Violation for every policy rule
+//     the file breaks, such as a missing KMS recipient.
 //
 // # Three entry points
 //
@@ -51,6 +53,7 @@ import (
 	"strings"
 
 	"github.com/dcadolph/cipher"
+	"github.com/dcadolph/cipher/policy"
 	"github.com/dcadolph/cipher/sopsconfig"
 )
 
@@ -64,7 +67,7 @@ const DefaultMaxStagedBytes int64 = 64 << 20
 var ErrTooLarge = errors.New("precommit: staged blob exceeds size limit")
 
 // Violation is a single offending file: it matches a creation rule in
-// the .sops.yaml but is not sops-encrypted.
+// the .sops.yaml but is not sops-encrypted, or it breaks a policy rule.
 type Violation struct {
 	// Path is the offending file path.
 	Path string
@@ -80,6 +83,7 @@ func (v Violation) Error() string {
 // Checker scans files against a single resolved sops config.
 type Checker struct {
 	cfg            *sopsconfig.Config
+	policy         *policy.Policy
 	maxStagedBytes int64
 }
 
@@ -97,6 +101,15 @@ func WithMaxStagedBytes(n int64) Option {
 	}
 }
 
+// WithPolicy also checks every file against p. Policy rules match the
+// path as passed to CheckPaths or CheckBytes, or relative to the
+// repository root for CheckStaged.
+func WithPolicy(p *policy.Policy) Option {
+	return func(c *Checker) {
+		c.policy = p
+	}
+}
+
 // NewChecker returns a Checker rooted at configPath. configPath may be
 // a directory (in which case .sops.yaml is appended) or a file.
 func NewChecker(configPath string, opts ...Option) (*Checker, error) {
@@ -135,9 +148,7 @@ func (c *Checker) CheckPaths(paths []string) ([]Violation, error) {
 		if err != nil {
 			return nil, err
 		}
-		if v != nil {
-			violations = append(violations, *v)
-		}
+		violations = append(violations, v...)
 	}
 	return violations, nil
 }
@@ -152,9 +163,7 @@ func (c *Checker) CheckBytes(items []PathBytes) ([]Violation, error) {
 		if err != nil {
 			return nil, err
 		}
-		if v != nil {
-			violations = append(violations, *v)
-		}
+		violations = append(violations, v...)
 	}
 	return violations, nil
 }
@@ -186,15 +195,17 @@ type PathBytes struct {
 	Data []byte
 }
 
-// checkPath returns a Violation if path matches a creation rule but is
-// not sops-encrypted. data is the file content; if nil, it is loaded
-// from disk using os.ReadFile.
-func (c *Checker) checkPath(path string, data []byte) (*Violation, error) {
+// checkPath returns the Violations of path: one if it matches a
+// creation rule but is not sops-encrypted, and one per policy rule it
+// breaks. data is the file content; if nil, it is loaded from disk
+// using os.ReadFile.
+func (c *Checker) checkPath(path string, data []byte) ([]Violation, error) {
 	matched, err := c.cfg.MatchesAnyRule(path, nil)
 	if err != nil {
 		return nil, fmt.Errorf("precommit: match %q: %w", path, err)
 	}
-	if !matched {
+	governed := c.policy != nil && c.policy.Matches(path)
+	if !matched && !governed {
 		return nil, nil
 	}
 	if data == nil {
@@ -203,13 +214,25 @@ func (c *Checker) checkPath(path string, data []byte) (*Violation, error) {
 			return nil, fmt.Errorf("precommit: read %q: %w", path, err)
 		}
 	}
-	if cipher.IsEncryptedPath(path, data) {
-		return nil, nil
+	var violations []Violation
+	if matched && !cipher.IsEncryptedPath(path, data) {
+		violations = append(violations, Violation{
+			Path:   path,
+			Reason: "matches sops creation rule but is not sops-encrypted",
+		})
 	}
-	return &Violation{
-		Path:   path,
-		Reason: "matches sops creation rule but is not sops-encrypted",
-	}, nil
+	if governed {
+		for _, v := range c.policy.CheckBytes(path, data) {
+			if v.Code == policy.CodeNotEncrypted && len(violations) > 0 {
+				continue
+			}
+			violations = append(violations, Violation{
+				Path:   path,
+				Reason: fmt.Sprintf("policy %s: %s", v.Rule, v.Message),
+			})
+		}
+	}
+	return violations, nil
 }
 
 // gitStagedPaths shells out to git to list paths staged for commit.
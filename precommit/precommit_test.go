@@ -9,9 +9,11 @@ import (
 	"testing"
 
 	"filippo.io/age"
+	"github.com/google/go-cmp/cmp"
 
 	"github.com/dcadolph/cipher"
 	cipherage "github.com/dcadolph/cipher/age"
+	"github.com/dcadolph/cipher/policy"
 	"github.com/dcadolph/cipher/precommit"
 	"github.com/dcadolph/cipher/sopsconfig"
 )
@@ -120,6 +122,33 @@ func TestCheckBytes(t *testing.T) {
 	}
 }
 
+// TestCheckBytesWithPolicy verifies that WithPolicy reports policy
+// violations on encrypted files and does not report a plaintext file
+// twice.
+func TestCheckBytesWithPolicy(t *testing.T) {
+	t.Parallel()
+	dir, plainPath, encryptedPath := writeFixture(t)
+	pol, err := policy.Parse([]byte("rules:\n  - name: need-kms\n    path_regex: /secrets/\n    require_types: [kms]\n"))
+	if err != nil {
+		t.Fatalf("policy.Parse: %v", err)
+	}
+	checker, err := precommit.NewChecker(dir, precommit.WithPolicy(pol))
+	if err != nil {
+		t.Fatalf("NewChecker: %v", err)
+	}
+	violations, err := checker.CheckPaths([]string{plainPath, encryptedPath})
+	if err != nil {
+		t.Fatalf("CheckPaths: %v", err)
+	}
+	want := []precommit.Violation{
+		{Path: plainPath, Reason: "matches sops creation rule but is not sops-encrypted"},
+		{Path: encryptedPath, Reason: "policy need-kms: no kms recipient"},
+	}
+	if diff := cmp.Diff(want, violations); diff != "" {
+		t.Errorf("violations (-want +got):\n%s", diff)
+	}
+}
+
 // TestNewCheckerForDirFindsConfig verifies the directory walker locates
 // the config in a parent directory.
 func TestNewCheckerForDirFindsConfig(t *testing.T) {
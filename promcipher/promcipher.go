@@ -0,0 +1,331 @@
+// Package promcipher records Prometheus metrics for cipher operations.
+//
+// It is an opt-in subpackage and the metrics counterpart of otelcipher.
+// Code that does not import promcipher does not pull in the Prometheus
+// client or its dependencies.
+//
+// # What you get
+//
+// A [Metrics] collector and wrappers that feed it:
+//
+//   - [WrapEncoder] and [WrapDecoder] count every Encode and Decode
+//     call and observe its latency and its plaintext and ciphertext
+//     sizes. Failed calls are also counted by error kind.
+//   - [WrapKeyProvider] observes the latency of every KeyGroups call,
+//     which is where KMS and other remote lookups spend their time.
+//   - [WalkOptions] counts the files a walk processes, skips, and
+//     fails, and observes how long each file takes.
+//
+// Every operation metric carries op and format labels. The format is
+// derived from the path with cipher.FormatForPath. Error kinds are the
+// names returned by cipher.Kind.String, such as "access-denied" or
+// "mac-mismatch".
+//
+// # Metrics
+//
+// With the default "cipher" namespace:
+//
+//	cipher_operations_total{op,format}                   counter
+//	cipher_operation_errors_total{op,format,kind}        counter
+//	cipher_operation_duration_seconds{op,format}         histogram
+//	cipher_plaintext_bytes{op,format}                    histogram
+//	cipher_ciphertext_bytes{op,format}                   histogram
+//	cipher_key_provider_duration_seconds                 histogram
+//	cipher_key_provider_errors_total{kind}               counter
+//	cipher_walk_files_total{op,format}                   counter
+//	cipher_walk_bytes_total{op,format}                   counter
+//	cipher_walk_skipped_total{op,reason}                 counter
+//	cipher_walk_file_errors_total{op,format,kind}        counter
+//	cipher_walk_file_duration_seconds{op,format}         histogram
+//
+// # Quick start
+//
+//	m := promcipher.NewMetrics()
+//	prometheus.MustRegister(m)
+//	enc := promcipher.WrapEncoder(cipher.NewEncoder(promcipher.WrapKeyProvider(kp, m)), m)
+//	dec := promcipher.WrapDecoder(cipher.NewDecoder(), m)
+//
+// Passing nil metrics falls back to [Default], which is registered on
+// prometheus.DefaultRegisterer on first use.
+package promcipher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/getsops/sops/v3"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/dcadolph/cipher"
+)
+
+// Namespace is the default metric name prefix.
+const Namespace = "cipher"
+
+// Operation names used for the op label.
+const (
+	OpEncode = "encode"
+	OpDecode = "decode"
+	OpRotate = "rotate"
+)
+
+// Options tunes NewMetricsWith.
+type Options struct {
+	// Namespace prefixes every metric name. If empty, Namespace is used.
+	Namespace string
+	// ConstLabels are added to every metric, for example to tell apart
+	// several services that share a Prometheus job.
+	ConstLabels prometheus.Labels
+	// DurationBuckets are the histogram buckets, in seconds, for
+	// operation and key provider latency. If nil, prometheus.DefBuckets
+	// is used.
+	DurationBuckets []float64
+	// SizeBuckets are the histogram buckets, in bytes, for plaintext
+	// and ciphertext sizes. If nil, powers of four from 64 B to 16 MiB
+	// are used.
+	SizeBuckets []float64
+}
+
+// Metrics holds the cipher collectors. It implements
+// prometheus.Collector; register it once and share it between wrappers.
+// A Metrics is safe for concurrent use.
+type Metrics struct {
+	operations       *prometheus.CounterVec
+	operationErrors  *prometheus.CounterVec
+	duration         *prometheus.HistogramVec
+	plaintextBytes   *prometheus.HistogramVec
+	ciphertextBytes  *prometheus.HistogramVec
+	keyProvider      prometheus.Histogram
+	keyProviderError *prometheus.CounterVec
+	walkFiles        *prometheus.CounterVec
+	walkBytes        *prometheus.CounterVec
+	walkSkipped      *prometheus.CounterVec
+	walkFileErrors   *prometheus.CounterVec
+	walkFileDuration *prometheus.HistogramVec
+}
+
+// NewMetrics returns unregistered Metrics with default options.
+func NewMetrics() *Metrics {
+	return NewMetricsWith(Options{})
+}
+
+// NewMetricsWith returns unregistered Metrics built from opts.
+func NewMetricsWith(opts Options) *Metrics {
+	ns := opts.Namespace
+	if ns == "" {
+		ns = Namespace
+	}
+	durations := opts.DurationBuckets
+	if durations == nil {
+		durations = prometheus.DefBuckets
+	}
+	sizes := opts.SizeBuckets
+	if sizes == nil {
+		sizes = prometheus.ExponentialBuckets(64, 4, 10)
+	}
+	counter := func(name, help string, labels ...string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Name: name, Help: help, ConstLabels: opts.ConstLabels,
+		}, labels)
+	}
+	histogram := func(name, help string, buckets []float64, labels ...string) *prometheus.HistogramVec {
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns, Name: name, Help: help, ConstLabels: opts.ConstLabels, Buckets: buckets,
+		}, labels)
+	}
+	return &Metrics{
+		operations: counter("operations_total",
+			"Encode and decode calls, by operation and format.", "op", "format"),
+		operationErrors: counter("operation_errors_total",
+			"Failed encode and decode calls, by operation, format, and error kind.", "op", "format", "kind"),
+		duration: histogram("operation_duration_seconds",
+			"Latency of encode and decode calls.", durations, "op", "format"),
+		plaintextBytes: histogram("plaintext_bytes",
+			"Plaintext size of successful encode and decode calls.", sizes, "op", "format"),
+		ciphertextBytes: histogram("ciphertext_bytes",
+			"Ciphertext size of successful encode and decode calls.", sizes, "op", "format"),
+		keyProvider: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: ns, Name: "key_provider_duration_seconds", ConstLabels: opts.ConstLabels,
+			Help: "Latency of key provider KeyGroups calls.", Buckets: durations,
+		}),
+		keyProviderError: counter("key_provider_errors_total",
+			"Failed key provider KeyGroups calls, by error kind.", "kind"),
+		walkFiles: counter("walk_files_total",
+			"Files a walk processed, by operation and format.", "op", "format"),
+		walkBytes: counter("walk_bytes_total",
+			"Bytes a walk wrote, by operation and format.", "op", "format"),
+		walkSkipped: counter("walk_skipped_total",
+			"Files a walk skipped, by operation and reason.", "op", "reason"),
+		walkFileErrors: counter("walk_file_errors_total",
+			"Files a walk failed, by operation, format, and error kind.", "op", "format", "kind"),
+		walkFileDuration: histogram("walk_file_duration_seconds",
+			"Latency of each file a walk works on.", durations, "op", "format"),
+	}
+}
+
+// collectors returns every collector in m.
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.operations, m.operationErrors, m.duration, m.plaintextBytes, m.ciphertextBytes,
+		m.keyProvider, m.keyProviderError, m.walkFiles, m.walkBytes, m.walkSkipped,
+		m.walkFileErrors, m.walkFileDuration,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+var (
+	defaultOnce    sync.Once
+	defaultMetrics *Metrics
+)
+
+// Default returns the Metrics used when a wrapper is passed nil. It is
+// registered on prometheus.DefaultRegisterer the first time it is
+// called, and panics if metrics with the same names are already
+// registered there.
+func Default() *Metrics {
+	defaultOnce.Do(func() {
+		defaultMetrics = NewMetrics()
+		prometheus.MustRegister(defaultMetrics)
+	})
+	return defaultMetrics
+}
+
+// observe records one op call on path that started at start. Sizes are
+// observed only when err is nil; otherwise the error kind is counted.
+func (m *Metrics) observe(op, path string, start time.Time, plaintext, ciphertext int, err error) {
+	format := cipher.FormatName(cipher.FormatForPath(path))
+	m.operations.WithLabelValues(op, format).Inc()
+	m.duration.WithLabelValues(op, format).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.operationErrors.WithLabelValues(op, format, cipher.KindOf(err).String()).Inc()
+		return
+	}
+	m.plaintextBytes.WithLabelValues(op, format).Observe(float64(plaintext))
+	m.ciphertextBytes.WithLabelValues(op, format).Observe(float64(ciphertext))
+}
+
+// WrapEncoder returns a cipher.Encoder that records an OpEncode
+// operation in m for every Encode call.
+func WrapEncoder(enc cipher.Encoder, m *Metrics) cipher.Encoder {
+	if enc == nil {
+		panic("promcipher: WrapEncoder: encoder required")
+	}
+	if m == nil {
+		m = Default()
+	}
+	return cipher.EncoderFunc(func(ctx context.Context, path string, data []byte) ([]byte, error) {
+		start := time.Now()
+		out, err := enc.Encode(ctx, path, data)
+		m.observe(OpEncode, path, start, len(data), len(out), err)
+		return out, err
+	})
+}
+
+// WrapDecoder returns a cipher.Decoder that records an OpDecode
+// operation in m for every Decode call.
+func WrapDecoder(dec cipher.Decoder, m *Metrics) cipher.Decoder {
+	if dec == nil {
+		panic("promcipher: WrapDecoder: decoder required")
+	}
+	if m == nil {
+		m = Default()
+	}
+	return cipher.DecoderFunc(func(ctx context.Context, path string, data []byte) ([]byte, error) {
+		start := time.Now()
+		out, err := dec.Decode(ctx, path, data)
+		m.observe(OpDecode, path, start, len(out), len(data), err)
+		return out, err
+	})
+}
+
+// WrapKeyProvider returns a cipher.KeyProvider that observes the
+// latency of every KeyGroups call in m. Wrap each slow provider, such
+// as a KMS, to alert on it separately from encryption itself.
+func WrapKeyProvider(kp cipher.KeyProvider, m *Metrics) cipher.KeyProvider {
+	if kp == nil {
+		panic("promcipher: WrapKeyProvider: provider required")
+	}
+	if m == nil {
+		m = Default()
+	}
+	return cipher.KeyProviderFunc(func(ctx context.Context) ([]sops.KeyGroup, error) {
+		start := time.Now()
+		groups, err := kp.KeyGroups(ctx)
+		m.keyProvider.Observe(time.Since(start).Seconds())
+		if err != nil {
+			m.keyProviderError.WithLabelValues(cipher.KindOf(err).String()).Inc()
+		}
+		return groups, err
+	})
+}
+
+// WalkOptions returns opts with OnFile and OnSkip also counting each
+// file in m under op, one of the Op constants, and WrapFile observing
+// each file's latency and counting failed files by error kind. The
+// original callbacks and WrapFile still run.
+//
+// A skip's reason label is the cipher.Kind of the reason, such as
+// "already-encrypted", "symlink-cycle" for a symlink loop, or
+// "filtered" for files a matcher rejected.
+func WalkOptions(m *Metrics, op string, opts cipher.WalkOptions) cipher.WalkOptions {
+	if m == nil {
+		m = Default()
+	}
+	wrap, onFile, onSkip := opts.WrapFile, opts.OnFile, opts.OnSkip
+	opts.WrapFile = func(ctx context.Context, path string, do func(context.Context) error) error {
+		start := time.Now()
+		var err error
+		if wrap != nil {
+			err = wrap(ctx, path, do)
+		} else {
+			err = do(ctx)
+		}
+		format := cipher.FormatName(cipher.FormatForPath(path))
+		m.walkFileDuration.WithLabelValues(op, format).Observe(time.Since(start).Seconds())
+		if err != nil {
+			m.walkFileErrors.WithLabelValues(op, format, cipher.KindOf(err).String()).Inc()
+		}
+		return err
+	}
+	opts.OnFile = func(path string, n int) {
+		format := cipher.FormatName(cipher.FormatForPath(path))
+		m.walkFiles.WithLabelValues(op, format).Inc()
+		m.walkBytes.WithLabelValues(op, format).Add(float64(n))
+		if onFile != nil {
+			onFile(path, n)
+		}
+	}
+	opts.OnSkip = func(path string, reason error) {
+		m.walkSkipped.WithLabelValues(op, skipReason(reason)).Inc()
+		if onSkip != nil {
+			onSkip(path, reason)
+		}
+	}
+	return opts
+}
+
+// skipReason returns the reason label for a walk skip.
+func skipReason(reason error) string {
+	if errors.Is(reason, cipher.ErrSymlinkCycle) {
+		return "symlink-cycle"
+	}
+	if kind := cipher.KindOf(reason); kind != cipher.KindUnknown {
+		return kind.String()
+	}
+	return "filtered"
+}
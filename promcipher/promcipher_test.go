@@ -0,0 +1,235 @@
+package promcipher_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/getsops/sops/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/afero"
+
+	"github.com/dcadolph/cipher"
+	"github.com/dcadolph/cipher/ciphertest"
+	"github.com/dcadolph/cipher/promcipher"
+)
+
+// newMetrics returns Metrics registered on a fresh pedantic registry,
+// which also checks that the collector describes what it collects.
+func newMetrics(t *testing.T) (*promcipher.Metrics, *prometheus.Registry) {
+	t.Helper()
+	m := promcipher.NewMetrics()
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(m); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	return m, reg
+}
+
+// sample returns the value of the counter, or the sample count of the
+// histogram, named name with exactly labels. Missing series return 0.
+func sample(t *testing.T, reg *prometheus.Registry, name string, labels map[string]string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	for _, fam := range families {
+		if fam.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range fam.GetMetric() {
+			if len(m.GetLabel()) != len(labels) {
+				continue
+			}
+			for _, l := range m.GetLabel() {
+				if labels[l.GetName()] != l.GetValue() {
+					continue metrics
+				}
+			}
+			if h := m.GetHistogram(); h != nil {
+				return float64(h.GetSampleCount())
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+// TestWrapEncoderDecoder verifies that successful calls are counted
+// and sized, and failed calls are counted by error kind.
+func TestWrapEncoderDecoder(t *testing.T) {
+	kp, _ := ciphertest.NewProvider(t)
+	m, reg := newMetrics(t)
+	ctx := context.Background()
+	enc := promcipher.WrapEncoder(cipher.NewEncoder(kp), m)
+	dec := promcipher.WrapDecoder(cipher.NewDecoder(), m)
+
+	ct, err := enc.Encode(ctx, "a.yaml", []byte("foo: bar\n"))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if _, err := dec.Decode(ctx, "a.yaml", ct); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if _, err := dec.Decode(ctx, "b.json", []byte(`{"a":1}`)); !errors.Is(err, cipher.ErrNotEncrypted) {
+		t.Fatalf("Decode plaintext: err = %v, want ErrNotEncrypted", err)
+	}
+
+	tests := []struct {
+		Name   string
+		Labels map[string]string
+		Want   float64
+	}{
+		// Test 0: Encode counted under its format.
+		{"cipher_operations_total", map[string]string{"op": "encode", "format": "yaml"}, 1},
+		// Test 1: YAML decode counted under its format.
+		{"cipher_operations_total", map[string]string{"op": "decode", "format": "yaml"}, 1},
+		// Test 2: JSON decode counted under its own format.
+		{"cipher_operations_total", map[string]string{"op": "decode", "format": "json"}, 1},
+		// Test 3: Latency observed for failed calls too.
+		{"cipher_operation_duration_seconds", map[string]string{"op": "decode", "format": "json"}, 1},
+		// Test 4: Size observed for a successful call.
+		{"cipher_ciphertext_bytes", map[string]string{"op": "decode", "format": "yaml"}, 1},
+		// Test 5: Size not observed for a failed call.
+		{"cipher_ciphertext_bytes", map[string]string{"op": "decode", "format": "json"}, 0},
+		// Test 6: Failure counted by kind.
+		{"cipher_operation_errors_total", map[string]string{"op": "decode", "format": "json", "kind": "not-encrypted"}, 1},
+		// Test 7: Success not counted as a failure.
+		{"cipher_operation_errors_total", map[string]string{"op": "encode", "format": "yaml", "kind": "not-encrypted"}, 0},
+	}
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			if got := sample(t, reg, test.Name, test.Labels); got != test.Want {
+				t.Errorf("%s%v = %v, want %v", test.Name, test.Labels, got, test.Want)
+			}
+		})
+	}
+}
+
+// TestWrapKeyProvider verifies that KeyGroups latency is observed and
+// failures are counted by kind.
+func TestWrapKeyProvider(t *testing.T) {
+	m, reg := newMetrics(t)
+	kp := promcipher.WrapKeyProvider(cipher.KeyProviderFunc(func(ctx context.Context) ([]sops.KeyGroup, error) {
+		return nil, ctx.Err()
+	}), m)
+
+	if _, err := kp.KeyGroups(context.Background()); err != nil {
+		t.Fatalf("KeyGroups: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := kp.KeyGroups(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("KeyGroups canceled: err = %v", err)
+	}
+
+	if got := sample(t, reg, "cipher_key_provider_duration_seconds", nil); got != 2 {
+		t.Errorf("duration samples = %v, want 2", got)
+	}
+	if got := sample(t, reg, "cipher_key_provider_errors_total", map[string]string{"kind": "canceled"}); got != 1 {
+		t.Errorf("canceled errors = %v, want 1", got)
+	}
+}
+
+// TestWalkOptions verifies that walk files, skips, and failures are
+// counted, per-file latency is observed, and the caller's callbacks
+// still run.
+func TestWalkOptions(t *testing.T) {
+	kp, _ := ciphertest.NewProvider(t)
+	m, reg := newMetrics(t)
+	ctx := context.Background()
+	enc := cipher.NewEncoder(kp)
+	files := afero.NewMemMapFs()
+	ct, err := enc.Encode(ctx, "/r/done.yaml", []byte("a: 1\n"))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	for path, data := range map[string][]byte{
+		"/r/done.yaml": ct,
+		"/r/new.yaml":  []byte("b: 2\n"),
+		"/r/new.json":  []byte(`{"c":3}`),
+		"/r/bad.yaml":  []byte("d: [\n"),
+		"/r/skip.txt":  []byte("x"),
+	} {
+		if err := afero.WriteFile(files, path, data, 0o600); err != nil {
+			t.Fatalf("seed %s: %v", path, err)
+		}
+	}
+
+	var seen, skipped, wrapped int
+	opts := promcipher.WalkOptions(m, promcipher.OpEncode, cipher.WalkOptions{
+		OnFile:          func(string, int) { seen++ },
+		OnSkip:          func(string, error) { skipped++ },
+		ContinueOnError: true,
+		WrapFile: func(ctx context.Context, _ string, do func(context.Context) error) error {
+			wrapped++
+			return do(ctx)
+		},
+	})
+	res, err := cipher.EncodeWalkWith(ctx, files, "/r", enc, []cipher.FileMatcher{cipher.MatchExt(".yaml", ".json")}, opts)
+	if err == nil || res == nil || res.Failed() != 1 {
+		t.Fatalf("EncodeWalkWith: err = %v, want one failed file", err)
+	}
+	if seen != 2 || skipped != 2 || wrapped != 4 {
+		t.Errorf("callbacks: files=%d skips=%d wraps=%d, want 2, 2, and 4", seen, skipped, wrapped)
+	}
+
+	tests := []struct {
+		Name   string
+		Labels map[string]string
+		Want   float64
+	}{
+		// Test 0: Processed YAML file counted under its format.
+		{"cipher_walk_files_total", map[string]string{"op": "encode", "format": "yaml"}, 1},
+		// Test 1: Processed JSON file counted under its format.
+		{"cipher_walk_files_total", map[string]string{"op": "encode", "format": "json"}, 1},
+		// Test 2: Skip reasons labeled by kind.
+		{"cipher_walk_skipped_total", map[string]string{"op": "encode", "reason": "already-encrypted"}, 1},
+		// Test 3: Matcher rejections labeled filtered.
+		{"cipher_walk_skipped_total", map[string]string{"op": "encode", "reason": "filtered"}, 1},
+		// Test 4: Failed file counted by kind.
+		{"cipher_walk_file_errors_total", map[string]string{"op": "encode", "format": "yaml", "kind": "unknown"}, 1},
+		// Test 5: Latency observed for every YAML file worked on.
+		{"cipher_walk_file_duration_seconds", map[string]string{"op": "encode", "format": "yaml"}, 3},
+		// Test 6: Latency observed for the JSON file.
+		{"cipher_walk_file_duration_seconds", map[string]string{"op": "encode", "format": "json"}, 1},
+	}
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			if got := sample(t, reg, test.Name, test.Labels); got != test.Want {
+				t.Errorf("%s%v = %v, want %v", test.Name, test.Labels, got, test.Want)
+			}
+		})
+	}
+	if got := sample(t, reg, "cipher_walk_bytes_total", map[string]string{"op": "encode", "format": "yaml"}); got == 0 {
+		t.Error("walk bytes not counted")
+	}
+}
+
+// TestNilPanics verifies the wrappers require a wrapped value.
+func TestNilPanics(t *testing.T) {
+	tests := []struct {
+		Name string
+		Fn   func()
+	}{
+		// Test 0: Nil encoder.
+		{"encoder", func() { promcipher.WrapEncoder(nil, nil) }},
+		// Test 1: Nil decoder.
+		{"decoder", func() { promcipher.WrapDecoder(nil, nil) }},
+		// Test 2: Nil key provider.
+		{"provider", func() { promcipher.WrapKeyProvider(nil, nil) }},
+	}
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("no panic")
+				}
+			}()
+			test.Fn()
+		})
+	}
+}
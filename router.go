@@ -33,6 +33,9 @@ func (f RouterFunc) Resolve(path string) (KeyProvider, EncoderOptions, error) {
 // Rule pairs a FileMatcher with the KeyProvider and EncoderOptions to
 // apply when the matcher selects a path.
 type Rule struct {
+	// Name identifies the rule in dry-run plans. Defaults to "rule N",
+	// counting from 1.
+	Name string
 	// Match decides whether this rule applies to the given path.
 	Match FileMatcher
 	// Provider supplies key groups for matching paths.
@@ -43,7 +46,8 @@ type Rule struct {
 }
 
 // NewRouter returns a Router whose Resolve method scans rules in order
-// and returns the first match. Panics if any rule has a nil Match or
+// and returns the first match. The Router implements RuleDescriber
+// with each rule's Name. Panics if any rule has a nil Match or
 // Provider.
 func NewRouter(rules ...Rule) Router {
 	for i, r := range rules {
@@ -55,32 +59,77 @@ func NewRouter(rules ...Rule) Router {
 		}
 	}
 	frozen := append([]Rule(nil), rules...)
-	return RouterFunc(func(path string) (KeyProvider, EncoderOptions, error) {
-		for _, r := range frozen {
-			if r.Match.Match(path) {
-				return r.Provider, r.Options, nil
-			}
+	for i := range frozen {
+		if frozen[i].Name == "" {
+			frozen[i].Name = fmt.Sprintf("rule %d", i+1)
 		}
-		return nil, EncoderOptions{}, fmt.Errorf("%w: %q", ErrNoMatchingRule, path)
-	})
+	}
+	return ruleRouter(frozen)
+}
+
+// ruleRouter is the Router returned by NewRouter.
+type ruleRouter []Rule
+
+// Resolve returns the Provider and Options of the first rule that
+// matches path.
+func (rs ruleRouter) Resolve(path string) (KeyProvider, EncoderOptions, error) {
+	r, err := rs.match(path)
+	if err != nil {
+		return nil, EncoderOptions{}, err
+	}
+	return r.Provider, r.Options, nil
+}
+
+// DescribeRule returns the Name of the first rule that matches path.
+func (rs ruleRouter) DescribeRule(path string) (string, error) {
+	r, err := rs.match(path)
+	if err != nil {
+		return "", err
+	}
+	return r.Name, nil
+}
+
+// match returns the first rule that matches path.
+func (rs ruleRouter) match(path string) (Rule, error) {
+	for _, r := range rs {
+		if r.Match.Match(path) {
+			return r, nil
+		}
+	}
+	return Rule{}, fmt.Errorf("%w: %q", ErrNoMatchingRule, path)
 }
 
 // NewRoutedEncoder returns an Encoder that consults router on every
 // Encode call. Fields set on the matched rule's EncoderOptions override
-// the same fields in base; zero-valued fields inherit base.
+// the same fields in base; zero-valued fields inherit base. The Encoder
+// implements RuleDescriber, delegating to router when it does too.
 // Panics if router is nil.
 func NewRoutedEncoder(router Router, base EncoderOptions) Encoder {
 	if router == nil {
 		panic("cipher: NewRoutedEncoder: router required")
 	}
-	return EncoderFunc(func(ctx context.Context, path string, data []byte) ([]byte, error) {
-		kp, ruleOpts, err := router.Resolve(path)
-		if err != nil {
-			return nil, fmt.Errorf("%w: %w", ErrEncode, err)
-		}
-		return NewEncoderWith(kp, mergeEncoderOptions(base, ruleOpts)).
-			Encode(ctx, path, data)
-	})
+	return routedEncoder{
+		EncoderFunc: func(ctx context.Context, path string, data []byte) ([]byte, error) {
+			kp, ruleOpts, err := router.Resolve(path)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrEncode, err)
+			}
+			return NewEncoderWith(kp, mergeEncoderOptions(base, ruleOpts)).
+				Encode(ctx, path, data)
+		},
+		router: router,
+	}
+}
+
+// routedEncoder is the Encoder returned by NewRoutedEncoder.
+type routedEncoder struct {
+	EncoderFunc
+	router Router
+}
+
+// DescribeRule describes the rule router picks for path.
+func (e routedEncoder) DescribeRule(path string) (string, error) {
+	return describeRoute(e.router, path)
 }
 
 // NewShamirRule returns a Rule that maps match to a multi-group
@@ -168,6 +217,9 @@ func mergeEncoderOptions(base, rule EncoderOptions) EncoderOptions {
 	if rule.UnencryptedSuffix != "" {
 		out.UnencryptedSuffix = rule.UnencryptedSuffix
 	}
+	if rule.Kubernetes {
+		out.Kubernetes = true
+	}
 	if rule.MAC != MACInherit {
 		out.MAC = rule.MAC
 	}
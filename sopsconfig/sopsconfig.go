@@ -1,11 +1,12 @@
 // Package sopsconfig parses a sops .sops.yaml configuration file and
 // exposes it as a cipher.Router.
 //
-// The router consults sops's own config loader on every Resolve call,
-// so the project's existing .sops.yaml rules drive cipher encryption
-// decisions. Matching semantics are identical to the sops CLI's
-// creation_rules: each rule has a path_regex and a set of recipients
-// (key_groups), and the first matching rule wins.
+// The router reads the .sops.yaml once and has sops's own config
+// loader build each rule's recipients, so the project's existing
+// .sops.yaml rules drive cipher encryption decisions. Matching
+// semantics are identical to the sops CLI's creation_rules: each rule
+// has a path_regex and a set of recipients (key_groups), and the first
+// matching rule wins.
 //
 // # Locating .sops.yaml
 //
@@ -41,9 +42,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 
 	sopsconfig "github.com/getsops/sops/v3/config"
+	"go.yaml.in/yaml/v3"
 
 	"github.com/dcadolph/cipher"
 )
@@ -101,37 +105,184 @@ func LoadFromDir(dir string) (*Config, error) {
 	}
 }
 
-// Router returns a cipher.Router that consults the underlying sops
-// config on every Resolve call. kmsContext is forwarded to sops as the
-// KMS encryption context (use nil for none).
+// Router returns a cipher.Router for the creation rules in the config.
+// The file is read and its rules parsed once, here; each rule's keys
+// are resolved by sops the first time a path matches it. kmsContext is
+// forwarded to sops as the KMS encryption context (use nil for none).
+// The Router implements cipher.RuleDescriber, naming the creation rule
+// that matches a path, such as `creation_rules[1] (path_regex "^prod/")`.
 func (c *Config) Router(kmsContext map[string]string) cipher.Router {
-	ptrCtx := toPtrMap(kmsContext)
-	confPath := c.Path
-	return cipher.RouterFunc(func(path string) (cipher.KeyProvider, cipher.EncoderOptions, error) {
-		cfg, err := sopsconfig.LoadCreationRuleForFile(confPath, path, ptrCtx)
-		if err != nil {
-			if isNoMatchErr(err) {
-				return nil, cipher.EncoderOptions{},
-					fmt.Errorf("%w: %q", cipher.ErrNoMatchingRule, path)
-			}
-			return nil, cipher.EncoderOptions{},
-				fmt.Errorf("sopsconfig: load rule for %q: %w", path, err)
+	r := &router{path: c.Path, kmsContext: toPtrMap(kmsContext)}
+	r.rules, r.err = parseRules(c.Path)
+	r.RouterFunc = r.resolve
+	return r
+}
+
+// router is the cipher.Router returned by Config.Router.
+type router struct {
+	cipher.RouterFunc
+	path       string
+	kmsContext map[string]*string
+	rules      []*creationRule
+	// err is the error reading or parsing the config, returned by
+	// every call.
+	err error
+}
+
+// creationRule is one entry of creation_rules. node is the rule as
+// written, from which sops builds its keys and options on first use.
+type creationRule struct {
+	index     int
+	pathRegex string
+	re        *regexp.Regexp
+	reErr     error
+	node      *yaml.Node
+
+	once sync.Once
+	cfg  *sopsconfig.Config
+	err  error
+}
+
+// parseRules reads the config at path and compiles the path_regex of
+// each creation rule. A rule whose regex does not compile fails only
+// the paths that reach it, as in sops.
+func parseRules(path string) ([]*creationRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("sopsconfig: read %q: %w", path, err)
+	}
+	var raw struct {
+		CreationRules []yaml.Node `yaml:"creation_rules"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("sopsconfig: parse %q: %w", path, err)
+	}
+	rules := make([]*creationRule, 0, len(raw.CreationRules))
+	for i := range raw.CreationRules {
+		var head struct {
+			PathRegex string `yaml:"path_regex"`
 		}
-		if cfg == nil || len(cfg.KeyGroups) == 0 {
-			return nil, cipher.EncoderOptions{},
-				fmt.Errorf("%w: %q", cipher.ErrNoMatchingRule, path)
+		if err := raw.CreationRules[i].Decode(&head); err != nil {
+			return nil, fmt.Errorf("sopsconfig: parse %q: creation_rules[%d]: %w", path, i, err)
 		}
-		kp := cipher.StaticKeyProvider(cfg.KeyGroups...)
-		opts := cipher.EncoderOptions{
-			EncryptedRegex:    cfg.EncryptedRegex,
-			UnencryptedRegex:  cfg.UnencryptedRegex,
-			EncryptedSuffix:   cfg.EncryptedSuffix,
-			UnencryptedSuffix: cfg.UnencryptedSuffix,
-			MAC:               macModeFromBool(cfg.MACOnlyEncrypted),
-			ShamirThreshold:   cfg.ShamirThreshold,
+		rule := &creationRule{index: i, pathRegex: head.PathRegex, node: &raw.CreationRules[i]}
+		if head.PathRegex != "" {
+			rule.re, rule.reErr = regexp.Compile(head.PathRegex)
 		}
-		return kp, opts, nil
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// match returns the first creation rule that applies to path, matched
+// relative to the directory of the .sops.yaml as sops does.
+func (r *router) match(path string) (*creationRule, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	dir, err := filepath.Abs(filepath.Dir(r.path))
+	if err != nil {
+		return nil, fmt.Errorf("sopsconfig: abs %q: %w", r.path, err)
+	}
+	rel := strings.TrimPrefix(path, dir+string(filepath.Separator))
+	for _, rule := range r.rules {
+		if rule.reErr != nil {
+			return nil, fmt.Errorf("sopsconfig: creation_rules[%d]: %w", rule.index, rule.reErr)
+		}
+		if rule.re == nil || rule.re.MatchString(rel) {
+			return rule, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", cipher.ErrNoMatchingRule, path)
+}
+
+// config returns the sops config for rule, loading it on first use.
+// sops builds configs only from a file, so the rule is written alone,
+// without its path_regex, to a temporary file that sops then loads.
+func (r *router) config(rule *creationRule) (*sopsconfig.Config, error) {
+	rule.once.Do(func() {
+		rule.cfg, rule.err = loadRule(rule, r.kmsContext)
 	})
+	return rule.cfg, rule.err
+}
+
+// loadRule has sops build the config for rule alone.
+func loadRule(rule *creationRule, kmsContext map[string]*string) (*sopsconfig.Config, error) {
+	node := *rule.node
+	node.Content = nil
+	for i := 0; i+1 < len(rule.node.Content); i += 2 {
+		if rule.node.Content[i].Value != "path_regex" {
+			node.Content = append(node.Content, rule.node.Content[i], rule.node.Content[i+1])
+		}
+	}
+	data, err := yaml.Marshal(map[string][]*yaml.Node{"creation_rules": {&node}})
+	if err != nil {
+		return nil, fmt.Errorf("sopsconfig: creation_rules[%d]: %w", rule.index, err)
+	}
+	f, err := os.CreateTemp("", "cipher-sops-rule-*.yaml")
+	if err != nil {
+		return nil, fmt.Errorf("sopsconfig: creation_rules[%d]: %w", rule.index, err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sopsconfig: creation_rules[%d]: %w", rule.index, err)
+	}
+	cfg, err := sopsconfig.LoadCreationRuleForFile(f.Name(), f.Name(), kmsContext)
+	if err != nil {
+		return nil, fmt.Errorf("sopsconfig: creation_rules[%d]: %w", rule.index, err)
+	}
+	return cfg, nil
+}
+
+// lookup returns the creation rule for path and its sops config.
+// Resolve and DescribeRule both go through it, so they always agree.
+func (r *router) lookup(path string) (*creationRule, *sopsconfig.Config, error) {
+	rule, err := r.match(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg, err := r.config(rule)
+	if err != nil {
+		return nil, nil, fmt.Errorf("sopsconfig: load rule for %q: %w", path, err)
+	}
+	if cfg == nil || len(cfg.KeyGroups) == 0 {
+		return nil, nil, fmt.Errorf("%w: %q", cipher.ErrNoMatchingRule, path)
+	}
+	return rule, cfg, nil
+}
+
+// resolve implements cipher.RouterFunc for r.
+func (r *router) resolve(path string) (cipher.KeyProvider, cipher.EncoderOptions, error) {
+	_, cfg, err := r.lookup(path)
+	if err != nil {
+		return nil, cipher.EncoderOptions{}, err
+	}
+	kp := cipher.StaticKeyProvider(cfg.KeyGroups...)
+	opts := cipher.EncoderOptions{
+		EncryptedRegex:    cfg.EncryptedRegex,
+		UnencryptedRegex:  cfg.UnencryptedRegex,
+		EncryptedSuffix:   cfg.EncryptedSuffix,
+		UnencryptedSuffix: cfg.UnencryptedSuffix,
+		MAC:               macModeFromBool(cfg.MACOnlyEncrypted),
+		ShamirThreshold:   cfg.ShamirThreshold,
+	}
+	return kp, opts, nil
+}
+
+// DescribeRule names the creation rule that Resolve uses for path.
+func (r *router) DescribeRule(path string) (string, error) {
+	rule, _, err := r.lookup(path)
+	if err != nil {
+		return "", err
+	}
+	if rule.pathRegex == "" {
+		return fmt.Sprintf("creation_rules[%d]", rule.index), nil
+	}
+	return fmt.Sprintf("creation_rules[%d] (path_regex %q)", rule.index, rule.pathRegex), nil
 }
 
 // MatchesAnyRule reports whether path matches any creation rule in the
@@ -147,12 +298,6 @@ func (c *Config) MatchesAnyRule(path string, kmsContext map[string]string) (bool
 	return false, err
 }
 
-// isNoMatchErr translates sops's untyped "no matching creation rules"
-// error into a signal we can wrap with cipher.ErrNoMatchingRule.
-func isNoMatchErr(err error) bool {
-	return err != nil && strings.Contains(err.Error(), "no matching creation rules")
-}
-
 // macModeFromBool maps the legacy bool field from .sops.yaml to the
 // MACMode tri-state. A .sops.yaml rule can only set true (encrypted
 // leaves only) or false (default sops MAC over all leaves); rules
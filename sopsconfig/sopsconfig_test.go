@@ -169,6 +169,49 @@ func TestMatchesAnyRule(t *testing.T) {
 	}
 }
 
+// TestRouterDescribeRule verifies the router names the creation rule
+// that applies to a path by index and path_regex, from the rules it
+// parsed when it was built.
+func TestRouterDescribeRule(t *testing.T) {
+	t.Parallel()
+	dir, _ := writeFixture(t)
+	cfg, err := sopsconfig.Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	d, ok := cfg.Router(nil).(cipher.RuleDescriber)
+	if !ok {
+		t.Fatal("router does not implement cipher.RuleDescriber")
+	}
+	// The router parsed the rules when it was built and does not read
+	// the file again.
+	if err := os.Remove(cfg.Path); err != nil {
+		t.Fatalf("remove config: %v", err)
+	}
+
+	tests := []struct {
+		Path    string
+		Want    string
+		WantErr error
+	}{
+		// Test 0: prod yaml is described by the first rule.
+		{Path: filepath.Join(dir, "secrets/prod/api.yaml"), Want: `creation_rules[0] (path_regex "secrets/prod/.*\\.yaml$")`},
+		// Test 1: any json is described by the third rule.
+		{Path: filepath.Join(dir, "other/data.json"), Want: `creation_rules[2] (path_regex ".*\\.json$")`},
+		// Test 2: unrelated path matches nothing.
+		{Path: filepath.Join(dir, "unrelated.txt"), WantErr: cipher.ErrNoMatchingRule},
+	}
+	for testNum, test := range tests {
+		got, err := d.DescribeRule(test.Path)
+		if !errors.Is(err, test.WantErr) {
+			t.Fatalf("Test %d: err = %v, want %v", testNum, err, test.WantErr)
+		}
+		if got != test.Want {
+			t.Errorf("Test %d: DescribeRule = %s, want %s", testNum, got, test.Want)
+		}
+	}
+}
+
 // freshRecipient returns the public recipient string for a freshly
 // generated age identity.
 func freshRecipient(t *testing.T) string {
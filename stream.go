@@ -0,0 +1,451 @@
+package cipher
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/keyservice"
+	"github.com/spf13/afero"
+
+	"github.com/dcadolph/cipher/internal/atomic"
+	"github.com/dcadolph/cipher/internal/sopsx"
+)
+
+// DefaultStreamChunkSize is the plaintext chunk size used when
+// StreamEncoderOptions.ChunkSize is zero. Memory use per stream is a
+// small multiple of this value.
+const DefaultStreamChunkSize = sopsx.DefaultStreamChunkSize
+
+// ErrStreamTruncated is returned by a StreamDecoder when the encrypted
+// stream ends before its authenticated trailer. It wraps ErrDecode.
+// Plaintext already written to dst is incomplete and must be discarded.
+var ErrStreamTruncated = errors.New("encrypted stream truncated")
+
+// StreamEncoder encrypts opaque content read from an io.Reader. Unlike
+// Encoder it never holds the whole payload in memory, so it suits
+// multi-gigabyte inputs such as database dumps. The output is a
+// cipher stream, not a sops document; decrypt it with a StreamDecoder.
+type StreamEncoder interface {
+	// EncodeStream reads plaintext from src and writes the encrypted
+	// stream to dst. path is used for routing and audit only. Returns
+	// ErrAlreadyEncrypted when src already holds a cipher stream and
+	// ErrEmpty when src is empty.
+	EncodeStream(ctx context.Context, path string, dst io.Writer, src io.Reader) error
+}
+
+// StreamEncoderFunc adapts a plain function to StreamEncoder.
+type StreamEncoderFunc func(ctx context.Context, path string, dst io.Writer, src io.Reader) error
+
+// EncodeStream calls f.
+func (f StreamEncoderFunc) EncodeStream(ctx context.Context, path string, dst io.Writer, src io.Reader) error {
+	return f(ctx, path, dst, src)
+}
+
+// StreamDecoder decrypts content produced by a StreamEncoder.
+type StreamDecoder interface {
+	// DecodeStream reads an encrypted stream from src and writes the
+	// plaintext to dst. Returns ErrNotEncrypted when src is not a
+	// cipher stream. On any other error dst may hold a prefix of the
+	// plaintext; stage output (see DecodeStreamWalk) when that matters.
+	DecodeStream(ctx context.Context, path string, dst io.Writer, src io.Reader) error
+}
+
+// StreamDecoderFunc adapts a plain function to StreamDecoder.
+type StreamDecoderFunc func(ctx context.Context, path string, dst io.Writer, src io.Reader) error
+
+// DecodeStream calls f.
+func (f StreamDecoderFunc) DecodeStream(ctx context.Context, path string, dst io.Writer, src io.Reader) error {
+	return f(ctx, path, dst, src)
+}
+
+// StreamEncoderOptions tunes a StreamEncoder created with
+// NewStreamEncoderWith.
+type StreamEncoderOptions struct {
+	// ChunkSize is the plaintext chunk size in bytes. Zero means
+	// DefaultStreamChunkSize. Larger chunks lower per-chunk overhead at
+	// the cost of memory.
+	ChunkSize int
+	// ShamirThreshold is the number of key groups required to recover
+	// the data key. Zero means the sops default.
+	ShamirThreshold int
+	// KeyServices overrides the default local key service. Empty means
+	// a single local key service is used.
+	KeyServices []keyservice.KeyServiceClient
+	// Cipher overrides the default AES cipher. Nil means a fresh
+	// aes.NewCipher() per chunk.
+	Cipher sops.Cipher
+	// Logger receives encode-time events. Nil uses NopLogger.
+	Logger Logger
+	// OnEncrypt is called after every successful EncodeStream with the
+	// path, plaintext size, and ciphertext size. Nil is a no-op.
+	OnEncrypt func(path string, plaintextBytes, ciphertextBytes int64)
+}
+
+// StreamDecoderOptions tunes a StreamDecoder created with
+// NewStreamDecoderWith.
+type StreamDecoderOptions struct {
+	// KeyServices overrides the default local key service. Empty means
+	// a single local key service is used.
+	KeyServices []keyservice.KeyServiceClient
+	// AgeIdentities are in-memory age identities used to open the
+	// stream header's data key without touching SOPS_AGE_KEY or key
+	// files. They behave like DecoderOptions.AgeIdentities.
+	AgeIdentities []string
+	// PGPPrivateKeys are armored, unprotected OpenPGP private keys
+	// tried on the header's PGP recipients instead of the GnuPG keyring.
+	PGPPrivateKeys [][]byte
+	// DecryptionOrder controls which key types are tried first.
+	// Empty means sops.DefaultDecryptionOrder.
+	DecryptionOrder []string
+	// Cipher overrides the default AES cipher. Nil means a fresh
+	// aes.NewCipher() per chunk.
+	Cipher sops.Cipher
+	// Logger receives decode-time events. Nil uses NopLogger.
+	Logger Logger
+	// OnDecrypt is called after every successful DecodeStream with the
+	// path, ciphertext size, and plaintext size. Nil is a no-op.
+	OnDecrypt func(path string, ciphertextBytes, plaintextBytes int64)
+}
+
+// NewStreamEncoder returns a StreamEncoder that wraps one fresh data
+// key per stream for the key groups from kp. Panics if kp is nil.
+func NewStreamEncoder(kp KeyProvider) StreamEncoder {
+	return NewStreamEncoderWith(kp, StreamEncoderOptions{})
+}
+
+// NewStreamEncoderWith is NewStreamEncoder with explicit options.
+// Panics if kp is nil.
+func NewStreamEncoderWith(kp KeyProvider, opts StreamEncoderOptions) StreamEncoder {
+	if kp == nil {
+		panic("cipher: NewStreamEncoderWith: KeyProvider required")
+	}
+	log := opts.Logger
+	if log == nil {
+		log = NopLogger
+	}
+	return StreamEncoderFunc(func(ctx context.Context, path string, dst io.Writer, src io.Reader) (err error) {
+		defer wrapError(&err, "encode", path, FormatBinary)
+		log.Debugf("cipher.EncodeStream start: path=%s", path)
+		br := bufio.NewReader(src)
+		prefix, _ := br.Peek(len(sopsx.StreamMagic) + 1)
+		switch {
+		case len(prefix) == 0:
+			log.Warnf("cipher.EncodeStream skip empty: path=%s", path)
+			return ErrEmpty
+		case sopsx.IsEncryptedStream(prefix):
+			log.Warnf("cipher.EncodeStream skip already-encrypted: path=%s", path)
+			return ErrAlreadyEncrypted
+		}
+		groups, err := kp.KeyGroups(ctx)
+		if err != nil {
+			return fmt.Errorf("%w: key groups: %w", ErrEncode, err)
+		}
+		if len(groups) == 0 {
+			return fmt.Errorf("%w: %w", ErrEncode, ErrNoKeyGroups)
+		}
+		plainN, cipherN, err := sopsx.EncryptStream(ctx, sopsx.StreamEncryptInput{
+			Path:            path,
+			KeyGroups:       groups,
+			KeyServices:     opts.KeyServices,
+			Cipher:          opts.Cipher,
+			ShamirThreshold: opts.ShamirThreshold,
+			ChunkSize:       opts.ChunkSize,
+		}, dst, br)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrEncode, err)
+		}
+		log.Debugf("cipher.EncodeStream done: path=%s plaintext=%d ciphertext=%d",
+			path, plainN, cipherN)
+		if opts.OnEncrypt != nil {
+			opts.OnEncrypt(path, plainN, cipherN)
+		}
+		return nil
+	})
+}
+
+// NewRoutedStreamEncoder returns a StreamEncoder that consults router
+// on every EncodeStream call. The matched rule's ShamirThreshold,
+// KeyServices, and Cipher apply; the key-selection and MAC fields of
+// EncoderOptions have no meaning for opaque streams and are ignored.
+// The StreamEncoder implements RuleDescriber like NewRoutedEncoder's.
+// Panics if router is nil.
+func NewRoutedStreamEncoder(router Router, base StreamEncoderOptions) StreamEncoder {
+	if router == nil {
+		panic("cipher: NewRoutedStreamEncoder: router required")
+	}
+	return routedStreamEncoder{
+		StreamEncoderFunc: func(ctx context.Context, path string, dst io.Writer, src io.Reader) error {
+			kp, ruleOpts, err := router.Resolve(path)
+			if err != nil {
+				return fmt.Errorf("%w: %w", ErrEncode, err)
+			}
+			opts := base
+			if ruleOpts.ShamirThreshold != 0 {
+				opts.ShamirThreshold = ruleOpts.ShamirThreshold
+			}
+			if ruleOpts.Cipher != nil {
+				opts.Cipher = ruleOpts.Cipher
+			}
+			opts.KeyServices = appendUniqueKS(opts.KeyServices, ruleOpts.KeyServices)
+			return NewStreamEncoderWith(kp, opts).EncodeStream(ctx, path, dst, src)
+		},
+		router: router,
+	}
+}
+
+// routedStreamEncoder is the StreamEncoder returned by
+// NewRoutedStreamEncoder.
+type routedStreamEncoder struct {
+	StreamEncoderFunc
+	router Router
+}
+
+// DescribeRule describes the rule router picks for path.
+func (e routedStreamEncoder) DescribeRule(path string) (string, error) {
+	return describeRoute(e.router, path)
+}
+
+// NewStreamDecoder returns a StreamDecoder using the local key service
+// and the default decryption order.
+func NewStreamDecoder() StreamDecoder {
+	return NewStreamDecoderWith(StreamDecoderOptions{})
+}
+
+// NewStreamDecoderWith returns a StreamDecoder using the supplied options.
+func NewStreamDecoderWith(opts StreamDecoderOptions) StreamDecoder {
+	log := opts.Logger
+	if log == nil {
+		log = NopLogger
+	}
+	services, ksErr := identityKeyServices(opts.KeyServices, opts.AgeIdentities, opts.PGPPrivateKeys)
+	return StreamDecoderFunc(func(ctx context.Context, path string, dst io.Writer, src io.Reader) (err error) {
+		defer wrapError(&err, "decode", path, FormatBinary)
+		if ksErr != nil {
+			return fmt.Errorf("%w: %w", ErrDecode, ksErr)
+		}
+		log.Debugf("cipher.DecodeStream start: path=%s", path)
+		cipherN, plainN, err := sopsx.DecryptStream(ctx, sopsx.StreamDecryptInput{
+			Path:            path,
+			KeyServices:     services,
+			DecryptionOrder: opts.DecryptionOrder,
+			Cipher:          opts.Cipher,
+		}, dst, src)
+		if ue := asUnwrapError(path, err); ue != nil {
+			return ue
+		}
+		switch {
+		case errors.Is(err, sopsx.ErrNotEncrypted):
+			log.Warnf("cipher.DecodeStream skip not-encrypted: path=%s", path)
+			return ErrNotEncrypted
+		case errors.Is(err, sopsx.ErrStreamTruncated):
+			return fmt.Errorf("%w: %w", ErrDecode, ErrStreamTruncated)
+		case errors.Is(err, sopsx.ErrParse):
+			return fmt.Errorf("%w: %w: %w", ErrDecode, ErrParse, err)
+		case err != nil:
+			return fmt.Errorf("%w: %w", ErrDecode, err)
+		}
+		log.Debugf("cipher.DecodeStream done: path=%s ciphertext=%d plaintext=%d",
+			path, cipherN, plainN)
+		if opts.OnDecrypt != nil {
+			opts.OnDecrypt(path, cipherN, plainN)
+		}
+		return nil
+	})
+}
+
+// IsEncryptedStream reports whether prefix begins with the header a
+// StreamEncoder writes. Only the first few bytes of the input are
+// needed; see IsEncrypted for sops documents.
+func IsEncryptedStream(prefix []byte) bool {
+	return sopsx.IsEncryptedStream(prefix)
+}
+
+// EncodeStreamWalk walks root on files and stream-encrypts every file
+// matched by any of the supplied matchers using enc. Files that are
+// already cipher streams, and empty files, are skipped. Each output is
+// staged in a temp file and renamed into place, so memory use stays
+// bounded and a failed file is left untouched.
+func EncodeStreamWalk(
+	ctx context.Context, files afero.Fs, root string,
+	enc StreamEncoder, matchers []FileMatcher,
+) (*WalkResult, error) {
+	return EncodeStreamWalkWith(ctx, files, root, enc, matchers, WalkOptions{})
+}
+
+// EncodeStreamWalkWith is EncodeStreamWalk with explicit options.
+func EncodeStreamWalkWith(
+	ctx context.Context, files afero.Fs, root string,
+	enc StreamEncoder, matchers []FileMatcher, opts WalkOptions,
+) (*WalkResult, error) {
+	if files == nil {
+		panic("cipher: EncodeStreamWalkWith: filesystem required")
+	}
+	if enc == nil {
+		panic("cipher: EncodeStreamWalkWith: encoder required")
+	}
+	serializeCallbacks(&opts)
+	if opts.DryRun {
+		return runPlan(ctx, files, root, matchers, opts, planEncodeStream(enc))
+	}
+	rec := recordWalk(&opts, "")
+	dest := newWalkDest(files, root, opts)
+	return runWalk(ctx, files, root, matchers, opts, rec,
+		func(ctx context.Context, dst afero.Fs, path string, info fs.FileInfo) error {
+			prefix, err := readPrefix(files, path, len(sopsx.StreamMagic)+1)
+			if err != nil {
+				return err
+			}
+			var skip error
+			switch {
+			case len(prefix) == 0:
+				skip = ErrEmpty
+			case sopsx.IsEncryptedStream(prefix):
+				skip = ErrAlreadyEncrypted
+			default:
+				return streamWalkFile(ctx, dest, dst, path, info, opts, "encode", enc.EncodeStream)
+			}
+			if err := dest.passThroughStream(dst, path, info); err != nil {
+				return err
+			}
+			notify(opts.OnSkip, path, skip)
+			return nil
+		})
+}
+
+// DecodeStreamWalk walks root on files and decrypts every cipher
+// stream matched by any of the supplied matchers using dec. Files that
+// are not cipher streams are skipped. Plaintext is staged in a temp
+// file and only renamed into place once the trailer authenticates.
+func DecodeStreamWalk(
+	ctx context.Context, files afero.Fs, root string,
+	dec StreamDecoder, matchers []FileMatcher,
+) (*WalkResult, error) {
+	return DecodeStreamWalkWith(ctx, files, root, dec, matchers, WalkOptions{})
+}
+
+// DecodeStreamWalkWith is DecodeStreamWalk with explicit options.
+func DecodeStreamWalkWith(
+	ctx context.Context, files afero.Fs, root string,
+	dec StreamDecoder, matchers []FileMatcher, opts WalkOptions,
+) (*WalkResult, error) {
+	if files == nil {
+		panic("cipher: DecodeStreamWalkWith: filesystem required")
+	}
+	if dec == nil {
+		panic("cipher: DecodeStreamWalkWith: decoder required")
+	}
+	serializeCallbacks(&opts)
+	if opts.DryRun {
+		return runPlan(ctx, files, root, matchers, opts, planDecodeStream)
+	}
+	rec := recordWalk(&opts, "")
+	dest := newWalkDest(files, root, opts)
+	return runWalk(ctx, files, root, matchers, opts, rec,
+		func(ctx context.Context, dst afero.Fs, path string, info fs.FileInfo) error {
+			prefix, err := readPrefix(files, path, len(sopsx.StreamMagic)+1)
+			if err != nil {
+				return err
+			}
+			if !sopsx.IsEncryptedStream(prefix) {
+				if err := dest.passThroughStream(dst, path, info); err != nil {
+					return err
+				}
+				notify(opts.OnSkip, path, ErrNotEncrypted)
+				return nil
+			}
+			return streamWalkFile(ctx, dest, dst, path, info, opts, "decode", dec.DecodeStream)
+		})
+}
+
+// streamFunc is the shared shape of EncodeStream and DecodeStream.
+type streamFunc func(ctx context.Context, path string, dst io.Writer, src io.Reader) error
+
+// streamWalkFile backs up path when requested, then pipes its current
+// contents through fn into a staged temp file on dst that replaces
+// path, or its mirror in an output tree. verb labels errors ("encode"
+// or "decode").
+func streamWalkFile(
+	ctx context.Context, dest walkDest, dst afero.Fs, path string, info fs.FileInfo,
+	opts WalkOptions, verb string, fn streamFunc,
+) error {
+	if err := writeBackupStream(dst, path, info, dest.backupSuffix); err != nil {
+		return err
+	}
+	target, err := dest.target(dst, path)
+	if err != nil {
+		return err
+	}
+	var written int64
+	err = atomic.WriteStream(dst, target, info.Mode().Perm(), func(w io.Writer) error {
+		src, err := dest.files.Open(path)
+		if err != nil {
+			return fmt.Errorf("open %q: %w", path, err)
+		}
+		defer func() { _ = src.Close() }()
+		cw := &countingWriter{w: w}
+		err = fn(ctx, path, cw, src)
+		written = cw.n
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%s %q: %w", verb, path, err)
+	}
+	notify(opts.OnFile, path, int(written))
+	return nil
+}
+
+// readPrefix returns up to n leading bytes of path. A short file
+// yields a short (possibly empty) slice and no error.
+func readPrefix(files afero.Fs, path string, n int) ([]byte, error) {
+	f, err := files.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %q: %w", path, err)
+	}
+	defer func() { _ = f.Close() }()
+	buf := make([]byte, n)
+	got, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("read %q: %w", path, err)
+	}
+	return buf[:got], nil
+}
+
+// writeBackupStream is writeBackup for inputs too large to buffer. It
+// copies path to path+suffix through a staged temp file.
+func writeBackupStream(files afero.Fs, path string, info fs.FileInfo, suffix string) error {
+	if suffix == "" {
+		return nil
+	}
+	backupPath := path + suffix
+	err := atomic.WriteStream(files, backupPath, info.Mode().Perm(), func(w io.Writer) error {
+		src, err := files.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = src.Close() }()
+		_, err = io.Copy(w, src)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("backup %q -> %q: %w", path, backupPath, err)
+	}
+	return nil
+}
+
+// countingWriter counts bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write forwards to the wrapped writer and adds to the count.
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,234 @@
+package cipher_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/afero"
+
+	"github.com/dcadolph/cipher"
+	cipherage "github.com/dcadolph/cipher/age"
+)
+
+// TestStreamRoundTrip encrypts a multi-chunk payload with a
+// StreamEncoder and recovers it with a StreamDecoder.
+func TestStreamRoundTrip(t *testing.T) {
+	recipient := newAgeIdentity(t)
+	var encCalls, decCalls int
+	enc := cipher.NewStreamEncoderWith(cipherage.MustNewProvider(recipient), cipher.StreamEncoderOptions{
+		ChunkSize: 1024,
+		OnEncrypt: func(_ string, plain, ciphertext int64) {
+			encCalls++
+			if plain != 10_000 || ciphertext <= plain {
+				t.Errorf("OnEncrypt sizes = (%d, %d)", plain, ciphertext)
+			}
+		},
+	})
+	dec := cipher.NewStreamDecoderWith(cipher.StreamDecoderOptions{
+		OnDecrypt: func(string, int64, int64) { decCalls++ },
+	})
+	ctx := context.Background()
+
+	plain := bytes.Repeat([]byte("0123456789"), 1_000)
+	var enc1 bytes.Buffer
+	if err := enc.EncodeStream(ctx, "dump.sql", &enc1, bytes.NewReader(plain)); err != nil {
+		t.Fatalf("EncodeStream: %v", err)
+	}
+	if !cipher.IsEncryptedStream(enc1.Bytes()) {
+		t.Fatalf("IsEncryptedStream = false")
+	}
+	if bytes.Contains(enc1.Bytes(), []byte("0123456789")) {
+		t.Errorf("plaintext visible in stream")
+	}
+	var got bytes.Buffer
+	if err := dec.DecodeStream(ctx, "dump.sql", &got, bytes.NewReader(enc1.Bytes())); err != nil {
+		t.Fatalf("DecodeStream: %v", err)
+	}
+	if diff := cmp.Diff(string(plain), got.String()); diff != "" {
+		t.Errorf("round trip mismatch (-want +got):\n%s", diff)
+	}
+	if encCalls != 1 || decCalls != 1 {
+		t.Errorf("callbacks = (%d, %d), want (1, 1)", encCalls, decCalls)
+	}
+}
+
+// TestStreamErrors covers the sentinel errors surfaced by stream
+// encoders and decoders.
+func TestStreamErrors(t *testing.T) {
+	recipient := newAgeIdentity(t)
+	enc := cipher.NewStreamEncoder(cipherage.MustNewProvider(recipient))
+	dec := cipher.NewStreamDecoder()
+	ctx := context.Background()
+
+	var encrypted bytes.Buffer
+	if err := enc.EncodeStream(ctx, "x", &encrypted, strings.NewReader("payload")); err != nil {
+		t.Fatalf("EncodeStream: %v", err)
+	}
+	truncated := encrypted.String()[:strings.LastIndex(strings.TrimSuffix(encrypted.String(), "\n"), "\n")+1]
+
+	tests := []struct {
+		Name    string
+		Run     func() error
+		WantErr []error
+	}{
+		// Test 0: Encoding an empty reader.
+		{
+			Name:    "empty",
+			Run:     func() error { return enc.EncodeStream(ctx, "x", &bytes.Buffer{}, strings.NewReader("")) },
+			WantErr: []error{cipher.ErrEmpty},
+		},
+		// Test 1: Encoding an existing stream.
+		{
+			Name: "already encrypted",
+			Run: func() error {
+				return enc.EncodeStream(ctx, "x", &bytes.Buffer{}, bytes.NewReader(encrypted.Bytes()))
+			},
+			WantErr: []error{cipher.ErrAlreadyEncrypted},
+		},
+		// Test 2: Decoding plaintext.
+		{
+			Name:    "not encrypted",
+			Run:     func() error { return dec.DecodeStream(ctx, "x", &bytes.Buffer{}, strings.NewReader("plain")) },
+			WantErr: []error{cipher.ErrNotEncrypted},
+		},
+		// Test 3: Decoding a stream missing its trailer.
+		{
+			Name:    "truncated",
+			Run:     func() error { return dec.DecodeStream(ctx, "x", &bytes.Buffer{}, strings.NewReader(truncated)) },
+			WantErr: []error{cipher.ErrDecode, cipher.ErrStreamTruncated},
+		},
+		// Test 4: Key provider yields no groups.
+		{
+			Name: "no key groups",
+			Run: func() error {
+				empty := cipher.NewStreamEncoder(cipher.StaticKeyProvider())
+				return empty.EncodeStream(ctx, "x", &bytes.Buffer{}, strings.NewReader("a"))
+			},
+			WantErr: []error{cipher.ErrEncode, cipher.ErrNoKeyGroups},
+		},
+	}
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			err := test.Run()
+			for _, want := range test.WantErr {
+				if !errors.Is(err, want) {
+					t.Errorf("err = %v, want %v", err, want)
+				}
+			}
+		})
+	}
+}
+
+// TestStreamWalk encrypts a tree with EncodeStreamWalk, verifies skips
+// and backups, and restores it with DecodeStreamWalk.
+func TestStreamWalk(t *testing.T) {
+	recipient := newAgeIdentity(t)
+	enc := cipher.NewStreamEncoderWith(cipherage.MustNewProvider(recipient),
+		cipher.StreamEncoderOptions{ChunkSize: 8})
+	dec := cipher.NewStreamDecoder()
+	ctx := context.Background()
+
+	files := afero.NewMemMapFs()
+	seed := map[string]string{
+		"/data/a.bin":   "alpha payload spanning chunks",
+		"/data/b.bin":   "bravo",
+		"/data/empty":   "",
+		"/data/skip.md": "not matched",
+	}
+	for p, c := range seed {
+		if err := afero.WriteFile(files, p, []byte(c), 0o640); err != nil {
+			t.Fatalf("seed %s: %v", p, err)
+		}
+	}
+
+	var done []string
+	skipped := map[string]error{}
+	opts := cipher.WalkOptions{
+		BackupSuffix: ".bak",
+		OnFile:       func(p string, _ int) { done = append(done, p) },
+		OnSkip:       func(p string, err error) { skipped[p] = err },
+	}
+	matchers := []cipher.FileMatcher{cipher.MatchRegex(regexp.MustCompile(`(\.bin|/empty)$`))}
+	if _, err := cipher.EncodeStreamWalkWith(ctx, files, "/data", enc, matchers, opts); err != nil {
+		t.Fatalf("EncodeStreamWalkWith: %v", err)
+	}
+	if diff := cmp.Diff([]string{"/data/a.bin", "/data/b.bin"}, done); diff != "" {
+		t.Errorf("OnFile mismatch (-want +got):\n%s", diff)
+	}
+	if !errors.Is(skipped["/data/empty"], cipher.ErrEmpty) {
+		t.Errorf("empty skip reason = %v", skipped["/data/empty"])
+	}
+	for _, p := range []string{"/data/a.bin", "/data/b.bin"} {
+		data, _ := afero.ReadFile(files, p)
+		if !cipher.IsEncryptedStream(data) {
+			t.Errorf("%s not encrypted", p)
+		}
+		backup, _ := afero.ReadFile(files, p+".bak")
+		if string(backup) != seed[p] {
+			t.Errorf("%s backup = %q, want %q", p, backup, seed[p])
+		}
+		info, _ := files.Stat(p)
+		if info.Mode().Perm() != 0o640 {
+			t.Errorf("%s mode = %v, want 0640", p, info.Mode().Perm())
+		}
+	}
+
+	// A second encode pass skips everything already encrypted.
+	skipped = map[string]error{}
+	opts.BackupSuffix = ""
+	if _, err := cipher.EncodeStreamWalkWith(ctx, files, "/data", enc, matchers, opts); err != nil {
+		t.Fatalf("second EncodeStreamWalkWith: %v", err)
+	}
+	if !errors.Is(skipped["/data/a.bin"], cipher.ErrAlreadyEncrypted) {
+		t.Errorf("second pass skip reason = %v", skipped["/data/a.bin"])
+	}
+
+	if _, err := cipher.DecodeStreamWalk(ctx, files, "/data", dec, matchers); err != nil {
+		t.Fatalf("DecodeStreamWalk: %v", err)
+	}
+	for _, p := range []string{"/data/a.bin", "/data/b.bin"} {
+		data, _ := afero.ReadFile(files, p)
+		if string(data) != seed[p] {
+			t.Errorf("%s = %q, want %q", p, data, seed[p])
+		}
+	}
+}
+
+// TestDecodeStreamWalkKeepsFileOnFailure verifies a corrupt stream is
+// left untouched instead of being replaced by partial plaintext.
+func TestDecodeStreamWalkKeepsFileOnFailure(t *testing.T) {
+	recipient := newAgeIdentity(t)
+	enc := cipher.NewStreamEncoderWith(cipherage.MustNewProvider(recipient),
+		cipher.StreamEncoderOptions{ChunkSize: 4})
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	if err := enc.EncodeStream(ctx, "a.bin", &buf, strings.NewReader("abcdefghijkl")); err != nil {
+		t.Fatalf("EncodeStream: %v", err)
+	}
+	s := strings.TrimSuffix(buf.String(), "\n")
+	corrupt := s[:strings.LastIndex(s, "\n")+1]
+
+	files := afero.NewMemMapFs()
+	if err := afero.WriteFile(files, "/d/a.bin", []byte(corrupt), 0o600); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	_, err := cipher.DecodeStreamWalk(ctx, files, "/d", cipher.NewStreamDecoder(), nil)
+	if !errors.Is(err, cipher.ErrStreamTruncated) {
+		t.Fatalf("err = %v, want ErrStreamTruncated", err)
+	}
+	got, _ := afero.ReadFile(files, "/d/a.bin")
+	if string(got) != corrupt {
+		t.Errorf("file modified after failed decode")
+	}
+	entries, _ := afero.ReadDir(files, "/d")
+	if len(entries) != 1 {
+		t.Errorf("entries = %d, want 1", len(entries))
+	}
+}
@@ -0,0 +1,85 @@
+package cipher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dcadolph/cipher/internal/sopsx"
+)
+
+// TextconvMode selects how Textconv renders an encrypted file.
+type TextconvMode = sopsx.TextconvMode
+
+// Textconv modes.
+const (
+	// TextconvPlain renders the decrypted file in its own format.
+	TextconvPlain = sopsx.TextconvPlain
+	// TextconvMasked lists every leaf as `["key"][0] = <masked digest>`.
+	// The digest is an HMAC-SHA256 under a key derived from the file's
+	// data key with HKDF, so it changes only when the value does and
+	// reveals nothing without the key. Rotating the data key changes
+	// every digest.
+	TextconvMasked = sopsx.TextconvMasked
+	// TextconvKeysOnly lists every leaf as `["key"][0] = <stored digest>`,
+	// a hash of the value as stored in the file. It decrypts nothing and
+	// needs no identity. Any re-encryption of a value changes its digest,
+	// even when the plaintext is the same.
+	TextconvKeysOnly = sopsx.TextconvKeysOnly
+)
+
+// Textconv renders an encrypted file as stable text for git's
+// diff.<driver>.textconv: the same input always renders the same bytes,
+// and sops metadata is omitted, so a diff shows only changed content.
+// Masked and keys-only output list one leaf per line in document order
+// with comments dropped. Returns ErrNotEncrypted when data carries no
+// sops metadata.
+func Textconv(ctx context.Context, path string, data []byte, mode TextconvMode) ([]byte, error) {
+	return TextconvWith(ctx, path, data, mode, DecoderOptions{})
+}
+
+// TextconvWith is Textconv with explicit options. OnUnwrapAudit fires
+// when the mode decrypts; the other callbacks do not.
+func TextconvWith(
+	ctx context.Context, path string, data []byte, mode TextconvMode, opts DecoderOptions,
+) (_ []byte, err error) {
+	defer wrapError(&err, "textconv", path, opts.Format)
+	log := opts.Logger
+	if log == nil {
+		log = NopLogger
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	services, err := identityKeyServices(opts.KeyServices, opts.AgeIdentities, opts.PGPPrivateKeys)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+
+	log.Debugf("cipher.Textconv start: path=%s bytes=%d mode=%d", path, len(data), mode)
+	out, err := sopsx.Textconv(ctx, sopsx.TextconvInput{
+		Path:               path,
+		Data:               data,
+		Format:             opts.Format,
+		Mode:               mode,
+		KeyServices:        services,
+		DecryptionOrder:    opts.DecryptionOrder,
+		IgnoreMAC:          opts.IgnoreMAC,
+		Cipher:             opts.Cipher,
+		MaxCiphertextBytes: opts.MaxCiphertextBytes,
+		OnUnwrap:           unwrapAudit(opts.OnUnwrapAudit, path),
+	})
+	if ue := asUnwrapError(path, err); ue != nil {
+		return nil, ue
+	}
+	switch {
+	case errors.Is(err, sopsx.ErrNotEncrypted):
+		return nil, ErrNotEncrypted
+	case errors.Is(err, sopsx.ErrTooLarge):
+		return nil, ErrTooLarge
+	case err != nil:
+		return nil, fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+	log.Debugf("cipher.Textconv done: path=%s bytes=%d", path, len(out))
+	return out, nil
+}
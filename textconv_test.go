@@ -0,0 +1,66 @@
+package cipher_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/dcadolph/cipher"
+	cipherage "github.com/dcadolph/cipher/age"
+)
+
+// TestTextconv renders an encrypted JSON file in each mode.
+func TestTextconv(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	id := mustAgeIdentity(t)
+	enc, err := cipher.NewEncoder(cipherage.MustNewProvider(id.Recipient().String())).
+		Encode(ctx, "s.json", []byte(`{"db":{"password":"hunter2"},"port":5432}`))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	digest := regexp.MustCompile(`<(masked|stored) [0-9a-f]{12}>`)
+
+	tests := []struct {
+		Name    string
+		Mode    cipher.TextconvMode
+		Opts    cipher.DecoderOptions
+		Want    string
+		WantErr error
+	}{{ // Test 0: Plain mode decrypts.
+		Name: "plain", Mode: cipher.TextconvPlain,
+		Opts: cipher.DecoderOptions{AgeIdentities: []string{id.String()}},
+		Want: "{\n\t\"db\": {\n\t\t\"password\": \"hunter2\"\n\t},\n\t\"port\": 5432\n}\n",
+	}, { // Test 1: Masked mode hides values.
+		Name: "masked", Mode: cipher.TextconvMasked,
+		Opts: cipher.DecoderOptions{AgeIdentities: []string{id.String()}},
+		Want: "[\"db\"][\"password\"] = <masked>\n[\"port\"] = <masked>\n",
+	}, { // Test 2: Keys-only mode needs no identity.
+		Name: "keys only", Mode: cipher.TextconvKeysOnly,
+		Want: "[\"db\"][\"password\"] = <stored>\n[\"port\"] = <stored>\n",
+	}, { // Test 3: Masked mode without an identity fails to unwrap.
+		Name: "no identity", Mode: cipher.TextconvMasked, WantErr: cipher.ErrDecode,
+	}}
+
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			t.Parallel()
+			out, err := cipher.TextconvWith(ctx, "s.json", enc, test.Mode, test.Opts)
+			if !errors.Is(err, test.WantErr) || (test.WantErr == nil && err != nil) {
+				t.Fatalf("TextconvWith err = %v, want %v", err, test.WantErr)
+			}
+			got := digest.ReplaceAllString(string(out), "<$1>")
+			if diff := cmp.Diff(test.Want, got); diff != "" {
+				t.Errorf("output mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if _, err := cipher.Textconv(ctx, "s.json", []byte(`{"a":1}`), cipher.TextconvKeysOnly); !errors.Is(err, cipher.ErrNotEncrypted) {
+		t.Errorf("plain input err = %v, want ErrNotEncrypted", err)
+	}
+}
@@ -0,0 +1,336 @@
+package cipher
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/spf13/afero"
+
+	"github.com/dcadolph/cipher/internal/atomic"
+)
+
+// Journal files are named journalPrefix + walk ID + journalSuffix and
+// live in the walk root.
+const (
+	journalPrefix = ".cipher-journal-"
+	journalSuffix = ".jsonl"
+)
+
+// txStage is one staged rename in a transactional walk: Temp replaces
+// Path on commit. Orig is where Path's current contents are moved
+// during the commit, empty when Path did not exist.
+type txStage struct {
+	Path string `json:"path"`
+	Temp string `json:"temp"`
+	Orig string `json:"orig,omitempty"`
+}
+
+// journalRecord is one line of a walk journal: a staged rename, or the
+// marker written once every rename has been applied.
+type journalRecord struct {
+	Stage     *txStage `json:"stage,omitempty"`
+	Committed bool     `json:"committed,omitempty"`
+}
+
+// walkTx is the afero.Fs a transactional walk hands to its per-file
+// work. Renames, which is how internal/atomic publishes a finished
+// temp file, are recorded in the journal instead of applied; commit
+// applies them all and rollback discards them. Everything else goes
+// straight to the wrapped Fs. It is safe for concurrent use.
+type walkTx struct {
+	afero.Fs
+	dir string
+	id  string
+
+	mu          sync.Mutex
+	journal     afero.File
+	journalPath string
+	stages      []txStage
+	staged      map[string]struct{}
+}
+
+// journalDir returns the directory that holds the journal of a
+// transactional walk of root on files: the root of the tree the walk
+// writes, or that root's directory when the walk is of a single file.
+func journalDir(files afero.Fs, root string, opts WalkOptions) string {
+	dir := root
+	if opts.OutputRoot != "" {
+		dir = opts.OutputRoot
+	}
+	if info, err := files.Stat(root); err == nil && !info.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// newWalkTx returns a transaction over files whose journal will live
+// in dir. The journal is created on the first staged rename, so a
+// walk that writes nothing leaves no trace.
+func newWalkTx(files afero.Fs, dir string) (*walkTx, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, fmt.Errorf("transaction id: %w", err)
+	}
+	return &walkTx{
+		Fs:     files,
+		dir:    dir,
+		id:     hex.EncodeToString(b[:]),
+		staged: make(map[string]struct{}),
+	}, nil
+}
+
+// Rename stages oldname to replace newname at commit and records it in
+// the journal. newname is left untouched until then.
+func (tx *walkTx) Rename(oldname, newname string) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if _, dup := tx.staged[newname]; dup {
+		return fmt.Errorf("transaction: %q staged twice", newname)
+	}
+	if tx.journal == nil {
+		tx.journalPath = filepath.Join(tx.dir, journalPrefix+tx.id+journalSuffix)
+		f, err := tx.Fs.OpenFile(tx.journalPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0o600)
+		if err != nil {
+			return fmt.Errorf("transaction: open journal: %w", err)
+		}
+		tx.journal = f
+	}
+	st := txStage{Path: newname, Temp: oldname}
+	switch _, err := tx.Fs.Stat(newname); {
+	case err == nil:
+		st.Orig = filepath.Join(filepath.Dir(newname), "."+filepath.Base(newname)+".orig."+tx.id)
+	case !errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("transaction: stat %q: %w", newname, err)
+	}
+	if err := tx.record(journalRecord{Stage: &st}); err != nil {
+		return err
+	}
+	tx.stages = append(tx.stages, st)
+	tx.staged[newname] = struct{}{}
+	return nil
+}
+
+// record appends rec to the journal and syncs it.
+func (tx *walkTx) record(rec journalRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("transaction: encode journal: %w", err)
+	}
+	if _, err := tx.journal.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("transaction: write journal %q: %w", tx.journalPath, err)
+	}
+	if err := tx.journal.Sync(); err != nil {
+		return fmt.Errorf("transaction: sync journal %q: %w", tx.journalPath, err)
+	}
+	return nil
+}
+
+// commit applies every staged rename, moving each replaced file aside
+// first so a failure part way can be undone, then marks the journal
+// committed and removes the originals and the journal. A failure
+// before the marker rolls the whole walk back.
+func (tx *walkTx) commit() error {
+	if tx.journal == nil {
+		return nil
+	}
+	for _, st := range tx.stages {
+		if err := swapIn(tx.Fs, st); err != nil {
+			return errors.Join(err, tx.rollback())
+		}
+	}
+	if err := tx.record(journalRecord{Committed: true}); err != nil {
+		return errors.Join(err, tx.rollback())
+	}
+	if err := finishStages(tx.Fs, tx.stages); err != nil {
+		_ = tx.journal.Close()
+		return fmt.Errorf("transaction: committed, but cleanup failed: %w; run RecoverWalks on %q", err, tx.dir)
+	}
+	return tx.closeJournal()
+}
+
+// rollback undoes every staged or applied rename and removes the
+// journal. When the rollback itself fails the journal is kept so
+// RecoverWalks can finish the job.
+func (tx *walkTx) rollback() error {
+	if tx.journal == nil {
+		return nil
+	}
+	if err := rollbackStages(tx.Fs, tx.stages); err != nil {
+		_ = tx.journal.Close()
+		return fmt.Errorf("transaction: rollback: %w; run RecoverWalks on %q", err, tx.dir)
+	}
+	return tx.closeJournal()
+}
+
+// closeJournal closes and removes the journal.
+func (tx *walkTx) closeJournal() error {
+	_ = tx.journal.Close()
+	if err := tx.Fs.Remove(tx.journalPath); err != nil {
+		return fmt.Errorf("transaction: remove journal: %w", err)
+	}
+	return nil
+}
+
+// swapIn moves st.Path aside to st.Orig, when it exists, and renames
+// st.Temp into its place.
+func swapIn(files afero.Fs, st txStage) error {
+	if st.Orig != "" {
+		if err := atomic.Rename(files, st.Path, st.Orig); err != nil {
+			return err
+		}
+	}
+	return atomic.Rename(files, st.Temp, st.Path)
+}
+
+// rollbackStages restores every path in stages to its state before
+// the walk, newest first. It is idempotent, so it is safe to run on a
+// walk that was interrupted at any point before its commit marker.
+func rollbackStages(files afero.Fs, stages []txStage) error {
+	var errs []error
+	for i := len(stages) - 1; i >= 0; i-- {
+		st := stages[i]
+		if err := removeIfExists(files, st.Temp); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if st.Orig == "" {
+			// Path is new to this walk, so whatever is there now is ours.
+			if err := removeIfExists(files, st.Path); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		switch ok, err := afero.Exists(files, st.Orig); {
+		case err != nil:
+			errs = append(errs, err)
+		case ok:
+			if err := atomic.Rename(files, st.Orig, st.Path); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// finishStages removes what a committed walk leaves behind: the
+// originals moved aside and any temp file not yet renamed.
+func finishStages(files afero.Fs, stages []txStage) error {
+	var errs []error
+	for _, st := range stages {
+		if err := removeIfExists(files, st.Temp); err != nil {
+			errs = append(errs, err)
+		}
+		if st.Orig != "" {
+			if err := removeIfExists(files, st.Orig); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// removeIfExists removes path, treating a missing file as success.
+func removeIfExists(files afero.Fs, path string) error {
+	if err := files.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove %q: %w", path, err)
+	}
+	return nil
+}
+
+// WalkRecovery describes one journal replayed by RecoverWalks.
+type WalkRecovery struct {
+	// Journal is the journal path.
+	Journal string
+	// Committed reports whether the walk had committed. A committed
+	// walk is completed by removing what it left behind; any other
+	// walk is rolled back.
+	Committed bool
+	// Files lists the paths the walk staged, in journal order.
+	Files []string
+}
+
+// RecoverWalks finds the journals that transactional walks left under
+// root, such as after a crash, and replays each one: walks that had
+// committed are completed and every other walk is rolled back, leaving
+// its files as they were before it started. Each replayed journal is
+// removed. It must not run while a transactional walk under root is
+// still in progress.
+func RecoverWalks(files afero.Fs, root string) ([]WalkRecovery, error) {
+	if files == nil {
+		panic("cipher: RecoverWalks: filesystem required")
+	}
+	var journals []string
+	err := afero.Walk(files, root, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if !info.IsDir() && strings.HasPrefix(name, journalPrefix) && strings.HasSuffix(name, journalSuffix) {
+			journals = append(journals, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]WalkRecovery, 0, len(journals))
+	var errs []error
+	for _, path := range journals {
+		rec, err := recoverJournal(files, path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("recover %q: %w", path, err))
+			continue
+		}
+		out = append(out, rec)
+	}
+	return out, errors.Join(errs...)
+}
+
+// recoverJournal replays and removes the journal at path.
+func recoverJournal(files afero.Fs, path string) (WalkRecovery, error) {
+	rec := WalkRecovery{Journal: path}
+	data, err := afero.ReadFile(files, path)
+	if err != nil {
+		return rec, err
+	}
+	var stages []txStage
+	lines := bufio.NewScanner(bytes.NewReader(data))
+	for lines.Scan() {
+		var r journalRecord
+		if err := json.Unmarshal(lines.Bytes(), &r); err != nil {
+			// A crash can tear the last line. The rename it described
+			// was never reported as staged, so its temp file is only
+			// an orphan.
+			break
+		}
+		switch {
+		case r.Committed:
+			rec.Committed = true
+		case r.Stage != nil:
+			stages = append(stages, *r.Stage)
+			rec.Files = append(rec.Files, r.Stage.Path)
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return rec, err
+	}
+	if rec.Committed {
+		err = finishStages(files, stages)
+	} else {
+		err = rollbackStages(files, stages)
+	}
+	if err != nil {
+		return rec, err
+	}
+	return rec, files.Remove(path)
+}
@@ -0,0 +1,210 @@
+package cipher_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/afero"
+
+	"github.com/dcadolph/cipher"
+)
+
+// TestEncodeWalkTransactional verifies that a transactional walk
+// replaces every file only when all succeed, and otherwise leaves the
+// tree exactly as it found it, with no temp files, backups, or journal.
+func TestEncodeWalkTransactional(t *testing.T) {
+	t.Parallel()
+	boom := errors.New("boom")
+	seed := map[string]string{
+		"root/a.yaml":     "a: 1\n",
+		"root/b.yaml":     "b: 2\n",
+		"root/sub/c.yaml": "c: 3\n",
+	}
+	encoder := func(fail string, cancel context.CancelFunc) cipher.Encoder {
+		return cipher.EncoderFunc(func(_ context.Context, path string, data []byte) ([]byte, error) {
+			if path == fail {
+				if cancel != nil {
+					cancel()
+					return append([]byte("enc:"), data...), nil
+				}
+				return nil, boom
+			}
+			return append([]byte("enc:"), data...), nil
+		})
+	}
+
+	tests := []struct {
+		Name    string
+		Fail    string
+		Cancel  bool
+		Opts    cipher.WalkOptions
+		WantErr error
+	}{
+		// Test 0: A failure part way rolls back the files already
+		// staged.
+		{
+			Name:    "failure",
+			Fail:    "root/b.yaml",
+			Opts:    cipher.WalkOptions{BackupSuffix: ".bak"},
+			WantErr: boom,
+		},
+		// Test 1: A parallel walk that keeps going still rolls back.
+		{
+			Name:    "continue parallel",
+			Fail:    "root/sub/c.yaml",
+			Opts:    cipher.WalkOptions{Parallelism: 3, ContinueOnError: true},
+			WantErr: boom,
+		},
+		// Test 2: Canceling the context rolls back.
+		{
+			Name:    "cancel",
+			Fail:    "root/a.yaml",
+			Cancel:  true,
+			WantErr: context.Canceled,
+		},
+		// Test 3: Success replaces every file and keeps backups.
+		{
+			Name: "success",
+			Opts: cipher.WalkOptions{BackupSuffix: ".bak", Parallelism: 2},
+		},
+	}
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			t.Parallel()
+			files := seedTree(t, seed)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var enc cipher.Encoder
+			if test.Cancel {
+				enc = encoder(test.Fail, cancel)
+			} else {
+				enc = encoder(test.Fail, nil)
+			}
+			opts := test.Opts
+			opts.Transactional = true
+			_, err := cipher.EncodeWalkWith(ctx, files, "root", enc, nil, opts)
+			if !errors.Is(err, test.WantErr) {
+				t.Fatalf("err = %v, want %v", err, test.WantErr)
+			}
+			want := seed
+			if test.WantErr == nil {
+				want = make(map[string]string)
+				for path, data := range seed {
+					want[path] = "enc:" + data
+					want[path+".bak"] = data
+				}
+			}
+			if diff := cmp.Diff(want, readTree(t, files)); diff != "" {
+				t.Errorf("tree (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestRecoverWalks verifies that RecoverWalks rolls back a walk whose
+// rollback was interrupted, and completes a walk whose cleanup was.
+func TestRecoverWalks(t *testing.T) {
+	t.Parallel()
+	seed := map[string]string{"root/a.yaml": "a: 1\n", "root/b.yaml": "b: 2\n"}
+	enc := cipher.EncoderFunc(func(_ context.Context, _ string, data []byte) ([]byte, error) {
+		return append([]byte("enc:"), data...), nil
+	})
+	encrypted := map[string]string{"root/a.yaml": "enc:a: 1\n", "root/b.yaml": "enc:b: 2\n"}
+
+	tests := []struct {
+		Name          string
+		FailRename    string
+		WantCommitted bool
+		Want          map[string]string
+	}{
+		// Test 0: Moving b aside fails during the commit and every
+		// removal fails during the rollback, so the walk leaves a
+		// journal behind; recovery restores the originals.
+		{
+			Name:       "rollback",
+			FailRename: "root/b.yaml",
+			Want:       seed,
+		},
+		// Test 1: Every rename succeeds but cleanup fails after the
+		// commit marker; recovery keeps the new contents.
+		{
+			Name:          "committed",
+			WantCommitted: true,
+			Want:          encrypted,
+		},
+	}
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			t.Parallel()
+			flaky := &flakyFs{Fs: seedTree(t, seed), failRename: test.FailRename, failRemove: true}
+			_, err := cipher.EncodeWalkWith(context.Background(), flaky, "root", enc, nil,
+				cipher.WalkOptions{Transactional: true})
+			if err == nil || !strings.Contains(err.Error(), "RecoverWalks") {
+				t.Fatalf("err = %v, want a pointer to RecoverWalks", err)
+			}
+
+			flaky.failRename, flaky.failRemove = "", false
+			got, err := cipher.RecoverWalks(flaky, "root")
+			if err != nil {
+				t.Fatalf("RecoverWalks: %v", err)
+			}
+			if len(got) != 1 || got[0].Committed != test.WantCommitted ||
+				!cmp.Equal(got[0].Files, []string{"root/a.yaml", "root/b.yaml"}) {
+				t.Errorf("recoveries = %+v", got)
+			}
+			if diff := cmp.Diff(test.Want, readTree(t, flaky)); diff != "" {
+				t.Errorf("tree (-want +got):\n%s", diff)
+			}
+			if got, err := cipher.RecoverWalks(flaky, "root"); err != nil || len(got) != 0 {
+				t.Errorf("second RecoverWalks = %v, %v, want nothing", got, err)
+			}
+		})
+	}
+}
+
+// flakyFs fails renames away from failRename, and every removal while
+// failRemove is set.
+type flakyFs struct {
+	afero.Fs
+	failRename string
+	failRemove bool
+}
+
+// Rename fails when oldname is failRename.
+func (f *flakyFs) Rename(oldname, newname string) error {
+	if oldname == f.failRename {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: fs.ErrPermission}
+	}
+	return f.Fs.Rename(oldname, newname)
+}
+
+// Remove fails while failRemove is set.
+func (f *flakyFs) Remove(name string) error {
+	if f.failRemove {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	return f.Fs.Remove(name)
+}
+
+// seedTree returns an in-memory filesystem holding seed.
+func seedTree(t *testing.T, seed map[string]string) afero.Fs {
+	t.Helper()
+	files := afero.NewMemMapFs()
+	for path, data := range seed {
+		if err := afero.WriteFile(files, path, []byte(data), 0o600); err != nil {
+			t.Fatalf("write %q: %v", path, err)
+		}
+	}
+	return files
+}
+
+// readTree returns every file under root on files, keyed by slash path.
+func readTree(t *testing.T, files afero.Fs) map[string]string {
+	t.Helper()
+	return readFiles(t, files, "root")
+}
@@ -0,0 +1,62 @@
+package cipher
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/dcadolph/cipher/internal/sopsx"
+)
+
+// UnwrapAttempt records one master key tried while unwrapping a file's
+// data key: its key group, sops key type, identifier, and the error,
+// or nil for the key that succeeded.
+type UnwrapAttempt = sopsx.UnwrapAttempt
+
+// UnwrapError is returned by decode operations when no master key
+// could unwrap the data key. It wraps ErrDecode and every failed
+// attempt's error, so errors.Is matches both; use errors.As to read
+// the per-key report.
+type UnwrapError struct {
+	// Path is the file that failed to decode.
+	Path string
+	// Attempts lists every master key tried, in order.
+	Attempts []UnwrapAttempt
+}
+
+// Error implements error.
+func (e *UnwrapError) Error() string {
+	var b strings.Builder
+	b.WriteString(ErrDecode.Error())
+	if e.Path != "" {
+		b.WriteString(": ")
+		b.WriteString(e.Path)
+	}
+	b.WriteString(": no master key could unwrap the data key")
+	for _, a := range e.Attempts {
+		b.WriteString("; ")
+		b.WriteString(a.String())
+	}
+	return b.String()
+}
+
+// Unwrap returns ErrDecode followed by the error of every failed
+// attempt.
+func (e *UnwrapError) Unwrap() []error {
+	errs := []error{ErrDecode}
+	for _, a := range e.Attempts {
+		if a.Err != nil {
+			errs = append(errs, a.Err)
+		}
+	}
+	return errs
+}
+
+// asUnwrapError converts a sopsx unwrap failure in err into an
+// *UnwrapError for path. It returns nil when err is not one.
+func asUnwrapError(path string, err error) *UnwrapError {
+	var ue *sopsx.UnwrapError
+	if !errors.As(err, &ue) {
+		return nil
+	}
+	return &UnwrapError{Path: path, Attempts: ue.Attempts}
+}
@@ -0,0 +1,68 @@
+package cipher_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dcadolph/cipher"
+	cipherage "github.com/dcadolph/cipher/age"
+)
+
+// TestDecodeUnwrapReport checks that OnUnwrapAudit names the key that
+// unwrapped and that a failed unwrap returns an *UnwrapError listing
+// every attempt while still matching ErrDecode.
+func TestDecodeUnwrapReport(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	holder, other, stranger := mustAgeIdentity(t), mustAgeIdentity(t), mustAgeIdentity(t)
+	enc := cipher.NewEncoder(cipherage.MustNewProvider(
+		other.Recipient().String(), holder.Recipient().String()))
+	data, err := enc.Encode(ctx, "s.yaml", []byte("a: b\n"))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	var audited []cipher.UnwrapAttempt
+	dec := cipher.NewDecoderWith(cipher.DecoderOptions{
+		AgeIdentities: []string{holder.String()},
+		OnUnwrapAudit: func(path string, attempts []cipher.UnwrapAttempt) {
+			if path != "s.yaml" {
+				t.Errorf("OnUnwrapAudit path = %q", path)
+			}
+			audited = attempts
+		},
+	})
+	if _, err := dec.Decode(ctx, "s.yaml", data); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if len(audited) != 2 || audited[0].Err == nil || audited[1].Err != nil {
+		t.Fatalf("attempts = %v, want first failed and second ok", audited)
+	}
+	if audited[1].Identifier != holder.Recipient().String() {
+		t.Errorf("unwrapped by %q, want %q", audited[1].Identifier, holder.Recipient().String())
+	}
+
+	audited = nil
+	dec = cipher.NewDecoderWith(cipher.DecoderOptions{
+		AgeIdentities: []string{stranger.String()},
+		OnUnwrapAudit: func(_ string, attempts []cipher.UnwrapAttempt) { audited = attempts },
+	})
+	_, err = dec.Decode(ctx, "s.yaml", data)
+	if !errors.Is(err, cipher.ErrDecode) {
+		t.Fatalf("Decode err = %v, want ErrDecode", err)
+	}
+	var ue *cipher.UnwrapError
+	if !errors.As(err, &ue) {
+		t.Fatalf("Decode err %T is not *UnwrapError", err)
+	}
+	if ue.Path != "s.yaml" || len(ue.Attempts) != 2 || len(audited) != 2 {
+		t.Errorf("UnwrapError = %+v, audited %d attempts", ue, len(audited))
+	}
+	for _, r := range []string{holder.Recipient().String(), other.Recipient().String()} {
+		if !strings.Contains(err.Error(), r) {
+			t.Errorf("error %q does not name %s", err, r)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package cipher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	yaml "go.yaml.in/yaml/v3"
+
+	"github.com/dcadolph/cipher/internal/sopsx"
+)
+
+// DecodeValue decrypts the single node of data selected by expr, a
+// key path in ParseKeyPath syntax such as `["db"]["password"]`. Only
+// the leaves under that node are decrypted, so the rest of the file
+// never becomes plaintext in process memory. Scalars render as their
+// raw value; maps and arrays re-encode in the file's format.
+//
+// YAML, JSON, and TOML files are supported. Because the file MAC spans every
+// leaf, it is not verified; each selected value is still authenticated
+// against its key path and the file's data key. Use Decode when whole
+// file integrity matters more than exposure.
+func DecodeValue(ctx context.Context, path string, data []byte, expr string) ([]byte, error) {
+	return DecodeValueWith(ctx, path, data, expr, DecoderOptions{})
+}
+
+// DecodeValueWith is DecodeValue with explicit options. IgnoreMAC has
+// no effect. OnDecrypt receives the size of the rendered value;
+// OnDecryptAudit and OnUnwrapAudit fire as they do for Decode.
+func DecodeValueWith(
+	ctx context.Context, path string, data []byte, expr string, opts DecoderOptions,
+) (_ []byte, err error) {
+	defer wrapError(&err, "decode", path, opts.Format)
+	log := opts.Logger
+	if log == nil {
+		log = NopLogger
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	steps, err := ParseKeyPath(expr)
+	if err != nil {
+		return nil, err
+	}
+	format := opts.Format
+	if format == 0 {
+		format = FormatForPath(path)
+	}
+	if format != FormatYAML && format != FormatJSON && format != FormatTOML {
+		return nil, fmt.Errorf("%w: %q: key paths need YAML, JSON, or TOML", ErrUnsupportedFormat, path)
+	}
+
+	services, err := identityKeyServices(opts.KeyServices, opts.AgeIdentities, opts.PGPPrivateKeys)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+
+	log.Debugf("cipher.DecodeValue start: path=%s bytes=%d expr=%s", path, len(data), steps)
+	node, err := sopsx.DecryptValue(ctx, sopsx.DecryptValueInput{
+		Path:               path,
+		Data:               data,
+		Format:             format,
+		Steps:              steps,
+		KeyServices:        services,
+		DecryptionOrder:    opts.DecryptionOrder,
+		Cipher:             opts.Cipher,
+		MaxCiphertextBytes: opts.MaxCiphertextBytes,
+		OnUnwrap:           unwrapAudit(opts.OnUnwrapAudit, path),
+	})
+	if ue := asUnwrapError(path, err); ue != nil {
+		return nil, ue
+	}
+	switch {
+	case errors.Is(err, sopsx.ErrNotEncrypted):
+		log.Warnf("cipher.DecodeValue skip not-encrypted: path=%s", path)
+		return nil, ErrNotEncrypted
+	case errors.Is(err, sopsx.ErrTooLarge):
+		return nil, ErrTooLarge
+	case errors.Is(err, sopsx.ErrPathNotFound):
+		return nil, fmt.Errorf("%w: %s: %w", ErrKeyNotFound, steps, err)
+	case err != nil:
+		return nil, fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+
+	out, err := renderValue(node, format)
+	if err != nil {
+		return nil, fmt.Errorf("%w: render %s: %w", ErrDecode, steps, err)
+	}
+	log.Debugf("cipher.DecodeValue done: path=%s expr=%s bytes=%d", path, steps, len(out))
+	if opts.OnDecrypt != nil {
+		opts.OnDecrypt(path, len(data), len(out))
+	}
+	if opts.OnDecryptAudit != nil {
+		auditDecrypt(log, opts.OnDecryptAudit, path, data)
+	}
+	return out, nil
+}
+
+// renderValue turns a decrypted node into output bytes. Scalars become
+// their raw value; maps and slices are re-encoded in format.
+func renderValue(node any, format Format) ([]byte, error) {
+	switch typed := node.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []byte(typed), nil
+	case bool:
+		return []byte(strconv.FormatBool(typed)), nil
+	case int:
+		return []byte(strconv.Itoa(typed)), nil
+	case int64:
+		return []byte(strconv.FormatInt(typed, 10)), nil
+	case float64:
+		return []byte(strconv.FormatFloat(typed, 'g', -1, 64)), nil
+	case time.Time:
+		return []byte(typed.Format(time.RFC3339Nano)), nil
+	}
+	switch format {
+	case FormatJSON:
+		return json.Marshal(node)
+	case FormatTOML:
+		return sopsx.MarshalTOML(node)
+	}
+	return yaml.Marshal(node)
+}
@@ -0,0 +1,144 @@
+package cipher_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/dcadolph/cipher"
+	cipherage "github.com/dcadolph/cipher/age"
+)
+
+// TestDecodeValue covers scalar, nested, array, subtree, and error
+// cases across YAML, JSON, and TOML.
+func TestDecodeValue(t *testing.T) {
+	recipient := newAgeIdentity(t)
+	enc := cipher.NewEncoder(cipherage.MustNewProvider(recipient))
+	ctx := context.Background()
+
+	tests := []struct {
+		Name    string
+		Path    string
+		In      string
+		Expr    string
+		WantOut string
+		WantErr error
+	}{{ // Test 0: Nested YAML scalar renders raw.
+		Name: "yaml nested", Path: "s.yaml",
+		In: "db:\n  password: super-secret\n", Expr: `["db"]["password"]`,
+		WantOut: "super-secret",
+	}, { // Test 1: Top-level JSON scalar.
+		Name: "json scalar", Path: "s.json",
+		In: `{"token":"abc123"}`, Expr: `["token"]`, WantOut: "abc123",
+	}, { // Test 2: Array index into JSON.
+		Name: "json index", Path: "s.json",
+		In: `{"hosts":["a","b","c"]}`, Expr: `["hosts"][1]`, WantOut: "b",
+	}, { // Test 3: Subtree re-encodes in YAML.
+		Name: "yaml subtree", Path: "s.yaml",
+		In: "db:\n  inner: value\n", Expr: `["db"]`, WantOut: "inner: value\n",
+	}, { // Test 4: Non-string scalars render raw.
+		Name: "yaml int", Path: "s.yaml",
+		In: "port: 5432\n", Expr: `["port"]`, WantOut: "5432",
+	}, { // Test 5: Comments around the selected key are ignored.
+		Name: "yaml comments", Path: "s.yaml",
+		In: "# note\ndb:\n  # pw\n  password: x\n", Expr: `["db"]["password"]`, WantOut: "x",
+	}, { // Test 6: Missing key errors.
+		Name: "missing key", Path: "s.yaml",
+		In: "db:\n  password: x\n", Expr: `["nope"]`, WantErr: cipher.ErrKeyNotFound,
+	}, { // Test 7: Index out of range errors.
+		Name: "index oob", Path: "s.json",
+		In: `{"hosts":["a"]}`, Expr: `["hosts"][5]`, WantErr: cipher.ErrKeyNotFound,
+	}, { // Test 8: Malformed expression.
+		Name: "bad expr", Path: "s.yaml",
+		In: "a: b\n", Expr: `a`, WantErr: cipher.ErrKeyPath,
+	}, { // Test 9: Unsupported format errors.
+		Name: "unsupported", Path: "s.env",
+		In: "K=v\n", Expr: `["K"]`, WantErr: cipher.ErrUnsupportedFormat,
+	}, { // Test 10: TOML table scalar.
+		Name: "toml scalar", Path: "s.toml",
+		In: "[db]\npassword = \"super-secret\"\n", Expr: `["db"]["password"]`,
+		WantOut: "super-secret",
+	}, { // Test 11: TOML subtree re-encodes in TOML.
+		Name: "toml subtree", Path: "s.toml",
+		In: "[db]\nport = 5432\nuser = \"app\"\n", Expr: `["db"]`,
+		WantOut: "port = 5432\nuser = \"app\"\n",
+	}, { // Test 12: Array of tables index.
+		Name: "toml array table", Path: "s.toml",
+		In: "[[hosts]]\nname = \"a\"\n\n[[hosts]]\nname = \"b\"\n", Expr: `["hosts"][1]["name"]`,
+		WantOut: "b",
+	}, { // Test 13: TOML datetime renders raw like other scalars.
+		Name: "toml datetime", Path: "s.toml",
+		In: "rotated = 2026-01-02T03:04:05.5Z\n", Expr: `["rotated"]`,
+		WantOut: "2026-01-02T03:04:05.5Z",
+	}}
+
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			data, err := enc.Encode(ctx, test.Path, []byte(test.In))
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			got, err := cipher.DecodeValue(ctx, test.Path, data, test.Expr)
+			if test.WantErr != nil {
+				if !errors.Is(err, test.WantErr) {
+					t.Fatalf("err = %v, want %v (out %q)", err, test.WantErr, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(test.WantOut, string(got)); diff != "" {
+				t.Errorf("output mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestDecodeValueOnlyDecryptsSelectedLeaf corrupts a sibling value and
+// verifies DecodeValue still succeeds, proving the sibling was never
+// decrypted, while a full Decode fails.
+func TestDecodeValueOnlyDecryptsSelectedLeaf(t *testing.T) {
+	recipient := newAgeIdentity(t)
+	enc := cipher.NewEncoder(cipherage.MustNewProvider(recipient))
+	ctx := context.Background()
+
+	data, err := enc.Encode(ctx, "s.yaml", []byte("keep: wanted\nother: sibling\n"))
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "other: ENC[") {
+			lines[i] = "other: ENC[AES256_GCM,data:AAAA,iv:AAAAAAAAAAAAAAAAAAAAAA==,tag:AAAAAAAAAAAAAAAAAAAAAA==,type:str]"
+		}
+	}
+	tampered := []byte(strings.Join(lines, "\n"))
+
+	got, err := cipher.DecodeValue(ctx, "s.yaml", tampered, `["keep"]`)
+	if err != nil {
+		t.Fatalf("DecodeValue: %v", err)
+	}
+	if string(got) != "wanted" {
+		t.Errorf("value = %q, want %q", got, "wanted")
+	}
+	if _, err := cipher.NewDecoder().Decode(ctx, "s.yaml", tampered); err == nil {
+		t.Errorf("full Decode of tampered file succeeded")
+	}
+	if _, err := cipher.DecodeValue(ctx, "s.yaml", tampered, `["other"]`); !errors.Is(err, cipher.ErrDecode) {
+		t.Errorf("tampered leaf err = %v, want ErrDecode", err)
+	}
+}
+
+// TestDecodeValueNotEncrypted verifies plain input maps to ErrNotEncrypted.
+func TestDecodeValueNotEncrypted(t *testing.T) {
+	t.Parallel()
+	_, err := cipher.DecodeValue(context.Background(), "s.yaml", []byte("a: b\n"), `["a"]`)
+	if !errors.Is(err, cipher.ErrNotEncrypted) {
+		t.Errorf("err = %v, want ErrNotEncrypted", err)
+	}
+}
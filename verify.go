@@ -0,0 +1,117 @@
+package cipher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/spf13/afero"
+
+	"github.com/dcadolph/cipher/internal/sopsx"
+)
+
+// Verify checks that data has not been tampered with. It unwraps the
+// data key, authenticates every value, and compares the file MAC, but
+// never returns or serializes plaintext. Values are decrypted in memory
+// because the MAC is defined over them.
+//
+// Returns nil for an intact file, ErrNotEncrypted for plain input, and
+// an error wrapping ErrMACMismatch when integrity fails. An error
+// wrapping ErrDecode means the check could not run; when no identity
+// could unwrap the data key it is an *UnwrapError.
+func Verify(ctx context.Context, path string, data []byte) error {
+	return VerifyWith(ctx, path, data, DecoderOptions{})
+}
+
+// VerifyWith is Verify with explicit options. Format, key services,
+// identities, decryption order, cipher, and MaxCiphertextBytes apply;
+// IgnoreMAC and the decrypt callbacks do not.
+func VerifyWith(ctx context.Context, path string, data []byte, opts DecoderOptions) (err error) {
+	defer wrapError(&err, "verify", path, opts.Format)
+	log := opts.Logger
+	if log == nil {
+		log = NopLogger
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	services, err := identityKeyServices(opts.KeyServices, opts.AgeIdentities, opts.PGPPrivateKeys)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+
+	log.Debugf("cipher.Verify start: path=%s bytes=%d", path, len(data))
+	err = sopsx.Verify(ctx, sopsx.VerifyInput{
+		Path:               path,
+		Data:               data,
+		Format:             opts.Format,
+		KeyServices:        services,
+		DecryptionOrder:    opts.DecryptionOrder,
+		Cipher:             opts.Cipher,
+		MaxCiphertextBytes: opts.MaxCiphertextBytes,
+	})
+	if ue := asUnwrapError(path, err); ue != nil {
+		return ue
+	}
+	switch {
+	case errors.Is(err, sopsx.ErrNotEncrypted):
+		return ErrNotEncrypted
+	case errors.Is(err, sopsx.ErrTooLarge):
+		return ErrTooLarge
+	case errors.Is(err, sopsx.ErrMACMismatch):
+		log.Warnf("cipher.Verify failed: path=%s err=%v", path, err)
+		return fmt.Errorf("%w: %w", ErrMACMismatch, err)
+	case err != nil:
+		return fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+	log.Debugf("cipher.Verify done: path=%s", path)
+	return nil
+}
+
+// VerifyWalk applies Verify to every matching file under root. Unlike
+// the other walks it does not stop at the first bad file: every file
+// is checked, as if WalkOptions.ContinueOnError were set, and the
+// returned error joins one "verify <path>" error per failure, in path
+// order. Plain files are skipped.
+func VerifyWalk(
+	ctx context.Context, files afero.Fs, root string, matchers []FileMatcher,
+) (*WalkResult, error) {
+	return VerifyWalkWith(ctx, files, root, DecoderOptions{}, matchers, WalkOptions{})
+}
+
+// VerifyWalkWith is VerifyWalk with explicit options. dec configures
+// each Verify call. OnFile receives the size of every file that passes;
+// BackupSuffix, DryRun, and Transactional are ignored because nothing
+// is written.
+func VerifyWalkWith(
+	ctx context.Context, files afero.Fs, root string,
+	dec DecoderOptions, matchers []FileMatcher, opts WalkOptions,
+) (*WalkResult, error) {
+	if files == nil {
+		panic("cipher: VerifyWalkWith: filesystem required")
+	}
+	serializeCallbacks(&opts)
+	opts.ContinueOnError = true
+	opts.Transactional = false
+	rec := recordWalk(&opts, stateScopeVerify)
+	return runWalk(ctx, files, root, matchers, opts, rec,
+		func(ctx context.Context, _ afero.Fs, path string, _ fs.FileInfo) error {
+			data, err := afero.ReadFile(files, path)
+			if err != nil {
+				return fmt.Errorf("read %q: %w", path, err)
+			}
+			err = VerifyWith(ctx, path, data, dec)
+			switch {
+			case errors.Is(err, ErrNotEncrypted):
+				rec.keep(path, data)
+				notify(opts.OnSkip, path, ErrNotEncrypted)
+				return nil
+			case err != nil:
+				return fmt.Errorf("verify %q: %w", path, err)
+			}
+			rec.keep(path, data)
+			notify(opts.OnFile, path, len(data))
+			return nil
+		})
+}
@@ -0,0 +1,134 @@
+package cipher_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/afero"
+
+	"github.com/dcadolph/cipher"
+	cipherage "github.com/dcadolph/cipher/age"
+)
+
+// tamper swaps the ciphertexts of two YAML keys so both fail to
+// authenticate.
+func tamper(t *testing.T, data []byte, a, b string) []byte {
+	t.Helper()
+	val := func(key string) string {
+		for _, line := range strings.Split(string(data), "\n") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(line), key+": "); ok {
+				return v
+			}
+		}
+		t.Fatalf("no %q in:\n%s", key, data)
+		return ""
+	}
+	va, vb := val(a), val(b)
+	return []byte(strings.NewReplacer(va, vb, vb, va).Replace(string(data)))
+}
+
+// TestVerify covers intact, plain, tampered, and wrong-identity input.
+func TestVerify(t *testing.T) {
+	recipient := newAgeIdentity(t)
+	enc := cipher.NewEncoder(cipherage.MustNewProvider(recipient))
+	ctx := context.Background()
+	data, err := enc.Encode(ctx, "s.yaml", []byte("a: one\nb: two\n"))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	other := mustAgeIdentity(t)
+
+	tests := []struct {
+		Name    string
+		Data    []byte
+		Opts    cipher.DecoderOptions
+		WantErr error
+	}{{ // Test 0: Intact file verifies.
+		Name: "intact", Data: data,
+	}, { // Test 1: Plain input reports ErrNotEncrypted.
+		Name: "plain", Data: []byte("a: one\n"), WantErr: cipher.ErrNotEncrypted,
+	}, { // Test 2: Swapped values fail integrity.
+		Name: "tampered", Data: tamper(t, data, "a", "b"), WantErr: cipher.ErrMACMismatch,
+	}, { // Test 3: An identity that cannot unwrap is a decode error.
+		Name: "wrong identity", Data: data,
+		Opts:    cipher.DecoderOptions{AgeIdentities: []string{other.String()}},
+		WantErr: cipher.ErrDecode,
+	}}
+
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			err := cipher.VerifyWith(ctx, "s.yaml", test.Data, test.Opts)
+			if !errors.Is(err, test.WantErr) || (test.WantErr == nil && err != nil) {
+				t.Fatalf("VerifyWith err = %v, want %v", err, test.WantErr)
+			}
+		})
+	}
+}
+
+// TestVerifyWalk checks that every bad file is reported, in path
+// order, and that plain files are skipped.
+func TestVerifyWalk(t *testing.T) {
+	recipient := newAgeIdentity(t)
+	enc := cipher.NewEncoder(cipherage.MustNewProvider(recipient))
+	ctx := context.Background()
+	files := afero.NewMemMapFs()
+
+	good, err := enc.Encode(ctx, "good.yaml", []byte("a: one\nb: two\n"))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	seed := map[string][]byte{
+		"root/good.yaml":   good,
+		"root/x/bad.yaml":  tamper(t, good, "a", "b"),
+		"root/a/bad.yaml":  tamper(t, good, "a", "b"),
+		"root/plain.yaml":  []byte("a: one\n"),
+		"root/ignored.txt": []byte("text\n"),
+	}
+	for p, b := range seed {
+		if err := afero.WriteFile(files, p, b, 0o600); err != nil {
+			t.Fatalf("write %q: %v", p, err)
+		}
+	}
+
+	var passed, skipped []string
+	_, err = cipher.VerifyWalkWith(ctx, files, "root", cipher.DecoderOptions{},
+		[]cipher.FileMatcher{cipher.MatchExt("yaml")}, cipher.WalkOptions{
+			Parallelism: 4,
+			OnFile:      func(p string, _ int) { passed = append(passed, filepath.ToSlash(p)) },
+			OnSkip: func(p string, reason error) {
+				if errors.Is(reason, cipher.ErrNotEncrypted) {
+					skipped = append(skipped, filepath.ToSlash(p))
+				}
+			},
+		})
+	if !errors.Is(err, cipher.ErrMACMismatch) {
+		t.Fatalf("VerifyWalkWith err = %v, want ErrMACMismatch", err)
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		t.Fatalf("VerifyWalkWith err %T does not join failures", err)
+	}
+	var failed []string
+	for _, e := range joined.Unwrap() {
+		failed = append(failed, filepath.ToSlash(strings.SplitN(e.Error(), `"`, 3)[1]))
+	}
+	if diff := cmp.Diff([]string{"root/a/bad.yaml", "root/x/bad.yaml"}, failed); diff != "" {
+		t.Errorf("failures mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"root/good.yaml"}, passed); diff != "" {
+		t.Errorf("passed mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"root/plain.yaml"}, skipped); diff != "" {
+		t.Errorf("skipped mismatch (-want +got):\n%s", diff)
+	}
+
+	onlyGood := cipher.FileMatcherFunc(func(p string) bool { return filepath.Base(p) == "good.yaml" })
+	if _, err := cipher.VerifyWalk(ctx, files, "root", []cipher.FileMatcher{onlyGood}); err != nil {
+		t.Errorf("VerifyWalk good only: %v", err)
+	}
+}
@@ -6,7 +6,10 @@ import (
 	"fmt"
 	"io/fs"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/spf13/afero"
 
@@ -41,12 +44,271 @@ type WalkOptions struct {
 	// ErrNotEncrypted, ErrEmpty, or matcher rejection). Nil is treated
 	// as a no-op. Serialized under Parallelism > 1, same as OnFile.
 	OnSkip func(path string, reason error)
+	// WrapFile, when non-nil, wraps the work done on each file the
+	// matchers accept, such as to give each file its own tracing span.
+	// It must call do once with the context for that file and return
+	// do's error. Unlike OnFile and OnSkip it is not serialized: under
+	// Parallelism > 1 it runs on every worker concurrently.
+	WrapFile func(ctx context.Context, path string, do func(ctx context.Context) error) error
+	// DryRun, when true, plans each file instead of changing it. Every
+	// file the walk visits, including those skipped by a matcher, is
+	// reported to OnPlan as a FilePlan. The plan is made from each
+	// file's content and metadata alone: nothing is written, no backup
+	// is made, no key is used, and OnFile and OnSkip are not called. A
+	// file that would fail is reported as PlanError and does not stop
+	// the walk. VerifyWalkWith writes nothing and ignores DryRun.
+	DryRun bool
+	// OnPlan receives one FilePlan per file on a dry run. Nil is
+	// treated as a no-op. Serialized under Parallelism > 1, same as
+	// OnFile.
+	OnPlan func(FilePlan)
+	// ContinueOnError, when true, keeps the walk going after a file
+	// fails instead of stopping at the first failure. Every file is
+	// attempted, each failure is recorded in the WalkResult, and the
+	// returned error joins one error per failed file, in path order.
+	// Canceling ctx still stops the walk. VerifyWalkWith always
+	// continues.
+	ContinueOnError bool
+	// Transactional, when true, makes the walk all-or-nothing. Each
+	// file's new contents, and its backup when BackupSuffix is set, are
+	// staged beside it and recorded in a journal in root, and no file
+	// is replaced until every file has succeeded. If a file fails or
+	// ctx is canceled, the staged files are removed and the tree is
+	// left as it was; with ContinueOnError every file is still
+	// attempted first. The result lists files as processed even when
+	// the walk then rolls back. A journal left by a crashed process is
+	// replayed by RecoverWalks. VerifyWalkWith writes nothing and
+	// ignores Transactional.
+	Transactional bool
+	// State, when non-nil, lets the walk skip files that have not
+	// changed since State last remembered them for the same kind of
+	// walk. Such files are reported to OnSkip with ErrUnchanged without
+	// being parsed. After the walk, State remembers each file that was
+	// processed or skipped for another reason and forgets each file
+	// that failed; a transactional walk that rolls back changes
+	// nothing. The caller loads and saves State. EncodeWalkWith,
+	// DecodeWalkWith, and VerifyWalkWith consult it; the other walks,
+	// and dry runs, ignore it.
+	State *WalkState
+	// OutputRoot, when non-empty, leaves the walked tree untouched and
+	// writes each result to OutputRoot joined with the file's path
+	// relative to root, keeping the file's mode and creating parent
+	// directories with the modes of their counterparts. When root is
+	// a file, OutputRoot names the output file. Files the walk skips
+	// after reading them, such as already-encrypted files on an encode
+	// walk, are copied unchanged so the output tree holds every
+	// matched file. BackupSuffix and State are ignored, and a
+	// transactional walk keeps its journal in OutputRoot. OutputRoot
+	// may not lie inside root on the same filesystem.
+	OutputRoot string
+	// OutputFs is the filesystem OutputRoot is on. Nil means the
+	// walked filesystem. Ignored unless OutputRoot is set.
+	OutputFs afero.Fs
 }
 
-// serializeCallbacks wraps opts.OnFile and opts.OnSkip in mutex-locked
-// versions when the walk runs more than one worker. Sequential walks
-// pay no cost. Wrapping happens once at entry so all subsequent calls
-// from worker goroutines pass through the same mutex.
+// FileOutcome is what a walk did with one file.
+type FileOutcome string
+
+const (
+	// FileProcessed means the file was encoded, decoded, rotated, or
+	// verified.
+	FileProcessed FileOutcome = "processed"
+	// FileSkipped means the walk left the file alone; FileResult.Err
+	// holds the reason reported to OnSkip.
+	FileSkipped FileOutcome = "skipped"
+	// FileFailed means the work on the file failed; FileResult.Err
+	// holds the error.
+	FileFailed FileOutcome = "failed"
+)
+
+// FileResult is the outcome of one file in a walk.
+type FileResult struct {
+	// Path is the file path.
+	Path string
+	// Outcome is what the walk did with the file.
+	Outcome FileOutcome
+	// Bytes is the size reported to OnFile for processed files. Zero
+	// otherwise.
+	Bytes int
+	// Duration is the time spent on the file. Zero for files skipped
+	// by a matcher, which are never opened.
+	Duration time.Duration
+	// Err is the skip reason for FileSkipped and the error for
+	// FileFailed. Nil for FileProcessed.
+	Err error
+}
+
+// WalkResult reports every file a walk visited. A walk that stops
+// early, on a failure or a canceled context, reports the files it
+// visited before stopping. A dry run reports no files; see
+// WalkOptions.OnPlan.
+type WalkResult struct {
+	// Files holds one entry per visited file, sorted by path.
+	Files []FileResult
+}
+
+// Processed returns the number of files the walk processed.
+func (r *WalkResult) Processed() int {
+	return r.count(FileProcessed)
+}
+
+// Skipped returns the number of files the walk skipped.
+func (r *WalkResult) Skipped() int {
+	return r.count(FileSkipped)
+}
+
+// Failed returns the number of files that failed.
+func (r *WalkResult) Failed() int {
+	return r.count(FileFailed)
+}
+
+// count returns the number of files with outcome o.
+func (r *WalkResult) count(o FileOutcome) int {
+	n := 0
+	for _, f := range r.Files {
+		if f.Outcome == o {
+			n++
+		}
+	}
+	return n
+}
+
+// failures returns the errors of the failed files, in path order.
+func (r *WalkResult) failures() []error {
+	var errs []error
+	for _, f := range r.Files {
+		if f.Outcome == FileFailed {
+			errs = append(errs, f.Err)
+		}
+	}
+	return errs
+}
+
+// walkRecorder builds a WalkResult from the OnFile and OnSkip
+// callbacks and from runWalk's per-file timing. When the walk uses a
+// WalkState it also keeps the size and hash of each file's final
+// contents until runWalk remembers them. It is safe for concurrent
+// use.
+type walkRecorder struct {
+	mu    sync.Mutex
+	files map[string]*FileResult
+	state *WalkState
+	scope string
+	sums  map[string]fileSum
+}
+
+// fileSum is the size and hex SHA-256 of a file's contents.
+type fileSum struct {
+	size int64
+	sum  string
+}
+
+// newWalkRecorder returns an empty walkRecorder.
+func newWalkRecorder() *walkRecorder {
+	return &walkRecorder{files: make(map[string]*FileResult)}
+}
+
+// recordWalk wraps opts.OnFile and opts.OnSkip so the returned
+// recorder sees every processed and skipped file. The wrapped
+// callbacks still run. Call it before building the per-file work so
+// the work notifies through the wrapped callbacks. scope names the
+// kind of walk in opts.State; empty means the walk ignores State, as
+// does a walk into an output tree.
+func recordWalk(opts *WalkOptions, scope string) *walkRecorder {
+	rec := newWalkRecorder()
+	if opts.State != nil && scope != "" && opts.OutputRoot == "" {
+		rec.state, rec.scope = opts.State, scope
+		rec.sums = make(map[string]fileSum)
+	}
+	onFile, onSkip := opts.OnFile, opts.OnSkip
+	opts.OnFile = func(path string, n int) {
+		rec.update(path, func(f *FileResult) { f.Outcome, f.Bytes = FileProcessed, n })
+		notify(onFile, path, n)
+	}
+	opts.OnSkip = func(path string, reason error) {
+		rec.update(path, func(f *FileResult) { f.Outcome, f.Err = FileSkipped, reason })
+		notify(onSkip, path, reason)
+	}
+	return rec
+}
+
+// update applies fn to the entry for path, creating it if needed.
+func (r *walkRecorder) update(path string, fn func(*FileResult)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, ok := r.files[path]
+	if !ok {
+		f = &FileResult{Path: path}
+		r.files[path] = f
+	}
+	fn(f)
+}
+
+// finish records how long the work on path took and, when err is
+// non-nil, that it failed. Work that neither failed nor notified
+// counts as processed.
+func (r *walkRecorder) finish(path string, d time.Duration, err error) {
+	r.update(path, func(f *FileResult) {
+		f.Duration = d
+		switch {
+		case err != nil:
+			f.Outcome, f.Err = FileFailed, err
+		case f.Outcome == "":
+			f.Outcome = FileProcessed
+		}
+	})
+}
+
+// keep notes data as path's contents at the end of the walk, for
+// WalkOptions.State. It is a no-op when the walk has no State.
+func (r *walkRecorder) keep(path string, data []byte) {
+	if r.state == nil {
+		return
+	}
+	sum := fileSum{size: int64(len(data)), sum: hashBytes(data)}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sums[path] = sum
+}
+
+// remember updates the walk's State from res: files whose contents
+// were kept are remembered and failed files are forgotten. A file
+// that cannot be remembered, such as one removed since, is forgotten;
+// the State is only a cache, so that merely costs the next walk a
+// read.
+func (r *walkRecorder) remember(files afero.Fs, res *WalkResult) {
+	if r.state == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, f := range res.Files {
+		if f.Outcome == FileFailed {
+			r.state.Forget(r.scope, f.Path)
+			continue
+		}
+		if k, ok := r.sums[f.Path]; ok {
+			_ = r.state.remember(files, r.scope, f.Path, k.size, k.sum)
+		}
+	}
+}
+
+// result returns the recorded files sorted by path.
+func (r *walkRecorder) result() *WalkResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := &WalkResult{Files: make([]FileResult, 0, len(r.files))}
+	for _, f := range r.files {
+		res.Files = append(res.Files, *f)
+	}
+	slices.SortFunc(res.Files, func(a, b FileResult) int { return strings.Compare(a.Path, b.Path) })
+	return res
+}
+
+// serializeCallbacks wraps opts.OnFile, opts.OnSkip, and opts.OnPlan
+// in mutex-locked versions when the walk runs more than one worker.
+// Sequential walks pay no cost. Wrapping happens once at entry so all
+// subsequent calls from worker goroutines pass through the same mutex.
 func serializeCallbacks(opts *WalkOptions) {
 	if opts.Parallelism <= 1 {
 		return
@@ -66,15 +328,23 @@ func serializeCallbacks(opts *WalkOptions) {
 			onSkip(path, reason)
 		}
 	}
+	if onPlan := opts.OnPlan; onPlan != nil {
+		opts.OnPlan = func(p FilePlan) {
+			mu.Lock()
+			defer mu.Unlock()
+			onPlan(p)
+		}
+	}
 }
 
 // EncodeWalk walks root on files and encrypts every file matched by any
 // of the supplied matchers using enc. Empty matchers means every file
-// is matched. Already-encrypted files are skipped.
+// is matched. Already-encrypted files are skipped. The result reports
+// every file visited, including when the walk fails.
 func EncodeWalk(
 	ctx context.Context, files afero.Fs, root string,
 	enc Encoder, matchers []FileMatcher,
-) error {
+) (*WalkResult, error) {
 	return EncodeWalkWith(ctx, files, root, enc, matchers, WalkOptions{})
 }
 
@@ -82,7 +352,7 @@ func EncodeWalk(
 func EncodeWalkWith(
 	ctx context.Context, files afero.Fs, root string,
 	enc Encoder, matchers []FileMatcher, opts WalkOptions,
-) error {
+) (*WalkResult, error) {
 	if files == nil {
 		panic("cipher: EncodeWalkWith: filesystem required")
 	}
@@ -90,26 +360,33 @@ func EncodeWalkWith(
 		panic("cipher: EncodeWalkWith: encoder required")
 	}
 	serializeCallbacks(&opts)
-	return runWalk(ctx, files, root, matchers, opts,
-		func(ctx context.Context, fs afero.Fs, path string, info fs.FileInfo) error {
-			data, err := afero.ReadFile(fs, path)
+	if opts.DryRun {
+		return runPlan(ctx, files, root, matchers, opts, planEncode(enc))
+	}
+	rec := recordWalk(&opts, stateScopeEncode)
+	dest := newWalkDest(files, root, opts)
+	return runWalk(ctx, files, root, matchers, opts, rec,
+		func(ctx context.Context, dst afero.Fs, path string, info fs.FileInfo) error {
+			data, err := afero.ReadFile(files, path)
 			if err != nil {
 				return fmt.Errorf("read %q: %w", path, err)
 			}
 			out, err := enc.Encode(ctx, path, data)
 			switch {
 			case errors.Is(err, ErrAlreadyEncrypted), errors.Is(err, ErrEmpty):
+				if err := dest.passThrough(dst, path, data, info); err != nil {
+					return err
+				}
+				rec.keep(path, data)
 				notify(opts.OnSkip, path, err)
 				return nil
 			case err != nil:
 				return fmt.Errorf("encode %q: %w", path, err)
 			}
-			if err := writeBackup(fs, path, data, info, opts.BackupSuffix); err != nil {
-				return err
-			}
-			if err := atomic.WriteFile(fs, path, out, info.Mode().Perm()); err != nil {
+			if err := dest.write(dst, path, data, out, info); err != nil {
 				return err
 			}
+			rec.keep(path, out)
 			notify(opts.OnFile, path, len(out))
 			return nil
 		})
@@ -117,11 +394,12 @@ func EncodeWalkWith(
 
 // DecodeWalk walks root on files and decrypts every file matched by
 // any of the supplied matchers using dec. Empty matchers means every
-// file is matched. Plain (non-encrypted) files are skipped.
+// file is matched. Plain (non-encrypted) files are skipped. The result
+// reports every file visited, including when the walk fails.
 func DecodeWalk(
 	ctx context.Context, files afero.Fs, root string,
 	dec Decoder, matchers []FileMatcher,
-) error {
+) (*WalkResult, error) {
 	return DecodeWalkWith(ctx, files, root, dec, matchers, WalkOptions{})
 }
 
@@ -129,7 +407,7 @@ func DecodeWalk(
 func DecodeWalkWith(
 	ctx context.Context, files afero.Fs, root string,
 	dec Decoder, matchers []FileMatcher, opts WalkOptions,
-) error {
+) (*WalkResult, error) {
 	if files == nil {
 		panic("cipher: DecodeWalkWith: filesystem required")
 	}
@@ -137,26 +415,33 @@ func DecodeWalkWith(
 		panic("cipher: DecodeWalkWith: decoder required")
 	}
 	serializeCallbacks(&opts)
-	return runWalk(ctx, files, root, matchers, opts,
-		func(ctx context.Context, fs afero.Fs, path string, info fs.FileInfo) error {
-			data, err := afero.ReadFile(fs, path)
+	if opts.DryRun {
+		return runPlan(ctx, files, root, matchers, opts, planDecode)
+	}
+	rec := recordWalk(&opts, stateScopeDecode)
+	dest := newWalkDest(files, root, opts)
+	return runWalk(ctx, files, root, matchers, opts, rec,
+		func(ctx context.Context, dst afero.Fs, path string, info fs.FileInfo) error {
+			data, err := afero.ReadFile(files, path)
 			if err != nil {
 				return fmt.Errorf("read %q: %w", path, err)
 			}
 			out, err := dec.Decode(ctx, path, data)
 			switch {
 			case errors.Is(err, ErrNotEncrypted):
+				if err := dest.passThrough(dst, path, data, info); err != nil {
+					return err
+				}
+				rec.keep(path, data)
 				notify(opts.OnSkip, path, err)
 				return nil
 			case err != nil:
 				return fmt.Errorf("decode %q: %w", path, err)
 			}
-			if err := writeBackup(fs, path, data, info, opts.BackupSuffix); err != nil {
-				return err
-			}
-			if err := atomic.WriteFile(fs, path, out, info.Mode().Perm()); err != nil {
+			if err := dest.write(dst, path, data, out, info); err != nil {
 				return err
 			}
+			rec.keep(path, out)
 			notify(opts.OnFile, path, len(out))
 			return nil
 		})
@@ -207,8 +492,11 @@ type walkItem struct {
 	info fs.FileInfo
 }
 
-// walkDoFunc is the per-file work performed by runWalk.
-type walkDoFunc func(ctx context.Context, files afero.Fs, path string, info fs.FileInfo) error
+// walkDoFunc is the per-file work performed by runWalk. It reads the
+// file at path from the walked filesystem and writes any result to
+// dst: the walked filesystem, the output filesystem, or a transaction
+// over either.
+type walkDoFunc func(ctx context.Context, dst afero.Fs, path string, info fs.FileInfo) error
 
 // enumerateFiles returns every file under root that matches matcher.
 // When opts.FollowSymlinks is false, directory symlinks are skipped;
@@ -365,41 +653,117 @@ func absoluteTarget(link, target string) string {
 // runWalk enumerates files under root that match matchers (or all
 // files, when matchers is empty), then runs do for each one either
 // sequentially (Parallelism <= 1) or concurrently with a bounded
-// semaphore. On the first do error in the parallel case, the context
-// passed to remaining workers is canceled and the function returns
-// the first observed error after in-flight work drains.
+// semaphore, recording each file's outcome in rec. rec must come from
+// recordWalk on opts. On the first do error the walk stops: in the
+// parallel case the context passed to remaining workers is canceled
+// and the first observed error is returned after in-flight work
+// drains. With opts.ContinueOnError every file is attempted and the
+// error joins each failure in path order. With opts.Transactional the
+// per-file work writes through a walkTx, which is committed when the
+// walk succeeds and rolled back otherwise. When rec has a WalkState,
+// files it proves unchanged are skipped with ErrUnchanged and the
+// State is updated once the walk's writes are in place. With
+// opts.OutputRoot the per-file work writes to the output filesystem.
 func runWalk(
 	ctx context.Context, files afero.Fs, root string,
-	matchers []FileMatcher, opts WalkOptions,
+	matchers []FileMatcher, opts WalkOptions, rec *walkRecorder,
 	do walkDoFunc,
-) error {
+) (*WalkResult, error) {
 	matcher := combineMatchers(matchers)
+	if state := rec.state; state != nil {
+		inner := do
+		do = func(ctx context.Context, dst afero.Fs, path string, info fs.FileInfo) error {
+			unchanged, err := state.Unchanged(files, rec.scope, path)
+			if err != nil {
+				return fmt.Errorf("walk state: %w", err)
+			}
+			if unchanged {
+				notify(opts.OnSkip, path, ErrUnchanged)
+				return nil
+			}
+			return inner(ctx, dst, path, info)
+		}
+	}
+	if wrap := opts.WrapFile; wrap != nil {
+		inner := do
+		do = func(ctx context.Context, dst afero.Fs, path string, info fs.FileInfo) error {
+			return wrap(ctx, path, func(ctx context.Context) error {
+				return inner(ctx, dst, path, info)
+			})
+		}
+	}
+	timed := do
+	do = func(ctx context.Context, dst afero.Fs, path string, info fs.FileInfo) error {
+		start := time.Now()
+		err := timed(ctx, dst, path, info)
+		rec.finish(path, time.Since(start), err)
+		return err
+	}
+	work, err := outputFs(files, root, opts)
+	if err != nil {
+		return rec.result(), err
+	}
 
 	items, walkErr := enumerateFiles(ctx, files, root, opts, matcher)
 	if walkErr != nil {
-		return walkErr
+		return rec.result(), walkErr
 	}
 
+	var tx *walkTx
+	if opts.Transactional {
+		if tx, err = newWalkTx(work, journalDir(files, root, opts)); err != nil {
+			return rec.result(), err
+		}
+		work = tx
+	}
 	if opts.Parallelism <= 1 {
-		for _, it := range items {
-			if err := ctx.Err(); err != nil {
-				return err
-			}
-			if err := do(ctx, files, it.path, it.info); err != nil {
-				return err
-			}
+		err = runSequential(ctx, work, items, opts.ContinueOnError, do)
+	} else {
+		err = runParallel(ctx, work, items, opts.Parallelism, opts.ContinueOnError, do)
+	}
+	res := rec.result()
+	if failures := res.failures(); opts.ContinueOnError && len(failures) > 0 {
+		err = errors.Join(append(failures, err)...)
+	}
+	if tx != nil {
+		if err != nil {
+			return res, errors.Join(err, tx.rollback())
+		}
+		if err := tx.commit(); err != nil {
+			return res, err
 		}
-		return nil
 	}
-	return runParallel(ctx, files, items, opts.Parallelism, do)
+	rec.remember(files, res)
+	return res, err
+}
+
+// runSequential processes items one at a time. It stops at the first
+// error unless continueOnError is set, and always stops when ctx is
+// done. Failures skipped over by continueOnError are not returned;
+// runWalk collects them from the recorder.
+func runSequential(
+	ctx context.Context, files afero.Fs,
+	items []walkItem, continueOnError bool, do walkDoFunc,
+) error {
+	for _, it := range items {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := do(ctx, files, it.path, it.info); err != nil && !continueOnError {
+			return err
+		}
+	}
+	return nil
 }
 
 // runParallel processes items via a bounded semaphore. The first error
 // from any worker cancels remaining work; runParallel returns after
-// in-flight workers finish and yields the first observed error.
+// in-flight workers finish and yields the first observed error. With
+// continueOnError, errors do not cancel anything and only ctx's error
+// is returned.
 func runParallel(
 	ctx context.Context, files afero.Fs,
-	items []walkItem, parallelism int, do walkDoFunc,
+	items []walkItem, parallelism int, continueOnError bool, do walkDoFunc,
 ) error {
 	sem := make(chan struct{}, parallelism)
 	var wg sync.WaitGroup
@@ -420,7 +784,7 @@ loop:
 		go func(it walkItem) {
 			defer wg.Done()
 			defer func() { <-sem }()
-			if err := do(subCtx, files, it.path, it.info); err != nil {
+			if err := do(subCtx, files, it.path, it.info); err != nil && !continueOnError {
 				mu.Lock()
 				if firstErr == nil {
 					firstErr = err
@@ -0,0 +1,220 @@
+package cipher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/dcadolph/cipher/internal/atomic"
+)
+
+// walkStateVersion is the format version written by WalkState.Save. A
+// file with any other version loads as an empty state.
+const walkStateVersion = 1
+
+// Walk state scopes used by the walks that consult WalkOptions.State.
+const (
+	stateScopeEncode = "encode"
+	stateScopeDecode = "decode"
+	stateScopeVerify = "verify"
+)
+
+// WalkState remembers the size, modification time, and SHA-256 of each
+// file a walk handled, so a later walk can skip files that have not
+// changed since. Records are kept per scope, a name for the kind of
+// walk that made them: a file encrypted by an encode walk is not
+// thereby verified. EncodeWalkWith, DecodeWalkWith, and VerifyWalkWith
+// use the scopes "encode", "decode", and "verify"; callers checking
+// files themselves can use any other name with Unchanged and Remember.
+//
+// Use NewWalkState or LoadWalkState to obtain one, and Save to persist
+// it between runs. It is safe for concurrent use.
+type WalkState struct {
+	mu     sync.Mutex
+	scopes map[string]map[string]*stateEntry
+}
+
+// stateEntry is what a WalkState knows about one file.
+type stateEntry struct {
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mtime"`
+	SHA256   string    `json:"sha256"`
+	Recorded time.Time `json:"recorded"`
+}
+
+// walkStateFile is the JSON form of a WalkState.
+type walkStateFile struct {
+	Version int                               `json:"version"`
+	Scopes  map[string]map[string]*stateEntry `json:"scopes"`
+}
+
+// NewWalkState returns an empty WalkState.
+func NewWalkState() *WalkState {
+	return &WalkState{scopes: make(map[string]map[string]*stateEntry)}
+}
+
+// LoadWalkState reads the WalkState saved at path on files. A missing
+// file, or one that does not parse or has an unknown version, loads as
+// an empty state, so the next walk handles every file and Save
+// replaces it.
+func LoadWalkState(files afero.Fs, path string) (*WalkState, error) {
+	if files == nil {
+		panic("cipher: LoadWalkState: filesystem required")
+	}
+	s := NewWalkState()
+	data, err := afero.ReadFile(files, path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return s, nil
+	case err != nil:
+		return nil, fmt.Errorf("read walk state %q: %w", path, err)
+	}
+	var f walkStateFile
+	if err := json.Unmarshal(data, &f); err != nil || f.Version != walkStateVersion {
+		return s, nil
+	}
+	for scope, entries := range f.Scopes {
+		if entries != nil {
+			s.scopes[scope] = entries
+		}
+	}
+	return s, nil
+}
+
+// Save writes s to path on files, replacing it atomically.
+func (s *WalkState) Save(files afero.Fs, path string) error {
+	if files == nil {
+		panic("cipher: WalkState.Save: filesystem required")
+	}
+	s.mu.Lock()
+	data, err := json.Marshal(walkStateFile{Version: walkStateVersion, Scopes: s.scopes})
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("encode walk state: %w", err)
+	}
+	if err := atomic.WriteFile(files, path, data, 0o600); err != nil {
+		return fmt.Errorf("write walk state %q: %w", path, err)
+	}
+	return nil
+}
+
+// Unchanged reports whether path on files still holds the contents
+// last remembered for it under scope. A file whose size and
+// modification time match the record, and whose modification time
+// falls before the second the record was made, is unchanged without
+// being read. Otherwise a file of the same size is hashed and
+// compared, and when it matches the record takes its new modification
+// time. A file with no record is never unchanged.
+func (s *WalkState) Unchanged(files afero.Fs, scope, path string) (bool, error) {
+	key := filepath.Clean(path)
+	s.mu.Lock()
+	e, ok := s.scopes[scope][key]
+	var want stateEntry
+	if ok {
+		want = *e
+	}
+	s.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	info, err := files.Stat(path)
+	if err != nil {
+		return false, fmt.Errorf("stat %q: %w", path, err)
+	}
+	if info.Size() != want.Size {
+		return false, nil
+	}
+	// A file rewritten within the same second as the record, at the
+	// same size, can keep the recorded modification time on
+	// filesystems with coarse timestamps, so only older files skip the
+	// hash.
+	if info.ModTime().Equal(want.ModTime) && want.ModTime.Before(want.Recorded.Truncate(time.Second)) {
+		return true, nil
+	}
+	sum, err := hashFile(files, path)
+	if err != nil {
+		return false, err
+	}
+	if sum != want.SHA256 {
+		return false, nil
+	}
+	s.set(scope, key, &stateEntry{
+		Size: want.Size, ModTime: info.ModTime(), SHA256: sum, Recorded: time.Now(),
+	})
+	return true, nil
+}
+
+// Remember records data as the contents of path under scope, along
+// with path's current size and modification time on files. Pass the
+// bytes that were checked or written rather than rereading the file,
+// so a change made in between is not remembered as checked. If path
+// no longer has data's size its record is dropped instead.
+func (s *WalkState) Remember(files afero.Fs, scope, path string, data []byte) error {
+	return s.remember(files, scope, path, int64(len(data)), hashBytes(data))
+}
+
+// remember is Remember with the size and hash already computed.
+func (s *WalkState) remember(files afero.Fs, scope, path string, size int64, sum string) error {
+	key := filepath.Clean(path)
+	info, err := files.Stat(path)
+	if err != nil {
+		s.Forget(scope, path)
+		return fmt.Errorf("stat %q: %w", path, err)
+	}
+	if info.Size() != size {
+		s.Forget(scope, path)
+		return nil
+	}
+	s.set(scope, key, &stateEntry{
+		Size: size, ModTime: info.ModTime(), SHA256: sum, Recorded: time.Now(),
+	})
+	return nil
+}
+
+// Forget drops the record for path under scope, if any.
+func (s *WalkState) Forget(scope, path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.scopes[scope], filepath.Clean(path))
+}
+
+// set stores e as the record for key under scope.
+func (s *WalkState) set(scope, key string, e *stateEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, ok := s.scopes[scope]
+	if !ok {
+		entries = make(map[string]*stateEntry)
+		s.scopes[scope] = entries
+	}
+	entries[key] = e
+}
+
+// hashBytes returns the hex SHA-256 of data.
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hashFile returns the hex SHA-256 of the file at path on files.
+func hashFile(files afero.Fs, path string) (string, error) {
+	f, err := files.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("open %q: %w", path, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("read %q: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
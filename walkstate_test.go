@@ -0,0 +1,201 @@
+package cipher_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/afero"
+
+	"github.com/dcadolph/cipher"
+)
+
+// TestEncodeWalkState verifies that a walk with a WalkState skips files
+// it already handled, handles changed and failed files again, and keeps
+// separate records per kind of walk.
+func TestEncodeWalkState(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	boom := errors.New("boom")
+	files := seedTree(t, map[string]string{
+		"root/a.yaml": "a: 1\n",
+		"root/b.yaml": "b: 2\n",
+		"root/c.yaml": "c: 3\n",
+	})
+	state := cipher.NewWalkState()
+
+	var encoded []string
+	failing := "root/c.yaml"
+	enc := cipher.EncoderFunc(func(_ context.Context, path string, data []byte) ([]byte, error) {
+		encoded = append(encoded, path)
+		switch {
+		case path == failing:
+			return nil, boom
+		case len(data) > 4 && string(data[:4]) == "enc:":
+			return nil, cipher.ErrAlreadyEncrypted
+		}
+		return append([]byte("enc:"), data...), nil
+	})
+	walk := func() map[string]error {
+		t.Helper()
+		encoded = nil
+		skipped := make(map[string]error)
+		_, _ = cipher.EncodeWalkWith(ctx, files, "root", enc, nil, cipher.WalkOptions{
+			State:           state,
+			ContinueOnError: true,
+			OnSkip:          func(p string, reason error) { skipped[p] = reason },
+		})
+		return skipped
+	}
+
+	// Test 1: The first walk handles every file.
+	walk()
+	if diff := cmp.Diff([]string{"root/a.yaml", "root/b.yaml", "root/c.yaml"}, encoded); diff != "" {
+		t.Errorf("Test 1: encoded (-want +got):\n%s", diff)
+	}
+
+	// Test 2: The second walk skips the files it encrypted and retries
+	// the one that failed.
+	skipped := walk()
+	if diff := cmp.Diff([]string{"root/c.yaml"}, encoded); diff != "" {
+		t.Errorf("Test 2: encoded (-want +got):\n%s", diff)
+	}
+	if !errors.Is(skipped["root/a.yaml"], cipher.ErrUnchanged) {
+		t.Errorf("Test 2: skip reason for a.yaml = %v, want ErrUnchanged", skipped["root/a.yaml"])
+	}
+
+	// Test 3: A file rewritten since is handled again, and the file
+	// that now succeeds is remembered.
+	failing = ""
+	if err := afero.WriteFile(files, "root/b.yaml", []byte("b: 3\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	walk()
+	if diff := cmp.Diff([]string{"root/b.yaml", "root/c.yaml"}, encoded); diff != "" {
+		t.Errorf("Test 3: encoded (-want +got):\n%s", diff)
+	}
+	walk()
+	if len(encoded) != 0 {
+		t.Errorf("Test 3: encoded %v on a walk of an unchanged tree", encoded)
+	}
+
+	// Test 4: Records are per kind of walk, so a decode walk visits
+	// every file.
+	var decoded atomic.Int32
+	dec := cipher.DecoderFunc(func(_ context.Context, _ string, data []byte) ([]byte, error) {
+		decoded.Add(1)
+		return data, nil
+	})
+	_, err := cipher.DecodeWalkWith(ctx, files, "root", dec, nil, cipher.WalkOptions{State: state})
+	if err != nil || decoded.Load() != 3 {
+		t.Errorf("Test 4: decode walk err = %v, decoded %d files, want 3", err, decoded.Load())
+	}
+}
+
+// TestWalkStateUnchanged verifies how WalkState decides a file is
+// unchanged, and that it survives a Save and LoadWalkState round trip.
+func TestWalkStateUnchanged(t *testing.T) {
+	t.Parallel()
+	const scope, path = "test", "root/a.yaml"
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		Name     string
+		Mtime    time.Time
+		Rewrite  string
+		NewMtime time.Time
+		Want     bool
+	}{
+		// Test 0: An untouched file is unchanged.
+		{
+			Name:     "untouched",
+			Mtime:    old,
+			NewMtime: old,
+			Want:     true,
+		},
+		// Test 1: A touched file with the same contents is unchanged.
+		{
+			Name:     "touched",
+			Mtime:    old,
+			Rewrite:  "a: 1\n",
+			NewMtime: old.Add(time.Hour),
+			Want:     true,
+		},
+		// Test 2: A rewrite of another size has changed.
+		{
+			Name:     "resized",
+			Mtime:    old,
+			Rewrite:  "a: 12\n",
+			NewMtime: old,
+		},
+		// Test 3: A same-size rewrite that keeps the recorded
+		// modification time is caught by the hash when that time is
+		// not older than the record.
+		{
+			Name:     "racy",
+			Mtime:    future,
+			Rewrite:  "a: 2\n",
+			NewMtime: future,
+		},
+	}
+	for testNum, test := range tests {
+		t.Run(fmt.Sprintf("test %d %s", testNum, test.Name), func(t *testing.T) {
+			t.Parallel()
+			files := seedTree(t, map[string]string{path: "a: 1\n"})
+			if err := files.Chtimes(path, test.Mtime, test.Mtime); err != nil {
+				t.Fatal(err)
+			}
+			state := cipher.NewWalkState()
+			if err := state.Remember(files, scope, path, []byte("a: 1\n")); err != nil {
+				t.Fatalf("Remember: %v", err)
+			}
+			if err := state.Save(files, "state.json"); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+			state, err := cipher.LoadWalkState(files, "state.json")
+			if err != nil {
+				t.Fatalf("LoadWalkState: %v", err)
+			}
+			if test.Rewrite != "" {
+				if err := afero.WriteFile(files, path, []byte(test.Rewrite), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := files.Chtimes(path, test.NewMtime, test.NewMtime); err != nil {
+				t.Fatal(err)
+			}
+			got, err := state.Unchanged(files, scope, path)
+			if err != nil || got != test.Want {
+				t.Errorf("Unchanged = %v, %v, want %v", got, err, test.Want)
+			}
+			if got, _ := state.Unchanged(files, "other", path); got {
+				t.Errorf("Unchanged under another scope")
+			}
+		})
+	}
+}
+
+// TestLoadWalkStateUnreadable verifies that a missing or unusable state
+// file loads as an empty state.
+func TestLoadWalkStateUnreadable(t *testing.T) {
+	t.Parallel()
+	files := seedTree(t, map[string]string{
+		"root/a.yaml":   "a: 1\n",
+		"garbage.json":  "{not json",
+		"version9.json": `{"version":9,"scopes":{"test":{"root/a.yaml":{"size":5}}}}`,
+	})
+	for _, path := range []string{"missing.json", "garbage.json", "version9.json"} {
+		state, err := cipher.LoadWalkState(files, path)
+		if err != nil {
+			t.Fatalf("LoadWalkState(%q): %v", path, err)
+		}
+		if got, err := state.Unchanged(files, "test", "root/a.yaml"); got || err != nil {
+			t.Errorf("%s: Unchanged = %v, %v, want false", path, got, err)
+		}
+	}
+}